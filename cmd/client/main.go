@@ -8,48 +8,31 @@ import (
 	"os"
 	"strings"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-
 	"deepapp_golang_grpc_hub/internal/proto"
 	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/client"
 )
 
 func main() {
 	// Connect to server
-	conn, err := grpc.Dial("localhost:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	c, err := client.Dial("localhost:50051",
+		client.WithClientID(utils.GenerateID()),
+		client.WithMessageHandler(func(msg *proto.Message) {
+			fmt.Printf("Received: %s\n", msg.Content)
+		}),
+	)
 	if err != nil {
 		log.Fatalf("Failed to connect: %v", err)
 	}
-	defer conn.Close()
-
-	client := proto.NewHubServiceClient(conn)
+	defer c.Close()
 
-	// Start streaming
-	stream, err := client.Connect(context.Background())
-	if err != nil {
-		log.Fatalf("Failed to start stream: %v", err)
-	}
-
-	// Generate client ID
-	clientID := utils.GenerateID()
+	clientID := c.ID()
 	fmt.Printf("Connected as client: %s\n", clientID)
 
-	// Goroutine to receive messages
-	go func() {
-		for {
-			msg, err := stream.Recv()
-			if err != nil {
-				log.Printf("Stream error: %v", err)
-				return
-			}
-			fmt.Printf("Received: %s\n", msg.Content)
-		}
-	}()
-
 	// Send messages from stdin
 	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Println("Enter messages (format: type:to:content or 'broadcast:content' or 'channel:chan:content'):")
+	fmt.Println("Enter messages (format: type:to:content or 'broadcast:content' or 'channel:chan:content'),")
+	fmt.Println("or 'subscribe:chan' / 'unsubscribe:chan':")
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -59,6 +42,23 @@ func main() {
 			continue
 		}
 
+		switch parts[0] {
+		case "subscribe":
+			if err := c.Subscribe(context.Background(), parts[1]); err != nil {
+				log.Printf("Failed to subscribe: %v", err)
+			} else {
+				fmt.Printf("Subscribed to %s\n", parts[1])
+			}
+			continue
+		case "unsubscribe":
+			if err := c.Unsubscribe(context.Background(), parts[1]); err != nil {
+				log.Printf("Failed to unsubscribe: %v", err)
+			} else {
+				fmt.Printf("Unsubscribed from %s\n", parts[1])
+			}
+			continue
+		}
+
 		msg := proto.Message{
 			Id:        utils.GenerateID(),
 			From:      clientID,
@@ -86,12 +86,12 @@ func main() {
 			msg.Channel = parts[1]
 			msg.Content = parts[2]
 		default:
-			fmt.Println("Unknown type. Use: direct, broadcast, or channel")
+			fmt.Println("Unknown type. Use: direct, broadcast, channel, subscribe or unsubscribe")
 			continue
 		}
 
-		if err := stream.Send(&msg); err != nil {
+		if err := c.Send(&msg); err != nil {
 			log.Printf("Failed to send message: %v", err)
 		}
 	}
-}
\ No newline at end of file
+}
@@ -1,46 +1,123 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
-    "fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"deepapp_golang_grpc_hub/internal/config"
 	"deepapp_golang_grpc_hub/internal/db"
 	"deepapp_golang_grpc_hub/internal/hub"
+	"deepapp_golang_grpc_hub/internal/repository"
 	"deepapp_golang_grpc_hub/pkg/logger"
 )
 
 func main() {
-	// Load configuration
-	fmt.Println("Loading configuration...")
-	cfg := config.Load()
-	fmt.Printf("Config loaded: Port=%s, LogLevel=%s, DBPath=%s\n", cfg.Port, cfg.LogLevel, cfg.DBPath)
+	// Load configuration: --config (or CONFIG_PATH) file, then environment
+	// variables, which take precedence
+	configPath := flag.String("config", os.Getenv("CONFIG_PATH"), "path to a YAML or JSON config file")
+	flag.Parse()
+
+	cfg, err := config.LoadFile(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Initialize logger
-	fmt.Println("Initializing logger...")
-	logger.Init(cfg.LogLevel)
-	fmt.Println("Logger initialized")
+	logger.InitWithFormat(cfg.LogLevel, cfg.LogFormat)
+	logger.WithFields(logger.Fields{
+		"port":      cfg.Port,
+		"log_level": cfg.LogLevel,
+		"db_path":   cfg.DBPath,
+		"config":    *configPath,
+	}).Info("config loaded")
 
 	// Initialize database
-	fmt.Println("Initializing database...")
 	database, err := db.InitDB(cfg.DBPath)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer database.Close()
-	fmt.Println("Database initialized")
+	logger.Debug("Database initialized")
 
 	// Create service registry with database
-	fmt.Println("Creating service registry with database...")
 	registry := hub.NewServiceRegistryWithDB(database)
-	fmt.Println("Service registry created")
+	logger.Debug("Service registry created")
 
 	// Start the hub server
-	fmt.Println("Creating server...")
 	server := hub.NewServerWithRegistry(cfg, registry)
-	fmt.Printf("Server created, starting on port %s...\n", cfg.Port)
-	
-	if err := server.Start(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	if err := server.SetSelectionStrategy(cfg.SelectionStrategy); err != nil {
+		log.Fatalf("Invalid SELECTION_STRATEGY: %v", err)
+	}
+	storage, err := hub.NewStorageBackend(cfg)
+	if err != nil {
+		log.Fatalf("Invalid STORAGE_BACKEND: %v", err)
+	}
+	server.SetStorageBackend(storage)
+	if cfg.AuthRequired {
+		logger.Info("Stream authentication enabled")
+		server.SetAuthenticator(hub.NewDBAuthenticator(database))
+	}
+	if cfg.PersistRequests {
+		// Requests still pending when the hub last stopped: their
+		// requesters are told to retry once they reconnect
+		pendingRepo := repository.NewPendingRequestsRepo(database)
+		interrupted, err := pendingRepo.GetAll()
+		if err != nil {
+			log.Fatalf("Failed to load pending requests: %v", err)
+		}
+		if err := pendingRepo.DeleteAll(); err != nil {
+			log.Fatalf("Failed to clear pending requests: %v", err)
+		}
+		server.RecoverRequests(interrupted)
+		server.SetRequestStore(pendingRepo)
+		logger.Info("Request persistence enabled")
+	}
+
+	if cfg.ChannelHistorySize > 0 {
+		// Retained channel messages survive restarts in the messages table
+		server.SetChannelStore(repository.NewMessagesRepo(database))
+		logger.WithFields(logger.Fields{"size": cfg.ChannelHistorySize}).Info("Channel history enabled")
+	}
+
+	serveErrs := make(chan error, 1)
+	go func() {
+		serveErrs <- server.Start()
+	}()
+
+	// Drain on SIGINT/SIGTERM; a second signal skips the wait
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErrs:
+		if err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	case sig := <-signals:
+		logger.Emoji("🛑").WithFields(logger.Fields{
+			"signal":  sig.String(),
+			"timeout": cfg.ShutdownTimeout.String(),
+		}).Info("shutdown requested")
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		go func() {
+			select {
+			case <-signals:
+				logger.Emoji("⚠️").Warn("second signal, forcing shutdown")
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		server.Shutdown(ctx)
+		cancel()
+	}
+
+	if err := database.Close(); err != nil {
+		logger.Emoji("⚠️").WithError(err).Warn("failed to close database")
+		return
 	}
+	logger.Emoji("✅").Info("database closed")
 }
\ No newline at end of file
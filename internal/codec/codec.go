@@ -0,0 +1,134 @@
+// Package codec provides pluggable serialization for Message.Content payloads.
+// The encoding is negotiated per message via Metadata["encoding"]; messages
+// without it are treated as JSON for backward compatibility.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"deepapp_golang_grpc_hub/internal/proto"
+)
+
+// MetadataKey is the message metadata key carrying the content encoding
+const MetadataKey = "encoding"
+
+const (
+	JSON    = "json"
+	MsgPack = "msgpack"
+)
+
+// Codec encodes and decodes message content
+type Codec interface {
+	// Name returns the value used in Metadata["encoding"]
+	Name() string
+
+	// Binary reports whether the encoded content may not be valid UTF-8.
+	// Proto3 strings must be, so SetContent carries binary content in
+	// BinaryContent instead of Content.
+	Binary() bool
+
+	// Marshal encodes v into a content string
+	Marshal(v interface{}) (string, error)
+
+	// Unmarshal decodes a content string into v
+	Unmarshal(content string, v interface{}) error
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = map[string]Codec{
+		JSON:    jsonCodec{},
+		MsgPack: msgpackCodec{},
+	}
+)
+
+// Register adds or replaces a codec
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// Get returns the codec for an encoding name
+func Get(name string) (Codec, error) {
+	if name == "" {
+		name = JSON
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported encoding: %s", name)
+	}
+	return c, nil
+}
+
+// FromMetadata returns the codec selected by message metadata, defaulting to JSON
+func FromMetadata(metadata map[string]string) (Codec, error) {
+	return Get(metadata[MetadataKey])
+}
+
+// SetContent stores encoded content in msg: in BinaryContent when the codec
+// named in msg.Metadata is binary, else in Content
+func SetContent(msg *proto.Message, content string) {
+	if c, err := FromMetadata(msg.Metadata); err == nil && c.Binary() {
+		msg.Content = ""
+		msg.BinaryContent = []byte(content)
+		return
+	}
+	msg.Content = content
+	msg.BinaryContent = nil
+}
+
+// jsonCodec is the default encoding
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JSON
+}
+
+func (jsonCodec) Binary() bool {
+	return false
+}
+
+func (jsonCodec) Marshal(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (jsonCodec) Unmarshal(content string, v interface{}) error {
+	return json.Unmarshal([]byte(content), v)
+}
+
+// msgpackCodec encodes content as MessagePack. The output is binary, so it
+// travels in BinaryContent (see SetContent) rather than base64 in Content.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return MsgPack
+}
+
+func (msgpackCodec) Binary() bool {
+	return true
+}
+
+func (msgpackCodec) Marshal(v interface{}) (string, error) {
+	data, err := msgpack.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (msgpackCodec) Unmarshal(content string, v interface{}) error {
+	return msgpack.Unmarshal([]byte(content), v)
+}
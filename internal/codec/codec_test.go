@@ -0,0 +1,153 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	protobuf "google.golang.org/protobuf/proto"
+
+	"deepapp_golang_grpc_hub/internal/proto"
+)
+
+// encodedMessage is a request carrying payload encoded with encoding,
+// after a round trip through the protobuf wire format
+func encodedMessage(t *testing.T, encoding string, payload interface{}) (*proto.Message, int) {
+	t.Helper()
+	c, err := Get(encoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := c.Marshal(payload)
+	if err != nil {
+		t.Fatalf("%s marshal: %v", encoding, err)
+	}
+	msg := &proto.Message{Id: "req-1", Type: proto.MessageType_REQUEST, Metadata: map[string]string{MetadataKey: encoding}}
+	SetContent(msg, content)
+
+	data, err := protobuf.Marshal(msg)
+	if err != nil {
+		t.Fatalf("%s message: %v", encoding, err)
+	}
+	received := &proto.Message{}
+	if err := protobuf.Unmarshal(data, received); err != nil {
+		t.Fatalf("%s message: %v", encoding, err)
+	}
+	return received, len(data)
+}
+
+// An OCR request with the image as bytes: JSON has to base64 it, msgpack
+// sends it as is
+func TestMsgPackSmallerForBinaryPayload(t *testing.T) {
+	image := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(image)
+	payload := map[string]interface{}{"image": image, "language": "vi", "dpi": 300}
+
+	jsonMsg, jsonSize := encodedMessage(t, JSON, payload)
+	msgpackMsg, msgpackSize := encodedMessage(t, MsgPack, payload)
+	t.Logf("message size: json %d bytes, msgpack %d bytes", jsonSize, msgpackSize)
+
+	if msgpackSize*5 > jsonSize*4 {
+		t.Errorf("msgpack message is %d bytes, want at most 4/5 of json's %d", msgpackSize, jsonSize)
+	}
+	if jsonMsg.Content == "" || len(jsonMsg.BinaryContent) != 0 {
+		t.Error("json content should be in Content")
+	}
+	if msgpackMsg.Content != "" || len(msgpackMsg.BinaryContent) == 0 {
+		t.Error("msgpack content should be in BinaryContent")
+	}
+
+	content, err := Content(msgpackMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := (msgpackCodec{}).Unmarshal(content, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got, _ := decoded["image"].([]byte); !bytes.Equal(got, image) {
+		t.Error("image changed in the msgpack round trip")
+	}
+}
+
+// An image already base64-encoded in a string gains nothing from msgpack,
+// but must not cost more than JSON either
+func TestMsgPackNotLargerForTextPayload(t *testing.T) {
+	image := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(image)
+	payload := map[string]interface{}{"image": base64.StdEncoding.EncodeToString(image), "language": "vi"}
+
+	_, jsonSize := encodedMessage(t, JSON, payload)
+	_, msgpackSize := encodedMessage(t, MsgPack, payload)
+	t.Logf("message size: json %d bytes, msgpack %d bytes", jsonSize, msgpackSize)
+	if msgpackSize > jsonSize {
+		t.Errorf("msgpack message is %d bytes, larger than json's %d", msgpackSize, jsonSize)
+	}
+}
+
+// Compression only applies to Content; Decompress must not move binary
+// content into it, or the message could not be marshalled
+func TestBinaryContentSkipsCompression(t *testing.T) {
+	payload := map[string]interface{}{"text": string(bytes.Repeat([]byte("lorem ipsum "), 20000)), "raw": []byte{0xff, 0xfe, 0x00}}
+	msg, _ := encodedMessage(t, MsgPack, payload)
+
+	if err := Compress(msg, 1024); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Metadata[ContentEncodingKey] != "" {
+		t.Fatalf("binary content was marked %s", msg.Metadata[ContentEncodingKey])
+	}
+	if err := Decompress(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != "" {
+		t.Fatal("Decompress moved binary content into Content")
+	}
+	if _, err := protobuf.Marshal(msg); err != nil {
+		t.Fatalf("marshal after Decompress: %v", err)
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	var text bytes.Buffer
+	rng := rand.New(rand.NewSource(1))
+	for text.Len() < 5<<20 {
+		fmt.Fprintf(&text, `{"line":%d,"text":"dòng văn bản nhận dạng","confidence":0.%03d},`, text.Len(), rng.Intn(1000))
+	}
+	content := text.String()
+	msg := &proto.Message{Id: "req-1", Content: content}
+
+	if err := Compress(msg, DefaultCompressionThreshold); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Metadata[ContentEncodingKey] != Gzip || len(msg.Content) >= len(content) {
+		t.Fatalf("content not compressed: %d bytes, encoding %q", len(msg.Content), msg.Metadata[ContentEncodingKey])
+	}
+	if _, err := protobuf.Marshal(msg); err != nil {
+		t.Fatalf("marshal compressed message: %v", err)
+	}
+
+	if got, err := Content(msg); err != nil || got != content {
+		t.Fatalf("Content returned %d bytes (%v), want the %d sent", len(got), err, len(content))
+	}
+	if err := Decompress(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != content || msg.Metadata[ContentEncodingKey] != "" {
+		t.Fatalf("Decompress left %d bytes, encoding %q", len(msg.Content), msg.Metadata[ContentEncodingKey])
+	}
+}
+
+// Content at or below the threshold is sent as is
+func TestCompressBelowThreshold(t *testing.T) {
+	content := string(bytes.Repeat([]byte("a"), DefaultCompressionThreshold))
+	msg := &proto.Message{Content: content}
+	if err := Compress(msg, DefaultCompressionThreshold); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != content || msg.Metadata[ContentEncodingKey] != "" {
+		t.Fatalf("content at the threshold was compressed")
+	}
+}
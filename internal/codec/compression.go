@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"deepapp_golang_grpc_hub/internal/proto"
+)
+
+// ContentEncodingKey is the message metadata key marking compressed content
+const ContentEncodingKey = "content_encoding"
+
+// Gzip is the only supported content encoding
+const Gzip = "gzip"
+
+// DefaultCompressionThreshold is the Content size above which senders compress
+const DefaultCompressionThreshold = 64 * 1024
+
+// Compress gzips msg.Content in place when it is longer than threshold and
+// marks it with Metadata["content_encoding"]=gzip. The compressed bytes are
+// base64-encoded because proto3 strings must be valid UTF-8. Content is left
+// untouched if threshold is <= 0 or compression would not make it smaller.
+func Compress(msg *proto.Message, threshold int) error {
+	if threshold <= 0 || len(msg.Content) <= threshold {
+		return nil
+	}
+	if msg.Metadata[ContentEncodingKey] != "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(msg.Content)); err != nil {
+		return fmt.Errorf("failed to compress content: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress content: %w", err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(encoded) >= len(msg.Content) {
+		return nil
+	}
+
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]string)
+	}
+	msg.Content = encoded
+	msg.Metadata[ContentEncodingKey] = Gzip
+	return nil
+}
+
+// Content returns the encoded content of msg: BinaryContent for binary
+// encodings (never compressed), else msg.Content with any compression
+// removed. The message is not modified.
+func Content(msg *proto.Message) (string, error) {
+	if len(msg.BinaryContent) > 0 {
+		return string(msg.BinaryContent), nil
+	}
+	switch msg.Metadata[ContentEncodingKey] {
+	case "":
+		return msg.Content, nil
+	case Gzip:
+		data, err := base64.StdEncoding.DecodeString(msg.Content)
+		if err != nil {
+			return "", fmt.Errorf("invalid gzip content: %w", err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", fmt.Errorf("invalid gzip content: %w", err)
+		}
+		defer zr.Close()
+
+		plain, err := io.ReadAll(zr)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress content: %w", err)
+		}
+		return string(plain), nil
+	default:
+		return "", fmt.Errorf("unsupported content encoding: %s", msg.Metadata[ContentEncodingKey])
+	}
+}
+
+// Decompress replaces compressed msg.Content with the plain content in place
+func Decompress(msg *proto.Message) error {
+	if msg.Metadata[ContentEncodingKey] == "" {
+		return nil
+	}
+	content, err := Content(msg)
+	if err != nil {
+		return err
+	}
+	msg.Content = content
+	delete(msg.Metadata, ContentEncodingKey)
+	return nil
+}
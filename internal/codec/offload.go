@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	protobuf "google.golang.org/protobuf/proto"
+
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+)
+
+// DefaultMaxMessageSize matches gRPC's default receive limit (4MB)
+const DefaultMaxMessageSize = 4 << 20
+
+// ContentFileKey is the message metadata key holding the file_id of
+// content that was too large to send inline. Message.FileId is left alone
+// because it may already reference a file the caller uploaded.
+const ContentFileKey = "content_file_id"
+
+// offloadChunkSize is the UploadFile chunk size used by Offload
+const offloadChunkSize = 64 * 1024
+
+// Offload moves msg.Content (or BinaryContent) into the hub's file storage when the encoded
+// message would exceed limit, which gRPC would otherwise reject on either
+// hop (sender -> hub -> receiver). The content is uploaded with the
+// chunked UploadFile RPC and replaced by Metadata["content_file_id"];
+// receivers restore it with Resolve. Call after Compress so only content
+// that is still too large is uploaded. A limit <= 0 disables offloading.
+// A message with both Content and BinaryContent cannot be offloaded, as
+// only one content comes back.
+func Offload(ctx context.Context, client proto.HubServiceClient, msg *proto.Message, limit int) (bool, error) {
+	if limit <= 0 || (msg.Content == "" && len(msg.BinaryContent) == 0) || protobuf.Size(msg) <= limit {
+		return false, nil
+	}
+	if msg.Content != "" && len(msg.BinaryContent) > 0 {
+		return false, fmt.Errorf("cannot offload message %s: it has both Content and BinaryContent", msg.Id)
+	}
+
+	fileID := utils.GenerateID()
+	stream, err := client.UploadFile(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to start content upload: %w", err)
+	}
+
+	data := []byte(msg.Content)
+	if len(msg.BinaryContent) > 0 {
+		data = msg.BinaryContent
+	}
+	sum := sha256.Sum256(data)
+	for offset := 0; offset < len(data); offset += offloadChunkSize {
+		end := offset + offloadChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := &proto.FileChunk{
+			FileId:    fileID,
+			RequestId: msg.RequestId,
+			Data:      data[offset:end],
+			Offset:    int64(offset),
+			TotalSize: int64(len(data)),
+			IsLast:    end == len(data),
+		}
+		if chunk.IsLast {
+			chunk.Sha256 = hex.EncodeToString(sum[:])
+		}
+		if err := stream.Send(chunk); err != nil {
+			return false, fmt.Errorf("failed to upload content: %w", err)
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return false, fmt.Errorf("failed to upload content: %w", err)
+	}
+	if resp.Status != "success" {
+		return false, fmt.Errorf("failed to upload content: %s", resp.Error)
+	}
+
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]string)
+	}
+	msg.Metadata[ContentFileKey] = fileID
+	msg.Content = ""
+	msg.BinaryContent = nil
+	return true, nil
+}
+
+// Resolve downloads content that the sender offloaded and puts it back
+// with SetContent. Messages without Metadata["content_file_id"] are untouched.
+// Content that does not match the checksum sent by the hub is rejected.
+// Call before Decompress.
+func Resolve(ctx context.Context, client proto.HubServiceClient, msg *proto.Message) error {
+	fileID := msg.Metadata[ContentFileKey]
+	if fileID == "" {
+		return nil
+	}
+
+	stream, err := client.DownloadFile(ctx, &proto.FileDownloadRequest{FileId: fileID})
+	if err != nil {
+		return fmt.Errorf("failed to download content %s: %w", fileID, err)
+	}
+
+	var buf bytes.Buffer
+	var checksum string
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to download content %s: %w", fileID, err)
+		}
+		buf.Write(chunk.Data)
+		if chunk.Sha256 != "" {
+			checksum = chunk.Sha256
+		}
+		if chunk.IsLast {
+			break
+		}
+	}
+
+	if checksum != "" {
+		sum := sha256.Sum256(buf.Bytes())
+		if hex.EncodeToString(sum[:]) != checksum {
+			return fmt.Errorf("content %s is corrupted: sha256 mismatch", fileID)
+		}
+	}
+
+	SetContent(msg, buf.String())
+	delete(msg.Metadata, ContentFileKey)
+	return nil
+}
@@ -0,0 +1,32 @@
+package codec
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	// Registers gRPC's gzip compressor, so the hub accepts gzip-compressed
+	// streams and answers them compressed
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+// Values for the GRPC_COMPRESSION setting of clients and workers. Unlike
+// Compress, which gzips a single large Content, gRPC compression applies
+// to every message on the stream, headers and metadata included.
+const (
+	GRPCCompressionNone = "none"
+	GRPCCompressionGzip = "gzip"
+)
+
+// GRPCCompressor returns the call option enabling gRPC compression with
+// the named compressor, or nil for "" and "none". The hub negotiates per
+// stream: it compresses what it sends only to clients that compress.
+func GRPCCompressor(name string) (grpc.CallOption, error) {
+	if name == "" || name == GRPCCompressionNone {
+		return nil, nil
+	}
+	if encoding.GetCompressor(name) == nil {
+		return nil, fmt.Errorf("unsupported gRPC compression %q (use %s or %s)", name, GRPCCompressionGzip, GRPCCompressionNone)
+	}
+	return grpc.UseCompressor(name), nil
+}
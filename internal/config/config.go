@@ -1,30 +1,417 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Config is the hub configuration. LoadFile reads it from a YAML or JSON
+// file overridden by environment variables; Load from environment
+// variables only. Both fall back to Default for unset values.
 type Config struct {
-	Port     string
-	LogLevel string
-	DBPath   string
+	Port      string
+	LogLevel  string
+	LogFormat string // text or json
+	DBPath    string
+
+	// Where files sent with UploadFile are kept: "local" (FileStorePath)
+	// or a backend registered with hub.RegisterStorageBackend
+	StorageBackend string
+	// Directory for files sent with UploadFile (local backend)
+	FileStorePath string
+	// How long the hub waits for a worker's response before dropping the
+	// request (and counting a failure for the circuit breaker)
+	RequestTimeout time.Duration
+	// How long shutdown waits for in-flight requests before closing the
+	// remaining streams
+	ShutdownTimeout time.Duration
+
+	// Per-client, per-capability request limit (0 disables)
+	RateLimit      float64 // requests per second
+	RateLimitBurst int
+
+	// Goroutines routing outbound messages, sharded by destination so a
+	// slow client does not hold up the others
+	DispatchWorkers int
+	// Messages each routing goroutine buffers, and what happens to a
+	// message whose queue is full: block (wait DispatchBlockTimeout, then
+	// drop), drop (nacking senders that asked for an ack) or reject
+	// (answer the sender with an error)
+	DispatchQueueSize      int
+	DispatchOverflowPolicy string
+	DispatchBlockTimeout   time.Duration
+
+	// Outbound buffering per connection
+	SendPolicy     string // drop_oldest, block or disconnect
+	SendBufferSize int
+	SendTimeout    time.Duration
+
+	// What to do when a client connects with an ID already in use
+	IDCollisionPolicy string // takeover or reject
+
+	// Require streams to authenticate with a token from the credentials table
+	AuthRequired bool
+	// Persist in-flight requests to SQLite so requesters can be told to
+	// retry after a hub restart (costs a write per request and response)
+	PersistRequests bool
+
+	// How a worker is chosen when several offer a capability:
+	// round_robin, least_connections, random or weighted
+	SelectionStrategy string
+
+	// gRPC message size limits in bytes (default 4MB, gRPC's own default).
+	// Clients send larger payloads through UploadFile instead.
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+
+	// Client IDs allowed to use admin control actions (e.g.
+	// list_connections). Empty allows none. Requires AuthRequired: without
+	// it client IDs are not verified.
+	AdminClients []string
+	// Cross-namespace calls allowed, as "from:to" pairs (e.g.
+	// "tenant-a:shared"; "*:public" lets every namespace call "public")
+	NamespaceGrants []string
+	// A request carrying the same idempotency_key as one from the same
+	// client that is still in flight and started within this window waits
+	// for that response instead of being dispatched again (0 disables)
+	DedupWindow time.Duration
+
+	// Stop routing to a worker after this many consecutive failures or
+	// timeouts (0 disables); after the cooldown one request probes it
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Reject a re-registration that changes the input/output schema of a
+	// capability the worker already offered (otherwise only log a warning)
+	StrictSchemas bool
+
+	// Successful responses of capabilities declared cacheable kept in an
+	// LRU cache of this many entries (0 disables the cache)
+	ResultCacheSize int
+
+	// Messages retained per channel and replayed to new subscribers, also
+	// stored in the messages table (0 keeps no history)
+	ChannelHistorySize int
+}
+
+// Default returns the configuration used for unset values
+func Default() *Config {
+	return &Config{
+		Port:            "50051",
+		LogLevel:        "info",
+		LogFormat:       "text",
+		DBPath:          "hub.db",
+		StorageBackend:  "local",
+		FileStorePath:   "/tmp/hub_files",
+		RequestTimeout:  5 * time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		RateLimitBurst:  20,
+		DispatchWorkers: 4,
+		SendPolicy:      "drop_oldest",
+		SendBufferSize:  100,
+		SendTimeout:     5 * time.Second,
+
+		DispatchQueueSize:      100,
+		DispatchOverflowPolicy: "block",
+		DispatchBlockTimeout:   5 * time.Second,
+
+		IDCollisionPolicy: "takeover",
+		SelectionStrategy: "round_robin",
+		MaxRecvMsgSize:    4 << 20,
+		MaxSendMsgSize:    4 << 20,
+		DedupWindow:       30 * time.Second,
+		BreakerThreshold:  5,
+		BreakerCooldown:   30 * time.Second,
+		ResultCacheSize:   1024,
+	}
 }
 
+// Load reads the configuration from environment variables. Unparseable
+// values are ignored and keep their default.
 func Load() *Config {
-	port := getEnv("PORT", "50051")
-	logLevel := getEnv("LOG_LEVEL", "info")
-	dbPath := getEnv("DB_PATH", "hub.db")
+	cfg := Default()
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			s.apply(cfg, value)
+		}
+	}
+	return cfg
+}
 
-	return &Config{
-		Port:     port,
-		LogLevel: logLevel,
-		DBPath:   dbPath,
+// LoadFile reads the configuration from path (.yaml, .yml or .json; empty
+// for none), then environment variables, which take precedence, then
+// defaults. Config file keys are the environment variable names in lower
+// case (port, db_path, rate_limit, ...). Unknown keys, unparseable values
+// and missing required fields are all reported in one error.
+func LoadFile(path string) (*Config, error) {
+	cfg := Default()
+	var problems []string
+
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		known := make(map[string]bool, len(settings))
+		for _, s := range settings {
+			key := s.fileKey()
+			known[key] = true
+			if value, ok := values[key]; ok {
+				if err := s.apply(cfg, value); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+				}
+			}
+		}
+		for key := range values {
+			if !known[key] {
+				problems = append(problems, fmt.Sprintf("%s: unknown setting", key))
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.apply(cfg, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+			}
+		}
+	}
+
+	problems = append(problems, cfg.problems()...)
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return cfg, nil
+}
+
+// problems lists missing required fields and out-of-range values
+func (c *Config) problems() []string {
+	var problems []string
+	required := []struct {
+		key   string
+		value string
+	}{
+		{"port", c.Port},
+		{"log_level", c.LogLevel},
+		{"db_path", c.DBPath},
+		{"storage_backend", c.StorageBackend},
+	}
+	for _, field := range required {
+		if strings.TrimSpace(field.value) == "" {
+			problems = append(problems, field.key+" is required")
+		}
+	}
+
+	if c.Port != "" {
+		if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("port: %q is not a TCP port", c.Port))
+		}
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		problems = append(problems, fmt.Sprintf("log_format: %q is not text or json", c.LogFormat))
+	}
+	if c.RequestTimeout <= 0 {
+		problems = append(problems, "request_timeout must be positive")
+	}
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdown_timeout must be positive")
+	}
+	if c.DispatchWorkers < 1 {
+		problems = append(problems, "dispatch_workers must be at least 1")
+	}
+	if c.DispatchQueueSize < 1 {
+		problems = append(problems, "dispatch_queue_size must be at least 1")
 	}
+	switch c.DispatchOverflowPolicy {
+	case "block", "drop", "reject":
+	default:
+		problems = append(problems, fmt.Sprintf("dispatch_overflow_policy: %q is not block, drop or reject", c.DispatchOverflowPolicy))
+	}
+	if c.DispatchBlockTimeout <= 0 {
+		problems = append(problems, "dispatch_block_timeout must be positive")
+	}
+	if c.RateLimit < 0 || c.RateLimitBurst < 0 || c.MaxRecvMsgSize < 0 || c.MaxSendMsgSize < 0 ||
+		c.DedupWindow < 0 || c.BreakerThreshold < 0 || c.BreakerCooldown < 0 || c.ResultCacheSize < 0 ||
+		c.ChannelHistorySize < 0 {
+		problems = append(problems, "limits, windows and thresholds must not be negative")
+	}
+	if c.StorageBackend == "local" && strings.TrimSpace(c.FileStorePath) == "" {
+		problems = append(problems, "file_store_path is required")
+	}
+	if len(c.AdminClients) > 0 && !c.AuthRequired {
+		problems = append(problems, "admin_clients requires auth_required: client IDs are not verified without it")
+	}
+	for _, grant := range c.NamespaceGrants {
+		if from, to, found := strings.Cut(grant, ":"); !found || strings.TrimSpace(from) == "" || strings.TrimSpace(to) == "" {
+			problems = append(problems, fmt.Sprintf("namespace_grants: %q is not from:to", grant))
+		}
+	}
+	return problems
+}
+
+// readFile decodes a YAML or JSON config file into key -> value strings
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format %q (use .yaml, .yml or .json)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		values[strings.ToLower(key)] = scalar(value)
+	}
+	return values, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// scalar formats a decoded value the way it would be written in an
+// environment variable (lists become comma-separated)
+func scalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = scalar(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
 	}
-	return defaultValue
-}
\ No newline at end of file
+}
+
+// setting is one configuration value, set by an environment variable or
+// by the same name in lower case in a config file
+type setting struct {
+	env   string
+	apply func(c *Config, value string) error
+}
+
+func (s setting) fileKey() string {
+	return strings.ToLower(s.env)
+}
+
+var settings = []setting{
+	{"PORT", stringVar(func(c *Config) *string { return &c.Port })},
+	{"LOG_LEVEL", stringVar(func(c *Config) *string { return &c.LogLevel })},
+	{"LOG_FORMAT", stringVar(func(c *Config) *string { return &c.LogFormat })},
+	{"DB_PATH", stringVar(func(c *Config) *string { return &c.DBPath })},
+	{"STORAGE_BACKEND", stringVar(func(c *Config) *string { return &c.StorageBackend })},
+	{"FILE_STORE_PATH", stringVar(func(c *Config) *string { return &c.FileStorePath })},
+	{"REQUEST_TIMEOUT", durationVar(func(c *Config) *time.Duration { return &c.RequestTimeout })},
+	{"SHUTDOWN_TIMEOUT", durationVar(func(c *Config) *time.Duration { return &c.ShutdownTimeout })},
+	{"RATE_LIMIT", floatVar(func(c *Config) *float64 { return &c.RateLimit })},
+	{"RATE_LIMIT_BURST", intVar(func(c *Config) *int { return &c.RateLimitBurst })},
+	{"SEND_POLICY", stringVar(func(c *Config) *string { return &c.SendPolicy })},
+	{"SEND_BUFFER_SIZE", intVar(func(c *Config) *int { return &c.SendBufferSize })},
+	{"DISPATCH_WORKERS", intVar(func(c *Config) *int { return &c.DispatchWorkers })},
+	{"DISPATCH_QUEUE_SIZE", intVar(func(c *Config) *int { return &c.DispatchQueueSize })},
+	{"DISPATCH_OVERFLOW_POLICY", stringVar(func(c *Config) *string { return &c.DispatchOverflowPolicy })},
+	{"DISPATCH_BLOCK_TIMEOUT", durationVar(func(c *Config) *time.Duration { return &c.DispatchBlockTimeout })},
+	{"SEND_TIMEOUT", durationVar(func(c *Config) *time.Duration { return &c.SendTimeout })},
+	{"ID_COLLISION_POLICY", stringVar(func(c *Config) *string { return &c.IDCollisionPolicy })},
+	{"AUTH_REQUIRED", boolVar(func(c *Config) *bool { return &c.AuthRequired })},
+	{"PERSIST_REQUESTS", boolVar(func(c *Config) *bool { return &c.PersistRequests })},
+	{"SELECTION_STRATEGY", stringVar(func(c *Config) *string { return &c.SelectionStrategy })},
+	{"ADMIN_CLIENTS", listVar(func(c *Config) *[]string { return &c.AdminClients })},
+	{"NAMESPACE_GRANTS", listVar(func(c *Config) *[]string { return &c.NamespaceGrants })},
+	{"MAX_RECV_MSG_SIZE", intVar(func(c *Config) *int { return &c.MaxRecvMsgSize })},
+	{"MAX_SEND_MSG_SIZE", intVar(func(c *Config) *int { return &c.MaxSendMsgSize })},
+	{"DEDUP_WINDOW", durationVar(func(c *Config) *time.Duration { return &c.DedupWindow })},
+	{"BREAKER_THRESHOLD", intVar(func(c *Config) *int { return &c.BreakerThreshold })},
+	{"BREAKER_COOLDOWN", durationVar(func(c *Config) *time.Duration { return &c.BreakerCooldown })},
+	{"STRICT_SCHEMAS", boolVar(func(c *Config) *bool { return &c.StrictSchemas })},
+	{"RESULT_CACHE_SIZE", intVar(func(c *Config) *int { return &c.ResultCacheSize })},
+	{"CHANNEL_HISTORY_SIZE", intVar(func(c *Config) *int { return &c.ChannelHistorySize })},
+}
+
+func stringVar(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func intVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func floatVar(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func durationVar(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration (e.g. 30s, 5m)", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func boolVar(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// listVar reads a comma-separated list, skipping empty entries
+func listVar(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		*field(c) = values
+		return nil
+	}
+}
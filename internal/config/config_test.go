@@ -0,0 +1,35 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if problems := Default().problems(); len(problems) > 0 {
+		t.Fatalf("default configuration is invalid: %v", problems)
+	}
+}
+
+func TestAdminClientsRequireAuth(t *testing.T) {
+	cfg := Default()
+	cfg.AdminClients = []string{"admin"}
+	if !hasProblem(cfg.problems(), "admin_clients") {
+		t.Fatal("admin_clients without auth_required was accepted")
+	}
+
+	cfg.AuthRequired = true
+	if problems := cfg.problems(); len(problems) > 0 {
+		t.Fatalf("admin_clients with auth_required rejected: %v", problems)
+	}
+}
+
+// hasProblem reports whether a problem mentions key
+func hasProblem(problems []string, key string) bool {
+	for _, problem := range problems {
+		if strings.Contains(problem, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS credentials (
+    client_id TEXT PRIMARY KEY,
+    token_hash TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+-- Capability tags (JSON array), used to group and filter capabilities
+ALTER TABLE capabilities ADD COLUMN tags TEXT;
@@ -0,0 +1,3 @@
+-- Deprecated capabilities keep routing but are flagged in discovery and docs
+ALTER TABLE capabilities ADD COLUMN deprecated BOOLEAN DEFAULT 0;
+ALTER TABLE capabilities ADD COLUMN deprecation_message TEXT;
@@ -0,0 +1,2 @@
+-- Structured worker labels (JSON object) used for label-selector routing
+ALTER TABLE workers ADD COLUMN labels TEXT;
@@ -0,0 +1,4 @@
+-- Tenant namespaces: workers register in, and credentials are bound to, a
+-- namespace; existing rows land in 'default'
+ALTER TABLE workers ADD COLUMN namespace TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE credentials ADD COLUMN namespace TEXT NOT NULL DEFAULT 'default';
@@ -0,0 +1,11 @@
+-- Requests routed to a worker and not yet answered (PERSIST_REQUESTS);
+-- reloaded on startup so requesters can be told to retry
+CREATE TABLE IF NOT EXISTS pending_requests (
+    request_id TEXT PRIMARY KEY,
+    requester_id TEXT NOT NULL,
+    worker_id TEXT NOT NULL,
+    capability TEXT NOT NULL,
+    correlation_id TEXT,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL
+);
@@ -0,0 +1,2 @@
+-- Example request (JSON object) shown in Swagger UI's "Try it out"
+ALTER TABLE capabilities ADD COLUMN example TEXT;
@@ -45,6 +45,8 @@ func runMigrations(db *sql.DB) error {
 			type TEXT NOT NULL,
 			status TEXT NOT NULL DEFAULT 'online',
 			metadata TEXT,
+			labels TEXT,
+			namespace TEXT NOT NULL DEFAULT 'default',
 			registered_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			last_seen DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 		)`,
@@ -58,12 +60,35 @@ func runMigrations(db *sql.DB) error {
 			http_method TEXT DEFAULT 'POST',
 			accepts_file BOOLEAN DEFAULT 0,
 			file_field_name TEXT,
+			tags TEXT,
+			deprecated BOOLEAN DEFAULT 0,
+			deprecation_message TEXT,
+			example TEXT,
+			default_timeout_ms INTEGER DEFAULT 0,
+			cacheable BOOLEAN DEFAULT 0,
+			cache_ttl_seconds INTEGER DEFAULT 0,
 			FOREIGN KEY (worker_id) REFERENCES workers(id) ON DELETE CASCADE,
 			UNIQUE(worker_id, name)
 		)`,
+		`CREATE TABLE IF NOT EXISTS credentials (
+			client_id TEXT PRIMARY KEY,
+			token_hash TEXT NOT NULL,
+			namespace TEXT NOT NULL DEFAULT 'default',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS pending_requests (
+			request_id TEXT PRIMARY KEY,
+			requester_id TEXT NOT NULL,
+			worker_id TEXT NOT NULL,
+			capability TEXT NOT NULL,
+			correlation_id TEXT,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_capabilities_name ON capabilities(name)`,
 		`CREATE INDEX IF NOT EXISTS idx_capabilities_worker ON capabilities(worker_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_workers_status ON workers(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_channel ON messages(channel)`,
 	}
 
 	for _, migration := range migrations {
@@ -72,5 +97,57 @@ func runMigrations(db *sql.DB) error {
 		}
 	}
 
+	// Columns added after the initial schema
+	columns := []struct{ table, column, definition string }{
+		{"capabilities", "tags", "TEXT"},
+		{"capabilities", "deprecated", "BOOLEAN DEFAULT 0"},
+		{"capabilities", "deprecation_message", "TEXT"},
+		{"capabilities", "example", "TEXT"},
+		{"capabilities", "default_timeout_ms", "INTEGER DEFAULT 0"},
+		{"capabilities", "cacheable", "BOOLEAN DEFAULT 0"},
+		{"capabilities", "cache_ttl_seconds", "INTEGER DEFAULT 0"},
+		{"workers", "labels", "TEXT"},
+		{"workers", "namespace", "TEXT NOT NULL DEFAULT 'default'"},
+		{"credentials", "namespace", "TEXT NOT NULL DEFAULT 'default'"},
+	}
+	for _, c := range columns {
+		if err := addColumn(db, c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("failed to run migration: %w", err)
+		}
+	}
+
 	return nil
+}
+
+// addColumn adds a column to an existing table unless it is already there
+func addColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
\ No newline at end of file
@@ -0,0 +1,225 @@
+// Package envelope defines where requests and responses keep their
+// capability, payload, correlation ID and error on a proto.Message, so the
+// hub, the worker SDK and clients read and write them the same way.
+// Payloads are encoded with the codec named in Metadata["encoding"] and
+// stay in Content, or in BinaryContent for binary codecs; errors are
+// apierr JSON, also carried in the structured Error field.
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// Metadata keys shared by requests and responses
+const (
+	CapabilityKey        = "capability"
+	CorrelationIDKey     = "correlation_id"
+	RequestIDKey         = "request_id"          // set by workers on responses
+	OriginalMessageIDKey = "original_message_id" // set by the hub on error responses
+	StreamKey            = "stream"              // "true" when the caller relays progress messages
+	AckKey               = "ack"                 // "true" when the sender wants an ACK once the hub queued the message
+	AckIDKey             = "ack_id"              // ID of the message an ACK acknowledges
+)
+
+// ErrNoCapability is returned when a request names no capability
+var ErrNoCapability = errors.New("capability not specified")
+
+// Request is a service request or worker call
+type Request struct {
+	Capability    string
+	Payload       map[string]interface{}
+	CorrelationID string
+}
+
+// legacyRequest is the content of clients that send the capability in the
+// body instead of in metadata
+type legacyRequest struct {
+	Capability string                 `json:"capability" msgpack:"capability"`
+	Payload    map[string]interface{} `json:"payload" msgpack:"payload"`
+}
+
+// Capability returns the capability a request targets: Metadata["capability"],
+// then Channel, then {"capability": ...} in the content of legacy clients
+func Capability(msg *proto.Message) (string, error) {
+	if capability := msg.Metadata[CapabilityKey]; capability != "" {
+		return capability, nil
+	}
+	if msg.Channel != "" {
+		return msg.Channel, nil
+	}
+
+	legacy, err := decodeLegacy(msg)
+	if err != nil {
+		return "", err
+	}
+	return legacy.Capability, nil
+}
+
+// SetCapability addresses msg to capability in both Channel and metadata
+func SetCapability(msg *proto.Message, capability string) {
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]string)
+	}
+	msg.Metadata[CapabilityKey] = capability
+	msg.Channel = capability
+}
+
+// DecodeRequest reads a request. Content is the payload, except for legacy
+// requests where it is the "payload" field next to "capability".
+func DecodeRequest(msg *proto.Message) (*Request, error) {
+	req := &Request{
+		Capability:    msg.Metadata[CapabilityKey],
+		CorrelationID: msg.Metadata[CorrelationIDKey],
+	}
+	if req.Capability == "" {
+		req.Capability = msg.Channel
+	}
+
+	if req.Capability == "" {
+		legacy, err := decodeLegacy(msg)
+		if err != nil {
+			return nil, err
+		}
+		req.Capability = legacy.Capability
+		req.Payload = legacy.Payload
+		return req, nil
+	}
+
+	content, err := codec.Content(msg)
+	if err != nil || content == "" {
+		return req, err
+	}
+	c, err := codec.FromMetadata(msg.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Unmarshal(content, &req.Payload); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// EncodeRequest writes req into msg, encoding the payload with the codec
+// named in msg.Metadata (JSON by default)
+func EncodeRequest(msg *proto.Message, req *Request) error {
+	SetCapability(msg, req.Capability)
+	if req.CorrelationID != "" {
+		msg.Metadata[CorrelationIDKey] = req.CorrelationID
+	}
+
+	c, err := codec.FromMetadata(msg.Metadata)
+	if err != nil {
+		return err
+	}
+	content, err := c.Marshal(req.Payload)
+	if err != nil {
+		return err
+	}
+	codec.SetContent(msg, content)
+	return nil
+}
+
+// ReplyKeys returns the IDs a response may answer, most specific first:
+// RequestId, Metadata["request_id"], Metadata["original_message_id"], Id
+func ReplyKeys(msg *proto.Message) []string {
+	keys := make([]string, 0, 4)
+	for _, key := range []string{msg.RequestId, msg.Metadata[RequestIDKey], msg.Metadata[OriginalMessageIDKey], msg.Id} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// IsResponse reports whether msg answers a request: RESPONSE, or ERROR
+// for failures
+func IsResponse(msg *proto.Message) bool {
+	return msg.Type == proto.MessageType_RESPONSE || msg.Type == proto.MessageType_ERROR
+}
+
+// WantsAck reports whether the sender of msg asked the hub for an ACK
+func WantsAck(msg *proto.Message) bool {
+	return msg.Type != proto.MessageType_ACK && msg.Metadata[AckKey] == "true"
+}
+
+// SetError makes msg a failed response carrying apiErr in the Error field,
+// as apierr JSON in Content for clients that predate the field, and in
+// Metadata["error_code"]. The type is left as is (RESPONSE) so older
+// clients still match the reply.
+func SetError(msg *proto.Message, apiErr *apierr.ErrorResponse) {
+	msg.Error = &proto.Error{Code: string(apiErr.Code), Message: apiErr.Message}
+	if len(apiErr.Details) > 0 {
+		if details, err := json.Marshal(apiErr.Details); err == nil {
+			msg.Error.Details = string(details)
+		}
+	}
+	msg.Content = apiErr.JSON()
+	msg.BinaryContent = nil
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]string)
+	}
+	msg.Metadata[apierr.MetadataKey] = string(apiErr.Code)
+}
+
+// Error returns the error carried by a response: the Error field when set,
+// else the content of responses flagged with Metadata["error_code"], else
+// the content if it parses as an error (workers that predate the flag).
+func Error(msg *proto.Message) (*apierr.ErrorResponse, bool) {
+	if msg.Error != nil && msg.Error.Code != "" {
+		apiErr := apierr.New(apierr.Code(msg.Error.Code), msg.Error.Message)
+		if msg.Error.Details != "" {
+			json.Unmarshal([]byte(msg.Error.Details), &apiErr.Details)
+		}
+		return apiErr, true
+	}
+	if code, flagged := msg.Metadata[apierr.MetadataKey]; flagged {
+		if apiErr, ok := apierr.Parse(msg.Content); ok {
+			return apiErr, true
+		}
+		return apierr.New(apierr.Code(code), msg.Content), true
+	}
+	return apierr.Parse(msg.Content)
+}
+
+// DecodeResponse decodes a response payload into v with the codec named in
+// its metadata. Error responses are returned as *apierr.ErrorResponse.
+func DecodeResponse(msg *proto.Message, v interface{}) error {
+	if apiErr, failed := Error(msg); failed {
+		return apiErr
+	}
+
+	c, err := codec.FromMetadata(msg.Metadata)
+	if err != nil {
+		return err
+	}
+	content, err := codec.Content(msg)
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(content, v)
+}
+
+func decodeLegacy(msg *proto.Message) (*legacyRequest, error) {
+	c, err := codec.FromMetadata(msg.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	content, err := codec.Content(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var legacy legacyRequest
+	if err := c.Unmarshal(content, &legacy); err != nil {
+		return nil, err
+	}
+	if legacy.Capability == "" {
+		return nil, ErrNoCapability
+	}
+	return &legacy, nil
+}
@@ -0,0 +1,121 @@
+package hub
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"deepapp_golang_grpc_hub/internal/proto"
+)
+
+// AuthTokenKey is the metadata key carrying the client's credential
+const AuthTokenKey = "auth_token"
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator validates the first message of a stream (REGISTER or AUTH)
+// and returns the identity bound to the connection
+type Authenticator interface {
+	Authenticate(msg *proto.Message) (string, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface
+type AuthenticatorFunc func(msg *proto.Message) (string, error)
+
+func (f AuthenticatorFunc) Authenticate(msg *proto.Message) (string, error) {
+	return f(msg)
+}
+
+// DBAuthenticator checks auth tokens against the credentials table.
+// Only SHA-256 hashes of tokens are stored.
+type DBAuthenticator struct {
+	db *sql.DB
+}
+
+func NewDBAuthenticator(db *sql.DB) *DBAuthenticator {
+	return &DBAuthenticator{db: db}
+}
+
+func (a *DBAuthenticator) Authenticate(msg *proto.Message) (string, error) {
+	clientID := msg.From
+	token := msg.Metadata[AuthTokenKey]
+	if clientID == "" || token == "" {
+		return "", fmt.Errorf("%w: client id and %s are required", ErrUnauthenticated, AuthTokenKey)
+	}
+
+	var storedHash string
+	err := a.db.QueryRow(`SELECT token_hash FROM credentials WHERE client_id = ?`, clientID).Scan(&storedHash)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: unknown client %s", ErrUnauthenticated, clientID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("credential lookup failed: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(storedHash)) != 1 {
+		return "", fmt.Errorf("%w: invalid token for %s", ErrUnauthenticated, clientID)
+	}
+	return clientID, nil
+}
+
+// SetCredential stores (or replaces) the token for clientID, keeping its
+// namespace
+func (a *DBAuthenticator) SetCredential(clientID, token string) error {
+	_, err := a.db.Exec(`INSERT INTO credentials (client_id, token_hash) VALUES (?, ?)
+		ON CONFLICT(client_id) DO UPDATE SET token_hash = excluded.token_hash`,
+		clientID, hashToken(token))
+	return err
+}
+
+// SetNamespace binds clientID to a namespace; its workers register there and
+// its requests are routed there
+func (a *DBAuthenticator) SetNamespace(clientID, namespace string) error {
+	result, err := a.db.Exec(`UPDATE credentials SET namespace = ? WHERE client_id = ?`,
+		normalizeNamespace(namespace), clientID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("unknown client %s", clientID)
+	}
+	return nil
+}
+
+// Namespace returns the namespace clientID's credential is bound to
+// (implements NamespaceResolver)
+func (a *DBAuthenticator) Namespace(clientID string) (string, error) {
+	var namespace sql.NullString
+	err := a.db.QueryRow(`SELECT namespace FROM credentials WHERE client_id = ?`, clientID).Scan(&namespace)
+	if err != nil {
+		return "", fmt.Errorf("namespace lookup failed: %w", err)
+	}
+	return normalizeNamespace(namespace.String), nil
+}
+
+// RevokeCredential removes clientID's token
+func (a *DBAuthenticator) RevokeCredential(clientID string) error {
+	_, err := a.db.Exec(`DELETE FROM credentials WHERE client_id = ?`, clientID)
+	return err
+}
+
+// isAdmin reports whether clientID may use admin control actions, i.e. is
+// listed in ADMIN_CLIENTS. With none configured no client may. Only the
+// exact ID counts: Invoke's temporary connections (see callerID) carry
+// requests, never control actions, and a stream claiming such an ID gets
+// no rights from the client it names.
+func (s *Server) isAdmin(clientID string) bool {
+	for _, admin := range s.config.AdminClients {
+		if admin == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
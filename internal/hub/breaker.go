@@ -0,0 +1,149 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // worker nhận request bình thường
+	BreakerOpen     = "open"      // worker bị bỏ qua khi chọn worker
+	BreakerHalfOpen = "half_open" // hết cooldown, cho một request thử
+)
+
+// CircuitBreaker ngừng route request tới worker sau threshold lỗi liên
+// tiếp. Sau cooldown, breaker chuyển half-open và cho đúng một request thử:
+// thành công thì đóng lại, lỗi thì mở tiếp một cooldown nữa.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	workers   map[string]*workerBreaker // chỉ worker có lỗi gần đây
+}
+
+type workerBreaker struct {
+	failures int       // lỗi liên tiếp
+	open     bool      // đã vượt threshold
+	openedAt time.Time // lần mở (hoặc probe lỗi) gần nhất
+	probing  bool      // đang có request thử khi half-open
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		workers:   make(map[string]*workerBreaker),
+	}
+}
+
+// State trả về trạng thái breaker của worker
+func (cb *CircuitBreaker) State(workerID string) string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state(cb.workers[workerID])
+}
+
+// Ready cho biết worker có được chọn không: breaker đóng, hoặc half-open
+// và chưa có request thử
+func (cb *CircuitBreaker) Ready(workerID string) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.workers[workerID]
+	switch cb.state(b) {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		return !b.probing
+	default:
+		return false
+	}
+}
+
+// Acquire ghi nhận worker vừa được chọn; khi half-open request này là
+// request thử
+func (cb *CircuitBreaker) Acquire(workerID string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if b := cb.workers[workerID]; cb.state(b) == BreakerHalfOpen {
+		b.probing = true
+	}
+}
+
+// Release kết thúc request thử mà không tính thành công hay lỗi (vd: worker
+// trả WORKER_BUSY): breaker vẫn half-open và request sau được thử tiếp
+func (cb *CircuitBreaker) Release(workerID string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if b := cb.workers[workerID]; b != nil {
+		b.probing = false
+	}
+}
+
+// RecordSuccess đóng breaker của worker
+func (cb *CircuitBreaker) RecordSuccess(workerID string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	delete(cb.workers, workerID)
+}
+
+// RecordFailure đếm một lỗi và trả về true nếu breaker vừa mở
+func (cb *CircuitBreaker) RecordFailure(workerID string) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b, exists := cb.workers[workerID]
+	if !exists {
+		b = &workerBreaker{}
+		cb.workers[workerID] = b
+	}
+	b.failures++
+	b.probing = false
+
+	if b.open {
+		// Request thử lỗi: mở thêm một cooldown
+		b.openedAt = time.Now()
+		return false
+	}
+	if b.failures >= cb.threshold {
+		b.open = true
+		b.openedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// Remove quên trạng thái của worker (khi worker gỡ đăng ký)
+func (cb *CircuitBreaker) Remove(workerID string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	delete(cb.workers, workerID)
+}
+
+// States trả về trạng thái các breaker không đóng (worker_id -> state)
+func (cb *CircuitBreaker) States() map[string]string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	states := make(map[string]string)
+	for workerID, b := range cb.workers {
+		if state := cb.state(b); state != BreakerClosed {
+			states[workerID] = state
+		}
+	}
+	return states
+}
+
+// state tính trạng thái hiện tại (caller giữ cb.mu)
+func (cb *CircuitBreaker) state(b *workerBreaker) string {
+	if b == nil || !b.open {
+		return BreakerClosed
+	}
+	if time.Since(b.openedAt) >= cb.cooldown {
+		return BreakerHalfOpen
+	}
+	return BreakerOpen
+}
@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// openBreaker returns a breaker for w1 that is half-open: opened by one
+// failure, cooldown already over
+func openBreaker(t *testing.T) *CircuitBreaker {
+	t.Helper()
+	cb := NewCircuitBreaker(1, time.Millisecond)
+	if !cb.RecordFailure("w1") {
+		t.Fatal("breaker did not open at the threshold")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if state := cb.State("w1"); state != BreakerHalfOpen {
+		t.Fatalf("state after cooldown: %s, want %s", state, BreakerHalfOpen)
+	}
+	return cb
+}
+
+func TestBreakerOpensAndCloses(t *testing.T) {
+	cb := NewCircuitBreaker(2, time.Hour)
+	cb.RecordFailure("w1")
+	if !cb.Ready("w1") {
+		t.Fatal("breaker opened below the threshold")
+	}
+	if !cb.RecordFailure("w1") || cb.Ready("w1") || cb.State("w1") != BreakerOpen {
+		t.Fatalf("breaker not open after threshold failures: %s", cb.State("w1"))
+	}
+	cb.RecordSuccess("w1")
+	if !cb.Ready("w1") || cb.State("w1") != BreakerClosed {
+		t.Fatalf("breaker not closed after success: %s", cb.State("w1"))
+	}
+}
+
+func TestBreakerHalfOpenAllowsOneProbe(t *testing.T) {
+	cb := openBreaker(t)
+	if !cb.Ready("w1") {
+		t.Fatal("half-open breaker refuses the probe")
+	}
+	cb.Acquire("w1")
+	if cb.Ready("w1") {
+		t.Fatal("half-open breaker allows a second request during the probe")
+	}
+
+	cb.RecordFailure("w1")
+	if state := cb.State("w1"); state != BreakerOpen {
+		t.Fatalf("state after failed probe: %s, want %s", state, BreakerOpen)
+	}
+}
+
+func TestBreakerReleaseEndsProbe(t *testing.T) {
+	cb := openBreaker(t)
+	cb.Acquire("w1")
+	cb.Release("w1")
+	if !cb.Ready("w1") || cb.State("w1") != BreakerHalfOpen {
+		t.Fatalf("released probe: ready %v, state %s; want a new probe allowed", cb.Ready("w1"), cb.State("w1"))
+	}
+}
+
+// A WORKER_BUSY answer to the half-open probe must not leave the worker
+// unselectable
+func TestBusyProbeKeepsWorkerSelectable(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.BreakerThreshold = 1
+		cfg.BreakerCooldown = time.Millisecond
+	})
+	h.connectWorker(t, "w1", "", ServiceCapability{Name: "echo"})
+
+	h.registry.RecordWorkerResult("w1", true)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := h.registry.SelectWorkerIn(DefaultNamespace, "echo", "", nil); err != nil {
+		t.Fatalf("probe selection: %v", err)
+	}
+
+	busy := &proto.Message{From: "w1", Type: proto.MessageType_RESPONSE}
+	envelope.SetError(busy, apierr.New(apierr.CodeWorkerBusy, "busy"))
+	h.recordWorkerResult("w1", busy)
+
+	if _, err := h.registry.SelectWorkerIn(DefaultNamespace, "echo", "", nil); err != nil {
+		t.Fatalf("selection after busy probe: %v", err)
+	}
+}
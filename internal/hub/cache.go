@@ -0,0 +1,199 @@
+package hub
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	protobuf "google.golang.org/protobuf/proto"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/proto"
+)
+
+// DefaultCacheTTL là thời gian giữ kết quả của capability cacheable không
+// khai báo cache_ttl_seconds
+const DefaultCacheTTL = 5 * time.Minute
+
+// maxCachedResponseBytes: response lớn hơn không được cache
+const maxCachedResponseBytes = 1 << 20
+
+// Metadata của result cache
+const (
+	CacheBypassMetadata = "cache_bypass" // "true": bỏ qua cache, luôn gọi worker
+	CacheMetadata       = "cache"        // "hit" trên response lấy từ cache
+)
+
+// ResultCache là LRU cache response thành công của capability cacheable,
+// key theo capability + hash nội dung request, mỗi entry có TTL riêng
+type ResultCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List               // front = dùng gần nhất
+	entries  map[string]*list.Element // key -> *cacheEntry
+
+	hits      int64
+	misses    int64
+	evictions int64
+	perCap    map[string]*CapabilityCacheStats
+}
+
+type cacheEntry struct {
+	key        string
+	capability string
+	response   *proto.Message
+	expiresAt  time.Time
+}
+
+// CapabilityCacheStats là số hit/miss của một capability
+type CapabilityCacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// CacheStats là thống kê của result cache
+type CacheStats struct {
+	Entries      int                             `json:"entries"`
+	Capacity     int                             `json:"capacity"`
+	Hits         int64                           `json:"hits"`
+	Misses       int64                           `json:"misses"`
+	Evictions    int64                           `json:"evictions"`
+	HitRatio     float64                         `json:"hit_ratio"`
+	Capabilities map[string]CapabilityCacheStats `json:"capabilities"`
+}
+
+// NewResultCache tạo cache giữ tối đa capacity response; capacity <= 0 tắt
+// cache (Get luôn miss, Put không làm gì)
+func NewResultCache(capacity int) *ResultCache {
+	return &ResultCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		perCap:   make(map[string]*CapabilityCacheStats),
+	}
+}
+
+// Enabled cho biết cache có được bật không
+func (c *ResultCache) Enabled() bool {
+	return c != nil && c.capacity > 0
+}
+
+// Get trả về response đã cache cho key (entry hết hạn bị xóa), và ghi hit
+// hoặc miss cho capability
+func (c *ResultCache) Get(key, capability string) (*proto.Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.capabilityStats(capability)
+	if element, found := c.entries[key]; found {
+		entry := element.Value.(*cacheEntry)
+		if time.Now().Before(entry.expiresAt) {
+			c.order.MoveToFront(element)
+			c.hits++
+			stats.Hits++
+			return entry.response, true
+		}
+		c.remove(element)
+	}
+	c.misses++
+	stats.Misses++
+	return nil, false
+}
+
+// Put lưu bản sao response (có metadata cache=hit) cho key trong ttl, bỏ
+// entry dùng lâu nhất khi cache đầy. Response lớn hơn
+// maxCachedResponseBytes không được lưu.
+func (c *ResultCache) Put(key, capability string, response *proto.Message, ttl time.Duration) {
+	if !c.Enabled() || ttl <= 0 || protobuf.Size(response) > maxCachedResponseBytes {
+		return
+	}
+	cached := protobuf.Clone(response).(*proto.Message)
+	if cached.Metadata == nil {
+		cached.Metadata = make(map[string]string)
+	}
+	cached.Metadata[CacheMetadata] = "hit"
+	entry := &cacheEntry{
+		key:        key,
+		capability: capability,
+		response:   cached,
+		expiresAt:  time.Now().Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.entries[key]; found {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+// Stats trả về thống kê hiện tại của cache
+func (c *ResultCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Entries:      c.order.Len(),
+		Capacity:     c.capacity,
+		Hits:         c.hits,
+		Misses:       c.misses,
+		Evictions:    c.evictions,
+		Capabilities: make(map[string]CapabilityCacheStats, len(c.perCap)),
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRatio = float64(c.hits) / float64(total)
+	}
+	for capability, capStats := range c.perCap {
+		stats.Capabilities[capability] = *capStats
+	}
+	return stats
+}
+
+// remove xóa entry (caller giữ c.mu)
+func (c *ResultCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// capabilityStats trả về bộ đếm của capability (caller giữ c.mu)
+func (c *ResultCache) capabilityStats(capability string) *CapabilityCacheStats {
+	stats, exists := c.perCap[capability]
+	if !exists {
+		stats = &CapabilityCacheStats{}
+		c.perCap[capability] = stats
+	}
+	return stats
+}
+
+// cacheKey là key cache của request: namespace, capability, worker được chỉ
+// định, label selector và hash nội dung request (JSON được chuẩn hóa nên
+// thứ tự key không ảnh hưởng). ok là false khi không đọc được nội dung.
+func cacheKey(namespace, capability, target string, msg *proto.Message) (string, bool) {
+	content, err := codec.Content(msg)
+	if err != nil {
+		return "", false
+	}
+	codecName := "json"
+	if c, err := codec.FromMetadata(msg.Metadata); err == nil {
+		codecName = c.Name()
+	}
+	if codecName == "json" {
+		content = canonicalSchema(content)
+	}
+
+	hash := sha256.New()
+	for _, part := range []string{codecName, msg.Metadata[codec.ContentFileKey], msg.Metadata[LabelSelectorMetadata], content} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return namespace + "|" + capability + "|" + target + "|" + hex.EncodeToString(hash.Sum(nil)), true
+}
@@ -0,0 +1,226 @@
+package hub
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	protobuf "google.golang.org/protobuf/proto"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/models"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// HistoryMetadata = "true" đánh dấu message được gửi lại từ history của
+// channel khi client subscribe, không phải message mới publish
+const HistoryMetadata = "history"
+
+// ChannelStore lưu history của channel (CHANNEL_HISTORY_SIZE) để giữ lại
+// qua các lần khởi động lại hub
+type ChannelStore interface {
+	Save(msg *models.Message) error
+	GetRecentByChannel(channel string, limit int) ([]*models.Message, error)
+	TrimChannel(channel string, keep int) error
+}
+
+// ChannelInfo là một channel trong ChannelManager.Channels
+type ChannelInfo struct {
+	Channel     string `json:"channel"`
+	Subscribers int    `json:"subscribers"`
+	Retained    int    `json:"retained"` // số message trong history
+}
+
+// ChannelManager là SubscriberManager kèm history: giữ historySize message
+// cuối của mỗi channel và gửi lại chúng cho client khi subscribe (Join).
+// Publish và Join chạy dưới cùng lock nên subscriber mới nhận mỗi message
+// đúng một lần, từ history hoặc từ Publish.
+type ChannelManager struct {
+	*SubscriberManager
+
+	mu          sync.Mutex
+	historySize int                         // 0 = không giữ history
+	history     map[string][]*proto.Message // channel -> message cũ nhất trước
+	loaded      map[string]bool             // channel đã đọc history từ store
+	store       ChannelStore                // nil = chỉ giữ trong bộ nhớ
+}
+
+func NewChannelManager(subMgr *SubscriberManager, historySize int) *ChannelManager {
+	return &ChannelManager{
+		SubscriberManager: subMgr,
+		historySize:       historySize,
+		history:           make(map[string][]*proto.Message),
+		loaded:            make(map[string]bool),
+	}
+}
+
+// SetStore lưu history vào store; history đã lưu được đọc lại khi channel
+// được dùng lần đầu
+func (cm *ChannelManager) SetStore(store ChannelStore) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.store = store
+}
+
+// HistorySize trả về số message giữ lại mỗi channel
+func (cm *ChannelManager) HistorySize() int {
+	return cm.historySize
+}
+
+// Publish ghi msg vào history của channel rồi gửi tới mọi subscriber, trả
+// về lỗi theo client ID
+func (cm *ChannelManager) Publish(channel string, msg *proto.Message) map[string]error {
+	if cm.historySize <= 0 {
+		return cm.SubscriberManager.Publish(channel, msg)
+	}
+
+	cm.mu.Lock()
+	history := append(cm.channelHistory(channel), protobuf.Clone(msg).(*proto.Message))
+	if len(history) > cm.historySize {
+		history = history[len(history)-cm.historySize:]
+	}
+	cm.history[channel] = history
+	subscribers := cm.Subscribers(channel)
+	store := cm.store
+	cm.mu.Unlock()
+
+	if store != nil {
+		cm.persist(store, channel, msg)
+	}
+
+	failed := make(map[string]error)
+	for _, clientID := range subscribers {
+		if err := cm.connMgr.Send(clientID, msg); err != nil {
+			failed[clientID] = err
+		}
+	}
+	return failed
+}
+
+// Join subscribe client vào channel và gửi lại history của channel (đánh
+// dấu HistoryMetadata), trả về số message đã gửi lại
+func (cm *ChannelManager) Join(channel, clientID string) int {
+	if cm.historySize <= 0 {
+		cm.Subscribe(channel, clientID)
+		return 0
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.Subscribe(channel, clientID)
+
+	replayed := 0
+	for _, msg := range cm.channelHistory(channel) {
+		replay := protobuf.Clone(msg).(*proto.Message)
+		replay.To = clientID
+		if replay.Metadata == nil {
+			replay.Metadata = make(map[string]string)
+		}
+		replay.Metadata[HistoryMetadata] = "true"
+		if err := cm.connMgr.Send(clientID, replay); err != nil {
+			logger.Emoji("⚠️").WithError(err).WithFields(logger.Fields{"client_id": clientID, "channel": channel}).Warn("failed to replay channel history")
+			break
+		}
+		replayed++
+	}
+	return replayed
+}
+
+// Channels liệt kê các channel có subscriber hoặc history, sorted
+func (cm *ChannelManager) Channels() []ChannelInfo {
+	counts := cm.SubscriberManager.Channels()
+
+	cm.mu.Lock()
+	retained := make(map[string]int, len(cm.history))
+	for channel, history := range cm.history {
+		retained[channel] = len(history)
+	}
+	cm.mu.Unlock()
+
+	names := make(map[string]bool, len(counts)+len(retained))
+	for channel := range counts {
+		names[channel] = true
+	}
+	for channel := range retained {
+		names[channel] = true
+	}
+
+	channels := make([]ChannelInfo, 0, len(names))
+	for channel := range names {
+		channels = append(channels, ChannelInfo{
+			Channel:     channel,
+			Subscribers: counts[channel],
+			Retained:    retained[channel],
+		})
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Channel < channels[j].Channel
+	})
+	return channels
+}
+
+// channelHistory trả về history của channel, đọc từ store ở lần đầu
+// (caller giữ cm.mu)
+func (cm *ChannelManager) channelHistory(channel string) []*proto.Message {
+	if cm.store == nil || cm.loaded[channel] {
+		return cm.history[channel]
+	}
+	cm.loaded[channel] = true
+
+	stored, err := cm.store.GetRecentByChannel(channel, cm.historySize)
+	if err != nil {
+		logger.Emoji("⚠️").WithError(err).WithField("channel", channel).Warn("failed to load channel history")
+		return cm.history[channel]
+	}
+	history := make([]*proto.Message, 0, len(stored)+len(cm.history[channel]))
+	for _, m := range stored {
+		history = append(history, &proto.Message{
+			Id:        m.ID,
+			From:      m.From,
+			Type:      proto.MessageType_CHANNEL,
+			Channel:   m.Channel,
+			Content:   m.Content,
+			Timestamp: m.Timestamp.Format(time.RFC3339),
+		})
+	}
+	history = append(history, cm.history[channel]...)
+	if len(history) > cm.historySize {
+		history = history[len(history)-cm.historySize:]
+	}
+	cm.history[channel] = history
+	return history
+}
+
+// persist lưu msg vào store và xóa các message cũ hơn historySize
+func (cm *ChannelManager) persist(store ChannelStore, channel string, msg *proto.Message) {
+	content, err := codec.Content(msg)
+	if err != nil {
+		content = msg.Content
+	}
+	timestamp, err := time.Parse(time.RFC3339, msg.Timestamp)
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	fields := logger.Fields{"msg_id": msg.Id, "channel": channel}
+	err = store.Save(&models.Message{
+		ID:        msg.Id,
+		From:      msg.From,
+		Channel:   channel,
+		Content:   content,
+		Timestamp: timestamp,
+	})
+	if err != nil {
+		logger.Emoji("⚠️").WithError(err).WithFields(fields).Warn("failed to store channel message")
+		return
+	}
+	if err := store.TrimChannel(channel, cm.historySize); err != nil {
+		logger.Emoji("⚠️").WithError(err).WithFields(fields).Warn("failed to trim channel history")
+	}
+}
+
+// SetChannelStore lưu history của channel vào store (CHANNEL_HISTORY_SIZE > 0)
+func (s *Server) SetChannelStore(store ChannelStore) {
+	s.channels.SetStore(store)
+}
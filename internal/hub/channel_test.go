@@ -0,0 +1,183 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// subscription sends a SUBSCRIBE or UNSUBSCRIBE for channel and returns the
+// hub's reply
+func (c *testClient) subscription(typ proto.MessageType, channel string) map[string]interface{} {
+	c.t.Helper()
+	sent := c.send(&proto.Message{To: "hub", Type: typ, Channel: channel})
+	for {
+		reply := c.next()
+		if reply.Id != sent.Id {
+			continue
+		}
+		if code := errorCode(reply); code != "" {
+			c.t.Fatalf("%s %s %s: %s", c.id, typ, channel, code)
+		}
+		var content map[string]interface{}
+		json.Unmarshal([]byte(reply.Content), &content)
+		return content
+	}
+}
+
+// publish sends content to channel
+func (c *testClient) publish(channel, content string) {
+	c.t.Helper()
+	c.send(&proto.Message{Type: proto.MessageType_CHANNEL, Channel: channel, Content: content})
+}
+
+// waitSubscribers waits until channel has exactly want subscribers
+func waitSubscribers(t *testing.T, h *testHub, channel string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for len(h.channels.Subscribers(channel)) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s has subscribers %v, want %d", channel, h.channels.Subscribers(channel), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestChannelSubscribeAndUnsubscribe(t *testing.T) {
+	h := newTestHub(t, nil)
+	a := h.connectClient(t, "a", nil)
+	b := h.connectClient(t, "b", nil)
+	publisher := h.connectClient(t, "publisher", nil)
+
+	for _, c := range []*testClient{a, b} {
+		if reply := c.subscription(proto.MessageType_SUBSCRIBE, "news"); reply["status"] != "subscribed" || reply["channel"] != "news" {
+			t.Fatalf("%s subscribe reply: %v", c.id, reply)
+		}
+	}
+	publisher.publish("news", "first")
+	for _, c := range []*testClient{a, b} {
+		if msg := c.next(); msg.Channel != "news" || msg.Content != "first" {
+			t.Fatalf("%s got %s %q on %q", c.id, msg.Type, msg.Content, msg.Channel)
+		}
+	}
+	publisher.expectNothing(50 * time.Millisecond)
+
+	if reply := b.subscription(proto.MessageType_UNSUBSCRIBE, "news"); reply["status"] != "unsubscribed" {
+		t.Fatalf("unsubscribe reply: %v", reply)
+	}
+	publisher.publish("news", "second")
+	if msg := a.next(); msg.Content != "second" {
+		t.Fatalf("a got %q, want second", msg.Content)
+	}
+	b.expectNothing(50 * time.Millisecond)
+}
+
+func TestChannelSubscriptionEndsOnDisconnect(t *testing.T) {
+	h := newTestHub(t, nil)
+	a := h.connectClient(t, "a", nil)
+	b := h.connectClient(t, "b", nil)
+	a.subscription(proto.MessageType_SUBSCRIBE, "news")
+	a.subscription(proto.MessageType_SUBSCRIBE, "sports")
+	b.subscription(proto.MessageType_SUBSCRIBE, "news")
+
+	a.disconnect()
+	waitSubscribers(t, h, "news", 1)
+	waitSubscribers(t, h, "sports", 0)
+
+	// Nothing is published to the closed stream
+	b.publish("sports", "goal")
+	b.publish("news", "headline")
+	if msg := b.next(); msg.Content != "headline" {
+		t.Fatalf("b got %q, want headline", msg.Content)
+	}
+	if dead := h.router.DeadLetterCount(); dead != 0 {
+		t.Errorf("%d messages sent to the disconnected subscriber", dead)
+	}
+}
+
+func TestChannelSubscriptionWithoutChannel(t *testing.T) {
+	h := newTestHub(t, nil)
+	c := h.connectClient(t, "c", nil)
+	sent := c.send(&proto.Message{To: "hub", Type: proto.MessageType_SUBSCRIBE})
+	reply := c.next()
+	if code := errorCode(reply); reply.Id != sent.Id || code != apierr.CodeValidation {
+		t.Fatalf("subscribe without a channel: got %q, want %s", code, apierr.CodeValidation)
+	}
+}
+
+// A late subscriber gets the last ChannelHistorySize messages, marked as
+// history, before its subscribe reply, then only new messages
+func TestChannelHistoryReplayedToLateSubscriber(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.ChannelHistorySize = 3
+		cfg.AdminClients = []string{"admin"}
+	})
+	early := h.connectClient(t, "early", nil)
+	publisher := h.connectClient(t, "publisher", nil)
+	early.subscription(proto.MessageType_SUBSCRIBE, "news")
+	for i := 1; i <= 5; i++ {
+		publisher.publish("news", fmt.Sprintf("m%d", i))
+	}
+	for i := 1; i <= 5; i++ {
+		if msg := early.next(); msg.Content != fmt.Sprintf("m%d", i) || msg.Metadata[HistoryMetadata] != "" {
+			t.Fatalf("early subscriber got %q (history %q), want live m%d", msg.Content, msg.Metadata[HistoryMetadata], i)
+		}
+	}
+
+	late := h.connectClient(t, "late", nil)
+	sent := late.send(&proto.Message{To: "hub", Type: proto.MessageType_SUBSCRIBE, Channel: "news"})
+	for i := 3; i <= 5; i++ {
+		msg := late.next()
+		if msg.Content != fmt.Sprintf("m%d", i) || msg.Channel != "news" || msg.Metadata[HistoryMetadata] != "true" {
+			t.Fatalf("late subscriber got %q on %q (history %q), want m%d from history", msg.Content, msg.Channel, msg.Metadata[HistoryMetadata], i)
+		}
+	}
+	reply := late.next()
+	var content map[string]interface{}
+	json.Unmarshal([]byte(reply.Content), &content)
+	if reply.Id != sent.Id || content["status"] != "subscribed" || content["history"] != float64(3) {
+		t.Fatalf("subscribe reply after history: %s", reply.Content)
+	}
+
+	publisher.publish("news", "m6")
+	for _, c := range []*testClient{early, late} {
+		if msg := c.next(); msg.Content != "m6" || msg.Metadata[HistoryMetadata] != "" {
+			t.Fatalf("%s got %q (history %q), want live m6", c.id, msg.Content, msg.Metadata[HistoryMetadata])
+		}
+	}
+	late.expectNothing(50 * time.Millisecond)
+
+	// Admins see each channel's subscribers and retained messages
+	if code := errorCode(publisher.control(ControlActionListChannels, map[string]string{})); code != apierr.CodeForbidden {
+		t.Fatalf("list_channels by non-admin: got %q, want %s", code, apierr.CodeForbidden)
+	}
+	admin := h.connectClient(t, "admin", nil)
+	var listed struct {
+		Channels    []ChannelInfo `json:"channels"`
+		HistorySize int           `json:"history_size"`
+	}
+	json.Unmarshal([]byte(admin.control(ControlActionListChannels, map[string]string{}).Content), &listed)
+	want := ChannelInfo{Channel: "news", Subscribers: 2, Retained: 3}
+	if len(listed.Channels) != 1 || listed.Channels[0] != want || listed.HistorySize != 3 {
+		t.Fatalf("list_channels: %+v, want %+v with history size 3", listed, want)
+	}
+}
+
+func TestChannelWithoutHistory(t *testing.T) {
+	h := newTestHub(t, nil)
+	early := h.connectClient(t, "early", nil)
+	early.subscription(proto.MessageType_SUBSCRIBE, "news")
+	early.publish("news", "m1")
+	early.next()
+
+	late := h.connectClient(t, "late", nil)
+	if reply := late.subscription(proto.MessageType_SUBSCRIBE, "news"); reply["history"] != float64(0) {
+		t.Fatalf("subscribe reply without history: %v", reply)
+	}
+	late.expectNothing(50 * time.Millisecond)
+}
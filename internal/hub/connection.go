@@ -1,39 +1,264 @@
 package hub
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/logger"
 )
 
+// SendPolicy decides what happens when a connection's outbound buffer is full
+type SendPolicy string
+
+const (
+	SendPolicyDropOldest SendPolicy = "drop_oldest" // discard the oldest queued message
+	SendPolicyBlock      SendPolicy = "block"       // wait up to the send timeout
+	SendPolicyDisconnect SendPolicy = "disconnect"  // treat the consumer as dead
+)
+
+// CollisionPolicy decides what happens when a client connects with an ID
+// that already has a live connection
+type CollisionPolicy string
+
+const (
+	CollisionTakeover CollisionPolicy = "takeover" // evict the old connection
+	CollisionReject   CollisionPolicy = "reject"   // refuse the newcomer
+)
+
+// Connection types reported by List. Streams start as clients; a REGISTER
+// makes them workers and gateways declare themselves with ClientTypeMetadata.
+const (
+	ConnectionTypeClient  = "client"
+	ConnectionTypeWorker  = "worker"
+	ConnectionTypeGateway = "api-gateway"
+)
+
+// ClientTypeMetadata is the message metadata key a gateway (e.g. the web
+// API) sets to be listed as ConnectionTypeGateway
+const ClientTypeMetadata = "client_type"
+
+// ConnectionInfo describes a live connection for the admin API
+type ConnectionInfo struct {
+	ClientID     string    `json:"client_id"`
+	Type         string    `json:"type"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastSeen     time.Time `json:"last_seen"`
+	Namespace    string    `json:"namespace"`
+	WorkerStatus string    `json:"worker_status,omitempty"` // registry status, for workers
+}
+
+var (
+	ErrClientIDInUse      = errors.New("client id already connected")
+	ErrEvicted            = errors.New("connection replaced by a newer connection with the same id")
+	ErrKicked             = errors.New("connection closed by an administrator")
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrConnectionClosed   = errors.New("connection closed")
+	ErrSendTimeout        = errors.New("send timed out: outbound buffer full")
+	ErrBufferFull         = errors.New("outbound buffer full")
+)
+
+// connection owns a client stream and serializes all sends through outbox:
+// writeLoop is the only goroutine calling stream.Send, as gRPC requires
+type connection struct {
+	clientID string
+	stream   proto.HubService_ConnectServer
+	outbox   chan *proto.Message
+	done     chan struct{} // closed when the connection is removed
+	stopped  chan struct{} // closed when writeLoop has returned
+	errs     chan error    // receives the first send failure
+
+	connectedAt time.Time
+	lastSeen    atomic.Int64 // unix nanos of the last received message
+	connType    atomic.Value // string, one of the ConnectionType constants
+	namespace   atomic.Value // string, see SetNamespace
+
+	closeOnce sync.Once
+	failOnce  sync.Once
+}
+
+func (c *connection) writeLoop() {
+	defer close(c.stopped)
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.outbox:
+			if err := c.stream.Send(msg); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// fail reports a send failure once so the stream owner can evict the connection
+func (c *connection) fail(err error) {
+	c.failOnce.Do(func() {
+		c.errs <- err
+	})
+}
+
+func (c *connection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// wait blocks until writeLoop has returned, at most timeout. A Send
+// blocked on a client that stopped reading only returns once the stream's
+// handler has ended, so the wait has to give up eventually.
+func (c *connection) wait(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.stopped:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
 type ConnectionManager struct {
 	mu          sync.RWMutex
-	connections map[string]proto.HubService_ConnectServer
+	connections map[string]*connection
+	// Every stream whose handler has not called RemoveStream yet,
+	// including streams taken over by a newer connection
+	streams map[proto.HubService_ConnectServer]*connection
+
+	policy      SendPolicy
+	bufferSize  int
+	sendTimeout time.Duration
+	collision   CollisionPolicy
 }
 
 func NewConnectionManager() *ConnectionManager {
+	return NewConnectionManagerWithPolicy(SendPolicyDropOldest, 100, 5*time.Second)
+}
+
+func NewConnectionManagerWithPolicy(policy SendPolicy, bufferSize int, sendTimeout time.Duration) *ConnectionManager {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	switch policy {
+	case SendPolicyDropOldest, SendPolicyBlock, SendPolicyDisconnect:
+	default:
+		policy = SendPolicyDropOldest
+	}
+
 	return &ConnectionManager{
-		connections: make(map[string]proto.HubService_ConnectServer),
+		connections: make(map[string]*connection),
+		streams:     make(map[proto.HubService_ConnectServer]*connection),
+		policy:      policy,
+		bufferSize:  bufferSize,
+		sendTimeout: sendTimeout,
+		collision:   CollisionTakeover,
 	}
 }
 
-func (cm *ConnectionManager) Add(clientID string, stream proto.HubService_ConnectServer) {
+// SetCollisionPolicy sets how duplicate client IDs are handled
+func (cm *ConnectionManager) SetCollisionPolicy(policy CollisionPolicy) {
+	switch policy {
+	case CollisionTakeover, CollisionReject:
+	default:
+		policy = CollisionTakeover
+	}
 	cm.mu.Lock()
-	defer cm.mu.Unlock()
-	cm.connections[clientID] = stream
+	cm.collision = policy
+	cm.mu.Unlock()
+}
+
+// Add registers a stream and starts its writer goroutine. The returned
+// channel receives an error if sending to the client fails, so the caller
+// can tear the stream down promptly.
+//
+// If clientID is already connected, the collision policy applies: reject
+// returns ErrClientIDInUse; takeover evicts the old connection, whose
+// error channel receives ErrEvicted.
+func (cm *ConnectionManager) Add(clientID string, stream proto.HubService_ConnectServer) (<-chan error, error) {
+	conn := &connection{
+		clientID: clientID,
+		stream:   stream,
+		outbox:   make(chan *proto.Message, cm.bufferSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		errs:     make(chan error, 1),
+
+		connectedAt: time.Now(),
+	}
+	conn.lastSeen.Store(conn.connectedAt.UnixNano())
+	conn.connType.Store(ConnectionTypeClient)
+	conn.namespace.Store(DefaultNamespace)
+
+	cm.mu.Lock()
+	if old, exists := cm.connections[clientID]; exists {
+		if cm.collision == CollisionReject {
+			cm.mu.Unlock()
+			logger.Emoji("⚠️").WithFields(logger.Fields{"client_id": clientID, "policy": "reject"}).Warn("ID collision, rejecting new stream")
+			return nil, ErrClientIDInUse
+		}
+		logger.Emoji("⚠️").WithFields(logger.Fields{"client_id": clientID, "policy": "takeover"}).Warn("ID collision, evicting old stream")
+		old.fail(ErrEvicted)
+		old.close()
+	}
+	cm.connections[clientID] = conn
+	cm.streams[stream] = conn
+	cm.mu.Unlock()
+
+	go conn.writeLoop()
+	return conn.errs, nil
+}
+
+// RemoveStream removes clientID only if it is still served by stream, so a
+// connection that was taken over does not remove its replacement. It
+// reports whether the connection was removed. Either way the writer of
+// stream is stopped and waited for (at most the send timeout), since gRPC
+// forbids Send once the stream's handler has returned; call it before
+// returning from the handler.
+func (cm *ConnectionManager) RemoveStream(clientID string, stream proto.HubService_ConnectServer) bool {
+	cm.mu.Lock()
+	writer := cm.streams[stream]
+	delete(cm.streams, stream)
+	conn, exists := cm.connections[clientID]
+	removed := exists && conn.stream == stream
+	if removed {
+		delete(cm.connections, clientID)
+	}
+	cm.mu.Unlock()
+
+	if writer != nil {
+		writer.close()
+		if !writer.wait(cm.sendTimeout) {
+			logger.Emoji("⚠️").WithField("client_id", clientID).Warn("stream writer still sending, closing anyway")
+		}
+	}
+	return removed
 }
 
 func (cm *ConnectionManager) Remove(clientID string) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	delete(cm.connections, clientID)
+	if conn, exists := cm.connections[clientID]; exists {
+		conn.close()
+		delete(cm.connections, clientID)
+	}
 }
 
-func (cm *ConnectionManager) Get(clientID string) (proto.HubService_ConnectServer, bool) {
+// Kick ends clientID's stream with ErrKicked; the stream owner then removes
+// the connection as after any other failure. It reports whether clientID
+// was connected.
+func (cm *ConnectionManager) Kick(clientID string) bool {
 	cm.mu.RLock()
-	defer cm.mu.RUnlock()
-	stream, exists := cm.connections[clientID]
-	return stream, exists
+	conn, exists := cm.connections[clientID]
+	cm.mu.RUnlock()
+	if exists {
+		conn.fail(ErrKicked)
+	}
+	return exists
 }
 
 func (cm *ConnectionManager) Has(clientID string) bool {
@@ -43,10 +268,178 @@ func (cm *ConnectionManager) Has(clientID string) bool {
 	return exists
 }
 
-func (cm *ConnectionManager) Broadcast(msg *proto.Message) {
+// Count returns the number of active connections
+func (cm *ConnectionManager) Count() int {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
-	for _, stream := range cm.connections {
-		stream.Send(msg)
+	return len(cm.connections)
+}
+
+// Pending returns the number of messages queued on all connections and
+// not yet sent
+func (cm *ConnectionManager) Pending() int {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	pending := 0
+	for _, conn := range cm.connections {
+		pending += len(conn.outbox)
+	}
+	return pending
+}
+
+// Touch records a message received from clientID and picks up a gateway's
+// declared type
+func (cm *ConnectionManager) Touch(clientID string, msg *proto.Message) {
+	cm.mu.RLock()
+	conn, exists := cm.connections[clientID]
+	cm.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	conn.lastSeen.Store(time.Now().UnixNano())
+	if msg.Metadata[ClientTypeMetadata] == ConnectionTypeGateway && conn.connType.Load() == ConnectionTypeClient {
+		conn.connType.Store(ConnectionTypeGateway)
+	}
+}
+
+// SetType sets the type reported for clientID (e.g. after registration)
+func (cm *ConnectionManager) SetType(clientID, connType string) {
+	cm.mu.RLock()
+	conn, exists := cm.connections[clientID]
+	cm.mu.RUnlock()
+	if exists {
+		conn.connType.Store(connType)
 	}
-}
\ No newline at end of file
+}
+
+// SetNamespace binds clientID to a tenant namespace
+func (cm *ConnectionManager) SetNamespace(clientID, namespace string) {
+	cm.mu.RLock()
+	conn, exists := cm.connections[clientID]
+	cm.mu.RUnlock()
+	if exists {
+		conn.namespace.Store(normalizeNamespace(namespace))
+	}
+}
+
+// Namespace returns the namespace clientID is bound to (DefaultNamespace
+// when it is not connected)
+func (cm *ConnectionManager) Namespace(clientID string) string {
+	cm.mu.RLock()
+	conn, exists := cm.connections[clientID]
+	cm.mu.RUnlock()
+	if !exists {
+		return DefaultNamespace
+	}
+	return conn.namespace.Load().(string)
+}
+
+// List returns the live connections sorted by client ID
+func (cm *ConnectionManager) List() []ConnectionInfo {
+	cm.mu.RLock()
+	infos := make([]ConnectionInfo, 0, len(cm.connections))
+	for clientID, conn := range cm.connections {
+		infos = append(infos, ConnectionInfo{
+			ClientID:    clientID,
+			Type:        conn.connType.Load().(string),
+			ConnectedAt: conn.connectedAt,
+			LastSeen:    time.Unix(0, conn.lastSeen.Load()),
+			Namespace:   conn.namespace.Load().(string),
+		})
+	}
+	cm.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ClientID < infos[j].ClientID })
+	return infos
+}
+
+// ClientIDsOfType returns the IDs of live connections of connType, sorted
+func (cm *ConnectionManager) ClientIDsOfType(connType string) []string {
+	cm.mu.RLock()
+	var ids []string
+	for clientID, conn := range cm.connections {
+		if conn.connType.Load() == connType {
+			ids = append(ids, clientID)
+		}
+	}
+	cm.mu.RUnlock()
+
+	sort.Strings(ids)
+	return ids
+}
+
+// Send queues msg for delivery to clientID according to the send policy
+func (cm *ConnectionManager) Send(clientID string, msg *proto.Message) error {
+	cm.mu.RLock()
+	conn, exists := cm.connections[clientID]
+	cm.mu.RUnlock()
+	if !exists {
+		return ErrConnectionNotFound
+	}
+	return cm.enqueue(conn, msg)
+}
+
+// Broadcast queues msg for every connection and returns the failures by client ID
+func (cm *ConnectionManager) Broadcast(msg *proto.Message) map[string]error {
+	cm.mu.RLock()
+	conns := make([]*connection, 0, len(cm.connections))
+	for _, conn := range cm.connections {
+		conns = append(conns, conn)
+	}
+	cm.mu.RUnlock()
+
+	failed := make(map[string]error)
+	for _, conn := range conns {
+		if err := cm.enqueue(conn, msg); err != nil {
+			failed[conn.clientID] = err
+		}
+	}
+	return failed
+}
+
+func (cm *ConnectionManager) enqueue(conn *connection, msg *proto.Message) error {
+	select {
+	case <-conn.done:
+		return ErrConnectionClosed
+	case conn.outbox <- msg:
+		return nil
+	default:
+	}
+
+	// Buffer is full - apply policy
+	switch cm.policy {
+	case SendPolicyBlock:
+		timer := time.NewTimer(cm.sendTimeout)
+		defer timer.Stop()
+		select {
+		case <-conn.done:
+			return ErrConnectionClosed
+		case conn.outbox <- msg:
+			return nil
+		case <-timer.C:
+			return ErrSendTimeout
+		}
+
+	case SendPolicyDisconnect:
+		err := fmt.Errorf("%w for %s", ErrBufferFull, conn.clientID)
+		conn.fail(err)
+		return err
+
+	default: // SendPolicyDropOldest
+		for {
+			select {
+			case <-conn.done:
+				return ErrConnectionClosed
+			case conn.outbox <- msg:
+				return nil
+			default:
+			}
+			select {
+			case dropped := <-conn.outbox:
+				logger.Emoji("⚠️").WithFields(logger.Fields{"client_id": conn.clientID, "msg_id": dropped.Id}).Warn("outbound buffer full, dropped message")
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// discoveryQuery là bộ lọc của discovery: content của request
+// capability_discovery, hoặc DiscoverRequest
+type discoveryQuery struct {
+	workerQuery
+	Tag       string `json:"tag"`
+	Namespace string `json:"namespace"`
+}
+
+// discover dựng discovery response (capabilities, providers, workers...) cho
+// clientID thuộc namespace callerNamespace
+func (s *Server) discover(clientID, callerNamespace string, query discoveryQuery) (map[string]interface{}, *apierr.ErrorResponse) {
+	namespace := callerNamespace
+	if query.Namespace != "" {
+		namespace = normalizeNamespace(query.Namespace)
+		if apiErr := s.checkNamespaceFrom(clientID, callerNamespace, namespace); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	workerFilter, err := query.filter(namespace)
+	if err != nil {
+		apiErr := apierr.New(apierr.CodeValidation, err.Error())
+		if errors.Is(err, ErrInvalidLabel) {
+			apiErr.WithDetail("labels", query.Labels)
+		}
+		return nil, apiErr
+	}
+
+	// Capabilities reflect every matching worker, not just the page
+	page := workerFilter
+	workerFilter.Offset, workerFilter.Limit = 0, 0
+	matching, _ := s.registry.ListWorkers(workerFilter)
+	workers := matching.Workers
+
+	var capabilities map[string]ServiceCapability
+	if query.Tag != "" {
+		capabilities = s.registry.GetCapabilitiesByTagIn(namespace, query.Tag)
+		workers = filterWorkersByTag(workers, query.Tag)
+	} else {
+		capabilities = s.registry.GetCapabilitiesIn(namespace)
+	}
+	providers := s.registry.GetCapabilityProvidersIn(namespace)
+	if query.filtersWorkers() {
+		capabilities, providers = filterByWorkers(capabilities, providers, workers)
+	}
+
+	response := map[string]interface{}{
+		"capabilities": capabilities,
+		"providers":    capabilityProviders(providers, capabilities, workers),
+		"workers":      paginate(workers, page.Offset, page.Limit),
+		"total":        len(workers),
+		"namespace":    namespace,
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}
+	if query.Tag != "" {
+		response["tag"] = query.Tag
+	}
+	if len(workerFilter.Labels) > 0 {
+		response["labels"] = FormatLabelSelector(workerFilter.Labels)
+	}
+	if page.Offset > 0 || page.Limit > 0 {
+		response["offset"] = page.Offset
+		response["limit"] = page.Limit
+	}
+	return response, nil
+}
+
+// Discover trả về discovery ngay từ registry, không cần stream: gateway
+// lấy capabilities đồng bộ lúc khởi động thay vì gửi REQUEST rồi chờ
+// response. Thay đổi sau đó vẫn được đẩy qua stream (system:capabilities).
+//
+// Lỗi của bộ lọc (VALIDATION, FORBIDDEN) nằm trong DiscoverResponse.Error;
+// lỗi gRPC chỉ dành cho xác thực.
+func (s *Server) Discover(ctx context.Context, req *proto.DiscoverRequest) (*proto.DiscoverResponse, error) {
+	clientID, namespace, err := s.authenticateCall(req.ClientId, req.Metadata)
+	if err != nil {
+		logger.Emoji("⛔").WithFields(logger.Fields{"client_id": req.ClientId}).WithError(err).Warn("discover rejected")
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	query := discoveryQuery{
+		workerQuery: workerQuery{
+			Status:     req.Status,
+			Type:       req.Type,
+			Capability: req.Capability,
+			Labels:     req.Labels,
+			Sort:       req.Sort,
+			Order:      req.Order,
+			Limit:      int(req.Limit),
+			Offset:     int(req.Offset),
+		},
+		Tag:       req.Tag,
+		Namespace: req.Namespace,
+	}
+	response, apiErr := s.discover(clientID, namespace, query)
+	if apiErr != nil {
+		reply := &proto.Message{}
+		envelope.SetError(reply, apiErr)
+		return &proto.DiscoverResponse{Content: reply.Content, Error: reply.Error}, nil
+	}
+	content, err := json.Marshal(response)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &proto.DiscoverResponse{Content: string(content)}, nil
+}
@@ -0,0 +1,179 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/test/bufconn"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+)
+
+// discoverResult is the part of a discovery response whose order matters
+type discoverResult struct {
+	Capabilities map[string]ServiceCapability    `json:"capabilities"`
+	Providers    map[string][]CapabilityProvider `json:"providers"`
+	Workers      []*WorkerInfo                   `json:"workers"`
+}
+
+// discover sends a capability_discovery request and returns the raw
+// response without its timestamp, and the decoded result
+func (c *testClient) discover() (map[string]json.RawMessage, discoverResult) {
+	c.t.Helper()
+	sent := c.send(&proto.Message{To: "hub", Type: proto.MessageType_REQUEST, Channel: "capability_discovery", Content: `{"action":"discover"}`})
+	reply := c.next()
+	if reply.Id != sent.Id {
+		c.t.Fatalf("discovery reply %s, want %s", reply.Id, sent.Id)
+	}
+	var raw map[string]json.RawMessage
+	var result discoverResult
+	if err := json.Unmarshal([]byte(reply.Content), &raw); err != nil {
+		c.t.Fatalf("discovery response: %v", err)
+	}
+	json.Unmarshal([]byte(reply.Content), &result)
+	delete(raw, "timestamp")
+	return raw, result
+}
+
+func TestDiscoveryOrderIsStable(t *testing.T) {
+	h := newTestHub(t, nil)
+	// Registered out of order, with a capability offered by two workers
+	h.connectWorker(t, "w3", "", ServiceCapability{Name: "translate"}, ServiceCapability{Name: "ocr", Description: "ocr on w3"})
+	h.connectWorker(t, "w1", "", ServiceCapability{Name: "resize"}, ServiceCapability{Name: "echo"})
+	h.connectWorker(t, "w2", "", ServiceCapability{Name: "ocr", Description: "ocr on w2"}, ServiceCapability{Name: "echo"})
+	client := h.connectClient(t, "c1", nil)
+
+	first, result := client.discover()
+	for i := 0; i < 10; i++ {
+		if again, _ := client.discover(); !reflect.DeepEqual(again, first) {
+			t.Fatalf("discovery %d differs from the first:\n%s\n%s", i+2, again, first)
+		}
+	}
+
+	var ids []string
+	for _, worker := range result.Workers {
+		ids = append(ids, worker.ID)
+		names := make([]string, len(worker.Capabilities))
+		for i, cap := range worker.Capabilities {
+			names[i] = cap.Name
+		}
+		if !sort.StringsAreSorted(names) {
+			t.Errorf("capabilities of %s not sorted: %v", worker.ID, names)
+		}
+	}
+	if !reflect.DeepEqual(ids, []string{"w1", "w2", "w3"}) {
+		t.Errorf("workers listed as %v, want sorted by ID", ids)
+	}
+	// The lowest worker ID defines a shared capability
+	if got := result.Capabilities["ocr"].Description; got != "ocr on w2" {
+		t.Errorf("ocr described as %q, want w2's definition", got)
+	}
+	for name, providers := range result.Providers {
+		if !sort.SliceIsSorted(providers, func(i, j int) bool { return providers[i].WorkerID < providers[j].WorkerID }) {
+			t.Errorf("providers of %s not sorted: %+v", name, providers)
+		}
+	}
+}
+
+// wireSizes is a client stats.Handler recording, for each message received,
+// its size on the wire and decoded
+type wireSizes struct {
+	mu      sync.Mutex
+	wire    map[string]int // message ID -> bytes on the wire
+	decoded map[string]int // message ID -> bytes once decompressed
+}
+
+func (w *wireSizes) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context   { return ctx }
+func (w *wireSizes) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+func (w *wireSizes) HandleConn(context.Context, stats.ConnStats)                       {}
+
+func (w *wireSizes) HandleRPC(_ context.Context, s stats.RPCStats) {
+	in, ok := s.(*stats.InPayload)
+	if !ok {
+		return
+	}
+	if msg, ok := in.Payload.(*proto.Message); ok {
+		w.mu.Lock()
+		w.wire[msg.Id] = in.WireLength
+		w.decoded[msg.Id] = in.Length
+		w.mu.Unlock()
+	}
+}
+
+// discoveryWireSize discovers on a new connection to h dialed with opts and
+// returns the bytes the discovery response took on the wire and decoded
+func discoveryWireSize(t *testing.T, h *testHub, opts ...grpc.DialOption) (wire, decoded int) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	go h.server.Serve(lis)
+	sizes := &wireSizes{wire: make(map[string]int), decoded: make(map[string]int)}
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(sizes))
+	conn, err := grpc.Dial("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	client := (&testHub{Server: h.Server, client: proto.NewHubServiceClient(conn)}).connectClient(t, utils.PrefixedID("c"), nil)
+	sent := client.send(&proto.Message{To: "hub", Type: proto.MessageType_REQUEST, Channel: "capability_discovery", Content: `{"action":"discover"}`})
+	if reply := client.next(); reply.Id != sent.Id {
+		t.Fatalf("discovery reply %s, want %s", reply.Id, sent.Id)
+	}
+	sizes.mu.Lock()
+	defer sizes.mu.Unlock()
+	return sizes.wire[sent.Id], sizes.decoded[sent.Id]
+}
+
+// A gzip stream carries the same discovery in far fewer bytes
+func TestDiscoveryCompressedWithGzip(t *testing.T) {
+	h := newTestHub(t, nil)
+	for w := 0; w < 10; w++ {
+		caps := make([]ServiceCapability, 20)
+		for c := range caps {
+			caps[c] = ServiceCapability{
+				Name:        fmt.Sprintf("capability_%d", c),
+				Description: "Extracts text from an image " + strings.Repeat("and reports its confidence ", 4),
+				InputSchema: `{"type":"object","properties":{"image":{"type":"string","format":"binary"},"lang":{"type":"string"}},"required":["image"]}`,
+				HTTPMethod:  "POST",
+				Tags:        []string{"ocr", "image"},
+			}
+		}
+		h.connectWorker(t, fmt.Sprintf("worker-%d", w), "", caps...)
+	}
+
+	plainWire, plainSize := discoveryWireSize(t, h)
+	gzip, err := codec.GRPCCompressor(codec.GRPCCompressionGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipWire, gzipSize := discoveryWireSize(t, h, grpc.WithDefaultCallOptions(gzip))
+	t.Logf("discovery of %d bytes: %d bytes on the wire plain, %d with gzip", plainSize, plainWire, gzipWire)
+
+	if plainWire == 0 || gzipWire == 0 {
+		t.Fatalf("no wire size recorded: plain %d, gzip %d", plainWire, gzipWire)
+	}
+	// Only the timestamp differs between the two discoveries
+	if diff := plainSize - gzipSize; diff < -8 || diff > 8 || plainWire < plainSize {
+		t.Fatalf("plain discovery of %d bytes took %d on the wire, gzip one is %d bytes", plainSize, plainWire, gzipSize)
+	}
+	if gzipWire*10 > plainWire {
+		t.Fatalf("gzip discovery took %d bytes on the wire, want under a tenth of %d", gzipWire, plainWire)
+	}
+}
@@ -1,39 +1,302 @@
 package hub
 
 import (
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"deepapp_golang_grpc_hub/internal/envelope"
 	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/pkg/logger"
 )
 
+var (
+	// ErrDispatcherStopped is returned by Dispatch after Stop
+	ErrDispatcherStopped = errors.New("dispatcher stopped")
+	// ErrQueueFull is returned by Dispatch for a message dropped by the
+	// overflow policy
+	ErrQueueFull = errors.New("dispatch queue full")
+)
+
+// Defaults of NewDispatcherWithWorkers
+const (
+	dispatchQueueSize    = 100
+	dispatchBlockTimeout = 5 * time.Second
+)
+
+// OverflowPolicy decides what Dispatch does when the queue of a message's
+// shard is full. Whatever the policy, the message is never queued late:
+// it is dropped, counted and the sender told according to the policy.
+type OverflowPolicy string
+
+const (
+	OverflowBlock  OverflowPolicy = "block"  // wait up to the block timeout, then drop
+	OverflowDrop   OverflowPolicy = "drop"   // drop at once; nack senders that asked for an ack
+	OverflowReject OverflowPolicy = "reject" // drop at once and answer the sender with an ERROR
+)
+
+// Dispatcher routes messages on a pool of goroutines. Messages are sharded
+// by destination (msg.To, or the channel for channel messages), so a slow
+// client only holds up messages for destinations on its shard, and
+// messages for one destination are still routed in order.
 type Dispatcher struct {
-	queue chan *proto.Message
-	wg    sync.WaitGroup
+	queues       []chan *proto.Message // one per worker
+	route        func(*proto.Message)
+	wg           sync.WaitGroup
+	queueSize    int
+	policy       OverflowPolicy
+	blockTimeout time.Duration
+
+	saturated atomic.Int64 // Dispatch calls that found their queue full
+	dropped   atomic.Int64 // messages dropped by the overflow policy
+
+	mu      sync.RWMutex // guards stopped against closing queues mid-send
+	stopped bool
 }
 
 func NewDispatcher(router *Router) *Dispatcher {
+	return NewDispatcherWithWorkers(router, 1)
+}
+
+// NewDispatcherWithWorkers creates a dispatcher routing on workers
+// goroutines, each buffering 100 messages and blocking up to 5s when full
+func NewDispatcherWithWorkers(router *Router, workers int) *Dispatcher {
+	return newDispatcher(router.Route, workers, dispatchQueueSize, OverflowBlock, dispatchBlockTimeout)
+}
+
+// NewDispatcherWithPolicy creates a dispatcher routing on workers
+// goroutines, each buffering queueSize messages, that handles a full queue
+// with policy (unknown policies block). blockTimeout bounds the wait of
+// OverflowBlock.
+func NewDispatcherWithPolicy(router *Router, workers, queueSize int, policy OverflowPolicy, blockTimeout time.Duration) *Dispatcher {
+	return newDispatcher(router.Route, workers, queueSize, policy, blockTimeout)
+}
+
+func newDispatcher(route func(*proto.Message), workers, queueSize int, policy OverflowPolicy, blockTimeout time.Duration) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = dispatchQueueSize
+	}
+	switch policy {
+	case OverflowBlock, OverflowDrop, OverflowReject:
+	default:
+		policy = OverflowBlock
+	}
+	if blockTimeout <= 0 {
+		blockTimeout = dispatchBlockTimeout
+	}
 	d := &Dispatcher{
-		queue: make(chan *proto.Message, 100),
+		queues:       make([]chan *proto.Message, workers),
+		route:        route,
+		queueSize:    queueSize,
+		policy:       policy,
+		blockTimeout: blockTimeout,
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan *proto.Message, queueSize)
+		d.start(d.queues[i])
 	}
-	d.start(router)
 	return d
 }
 
-func (d *Dispatcher) start(router *Router) {
+func (d *Dispatcher) start(queue chan *proto.Message) {
 	d.wg.Add(1)
 	go func() {
 		defer d.wg.Done()
-		for msg := range d.queue {
-			router.Route(msg)
+		for msg := range queue {
+			d.route(msg)
 		}
 	}()
 }
 
-func (d *Dispatcher) Dispatch(msg *proto.Message) {
-	d.queue <- msg
+// Dispatch queues msg for routing. When the sender asked for it
+// (Metadata["ack"] = "true"), an ACK referencing msg is queued for the
+// sender once msg is, so a sender that gets no ACK knows msg was dropped.
+// A message whose queue stays full is handled by the overflow policy and
+// dropped with ErrQueueFull; messages dispatched after Stop are dropped
+// with ErrDispatcherStopped.
+func (d *Dispatcher) Dispatch(msg *proto.Message) error {
+	// Built before queueing: msg belongs to the router once queued. The
+	// flag is consumed here so the recipient does not see it.
+	var ack *proto.Message
+	if envelope.WantsAck(msg) && msg.From != "" && msg.From != "hub" {
+		ack = newAck(msg)
+		delete(msg.Metadata, envelope.AckKey)
+	}
+
+	d.mu.RLock()
+	if d.stopped {
+		d.mu.RUnlock()
+		logger.Emoji("⚠️").WithFields(logger.Fields{"msg_id": msg.Id, "to": msg.To}).Warn("dispatcher stopped, dropping message")
+		return ErrDispatcherStopped
+	}
+	queued := d.enqueue(msg)
+	if queued && ack != nil && !d.tryEnqueue(ack) {
+		// The sender's queue is full: answer on this goroutine rather
+		// than leave the sender resending a message that was queued
+		defer d.route(ack)
+	}
+	d.mu.RUnlock()
+
+	if !queued {
+		d.overflow(msg, ack != nil)
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// enqueue queues msg on its shard, applying the overflow policy when the
+// queue is full. Callers hold d.mu for reading.
+func (d *Dispatcher) enqueue(msg *proto.Message) bool {
+	if d.tryEnqueue(msg) {
+		return true
+	}
+	d.saturated.Add(1)
+	if d.policy != OverflowBlock {
+		return false
+	}
+
+	timer := time.NewTimer(d.blockTimeout)
+	defer timer.Stop()
+	select {
+	case d.queues[d.shard(msg)] <- msg:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// tryEnqueue queues msg on its shard unless the queue is full
+func (d *Dispatcher) tryEnqueue(msg *proto.Message) bool {
+	select {
+	case d.queues[d.shard(msg)] <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// overflow tells the sender of a dropped message, bypassing the full
+// queue: a NACK (an ACK carrying the error) when it asked for an ack, and
+// with OverflowReject an ERROR reply in any case. Messages from the hub
+// itself are only logged.
+func (d *Dispatcher) overflow(msg *proto.Message, wantsAck bool) {
+	d.dropped.Add(1)
+	fields := logger.Fields{"msg_id": msg.Id, "type": msg.Type.String(), "from": msg.From, "to": msg.To, "policy": string(d.policy)}
+	logger.Emoji("🚧").WithFields(fields).Warn("dispatch queue full, dropping message")
+	if msg.From == "" || msg.From == "hub" || msg.Type == proto.MessageType_ACK {
+		return
+	}
+
+	apiErr := apierr.New(apierr.CodeOverloaded, fmt.Sprintf("hub routing queue is full, message %s was dropped; retry later", msg.Id)).
+		WithDetail("message_id", msg.Id)
+	if wantsAck {
+		nack := newAck(msg)
+		nack.Action = "dropped"
+		envelope.SetError(nack, apiErr)
+		d.route(nack)
+	}
+	if d.policy == OverflowReject {
+		reply := &proto.Message{
+			Id:        msg.Id,
+			RequestId: msg.RequestId,
+			From:      "hub",
+			To:        msg.From,
+			Type:      proto.MessageType_ERROR,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Metadata:  map[string]string{envelope.OriginalMessageIDKey: msg.Id},
+		}
+		envelope.SetError(reply, apiErr)
+		copyCorrelation(msg, reply)
+		d.route(reply)
+	}
+}
+
+// newAck acknowledges msg to its sender. The ACK keeps msg's request_id
+// and correlation ID and names msg in Metadata["ack_id"].
+func newAck(msg *proto.Message) *proto.Message {
+	ack := &proto.Message{
+		Id:        utils.PrefixedID("ack"),
+		RequestId: msg.RequestId,
+		From:      "hub",
+		To:        msg.From,
+		Type:      proto.MessageType_ACK,
+		Action:    "queued",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Metadata:  map[string]string{envelope.AckIDKey: msg.Id},
+	}
+	copyCorrelation(msg, ack)
+	return ack
 }
 
+// shard picks the worker for msg's destination
+func (d *Dispatcher) shard(msg *proto.Message) int {
+	if len(d.queues) == 1 {
+		return 0
+	}
+	key := msg.To
+	if key == "" {
+		key = msg.Channel
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
+
+// Workers returns the number of routing goroutines
+func (d *Dispatcher) Workers() int {
+	return len(d.queues)
+}
+
+// DispatcherStats reports the routing queues and how often they overflowed
+type DispatcherStats struct {
+	Workers        int    `json:"workers"`
+	QueueSize      int    `json:"queue_size"` // per worker
+	Pending        int    `json:"pending"`
+	OverflowPolicy string `json:"overflow_policy"`
+	Saturated      int64  `json:"saturated"` // Dispatch calls that found their queue full
+	Dropped        int64  `json:"dropped"`   // messages dropped by the overflow policy
+}
+
+// Stats returns the current queue depth and overflow counters
+func (d *Dispatcher) Stats() DispatcherStats {
+	return DispatcherStats{
+		Workers:        len(d.queues),
+		QueueSize:      d.queueSize,
+		Pending:        d.Pending(),
+		OverflowPolicy: string(d.policy),
+		Saturated:      d.saturated.Load(),
+		Dropped:        d.dropped.Load(),
+	}
+}
+
+// Pending returns the number of messages waiting to be routed
+func (d *Dispatcher) Pending() int {
+	pending := 0
+	for _, queue := range d.queues {
+		pending += len(queue)
+	}
+	return pending
+}
+
+// Stop routes the messages already queued and ends the worker goroutines
 func (d *Dispatcher) Stop() {
-	close(d.queue)
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	d.stopped = true
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.mu.Unlock()
 	d.wg.Wait()
-}
\ No newline at end of file
+}
@@ -0,0 +1,304 @@
+package hub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+const (
+	fanInSenders        = 50
+	fanInReceivers      = 5
+	fanInPerReceiver    = 20 // messages from each sender to each receiver
+	fanInPerDestination = fanInSenders * fanInPerReceiver
+)
+
+// fanInContent is the content of the seq'th message from sender
+func fanInContent(sender string, seq int) string {
+	return sender + "/" + strconv.Itoa(seq)
+}
+
+// checkOrder fails unless received holds every sender's messages to one
+// destination exactly once and in the order they were sent
+func checkOrder(t *testing.T, dest string, received []string) {
+	t.Helper()
+	next := make(map[string]int)
+	for _, content := range received {
+		sender, seq, _ := strings.Cut(content, "/")
+		n, _ := strconv.Atoi(seq)
+		if n != next[sender] {
+			t.Errorf("%s got %s after %s/%d", dest, content, sender, next[sender]-1)
+			return
+		}
+		next[sender]++
+	}
+	if len(received) != fanInPerDestination {
+		t.Errorf("%s got %d messages, want %d", dest, len(received), fanInPerDestination)
+	}
+}
+
+// Messages routed on several goroutines still reach each destination in
+// the order each sender dispatched them (run with -race)
+func TestDispatcherKeepsOrderPerDestination(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	d := newDispatcher(func(msg *proto.Message) {
+		mu.Lock()
+		received[msg.To] = append(received[msg.To], msg.Content)
+		mu.Unlock()
+	}, 4, 16, OverflowBlock, 5*time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < fanInSenders; i++ {
+		sender := fmt.Sprintf("s%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := 0; seq < fanInPerReceiver; seq++ {
+				for r := 0; r < fanInReceivers; r++ {
+					msg := &proto.Message{From: sender, To: fmt.Sprintf("r%d", r), Type: proto.MessageType_DIRECT, Content: fanInContent(sender, seq)}
+					if err := d.Dispatch(msg); err != nil {
+						t.Errorf("dispatch: %v", err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	d.Stop()
+
+	for r := 0; r < fanInReceivers; r++ {
+		dest := fmt.Sprintf("r%d", r)
+		checkOrder(t, dest, received[dest])
+	}
+	if stats := d.Stats(); stats.Dropped != 0 {
+		t.Errorf("dispatcher dropped %d messages", stats.Dropped)
+	}
+}
+
+// The same through the hub: 50 client streams sending to 5 others at once
+func TestFanInKeepsOrderPerDestination(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.SendPolicy = string(SendPolicyBlock)
+	})
+	receivers := make([]*testClient, fanInReceivers)
+	for r := range receivers {
+		receivers[r] = h.connectClient(t, fmt.Sprintf("r%d", r), nil)
+	}
+	senders := make([]*testClient, fanInSenders)
+	for i := range senders {
+		senders[i] = h.connectClient(t, fmt.Sprintf("s%d", i), nil)
+	}
+
+	var wg sync.WaitGroup
+	for _, sender := range senders {
+		sender := sender
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := 0; seq < fanInPerReceiver; seq++ {
+				for _, receiver := range receivers {
+					sender.send(&proto.Message{To: receiver.id, Type: proto.MessageType_DIRECT, Content: fanInContent(sender.id, seq)})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, receiver := range receivers {
+		received := make([]string, 0, fanInPerDestination)
+		for len(received) < fanInPerDestination {
+			received = append(received, receiver.next().Content)
+		}
+		checkOrder(t, receiver.id, received)
+	}
+	if dead := h.router.DeadLetterCount(); dead != 0 {
+		t.Errorf("%d messages were not delivered", dead)
+	}
+}
+
+// slowRoute routes messages for "slow" only once release is closed and
+// records everything routed, so a test can fill a dispatcher's queue
+type slowRoute struct {
+	started chan struct{} // receives once per message for "slow"
+	release chan struct{}
+
+	mu     sync.Mutex
+	routed []*proto.Message
+}
+
+func newSlowRoute() *slowRoute {
+	return &slowRoute{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (r *slowRoute) route(msg *proto.Message) {
+	if msg.To == "slow" {
+		r.started <- struct{}{}
+		<-r.release
+	}
+	r.mu.Lock()
+	r.routed = append(r.routed, msg)
+	r.mu.Unlock()
+}
+
+// to returns the messages routed to dest
+func (r *slowRoute) to(dest string) []*proto.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var msgs []*proto.Message
+	for _, msg := range r.routed {
+		if msg.To == dest {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// A message finding its queue full is dropped with ErrQueueFull, and the
+// sender is told as the policy says
+func TestDispatcherOverflowPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy  OverflowPolicy
+		ack     bool
+		waits   bool // Dispatch waits the block timeout
+		nack    bool
+		errored bool // the sender gets an ERROR reply
+	}{
+		{policy: OverflowBlock, waits: true},
+		{policy: OverflowBlock, ack: true, waits: true, nack: true},
+		{policy: OverflowDrop},
+		{policy: OverflowDrop, ack: true, nack: true},
+		{policy: OverflowReject, errored: true},
+		{policy: OverflowReject, ack: true, nack: true, errored: true},
+	} {
+		name := fmt.Sprintf("%s ack=%v", tc.policy, tc.ack)
+		r := newSlowRoute()
+		const blockTimeout = 100 * time.Millisecond
+		d := newDispatcher(r.route, 1, 2, tc.policy, blockTimeout)
+
+		// One message held by the routing goroutine, two filling the queue
+		for i := 0; i < 3; i++ {
+			if err := d.Dispatch(&proto.Message{Id: fmt.Sprintf("m%d", i), From: "s1", To: "slow", Type: proto.MessageType_DIRECT}); err != nil {
+				t.Fatalf("%s: dispatch m%d: %v", name, i, err)
+			}
+			if i == 0 {
+				<-r.started
+			}
+		}
+
+		overflow := &proto.Message{Id: "m3", RequestId: "r3", From: "s1", To: "slow", Type: proto.MessageType_DIRECT}
+		if tc.ack {
+			overflow.Metadata = map[string]string{envelope.AckKey: "true"}
+		}
+		start := time.Now()
+		if err := d.Dispatch(overflow); err != ErrQueueFull {
+			t.Fatalf("%s: overflowing dispatch returned %v, want ErrQueueFull", name, err)
+		}
+		waited := time.Since(start)
+		if tc.waits && waited < blockTimeout || !tc.waits && waited >= blockTimeout {
+			t.Errorf("%s: Dispatch returned after %v with a %v block timeout", name, waited, blockTimeout)
+		}
+		// The hub's own messages are dropped without telling anyone
+		if err := d.Dispatch(&proto.Message{Id: "h1", From: "hub", To: "slow", Type: proto.MessageType_DIRECT}); err != ErrQueueFull {
+			t.Fatalf("%s: hub message returned %v, want ErrQueueFull", name, err)
+		}
+
+		var nacks, rejections int
+		for _, msg := range r.to("s1") {
+			code := ""
+			if apiErr, failed := envelope.Error(msg); failed {
+				code = string(apiErr.Code)
+			}
+			switch {
+			case msg.Type == proto.MessageType_ACK && msg.Action == "dropped" && msg.Metadata[envelope.AckIDKey] == "m3" && code == string(apierr.CodeOverloaded):
+				nacks++
+			case msg.Type == proto.MessageType_ERROR && msg.Metadata[envelope.OriginalMessageIDKey] == "m3" && msg.RequestId == "r3" && code == string(apierr.CodeOverloaded):
+				rejections++
+			default:
+				t.Errorf("%s: sender got %s %s %q", name, msg.Type, msg.Action, code)
+			}
+		}
+		if nacks > 1 || rejections > 1 || (nacks == 1) != tc.nack || (rejections == 1) != tc.errored {
+			t.Errorf("%s: sender got %d NACKs and %d ERRORs", name, nacks, rejections)
+		}
+
+		stats := d.Stats()
+		if stats.Saturated != 2 || stats.Dropped != 2 || stats.Pending != 2 || stats.OverflowPolicy != string(tc.policy) {
+			t.Errorf("%s: stats %+v", name, stats)
+		}
+
+		close(r.release)
+		d.Stop()
+		var ids []string
+		for _, msg := range r.to("slow") {
+			ids = append(ids, msg.Id)
+		}
+		if fmt.Sprint(ids) != "[m0 m1 m2]" {
+			t.Errorf("%s: routed %v, want the queued m0 m1 m2 only", name, ids)
+		}
+	}
+}
+
+const (
+	benchFastConsumers = 15
+	benchSlowSend      = 100 * time.Microsecond
+)
+
+// benchmarkDispatcher routes b.N messages round robin to one consumer whose
+// sends take benchSlowSend and benchFastConsumers whose sends are instant.
+// ns/op is the overall throughput, bounded by the slow consumer since its
+// messages stay in order; fast-µs/msg is how long messages for the fast
+// consumers waited to be routed. With a pool the fast consumers are picked
+// off the slow one's shard, as those sharing it wait behind it by design.
+func benchmarkDispatcher(b *testing.B, workers int) {
+	var waited, fast atomic.Int64
+	d := newDispatcher(func(msg *proto.Message) {
+		if msg.To == "slow" {
+			time.Sleep(benchSlowSend)
+			return
+		}
+		sent, _ := strconv.ParseInt(msg.Content, 10, 64)
+		waited.Add(time.Now().UnixNano() - sent)
+		fast.Add(1)
+	}, workers, dispatchQueueSize, OverflowBlock, dispatchBlockTimeout)
+
+	destinations := []string{"slow"}
+	slowShard := d.shard(&proto.Message{To: "slow"})
+	for i := 0; len(destinations) <= benchFastConsumers; i++ {
+		to := fmt.Sprintf("fast%d", i)
+		if workers == 1 || d.shard(&proto.Message{To: to}) != slowShard {
+			destinations = append(destinations, to)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg := &proto.Message{From: "s1", To: destinations[i%len(destinations)], Type: proto.MessageType_DIRECT, Content: strconv.FormatInt(time.Now().UnixNano(), 10)}
+		if err := d.Dispatch(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+	d.Stop()
+	b.StopTimer()
+
+	if n := fast.Load(); n > 0 {
+		b.ReportMetric(float64(waited.Load())/float64(n)/1e3, "fast-µs/msg")
+	}
+}
+
+func BenchmarkDispatcherSingle(b *testing.B) {
+	benchmarkDispatcher(b, 1)
+}
+
+func BenchmarkDispatcherPooled(b *testing.B) {
+	benchmarkDispatcher(b, 8)
+}
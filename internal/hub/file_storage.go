@@ -1,20 +1,58 @@
 package hub
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"deepapp_golang_grpc_hub/internal/config"
 	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// Download chunk sizes: the default, and the largest a client may ask for
+const (
+	defaultDownloadChunkSize = 64 * 1024
+	maxDownloadChunkSize     = 1 << 20
 )
 
-// FileStorage handles file upload/download with chunking
+// partialUploadTTL is how long an interrupted upload is kept for resuming
+const partialUploadTTL = time.Hour
+
+// FileStorage keeps uploaded files in a StorageBackend, with the filename
+// and MIME type given at upload. Uploads in progress, and interrupted ones
+// that can still be resumed, are staged in local files under uploadDir.
 type FileStorage struct {
 	mu        sync.RWMutex
-	storePath string
-	files     map[string]*FileInfo // file_id -> FileInfo
+	backend   StorageBackend
+	files     map[string]*FileInfo      // file_id -> FileInfo
+	uploads   map[string]*partialUpload // file_id -> upload not yet stored
+	uploadDir string
+}
+
+// partialUpload is an upload whose bytes are staged until its last chunk
+// arrives. Only one stream at a time may write to it (active). received
+// is only changed under FileStorage.mu, so GetUploadOffset can read it
+// while a stream writes; file and hash belong to the active stream.
+type partialUpload struct {
+	file      *os.File
+	received  int64
+	hash      hash.Hash // sha256 of the received bytes
+	filename  string
+	mimeType  string
+	active    bool
+	updatedAt time.Time
 }
 
 // FileInfo stores metadata about uploaded files
@@ -23,28 +61,178 @@ type FileInfo struct {
 	Filename  string
 	Size      int64
 	MimeType  string
-	Path      string
+	Path      string // local backend only
 	CreatedAt string
+	SHA256    string // hex checksum computed at upload
 }
 
-// NewFileStorage creates a new file storage
-func NewFileStorage(storePath string) *FileStorage {
-	// Create storage directory if not exists
-	os.MkdirAll(storePath, 0755)
-	
+// NewFileStorage creates a file storage on backend
+func NewFileStorage(backend StorageBackend) *FileStorage {
 	return &FileStorage{
-		storePath: storePath,
+		backend:   backend,
 		files:     make(map[string]*FileInfo),
+		uploads:   make(map[string]*partialUpload),
+		uploadDir: filepath.Join(os.TempDir(), "hub_uploads"),
+	}
+}
+
+// newFileStorage uses the backend named by STORAGE_BACKEND, falling back
+// to the local directory FILE_STORE_PATH when it cannot be created
+func newFileStorage(cfg *config.Config) *FileStorage {
+	backend, err := NewStorageBackend(cfg)
+	if err != nil {
+		logger.Emoji("⚠️").WithError(err).Warn("invalid storage backend, using local")
+		backend = NewLocalBackend(cfg.FileStorePath)
+	}
+	return NewFileStorage(backend)
+}
+
+// SetStorageBackend replaces the backend uploaded files are kept in. Must
+// be called before Start.
+func (s *Server) SetStorageBackend(backend StorageBackend) {
+	s.files.mu.Lock()
+	defer s.files.mu.Unlock()
+	s.files.backend = backend
+}
+
+// storage returns the current backend
+func (fs *FileStorage) storage() StorageBackend {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.backend
+}
+
+// record remembers the metadata of an uploaded file
+func (fs *FileStorage) record(info *FileInfo) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.files[info.FileID] = info
+}
+
+// lookup returns the metadata recorded when fileID was uploaded. Files
+// uploaded before the hub last started, or through another hub, have none.
+func (fs *FileStorage) lookup(fileID string) (*FileInfo, bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	info, ok := fs.files[fileID]
+	return info, ok
+}
+
+// GetUploadOffset returns how many bytes of fileID the hub has staged, so
+// an interrupted upload can resume from there. ok is false when no upload
+// of fileID is pending (never started, completed or expired).
+func (fs *FileStorage) GetUploadOffset(fileID string) (offset int64, ok bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	upload, ok := fs.uploads[fileID]
+	if !ok || upload.expired(time.Now()) {
+		return 0, false
 	}
+	return upload.received, true
+}
+
+// beginUpload claims the staged upload of fileID for one stream. Offset 0
+// starts over (discarding staged bytes); any other offset must equal the
+// staged size. A stream already writing fileID fails the claim with
+// codes.Aborted.
+func (fs *FileStorage) beginUpload(fileID string, offset int64) (*partialUpload, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.sweepUploads(time.Now())
+
+	upload, ok := fs.uploads[fileID]
+	if ok && upload.active {
+		return nil, status.Errorf(codes.Aborted, "upload of %s is already in progress", fileID)
+	}
+	switch {
+	case offset == 0 && ok:
+		if err := upload.file.Truncate(0); err != nil {
+			return nil, fmt.Errorf("failed to restart upload: %v", err)
+		}
+		upload.received = 0
+		upload.hash.Reset()
+		upload.filename, upload.mimeType = "", ""
+	case offset == 0:
+		if err := os.MkdirAll(fs.uploadDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create upload directory: %v", err)
+		}
+		file, err := os.CreateTemp(fs.uploadDir, fileID+".*.part")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file: %v", err)
+		}
+		upload = &partialUpload{file: file, hash: sha256.New()}
+		fs.uploads[fileID] = upload
+	case !ok:
+		return nil, status.Errorf(codes.FailedPrecondition, "no upload of %s to resume at offset %d", fileID, offset)
+	case offset != upload.received:
+		return nil, status.Errorf(codes.FailedPrecondition, "upload of %s resumes at offset %d, not %d", fileID, upload.received, offset)
+	}
+	upload.active = true
+	upload.updatedAt = time.Now()
+	return upload, nil
+}
+
+// advance counts n more bytes staged for upload
+func (fs *FileStorage) advance(upload *partialUpload, n int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	upload.received += int64(n)
+}
+
+// releaseUpload keeps an interrupted upload for resuming
+func (fs *FileStorage) releaseUpload(upload *partialUpload) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	upload.active = false
+	upload.updatedAt = time.Now()
 }
 
-// UploadFile handles streaming file upload
+// discardUpload drops the staged upload of fileID and its file
+func (fs *FileStorage) discardUpload(fileID string, upload *partialUpload) {
+	fs.mu.Lock()
+	if fs.uploads[fileID] == upload {
+		delete(fs.uploads, fileID)
+	}
+	fs.mu.Unlock()
+	upload.file.Close()
+	os.Remove(upload.file.Name())
+}
+
+// sweepUploads removes interrupted uploads not resumed within
+// partialUploadTTL. Callers hold fs.mu.
+func (fs *FileStorage) sweepUploads(now time.Time) {
+	for fileID, upload := range fs.uploads {
+		if upload.expired(now) {
+			delete(fs.uploads, fileID)
+			upload.file.Close()
+			os.Remove(upload.file.Name())
+			logger.Emoji("🗑️").WithFields(logger.Fields{"file_id": fileID, "received": upload.received}).Info("expired partial upload removed")
+		}
+	}
+}
+
+func (u *partialUpload) expired(now time.Time) bool {
+	return !u.active && now.Sub(u.updatedAt) > partialUploadTTL
+}
+
+// validFileID rejects IDs that would resolve outside FileStorePath
+func validFileID(fileID string) bool {
+	return fileID != "" && fileID != "." && fileID != ".." && !strings.ContainsAny(fileID, `/\`)
+}
+
+// UploadFile handles streaming file upload. Chunks are staged in a local
+// file and handed to the storage backend once the stream ends, so the
+// backend never holds a partial file. When the stream breaks the staged
+// bytes are kept for partialUploadTTL: the client asks for them with the
+// upload_offset control action (or GetUploadOffset) and sends the rest
+// with the first chunk's offset set to that size. A first chunk at offset
+// 0 starts over. When a chunk carries a sha256 (normally the last one) the
+// whole file must match it, otherwise nothing is stored and the upload
+// fails with codes.DataLoss. The response carries the file's SHA-256.
 func (s *Server) UploadFile(stream proto.HubService_UploadFileServer) error {
 	var fileID string
-	var filename string
-	var filePath string
-	var file *os.File
-	var totalReceived int64
+	var expected string // sha256 sent by the uploader
+	var upload *partialUpload
 
 	for {
 		chunk, err := stream.Recv()
@@ -53,84 +241,145 @@ func (s *Server) UploadFile(stream proto.HubService_UploadFileServer) error {
 			break
 		}
 		if err != nil {
+			// Interrupted upload (e.g. the connection dropped): keep the
+			// staged bytes so the client can resume
+			if upload != nil {
+				received := upload.received // another stream may claim it once released
+				s.files.releaseUpload(upload)
+				logger.Emoji("⏸️").WithFields(logger.Fields{"file_id": fileID, "received": received}).Warn("upload interrupted, kept for resume")
+			}
 			return fmt.Errorf("failed to receive chunk: %v", err)
 		}
 
-		// First chunk - create file
-		if file == nil {
+		// First chunk - claim the upload, fresh or resumed
+		if upload == nil {
 			fileID = chunk.FileId
-			filename = chunk.Metadata["filename"]
-			if filename == "" {
-				filename = fileID
+			if !validFileID(fileID) {
+				return status.Errorf(codes.InvalidArgument, "invalid file id %q", fileID)
+			}
+			if upload, err = s.files.beginUpload(fileID, chunk.Offset); err != nil {
+				return err
+			}
+			if upload.filename == "" {
+				upload.filename = chunk.Filename
+			}
+			if upload.filename == "" {
+				upload.filename = chunk.Metadata["filename"]
+			}
+			if upload.mimeType == "" {
+				upload.mimeType = chunk.ContentType
+			}
+			if upload.mimeType == "" {
+				upload.mimeType = chunk.Metadata["content_type"]
 			}
 
-			filePath = filepath.Join("/tmp/hub_files", fileID)
-			os.MkdirAll(filepath.Dir(filePath), 0755)
-
-			file, err = os.Create(filePath)
-			if err != nil {
-				return fmt.Errorf("failed to create file: %v", err)
+			if chunk.Offset > 0 {
+				logger.Emoji("📥").WithFields(logger.Fields{"filename": upload.filename, "offset": chunk.Offset, "size": chunk.TotalSize}).Info("resuming file upload")
+			} else {
+				logger.Emoji("📥").WithFields(logger.Fields{"filename": upload.filename, "size": chunk.TotalSize}).Info("receiving file")
 			}
-			defer file.Close()
+		}
 
-			fmt.Printf("📥 Receiving file: %s (%d bytes)\n", filename, chunk.TotalSize)
+		if chunk.Sha256 != "" {
+			expected = strings.ToLower(chunk.Sha256)
 		}
 
-		// Write chunk
-		n, err := file.Write(chunk.Data)
+		// Write chunk at the staged size
+		n, err := upload.file.WriteAt(chunk.Data, upload.received)
+		upload.hash.Write(chunk.Data[:n])
+		s.files.advance(upload, n)
 		if err != nil {
+			s.files.discardUpload(fileID, upload)
 			return fmt.Errorf("failed to write chunk: %v", err)
 		}
 
-		totalReceived += int64(n)
+		logger.Emoji("📦").WithFields(logger.Fields{"filename": upload.filename, "received": upload.received, "size": chunk.TotalSize}).
+			Debug("received chunk")
+	}
 
-		fmt.Printf("📦 Received chunk: %d/%d bytes (%.1f%%)\n",
-			totalReceived, chunk.TotalSize,
-			float64(totalReceived)/float64(chunk.TotalSize)*100)
+	if upload == nil {
+		return stream.SendAndClose(&proto.FileUploadResponse{
+			Status:        "success",
+			BytesReceived: 0,
+			Sha256:        hex.EncodeToString(sha256.New().Sum(nil)),
+		})
 	}
 
-	if file != nil {
-		file.Close()
-		fmt.Printf("✅ File upload complete: %s (%d bytes)\n", filename, totalReceived)
+	defer s.files.discardUpload(fileID, upload)
+	checksum := hex.EncodeToString(upload.hash.Sum(nil))
+	if expected != "" && expected != checksum {
+		logger.Emoji("❌").WithFields(logger.Fields{"filename": upload.filename, "expected": expected, "actual": checksum}).Warn("upload checksum mismatch, file discarded")
+		return status.Errorf(codes.DataLoss, "checksum mismatch for %s: expected sha256 %s, received %s", fileID, expected, checksum)
+	}
+	if err := s.files.storage().Put(fileID, io.NewSectionReader(upload.file, 0, upload.received)); err != nil {
+		return fmt.Errorf("failed to store file: %v", err)
 	}
+	filename := upload.filename
+	if filename == "" {
+		filename = fileID
+	}
+	s.files.record(&FileInfo{
+		FileID:    fileID,
+		Filename:  filename,
+		Size:      upload.received,
+		MimeType:  upload.mimeType,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		SHA256:    checksum,
+	})
+	logger.Emoji("✅").WithFields(logger.Fields{"filename": filename, "size": upload.received, "sha256": checksum}).Info("file upload complete")
 
 	// Send response
 	return stream.SendAndClose(&proto.FileUploadResponse{
 		FileId:        fileID,
-		BytesReceived: totalReceived,
+		BytesReceived: upload.received,
 		Status:        "success",
 		Error:         "",
+		Sha256:        checksum,
 	})
 }
 
-// DownloadFile handles streaming file download
+// DownloadFile handles streaming file download. Chunks carry the filename
+// and content type given at upload, when the hub still knows them. The
+// first and last chunks carry the SHA-256 of the whole file recorded at
+// upload; without one, the last chunk of a download from offset 0 carries
+// the checksum of the bytes sent. Unknown files fail with codes.NotFound.
 func (s *Server) DownloadFile(req *proto.FileDownloadRequest, stream proto.HubService_DownloadFileServer) error {
 	fileID := req.FileId
-	filePath := filepath.Join("/tmp/hub_files", fileID)
-
-	// Check if file exists
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		return fmt.Errorf("file not found: %s", fileID)
+	if !validFileID(fileID) {
+		return status.Errorf(codes.InvalidArgument, "invalid file id %q", fileID)
 	}
 
-	file, err := os.Open(filePath)
+	file, fileInfo, err := s.files.storage().Get(fileID)
+	if errors.Is(err, ErrFileNotFound) {
+		return status.Errorf(codes.NotFound, "file not found: %s", fileID)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
+	var filename, contentType, checksum string
+	if info, ok := s.files.lookup(fileID); ok {
+		filename, contentType, checksum = info.Filename, info.MimeType, info.SHA256
+	}
 
-	fmt.Printf("📤 Sending file: %s (%d bytes)\n", fileID, fileInfo.Size())
+	logger.Emoji("📤").WithFields(logger.Fields{"file_id": fileID, "size": fileInfo.Size}).Info("sending file")
 
 	// Determine chunk size
 	chunkSize := req.ChunkSize
-	if chunkSize == 0 {
-		chunkSize = 64 * 1024 // 64KB default
+	if chunkSize <= 0 {
+		chunkSize = defaultDownloadChunkSize
+	} else if chunkSize > maxDownloadChunkSize {
+		chunkSize = maxDownloadChunkSize
 	}
 
-	// Seek to offset if specified
+	// Skip to offset if specified: seek when the backend allows it,
+	// otherwise read past the bytes
 	if req.Offset > 0 {
-		_, err = file.Seek(req.Offset, 0)
+		if seeker, ok := file.(io.Seeker); ok {
+			_, err = seeker.Seek(req.Offset, io.SeekStart)
+		} else if _, err = io.CopyN(io.Discard, file, req.Offset); err == io.EOF {
+			err = nil
+		}
 		if err != nil {
 			return fmt.Errorf("failed to seek: %v", err)
 		}
@@ -140,50 +389,72 @@ func (s *Server) DownloadFile(req *proto.FileDownloadRequest, stream proto.HubSe
 	buffer := make([]byte, chunkSize)
 	var offset int64 = req.Offset
 	var sentChunks int
+	hash := sha256.New()
 
 	for {
-		n, err := file.Read(buffer)
+		// Full chunks even from backends whose reads come in smaller pieces
+		n, err := io.ReadFull(file, buffer)
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
+		if err != nil && err != io.ErrUnexpectedEOF {
 			return fmt.Errorf("failed to read file: %v", err)
 		}
 
 		chunk := &proto.FileChunk{
-			FileId:    fileID,
-			Data:      buffer[:n],
-			Offset:    offset,
-			TotalSize: fileInfo.Size(),
-			IsLast:    false,
+			FileId:      fileID,
+			Data:        buffer[:n],
+			Offset:      offset,
+			TotalSize:   fileInfo.Size,
+			Filename:    filename,
+			ContentType: contentType,
+			IsLast:      false,
+		}
+		if sentChunks == 0 {
+			chunk.Sha256 = checksum
 		}
 
 		if err := stream.Send(chunk); err != nil {
 			return fmt.Errorf("failed to send chunk: %v", err)
 		}
+		hash.Write(buffer[:n])
 
 		offset += int64(n)
 		sentChunks++
 
 		if sentChunks%10 == 0 {
-			fmt.Printf("📦 Sent chunk %d: %d/%d bytes (%.1f%%)\n",
-				sentChunks, offset, fileInfo.Size(),
-				float64(offset)/float64(fileInfo.Size())*100)
+			logger.Emoji("📦").WithFields(logger.Fields{"file_id": fileID, "chunks": sentChunks, "sent": offset, "size": fileInfo.Size}).
+				Debug("sent chunks")
+		}
+		if err == io.ErrUnexpectedEOF {
+			break // short final chunk
 		}
 	}
 
-	// Send last empty chunk to signal completion
+	// Send last empty chunk to signal completion. A full download is
+	// checked against the recorded checksum, so storage corruption shows
+	// in the log as well as to the receiver.
+	if req.Offset == 0 {
+		sent := hex.EncodeToString(hash.Sum(nil))
+		if checksum == "" {
+			checksum = sent
+		} else if sent != checksum {
+			logger.Emoji("❌").WithFields(logger.Fields{"file_id": fileID, "expected": checksum, "actual": sent}).Error("stored file does not match its checksum")
+		}
+	}
 	lastChunk := &proto.FileChunk{
-		FileId:    fileID,
-		Data:      []byte{},
-		Offset:    offset,
-		TotalSize: fileInfo.Size(),
-		IsLast:    true,
+		FileId:      fileID,
+		Data:        []byte{},
+		Offset:      offset,
+		TotalSize:   fileInfo.Size,
+		Filename:    filename,
+		ContentType: contentType,
+		IsLast:      true,
+		Sha256:      checksum,
 	}
 	stream.Send(lastChunk)
 
-	fmt.Printf("✅ File download complete: %s (%d chunks, %d bytes)\n",
-		fileID, sentChunks, offset)
+	logger.Emoji("✅").WithFields(logger.Fields{"file_id": fileID, "chunks": sentChunks, "size": offset}).Info("file download complete")
 
 	return nil
 }
@@ -0,0 +1,353 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/proto"
+)
+
+// testChunkSize is the upload chunk size used by the tests
+const testChunkSize = 64 * 1024
+
+// randomBytes returns n reproducible pseudo-random bytes
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sendChunks sends data in testChunkSize chunks starting at offset; the
+// last chunk carries checksum if not empty
+func sendChunks(t *testing.T, stream proto.HubService_UploadFileClient, fileID string, data []byte, offset int64, checksum string) {
+	t.Helper()
+	for start := 0; start < len(data); start += testChunkSize {
+		end := start + testChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := &proto.FileChunk{FileId: fileID, Data: data[start:end], Filename: "test.bin"}
+		if start == 0 {
+			chunk.Offset = offset
+		}
+		if end == len(data) {
+			chunk.IsLast = true
+			chunk.Sha256 = checksum
+		}
+		if err := stream.Send(chunk); err != nil {
+			t.Fatalf("send chunk at %d: %v", start, err)
+		}
+	}
+}
+
+// waitReleased waits until no stream is writing the upload of fileID
+func waitReleased(t *testing.T, fs *FileStorage, fileID string) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		fs.mu.RLock()
+		upload, ok := fs.uploads[fileID]
+		active := ok && upload.active
+		fs.mu.RUnlock()
+		if !active {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upload of %s still claimed", fileID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// The resume offset can be read while a stream is still writing the upload
+// (run with -race)
+func TestUploadOffsetDuringUpload(t *testing.T) {
+	h := newTestHub(t, nil)
+	data := randomBytes(2 << 20)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var last int64
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if offset, ok := h.files.GetUploadOffset("f1"); ok {
+				if offset < last || offset > int64(len(data)) {
+					t.Errorf("offset went from %d to %d", last, offset)
+				}
+				last = offset
+			}
+		}
+	}()
+
+	stream, err := h.client.UploadFile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendChunks(t, stream, "f1", data, 0, sha256Hex(data))
+	resp, err := stream.CloseAndRecv()
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if resp.BytesReceived != int64(len(data)) {
+		t.Fatalf("BytesReceived = %d, want %d", resp.BytesReceived, len(data))
+	}
+}
+
+func TestUploadResumesAfterInterruption(t *testing.T) {
+	h := newTestHub(t, nil)
+	data := randomBytes(1 << 20)
+	half := len(data) / 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := h.client.UploadFile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendChunks(t, stream, "f1", data[:half], 0, "")
+	deadline := time.Now().Add(testTimeout)
+	for {
+		if offset, _ := h.files.GetUploadOffset("f1"); offset == int64(half) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first half never staged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	waitReleased(t, h.files, "f1")
+
+	stream, err = h.client.UploadFile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendChunks(t, stream, "f1", data[half:], int64(half), sha256Hex(data))
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	r, info, err := h.files.storage().Get("f1")
+	if err != nil {
+		t.Fatalf("stored file: %v", err)
+	}
+	defer r.Close()
+	var stored bytes.Buffer
+	stored.ReadFrom(r)
+	if info.Size != int64(len(data)) || !bytes.Equal(stored.Bytes(), data) {
+		t.Fatalf("stored %d bytes, want the %d uploaded", info.Size, len(data))
+	}
+}
+
+// downloadFile downloads fileID and returns its bytes and the checksum the
+// chunks carried
+func downloadFile(t *testing.T, h *testHub, fileID string) ([]byte, string) {
+	t.Helper()
+	stream, err := h.client.DownloadFile(context.Background(), &proto.FileDownloadRequest{FileId: fileID, ChunkSize: testChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data bytes.Buffer
+	var checksum string
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return data.Bytes(), checksum
+		}
+		if err != nil {
+			t.Fatalf("download %s: %v", fileID, err)
+		}
+		data.Write(chunk.Data)
+		if chunk.Sha256 != "" {
+			checksum = chunk.Sha256
+		}
+	}
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	h := newTestHub(t, nil)
+	data := randomBytes(5 << 20)
+
+	stream, err := h.client.UploadFile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendChunks(t, stream, "f1", data, 0, sha256Hex(data))
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if resp.BytesReceived != int64(len(data)) || resp.Sha256 != sha256Hex(data) {
+		t.Fatalf("upload response: %d bytes, sha256 %s", resp.BytesReceived, resp.Sha256)
+	}
+
+	got, checksum := downloadFile(t, h, "f1")
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, not the %d uploaded", len(got), len(data))
+	}
+	if checksum != sha256Hex(data) {
+		t.Fatalf("download checksum %s, want %s", checksum, sha256Hex(data))
+	}
+}
+
+func TestUploadChecksumMismatch(t *testing.T) {
+	h := newTestHub(t, nil)
+	data := randomBytes(1 << 20)
+
+	stream, err := h.client.UploadFile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendChunks(t, stream, "f1", data, 0, sha256Hex(data[1:]))
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.DataLoss {
+		t.Fatalf("upload with a wrong checksum: %v, want %s", err, codes.DataLoss)
+	}
+
+	download, err := h.client.DownloadFile(context.Background(), &proto.FileDownloadRequest{FileId: "f1"})
+	if err == nil {
+		_, err = download.Recv()
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("download of the rejected file: %v, want %s", err, codes.NotFound)
+	}
+	if _, ok := h.files.GetUploadOffset("f1"); ok {
+		t.Fatal("rejected upload kept for resume")
+	}
+}
+
+// A chunk corrupted in transit fails the upload even though the checksum
+// the uploader sent is right
+func TestUploadCorruptedChunkRejected(t *testing.T) {
+	h := newTestHub(t, nil)
+	data := randomBytes(1 << 20)
+	corrupted := append([]byte(nil), data...)
+	corrupted[3*testChunkSize+17] ^= 0x01
+
+	stream, err := h.client.UploadFile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendChunks(t, stream, "f1", corrupted, 0, sha256Hex(data))
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.DataLoss {
+		t.Fatalf("upload with a corrupted chunk: %v, want %s", err, codes.DataLoss)
+	}
+	if _, _, err := h.files.storage().Get("f1"); err == nil {
+		t.Fatal("corrupted upload was stored")
+	}
+}
+
+// A file corrupted in storage after upload still carries the checksum
+// recorded at upload, so the receiver notices
+func TestDownloadOfCorruptedFileDetected(t *testing.T) {
+	h := newTestHub(t, nil)
+	data := randomBytes(1 << 20)
+	stream, err := h.client.UploadFile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendChunks(t, stream, "f1", data, 0, sha256Hex(data))
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2] ^= 0x01
+	if err := h.files.storage().Put("f1", bytes.NewReader(corrupted)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, checksum := downloadFile(t, h, "f1")
+	if checksum != sha256Hex(data) || sha256Hex(got) == checksum {
+		t.Fatalf("download of a corrupted file: checksum %s for bytes hashing to %s", checksum, sha256Hex(got))
+	}
+	msg := &proto.Message{Id: "req-1", Metadata: map[string]string{codec.ContentFileKey: "f1"}}
+	if err := codec.Resolve(context.Background(), h.client, msg); err == nil {
+		t.Fatal("Resolve accepted corrupted content")
+	}
+	if msg.Content != "" {
+		t.Fatal("Resolve filled in corrupted content")
+	}
+}
+
+// Content too large for one message goes through file storage, as
+// clients and the SDK send it, and comes back unchanged
+func TestLargeContentRoundTrip(t *testing.T) {
+	h := newTestHub(t, nil)
+	// Base64 of random bytes, like an OCR image: gzip cannot shrink it once
+	// its output is base64-encoded too, so it is sent as is
+	content := base64.StdEncoding.EncodeToString(randomBytes(5 << 20))
+	msg := &proto.Message{Id: "req-1", Content: content}
+
+	if err := codec.Compress(msg, codec.DefaultCompressionThreshold); err != nil {
+		t.Fatal(err)
+	}
+	offloaded, err := codec.Offload(context.Background(), h.client, msg, codec.DefaultMaxMessageSize)
+	if err != nil {
+		t.Fatalf("offload: %v", err)
+	}
+	if !offloaded || msg.Content != "" {
+		t.Fatalf("offloaded = %v with %d bytes left in Content", offloaded, len(msg.Content))
+	}
+
+	if err := codec.Resolve(context.Background(), h.client, msg); err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if err := codec.Decompress(msg); err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if msg.Content != content {
+		t.Fatalf("got %d bytes of content back, want the %d sent", len(msg.Content), len(content))
+	}
+}
+
+// Binary content is offloaded and restored to BinaryContent; a message
+// with both contents is refused rather than losing one
+func TestOffloadBinaryContent(t *testing.T) {
+	h := newTestHub(t, nil)
+	content := randomBytes(5 << 20)
+	msg := &proto.Message{Id: "req-1", BinaryContent: content, Metadata: map[string]string{codec.MetadataKey: codec.MsgPack}}
+
+	offloaded, err := codec.Offload(context.Background(), h.client, msg, codec.DefaultMaxMessageSize)
+	if err != nil || !offloaded || len(msg.BinaryContent) != 0 {
+		t.Fatalf("offload: %v %v with %d bytes left", offloaded, err, len(msg.BinaryContent))
+	}
+	if err := codec.Resolve(context.Background(), h.client, msg); err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if !bytes.Equal(msg.BinaryContent, content) || msg.Content != "" {
+		t.Fatalf("got %d binary and %d text bytes back, want the %d binary sent", len(msg.BinaryContent), len(msg.Content), len(content))
+	}
+
+	both := &proto.Message{Id: "req-2", Content: `{"text":"kept"}`, BinaryContent: content}
+	if offloaded, err := codec.Offload(context.Background(), h.client, both, codec.DefaultMaxMessageSize); err == nil || offloaded {
+		t.Fatalf("message with both contents offloaded = %v, %v", offloaded, err)
+	}
+	if both.Content != `{"text":"kept"}` || len(both.BinaryContent) != len(content) || both.Metadata[codec.ContentFileKey] != "" {
+		t.Fatal("refused offload changed the message")
+	}
+}
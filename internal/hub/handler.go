@@ -22,11 +22,11 @@ func (h *Handler) Handle(ctx context.Context, req *proto.Request) (*proto.Respon
 		return h.handleJSON(req)
 	case proto.RequestType_FILE:
 		return h.handleFile(req)
-	case proto.RequestType_CONTROL:
+	case proto.RequestType_CONTROL_REQUEST:
 		return h.handleControl(req)
 	default:
 		logger.Warn("Unknown request type")
-		return &proto.Response{Status: proto.Status_ERROR}, nil
+		return &proto.Response{Status: proto.Status_ERROR_STATUS}, nil
 	}
 }
 
@@ -2,60 +2,129 @@ package hub
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	protobuf "google.golang.org/protobuf/proto"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/envelope"
 	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/pkg/logger"
 )
 
 // handleRegistration xử lý worker registration
 func (s *Server) handleRegistration(msg *proto.Message) {
-	fmt.Printf("📋 Processing registration from %s\n", msg.From)
-	fmt.Printf("📄 Registration content: %s\n", msg.Content)
+	log := logger.Emoji("📋").WithField("client_id", msg.From)
+	log.Debug("processing registration")
+
+	content, err := codec.Content(msg)
+	if err != nil {
+		log.WithError(err).Error("failed to read registration")
+		return
+	}
+	log.WithField("content", content).Debug("registration content")
 
 	var regData struct {
 		WorkerID     string                   `json:"worker_id"`
 		WorkerType   string                   `json:"worker_type"`
 		Capabilities []ServiceCapability      `json:"capabilities"`
 		Metadata     map[string]interface{}   `json:"metadata"`
+		Labels       map[string]string        `json:"labels"`
+		Namespace    string                   `json:"namespace"`
 	}
 
-	if err := json.Unmarshal([]byte(msg.Content), &regData); err != nil {
-		fmt.Printf("❌ Failed to parse registration: %v\n", err)
+	if err := json.Unmarshal([]byte(content), &regData); err != nil {
+		log.WithError(err).Error("failed to parse registration")
 		return
 	}
 
-	fmt.Printf("🔍 Received %d capabilities from %s\n", len(regData.Capabilities), regData.WorkerID)
-	for i, cap := range regData.Capabilities {
-		fmt.Printf("  Cap %d: %s (http_method=%s, accepts_file=%v, file_field=%s)\n", 
-			i, cap.Name, cap.HTTPMethod, cap.AcceptsFile, cap.FileFieldName)
+	// Authenticated workers may only register under their own identity
+	if s.authenticator != nil && regData.WorkerID != msg.From {
+		logger.Emoji("⛔").WithFields(logger.Fields{"client_id": msg.From, "worker_id": regData.WorkerID}).
+			Warn("registration under another identity rejected")
+		s.sendErrorResponse(msg, apierr.Newf(apierr.CodeForbidden, "cannot register as %s", regData.WorkerID))
+		return
+	}
+
+	// A worker_id served by another live stream would orphan that worker
+	if regData.WorkerID != msg.From && s.connMgr.Has(regData.WorkerID) {
+		logger.Emoji("⚠️").WithFields(logger.Fields{"client_id": msg.From, "worker_id": regData.WorkerID}).
+			Warn("ID collision: worker id is connected on another stream")
+		s.sendErrorResponse(msg, apierr.Newf(apierr.CodeConflict, "worker id %s is already connected", regData.WorkerID))
+		return
+	}
+
+	// Workers register in their connection's namespace. Without auth the
+	// registration may pick it; with auth it must match the credentials.
+	if regData.Namespace != "" {
+		namespace := normalizeNamespace(regData.Namespace)
+		if s.authenticator != nil && namespace != s.connMgr.Namespace(msg.From) {
+			logger.Emoji("⛔").WithFields(logger.Fields{"client_id": msg.From, "namespace": namespace}).
+				Warn("registration in another namespace rejected")
+			s.sendErrorResponse(msg, apierr.Newf(apierr.CodeForbidden, "cannot register in namespace %s", namespace))
+			return
+		}
+		s.connMgr.SetNamespace(msg.From, namespace)
+	}
+
+	for _, cap := range regData.Capabilities {
+		logger.WithFields(logger.Fields{
+			"worker_id":    regData.WorkerID,
+			"capability":   cap.Name,
+			"http_method":  cap.HTTPMethod,
+			"accepts_file": cap.AcceptsFile,
+			"file_field":   cap.FileFieldName,
+		}).Debug("capability received")
 	}
 
 	// Create worker info
 	workerInfo := &WorkerInfo{
 		ID:           regData.WorkerID,
 		Type:         regData.WorkerType,
-		Status:       "online",
+		Status:       WorkerStatusOnline,
 		Capabilities: regData.Capabilities,
 		Metadata:     regData.Metadata,
+		Labels:       regData.Labels,
+		Namespace:    s.connMgr.Namespace(msg.From),
 		RegisteredAt: time.Now().Format(time.RFC3339),
 		LastSeen:     time.Now().Format(time.RFC3339),
+
+		MaxConcurrency: maxConcurrency(regData.Metadata),
 	}
 
 	// Register with registry
-	s.registry.RegisterWorker(regData.WorkerID, workerInfo)
+	if err := s.registry.RegisterWorker(regData.WorkerID, workerInfo); err != nil {
+		log.WithError(err).Warn("registration rejected")
+		code := apierr.CodeValidation
+		if errors.Is(err, ErrSchemaDrift) {
+			code = apierr.CodeConflict
+		}
+		s.sendErrorResponse(msg, apierr.New(code, err.Error()))
+		return
+	}
+	s.connMgr.SetType(msg.From, ConnectionTypeWorker)
 
 	capNames := make([]string, len(regData.Capabilities))
 	for i, cap := range regData.Capabilities {
 		capNames[i] = cap.Name
 	}
 
-	fmt.Printf("✅ Worker registered: %s [%s] with capabilities: %v\n",
-		regData.WorkerID, regData.WorkerType, capNames)
+	logger.Emoji("✅").WithFields(logger.Fields{
+		"worker_id":    regData.WorkerID,
+		"worker_type":  regData.WorkerType,
+		"capabilities": capNames,
+		"labels":       workerInfo.Labels,
+		"namespace":    workerInfo.Namespace,
+	}).Info("worker registered")
 
 	// Send confirmation back to worker
 	confirmMsg := &proto.Message{
-		Id:        fmt.Sprintf("confirm-%d", time.Now().UnixNano()),
+		Id:        utils.PrefixedID("confirm"),
 		From:      "hub",
 		To:        msg.From,
 		Type:      proto.MessageType_RESPONSE,
@@ -66,196 +135,1227 @@ func (s *Server) handleRegistration(msg *proto.Message) {
 	s.dispatcher.Dispatch(confirmMsg)
 }
 
+// handleAuth xác nhận auth handshake
+func (s *Server) handleAuth(msg *proto.Message) {
+	ackMsg := &proto.Message{
+		Id:        msg.Id,
+		From:      "hub",
+		To:        msg.From,
+		Type:      proto.MessageType_RESPONSE,
+		Content:   fmt.Sprintf(`{"status":"authenticated","client_id":%q}`, msg.From),
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	s.dispatcher.Dispatch(ackMsg)
+}
+
+// Metadata hub thêm vào response của capability deprecated
+const (
+	DeprecatedMetadata         = "deprecated"          // tên capability
+	DeprecationMessageMetadata = "deprecation_message" // hướng dẫn migrate
+)
+
 // handleCapabilityDiscovery xử lý yêu cầu discovery capabilities
 func (s *Server) handleCapabilityDiscovery(msg *proto.Message) {
-	fmt.Printf("🔍 Processing capability discovery from %s\n", msg.From)
+	logger.Emoji("🔍").WithField("client_id", msg.From).Debug("processing capability discovery")
+
+	// Optional filters: {"action": "discover", "tag": "ocr", "labels": "region=eu",
+	// "namespace": "shared"} plus the workerQuery fields (status, type,
+	// capability, sort, order, limit, offset) for the workers list. Only the
+	// caller's namespace is listed unless it asks for one it has a grant for.
+	var query discoveryQuery
+	if content, err := codec.Content(msg); err == nil && content != "" {
+		json.Unmarshal([]byte(content), &query)
+	}
+	response, apiErr := s.discover(msg.From, s.namespaceOf(msg.From), query)
+	if apiErr != nil {
+		s.sendErrorResponse(msg, apiErr)
+		return
+	}
 
-	capabilities := s.registry.GetAllCapabilities()
-	workers := s.registry.GetAllWorkers()
+	responseJSON, _ := json.Marshal(response)
 
-	response := map[string]interface{}{
-		"capabilities": capabilities,
-		"workers":      workers,
+	responseMsg := &proto.Message{
+		Id:        msg.Id,
+		From:      "hub",
+		To:        msg.From,
+		Type:      proto.MessageType_RESPONSE,
+		Content:   string(responseJSON),
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	copyCorrelation(msg, responseMsg)
+
+	s.dispatcher.Dispatch(responseMsg)
+	logger.Emoji("✅").WithFields(logger.Fields{"client_id": msg.From, "count": len(response["capabilities"].(map[string]ServiceCapability)), "tag": query.Tag}).
+		Debug("capabilities sent")
+}
+
+// CapabilityProvider là một worker cung cấp capability, trong discovery response
+type CapabilityProvider struct {
+	WorkerID string            `json:"worker_id"`
+	Status   string            `json:"status"`
+	Weight   float64           `json:"weight"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// capabilityProviders gom worker IDs, status, weight và labels theo capability,
+// chỉ cho các capabilities đang được liệt kê
+func capabilityProviders(providers map[string][]string, capabilities map[string]ServiceCapability, workers []*WorkerInfo) map[string][]CapabilityProvider {
+	byID := make(map[string]*WorkerInfo, len(workers))
+	for _, info := range workers {
+		byID[info.ID] = info
+	}
+
+	result := make(map[string][]CapabilityProvider, len(capabilities))
+	for name := range capabilities {
+		for _, workerID := range providers[name] {
+			provider := CapabilityProvider{WorkerID: workerID}
+			if info, ok := byID[workerID]; ok {
+				provider.Status = info.Status
+				provider.Weight = info.Weight
+				provider.Labels = info.Labels
+			}
+			result[name] = append(result[name], provider)
+		}
+	}
+	return result
+}
+
+// filterByWorkers giữ lại các capabilities và providers thuộc về workers
+// (sau khi lọc theo label selector)
+func filterByWorkers(capabilities map[string]ServiceCapability, providers map[string][]string, workers []*WorkerInfo) (map[string]ServiceCapability, map[string][]string) {
+	listed := make(map[string]bool, len(workers))
+	for _, info := range workers {
+		listed[info.ID] = true
+	}
+
+	filteredCaps := make(map[string]ServiceCapability, len(capabilities))
+	filteredProviders := make(map[string][]string, len(providers))
+	for name, cap := range capabilities {
+		for _, workerID := range providers[name] {
+			if listed[workerID] {
+				filteredProviders[name] = append(filteredProviders[name], workerID)
+			}
+		}
+		if len(filteredProviders[name]) > 0 {
+			filteredCaps[name] = cap
+		}
+	}
+	return filteredCaps, filteredProviders
+}
+
+// filterWorkersByTag giữ lại workers có capability mang tag, chỉ với các capability đó
+func filterWorkersByTag(workers []*WorkerInfo, tag string) []*WorkerInfo {
+	filtered := make([]*WorkerInfo, 0, len(workers))
+	for _, info := range workers {
+		caps := make([]ServiceCapability, 0, len(info.Capabilities))
+		for _, cap := range info.Capabilities {
+			if cap.HasTag(tag) {
+				caps = append(caps, cap)
+			}
+		}
+		if len(caps) == 0 {
+			continue
+		}
+		info.Capabilities = caps
+		filtered = append(filtered, info)
+	}
+	return filtered
+}
+
+// handleControl xử lý hub control messages theo msg.Action
+func (s *Server) handleControl(msg *proto.Message) {
+	logger.Emoji("🛠️").WithFields(logger.Fields{"client_id": msg.From, "action": msg.Action}).Info("control message")
+
+	switch msg.Action {
+	case "system_health":
+		s.handleSystemHealth(msg)
+	case ControlActionDrain:
+		s.handleDrain(msg)
+	case ControlActionDrained:
+		s.handleDrained(msg)
+	case "list_connections":
+		s.handleListConnections(msg)
+	case ControlActionUnregisterWorker:
+		s.handleUnregisterWorker(msg)
+	case ControlActionUpdateMetadata:
+		s.handleUpdateMetadata(msg)
+	case ControlActionSetCapability:
+		s.handleSetCapability(msg)
+	case ControlActionCapabilityStats:
+		s.handleCapabilityStats(msg)
+	case ControlActionSubscribe, ControlActionUnsubscribe:
+		s.handleSubscription(msg)
+	case ControlActionUploadOffset:
+		s.handleUploadOffset(msg)
+	case ControlActionWorkerLogs:
+		s.handleWorkerLogs(msg)
+	case ControlActionListWorkers:
+		s.handleListWorkers(msg)
+	case ControlActionListChannels:
+		s.handleListChannels(msg)
+	default:
+		s.replyError(msg, apierr.Newf(apierr.CodeValidation, "Unknown control action: %s", msg.Action))
+	}
+}
+
+// Control actions cho rolling deployment: "drain" gửi tới worker, worker
+// trả "drained" khi đã xử lý xong các request đang chạy
+const (
+	ControlActionDrain   = "drain"
+	ControlActionDrained = "drained"
+)
+
+// handleDrain ngừng route request mới tới worker và chuyển lệnh drain cho
+// worker. Target là msg.To, {"worker_id": ...} trong content, hoặc chính
+// người gửi. Worker tự drain được; drain worker khác cần admin, và chỉ
+// trong namespace của người gửi.
+func (s *Server) handleDrain(msg *proto.Message) {
+	workerID := msg.To
+	if workerID == "" || workerID == "hub" {
+		var req struct {
+			WorkerID string `json:"worker_id"`
+		}
+		content, _ := codec.Content(msg)
+		json.Unmarshal([]byte(content), &req)
+		workerID = req.WorkerID
+	}
+	if workerID == "" {
+		workerID = msg.From
+	}
+
+	if workerID != msg.From && !s.isAdmin(msg.From) {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "%s is not an admin client", msg.From))
+		return
+	}
+	if !s.connMgr.Has(workerID) {
+		s.replyError(msg, apierr.Newf(apierr.CodeWorkerNotFound, "Worker not found: %s", workerID).
+			WithDetail("worker_id", workerID))
+		return
+	}
+	// NAMESPACE_GRANTS cho phép gọi worker, không cho phép drain nó
+	if from, target := s.namespaceOf(msg.From), s.namespaceOf(workerID); from != target {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "namespace %s may not drain workers in namespace %s", from, target).
+			WithDetail("namespace", from).
+			WithDetail("target_namespace", target))
+		return
+	}
+	if !s.registry.MarkDraining(workerID) {
+		s.replyError(msg, apierr.Newf(apierr.CodeWorkerNotFound, "Worker not found: %s", workerID).
+			WithDetail("worker_id", workerID))
+		return
+	}
+	logger.Emoji("🚰").WithFields(logger.Fields{"worker_id": workerID, "requested_by": msg.From}).Info("worker draining")
+
+	// From giữ nguyên requester để ack "drained" quay về đúng người yêu cầu
+	drainMsg := &proto.Message{
+		Id:        utils.PrefixedID("drain"),
+		RequestId: msg.Id,
+		From:      msg.From,
+		To:        workerID,
+		Type:      proto.MessageType_CONTROL,
+		Action:    ControlActionDrain,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	copyCorrelation(msg, drainMsg)
+	s.dispatcher.Dispatch(drainMsg)
+
+	if msg.From != workerID {
+		s.replyControl(msg, map[string]interface{}{
+			"worker_id": workerID,
+			"status":    WorkerStatusDraining,
+		})
+	}
+}
+
+// handleDrained gỡ worker khỏi registry khi nó báo đã drain xong (kết nối
+// vẫn giữ) và báo lại cho người đã yêu cầu drain
+func (s *Server) handleDrained(msg *proto.Message) {
+	s.registry.UnregisterWorker(msg.From)
+	logger.Emoji("✅").WithFields(logger.Fields{"worker_id": msg.From}).Info("worker drained and deregistered")
+
+	if msg.To == "" || msg.To == "hub" || msg.To == msg.From || !s.connMgr.Has(msg.To) {
+		return
+	}
+
+	ack := &proto.Message{
+		Id:        utils.PrefixedID("drained"),
+		RequestId: msg.RequestId,
+		From:      msg.From,
+		To:        msg.To,
+		Type:      proto.MessageType_RESPONSE,
+		Action:    ControlActionDrained,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	content, _ := json.Marshal(map[string]interface{}{
+		"worker_id": msg.From,
+		"status":    ControlActionDrained,
+	})
+	ack.Content = string(content)
+	copyCorrelation(msg, ack)
+	s.dispatcher.Dispatch(ack)
+}
+
+// handleListConnections trả về các kết nối đang mở (chỉ cho admin), để tìm
+// ghost connections
+func (s *Server) handleListConnections(msg *proto.Message) {
+	if !s.isAdmin(msg.From) {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "%s is not an admin client", msg.From))
+		return
+	}
+
+	statuses := make(map[string]string)
+	for _, worker := range s.registry.GetAllWorkers() {
+		statuses[worker.ID] = worker.Status
+	}
+
+	connections := s.connMgr.List()
+	for i := range connections {
+		connections[i].WorkerStatus = statuses[connections[i].ClientID]
+	}
+
+	s.replyControl(msg, map[string]interface{}{
+		"connections": connections,
+		"count":       len(connections),
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// ControlActionListWorkers liệt kê workers của mọi namespace (chỉ cho
+// admin) với các bộ lọc, sắp xếp và phân trang của workerQuery, vd:
+// {"status": "online", "sort": "requests_handled", "order": "desc", "limit": 20}
+const ControlActionListWorkers = "list_workers"
+
+// handleListWorkers trả về một trang workers kèm tổng số workers khớp
+func (s *Server) handleListWorkers(msg *proto.Message) {
+	if !s.isAdmin(msg.From) {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "%s is not an admin client", msg.From))
+		return
+	}
+
+	var query struct {
+		workerQuery
+		Namespace string `json:"namespace"`
+	}
+	if content, _ := codec.Content(msg); content != "" {
+		if err := json.Unmarshal([]byte(content), &query); err != nil {
+			s.replyError(msg, apierr.Newf(apierr.CodeValidation, "invalid list_workers request: %v", err))
+			return
+		}
+	}
+	namespace := AllNamespaces
+	if query.Namespace != "" {
+		namespace = normalizeNamespace(query.Namespace)
+	}
+	filter, err := query.filter(namespace)
+	if err != nil {
+		s.replyError(msg, apierr.New(apierr.CodeValidation, err.Error()))
+		return
+	}
+	list, err := s.registry.ListWorkers(filter)
+	if err != nil {
+		s.replyError(msg, apierr.New(apierr.CodeValidation, err.Error()))
+		return
+	}
+
+	s.replyControl(msg, map[string]interface{}{
+		"workers":   list.Workers,
+		"total":     list.Total,
+		"offset":    list.Offset,
+		"limit":     list.Limit,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// ControlActionUnregisterWorker buộc gỡ một worker (chỉ cho admin), vd:
+// ghost worker chết mà không đóng stream
+const ControlActionUnregisterWorker = "unregister_worker"
+
+// handleUnregisterWorker gỡ worker {"worker_id": ...} khỏi registry và
+// database rồi đóng kết nối của nó, trả về các capabilities đã bị gỡ
+func (s *Server) handleUnregisterWorker(msg *proto.Message) {
+	if !s.isAdmin(msg.From) {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "%s is not an admin client", msg.From))
+		return
+	}
+
+	var req struct {
+		WorkerID string `json:"worker_id"`
+	}
+	content, _ := codec.Content(msg)
+	json.Unmarshal([]byte(content), &req)
+	if req.WorkerID == "" {
+		s.replyError(msg, apierr.New(apierr.CodeValidation, "worker_id is required"))
+		return
+	}
+
+	removed, found := s.registry.DeleteWorker(req.WorkerID)
+	if !found {
+		s.replyError(msg, apierr.Newf(apierr.CodeWorkerNotFound, "Worker not found: %s", req.WorkerID).
+			WithDetail("worker_id", req.WorkerID))
+		return
+	}
+	closed := s.connMgr.Kick(req.WorkerID)
+	logger.Emoji("🧹").WithFields(logger.Fields{
+		"worker_id":         req.WorkerID,
+		"requested_by":      msg.From,
+		"capabilities":      len(removed),
+		"connection_closed": closed,
+	}).Warn("worker unregistered by admin")
+
+	if removed == nil {
+		removed = []string{}
+	}
+	s.replyControl(msg, map[string]interface{}{
+		"worker_id":            req.WorkerID,
+		"removed_capabilities": removed,
+		"connection_closed":    closed,
+		"timestamp":            time.Now().Format(time.RFC3339),
+	})
+}
+
+// ControlActionUpdateMetadata vá metadata của chính worker gửi (vd: load,
+// queue depth) mà không gửi lại toàn bộ registration
+const ControlActionUpdateMetadata = "update_metadata"
+
+// handleUpdateMetadata áp dụng {"metadata": {...}} lên worker msg.From; key
+// có giá trị null bị xóa
+func (s *Server) handleUpdateMetadata(msg *proto.Message) {
+	var req struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	content, _ := codec.Content(msg)
+	if err := json.Unmarshal([]byte(content), &req); err != nil {
+		s.replyError(msg, apierr.Newf(apierr.CodeValidation, "Invalid update_metadata payload: %v", err))
+		return
+	}
+	if len(req.Metadata) == 0 {
+		s.replyError(msg, apierr.New(apierr.CodeValidation, "metadata is required"))
+		return
+	}
+
+	metadata, found := s.registry.UpdateWorkerMetadata(msg.From, req.Metadata)
+	if !found {
+		s.replyError(msg, apierr.Newf(apierr.CodeWorkerNotFound, "Worker not registered: %s", msg.From).
+			WithDetail("worker_id", msg.From))
+		return
+	}
+	s.replyControl(msg, map[string]interface{}{
+		"worker_id": msg.From,
+		"metadata":  metadata,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// ControlActionSetCapability tắt hoặc bật lại một capability của chính
+// worker gửi (vd: ocr_batch trong lúc reload model) mà không ngắt kết nối
+const ControlActionSetCapability = "set_capability"
+
+// handleSetCapability áp dụng {"capability": ..., "enabled": true|false} lên
+// worker msg.From
+func (s *Server) handleSetCapability(msg *proto.Message) {
+	var req struct {
+		Capability string `json:"capability"`
+		Enabled    *bool  `json:"enabled"`
+	}
+	content, _ := codec.Content(msg)
+	if err := json.Unmarshal([]byte(content), &req); err != nil {
+		s.replyError(msg, apierr.Newf(apierr.CodeValidation, "Invalid set_capability payload: %v", err))
+		return
+	}
+	if req.Capability == "" || req.Enabled == nil {
+		s.replyError(msg, apierr.New(apierr.CodeValidation, "capability and enabled are required"))
+		return
+	}
+
+	changed, err := s.registry.SetCapabilityEnabled(msg.From, req.Capability, *req.Enabled)
+	switch {
+	case errors.Is(err, ErrWorkerNotFound):
+		s.replyError(msg, apierr.Newf(apierr.CodeWorkerNotFound, "Worker not registered: %s", msg.From).
+			WithDetail("worker_id", msg.From))
+		return
+	case errors.Is(err, ErrUnknownCapability):
+		s.replyError(msg, apierr.Newf(apierr.CodeUnknownCapability, "Worker %s does not provide %s", msg.From, req.Capability).
+			WithDetail("capability", req.Capability))
+		return
+	}
+	s.replyControl(msg, map[string]interface{}{
+		"worker_id":  msg.From,
+		"capability": req.Capability,
+		"enabled":    *req.Enabled,
+		"changed":    changed,
+		"timestamp":  time.Now().Format(time.RFC3339),
+	})
+}
+
+// ControlActionUploadOffset hỏi hub đã nhận bao nhiêu byte của một upload
+// bị gián đoạn, để client gửi tiếp từ offset đó thay vì upload lại
+const ControlActionUploadOffset = "upload_offset"
+
+// handleUploadOffset trả về offset của {"file_id": ...}: số byte đã nhận
+// nếu upload còn dở, kích thước file nếu đã lưu xong (complete), 0 nếu
+// hub không biết file
+func (s *Server) handleUploadOffset(msg *proto.Message) {
+	var req struct {
+		FileID string `json:"file_id"`
+	}
+	content, _ := codec.Content(msg)
+	json.Unmarshal([]byte(content), &req)
+	if !validFileID(req.FileID) {
+		s.replyError(msg, apierr.Newf(apierr.CodeValidation, "Invalid file id %q", req.FileID))
+		return
+	}
+
+	offset, pending := s.files.GetUploadOffset(req.FileID)
+	complete := false
+	if !pending {
+		if info, ok := s.files.lookup(req.FileID); ok {
+			offset, complete = info.Size, true
+		}
+	}
+	s.replyControl(msg, map[string]interface{}{
+		"file_id":   req.FileID,
+		"offset":    offset,
+		"pending":   pending,
+		"complete":  complete,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// ControlActionCapabilitiesChanged được hub gửi tới các gateway khi worker
+// đăng ký, gỡ đăng ký hoặc đổi status, để gateway xóa discovery cache
+const ControlActionCapabilitiesChanged = "capabilities_changed"
+
+// systemChannelPrefix đánh dấu các channel chỉ hub được publish
+const systemChannelPrefix = "system:"
+
+// CapabilitiesChannel nhận một CHANNEL message (content là RegistryChange
+// dạng JSON) cho mỗi thay đổi của registry
+const CapabilitiesChannel = systemChannelPrefix + "capabilities"
+
+// notifyCapabilitiesChanged báo thay đổi của registry: gửi CONTROL
+// capabilities_changed tới các kết nối api-gateway và publish delta lên
+// CapabilitiesChannel (registry gọi hàm này sau mỗi thay đổi). Chỉ client
+// được gọi sang namespace của worker mới nhận được thay đổi.
+func (s *Server) notifyCapabilitiesChanged(change RegistryChange) {
+	content, _ := json.Marshal(struct {
+		RegistryChange
+		Timestamp string `json:"timestamp"`
+	}{change, time.Now().Format(time.RFC3339)})
+	visible := func(clientID string) bool {
+		return s.grants.allows(s.namespaceOf(clientID), change.Namespace)
+	}
+
+	for _, clientID := range s.connMgr.ClientIDsOfType(ConnectionTypeGateway) {
+		if !visible(clientID) {
+			continue
+		}
+		msg := &proto.Message{
+			Id:        utils.PrefixedID("caps"),
+			From:      "hub",
+			To:        clientID,
+			Type:      proto.MessageType_CONTROL,
+			Action:    ControlActionCapabilitiesChanged,
+			Content:   string(content),
+			Timestamp: time.Now().Format(time.RFC3339),
+		}
+		if err := s.connMgr.Send(clientID, msg); err != nil {
+			logger.Emoji("⚠️").WithError(err).WithFields(logger.Fields{"client_id": clientID}).Warn("failed to notify capabilities change")
+		}
+	}
+
+	event := &proto.Message{
+		Id:        utils.PrefixedID("caps-event"),
+		From:      "hub",
+		Type:      proto.MessageType_CHANNEL,
+		Channel:   CapabilitiesChannel,
+		Action:    change.Event,
+		Content:   string(content),
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	for _, clientID := range s.channels.Subscribers(CapabilitiesChannel) {
+		if !visible(clientID) {
+			continue
+		}
+		if err := s.connMgr.Send(clientID, event); err != nil {
+			s.router.RecordDeadLetter()
+			logger.Emoji("❌").WithFields(logger.Fields{"msg_id": event.Id, "channel": CapabilitiesChannel, "to": clientID}).WithError(err).Warn("failed to publish message")
+		}
+	}
+}
+
+// Control actions để subscribe/unsubscribe một channel (tương đương
+// MessageType SUBSCRIBE/UNSUBSCRIBE); channel lấy từ msg.Channel hoặc
+// {"channel": ...} trong content
+const (
+	ControlActionSubscribe   = "subscribe"
+	ControlActionUnsubscribe = "unsubscribe"
+)
+
+// handleSubscription thêm/gỡ người gửi khỏi subscribers của channel
+func (s *Server) handleSubscription(msg *proto.Message) {
+	channel := msg.Channel
+	if channel == "" {
+		var req struct {
+			Channel string `json:"channel"`
+		}
+		content, _ := codec.Content(msg)
+		json.Unmarshal([]byte(content), &req)
+		channel = req.Channel
+	}
+	if channel == "" {
+		s.replyError(msg, apierr.New(apierr.CodeValidation, "channel is required"))
+		return
+	}
+
+	subscribe := msg.Type == proto.MessageType_SUBSCRIBE || msg.Action == ControlActionSubscribe
+	if subscribe && strings.HasPrefix(channel, LogsChannelPrefix) && !s.isAdmin(msg.From) {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "%s is not an admin client", msg.From))
+		return
+	}
+
+	status := "subscribed"
+	replayed := 0
+	if subscribe {
+		replayed = s.channels.Join(channel, msg.From)
+	} else {
+		s.channels.Unsubscribe(channel, msg.From)
+		status = "unsubscribed"
+	}
+	logger.Emoji("📡").WithFields(logger.Fields{"client_id": msg.From, "channel": channel, "status": status}).Info("channel subscription updated")
+
+	reply := map[string]interface{}{
+		"channel": channel,
+		"status":  status,
+	}
+	if subscribe {
+		reply["history"] = replayed // số message cũ đã gửi lại trước reply này
+	}
+	s.replyControl(msg, reply)
+}
+
+// ControlActionListChannels liệt kê các channel kèm số subscriber và số
+// message trong history (chỉ cho admin)
+const ControlActionListChannels = "list_channels"
+
+// handleListChannels trả về channels có subscriber hoặc history
+func (s *Server) handleListChannels(msg *proto.Message) {
+	if !s.isAdmin(msg.From) {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "%s is not an admin client", msg.From))
+		return
+	}
+
+	channels := s.channels.Channels()
+	s.replyControl(msg, map[string]interface{}{
+		"channels":     channels,
+		"count":        len(channels),
+		"history_size": s.channels.HistorySize(),
+		"timestamp":    time.Now().Format(time.RFC3339),
+	})
+}
+
+// LogsChannelPrefix + worker ID là channel worker forward log của nó lên
+// (xem ControlActionWorkerLogs). Chỉ worker đó được publish, chỉ admin
+// được subscribe.
+const LogsChannelPrefix = "logs:"
+
+// ControlActionWorkerLogs bật/tắt việc forward log của một worker (chỉ cho
+// admin), vd: {"worker_id": "go-worker", "enabled": true}. Hub chuyển
+// message tới worker và trả về channel để subscribe.
+const ControlActionWorkerLogs = "worker_logs"
+
+// handleWorkerLogs chuyển yêu cầu bật/tắt log forwarding tới worker
+func (s *Server) handleWorkerLogs(msg *proto.Message) {
+	if !s.isAdmin(msg.From) {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "%s is not an admin client", msg.From))
+		return
+	}
+
+	var req struct {
+		WorkerID string `json:"worker_id"`
+		Enabled  bool   `json:"enabled"`
+	}
+	content, _ := codec.Content(msg)
+	json.Unmarshal([]byte(content), &req)
+	if req.WorkerID == "" {
+		s.replyError(msg, apierr.New(apierr.CodeValidation, "worker_id is required"))
+		return
+	}
+	if _, ok := s.registry.WorkerNamespace(req.WorkerID); !ok || !s.connMgr.Has(req.WorkerID) {
+		s.replyError(msg, apierr.Newf(apierr.CodeWorkerNotFound, "Worker not found: %s", req.WorkerID).
+			WithDetail("worker_id", req.WorkerID))
+		return
+	}
+
+	toggle, _ := json.Marshal(map[string]bool{"enabled": req.Enabled})
+	toggleMsg := &proto.Message{
+		Id:        utils.PrefixedID("logs"),
+		RequestId: msg.Id,
+		From:      msg.From,
+		To:        req.WorkerID,
+		Type:      proto.MessageType_CONTROL,
+		Action:    ControlActionWorkerLogs,
+		Content:   string(toggle),
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	copyCorrelation(msg, toggleMsg)
+	s.dispatcher.Dispatch(toggleMsg)
+	logger.Emoji("📜").WithFields(logger.Fields{"worker_id": req.WorkerID, "enabled": req.Enabled, "requested_by": msg.From}).Info("worker log forwarding toggled")
+
+	s.replyControl(msg, map[string]interface{}{
+		"worker_id": req.WorkerID,
+		"enabled":   req.Enabled,
+		"channel":   LogsChannelPrefix + req.WorkerID,
+	})
+}
+
+// replyControl trả kết quả của control action cho người gửi
+func (s *Server) replyControl(msg *proto.Message, payload interface{}) {
+	content, _ := json.Marshal(payload)
+
+	responseMsg := &proto.Message{
+		Id:        msg.Id,
+		RequestId: msg.RequestId,
+		From:      "hub",
+		To:        msg.From,
+		Type:      proto.MessageType_RESPONSE,
+		Action:    msg.Action,
+		Content:   string(content),
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	copyCorrelation(msg, responseMsg)
+
+	s.dispatcher.Dispatch(responseMsg)
+}
+
+// Control actions đo round-trip tới hub: client gửi "ping", hub trả ngay
+// "pong" kèm timestamp gốc
+const (
+	ControlActionPing = "ping"
+	ControlActionPong = "pong"
+)
+
+// handlePing trả lời ping mà không qua registry hay worker
+func (s *Server) handlePing(msg *proto.Message) {
+	content, _ := json.Marshal(map[string]string{
+		"action":    ControlActionPong,
+		"timestamp": msg.Timestamp,
+		"hub_time":  time.Now().Format(time.RFC3339Nano),
+	})
+	pong := &proto.Message{
+		Id:        msg.Id,
+		RequestId: msg.RequestId,
+		From:      "hub",
+		To:        msg.From,
+		Type:      proto.MessageType_RESPONSE,
+		Action:    ControlActionPong,
+		Content:   string(content),
+		Timestamp: msg.Timestamp,
+	}
+	copyCorrelation(msg, pong)
+	s.dispatcher.Dispatch(pong)
+}
+
+// ControlActionCapabilityStats trả về latency (p50/p95/p99) và số request
+// theo capability; {"capability": ...} trong content để lọc một capability
+const ControlActionCapabilityStats = "capability_stats"
+
+// handleCapabilityStats trả về thống kê latency theo capability
+func (s *Server) handleCapabilityStats(msg *proto.Message) {
+	var req struct {
+		Capability string `json:"capability"`
+	}
+	content, _ := codec.Content(msg)
+	json.Unmarshal([]byte(content), &req)
+
+	stats := s.latency.Snapshot()
+	if req.Capability != "" {
+		filtered := make(map[string]CapabilityLatency)
+		if capStats, found := stats[req.Capability]; found {
+			filtered[req.Capability] = capStats
+		}
+		stats = filtered
+	}
+
+	cache := s.cache.Stats().Capabilities
+	if req.Capability != "" {
+		filtered := make(map[string]CapabilityCacheStats)
+		if capStats, found := cache[req.Capability]; found {
+			filtered[req.Capability] = capStats
+		}
+		cache = filtered
+	}
+
+	s.replyControl(msg, map[string]interface{}{
+		"capabilities": stats,
+		"cache":        cache,
+		"window":       s.latency.Window(),
 		"timestamp":    time.Now().Format(time.RFC3339),
+	})
+}
+
+// handleSystemHealth trả về trạng thái tổng hợp của hub
+func (s *Server) handleSystemHealth(msg *proto.Message) {
+	workersByStatus := make(map[string]int)
+	for _, worker := range s.registry.GetAllWorkers() {
+		workersByStatus[worker.Status]++
+	}
+
+	response := map[string]interface{}{
+		"status":             "running",
+		"version":            Version,
+		"uptime_seconds":     time.Since(s.startedAt).Seconds(),
+		"started_at":         s.startedAt.Format(time.RFC3339),
+		"active_connections": s.connMgr.Count(),
+		"workers":            workersByStatus,
+		"capability_count":   len(s.registry.GetAllCapabilities()),
+		"dead_letters":       s.router.DeadLetterCount(),
+		"dispatcher":         s.dispatcher.Stats(),
+		"breakers":           s.registry.BreakerStates(),
+		"requests":           s.requestTracker.GetStats(),
+		"cache":              s.cache.Stats(),
+		"timestamp":          time.Now().Format(time.RFC3339),
 	}
 
 	responseJSON, _ := json.Marshal(response)
 
 	responseMsg := &proto.Message{
 		Id:        msg.Id,
+		RequestId: msg.RequestId,
 		From:      "hub",
 		To:        msg.From,
 		Type:      proto.MessageType_RESPONSE,
+		Action:    msg.Action,
 		Content:   string(responseJSON),
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
+	copyCorrelation(msg, responseMsg)
 
 	s.dispatcher.Dispatch(responseMsg)
-	fmt.Printf("✅ Sent %d capabilities to %s\n", len(capabilities), msg.From)
+}
+
+// recordWorkerResult cập nhật circuit breaker theo response của worker.
+// Chỉ lỗi phía worker (thực thi lỗi, internal, timeout) được tính; lỗi do
+// request (validation, ...) vẫn là worker hoạt động bình thường. WORKER_BUSY
+// không tính gì, nhưng trả lượt thử nếu request là request thử half-open.
+func (s *Server) recordWorkerResult(workerID string, msg *proto.Message) {
+	failed := false
+	if apiErr, isErr := envelope.Error(msg); isErr {
+		switch apiErr.Code {
+		case apierr.CodeExecution, apierr.CodeInternal, apierr.CodeTimeout:
+			failed = true
+		case apierr.CodeWorkerBusy:
+			s.registry.ReleaseBreaker(workerID)
+			return
+		}
+	}
+	if s.registry.RecordWorkerResult(workerID, failed) {
+		logger.Emoji("🔌").WithFields(logger.Fields{"worker_id": workerID}).Warn("circuit breaker opened")
+	}
+}
+
+// handleRequestExpired tính request không có response là một lần timeout
+// của worker
+func (s *Server) handleRequestExpired(info RequestInfo) {
+	logger.Emoji("⌛").WithFields(logger.Fields{"request_id": info.RequestID, "worker_id": info.WorkerID, "capability": info.Capability}).
+		Warn("request expired without response")
+	s.latency.RecordTimeout(info.Capability)
+	if s.registry.RecordWorkerResult(info.WorkerID, true) {
+		logger.Emoji("🔌").WithFields(logger.Fields{"worker_id": info.WorkerID}).Warn("circuit breaker opened")
+	}
+}
+
+// forwardToWaiter gửi bản sao response cho một request trùng đã được gộp
+func (s *Server) forwardToWaiter(msg *proto.Message, waiter Waiter) {
+	if !s.connMgr.Has(waiter.RequesterID) {
+		s.router.RecordDeadLetter()
+		return
+	}
+
+	reply := protobuf.Clone(msg).(*proto.Message)
+	reply.To = waiter.RequesterID
+	reply.RequestId = waiter.RequestID
+	if reply.Metadata == nil {
+		reply.Metadata = make(map[string]string)
+	}
+	reply.Metadata[envelope.RequestIDKey] = waiter.MessageID
+	delete(reply.Metadata, CorrelationIDMetadata)
+	if waiter.CorrelationID != "" {
+		reply.Metadata[CorrelationIDMetadata] = waiter.CorrelationID
+	}
+	s.dispatcher.Dispatch(reply)
 }
 
 // handleServiceRequest route request to appropriate worker
 func (s *Server) handleServiceRequest(msg *proto.Message) {
-	fmt.Printf("📨 Processing service request from %s to %s\n", msg.From, msg.To)
-
 	// Generate request_id if not present
 	if msg.RequestId == "" {
-		msg.RequestId = fmt.Sprintf("req-%d", time.Now().UnixNano())
+		msg.RequestId = utils.PrefixedID("req")
 	}
 
-	// Check if capability is in metadata
-	capability, hasCapability := msg.Metadata["capability"]
-	
-	// If not in metadata, try parsing from content (backward compatibility)
-	if !hasCapability {
-		var reqData struct {
-			Capability string                 `json:"capability"`
-			Payload    map[string]interface{} `json:"payload"`
-		}
+	capability, err := envelope.Capability(msg)
+	if err != nil {
+		logger.Emoji("❌").WithFields(messageFields(msg)).WithError(err).Error("failed to read capability from request")
+		s.replyError(msg, apierr.Newf(apierr.CodeValidation, "Invalid request: %v", err))
+		return
+	}
+
+	fields := messageFields(msg)
+	fields["capability"] = capability
 
-		if err := json.Unmarshal([]byte(msg.Content), &reqData); err != nil {
-			fmt.Printf("❌ Failed to parse request and no capability in metadata: %v\n", err)
+	// Requests only reach workers in the caller's namespace unless
+	// NAMESPACE_GRANTS allows otherwise; a direct request's target decides
+	namespace := s.targetNamespace(msg)
+	if msg.To != "" && msg.To != "hub" {
+		namespace = s.namespaceOf(msg.To)
+	}
+	if apiErr := s.checkNamespace(msg, namespace); apiErr != nil {
+		s.replyError(msg, apiErr)
+		return
+	}
+	fields["namespace"] = namespace
+
+	// Cacheable capabilities are answered from the result cache if possible
+	cacheKey, cacheTTL, hit := s.serveFromCache(msg, namespace, capability)
+	if hit {
+		logger.Emoji("💾").WithFields(fields).Info("request answered from result cache")
+		return
+	}
+
+	// A retry of a request still in flight waits for its response
+	dedupKey := s.dedupKey(msg)
+	if dedupKey != "" {
+		waiter := Waiter{
+			RequesterID:   msg.From,
+			RequestID:     msg.RequestId,
+			MessageID:     msg.Id,
+			CorrelationID: msg.Metadata[CorrelationIDMetadata],
+		}
+		if primaryID, attached := s.requestTracker.Attach(dedupKey, s.config.DedupWindow, waiter); attached {
+			fields["attached_to"] = primaryID
+			logger.Emoji("🔁").WithFields(fields).Info("duplicate request attached to in-flight request")
 			return
 		}
-		capability = reqData.Capability
+	}
+
+	// Enforce per-client rate limit
+	if !s.rateLimiter.Allow(callerID(msg.From), capability) {
+		logger.Emoji("⛔").WithFields(fields).Warn("rate limit exceeded")
+		s.replyError(msg, apierr.Newf(apierr.CodeRateLimited, "Rate limit exceeded for capability: %s", capability).
+			WithDetail("capability", capability))
+		return
 	}
 
 	// If To field is already set, route directly
 	if msg.To != "" && msg.To != "hub" {
-		fmt.Printf("🎯 Routing request to specified worker: %s (capability: %s)\n", msg.To, capability)
-		
 		// Track request
-		s.requestTracker.Track(msg.RequestId, msg.From, msg.To, capability)
-		fmt.Printf("📝 Tracking request %s: %s → %s\n", msg.RequestId, msg.From, msg.To)
+		s.trackRequest(msg, msg.To, capability, dedupKey, cacheKey, cacheTTL)
+		fields["worker_id"] = msg.To
+		logger.Emoji("🎯").WithFields(fields).Info("request routed to specified worker")
 		
 		s.dispatcher.Dispatch(msg)
 		return
 	}
 
-	// Find worker for capability
-	workerID, found := s.registry.GetWorkerForCapability(capability)
-	if !found {
-		fmt.Printf("❌ No worker found for capability: %s\n", capability)
-		
-		// Send error response
-		errorMsg := &proto.Message{
-			Id:        msg.Id,
-			RequestId: msg.RequestId,
-			From:      "hub",
-			To:        msg.From,
-			Type:      proto.MessageType_RESPONSE,
-			Content:   fmt.Sprintf(`{"error":"No worker available for capability: %s"}`, capability),
-			Timestamp: time.Now().Format(time.RFC3339),
-		}
-		s.dispatcher.Dispatch(errorMsg)
+	// Find worker for capability (sticky when the request carries a session
+	// key, restricted to matching workers when it carries a label selector)
+	selector, err := ParseLabelSelector(msg.Metadata[LabelSelectorMetadata])
+	if err != nil {
+		logger.Emoji("❌").WithFields(fields).WithError(err).Warn("invalid label selector")
+		s.replyError(msg, apierr.New(apierr.CodeValidation, err.Error()).
+			WithDetail("label_selector", msg.Metadata[LabelSelectorMetadata]))
+		return
+	}
+	if len(selector) > 0 {
+		fields["label_selector"] = FormatLabelSelector(selector)
+	}
+	workerID, err := s.registry.SelectWorkerIn(namespace, capability, msg.Metadata[SessionKeyMetadata], selector)
+	if errors.Is(err, ErrNoMatchingWorker) {
+		logger.Emoji("🏷️").WithFields(fields).Warn("no worker for capability matches the label selector")
+		s.replyError(msg, apierr.Newf(apierr.CodeNoWorker, "No worker for capability %s matches labels %s", capability, FormatLabelSelector(selector)).
+			WithDetail("capability", capability).
+			WithDetail("label_selector", FormatLabelSelector(selector)))
+		return
+	}
+	if errors.Is(err, ErrWorkersBusy) {
+		logger.Emoji("⏳").WithFields(fields).Warn("all workers for capability at capacity")
+		s.replyError(msg, apierr.Newf(apierr.CodeWorkerBusy, "All workers for capability %s are at capacity, retry later", capability).
+			WithDetail("capability", capability))
+		return
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		logger.Emoji("🔌").WithFields(fields).Warn("all workers for capability have an open circuit breaker")
+		s.replyError(msg, apierr.Newf(apierr.CodeNoWorker, "All workers for capability %s are failing, retry later", capability).
+			WithDetail("capability", capability).
+			WithDetail("breaker", BreakerOpen))
+		return
+	}
+	if err != nil {
+		logger.Emoji("❌").WithFields(fields).Warn("no worker for capability")
+		s.replyError(msg, apierr.Newf(apierr.CodeNoWorker, "No worker available for capability: %s", capability).
+			WithDetail("capability", capability))
 		return
 	}
-
-	fmt.Printf("🎯 Routing %s request to worker: %s\n", capability, workerID)
 
 	// Track request before routing
-	s.requestTracker.Track(msg.RequestId, msg.From, workerID, capability)
-	fmt.Printf("📝 Tracking request %s: %s → %s\n", msg.RequestId, msg.From, workerID)
+	s.trackRequest(msg, workerID, capability, dedupKey, cacheKey, cacheTTL)
 
 	// Route to worker - preserve all message fields
 	msg.To = workerID
+	fields["worker_id"] = workerID
+	logger.Emoji("🎯").WithFields(fields).Info("request routed")
 	s.dispatcher.Dispatch(msg)
 }
 
+// IdempotencyKeyMetadata là metadata key client dùng để đánh dấu các lần
+// retry của cùng một request
+const IdempotencyKeyMetadata = "idempotency_key"
+
+// dedupKey trả về key gộp request trùng (requester|idempotency_key), rỗng
+// khi request không có idempotency key hoặc dedup bị tắt
+func (s *Server) dedupKey(msg *proto.Message) string {
+	key := msg.Metadata[IdempotencyKeyMetadata]
+	if key == "" || s.config.DedupWindow <= 0 {
+		return ""
+	}
+	return callerID(msg.From) + "|" + key
+}
+
+// trackRequest ghi nhận request đã route tới workerID để response quay về
+// đúng requester (và được cache dưới cacheKey nếu có)
+func (s *Server) trackRequest(msg *proto.Message, workerID, capability, dedupKey, cacheKey string, cacheTTL time.Duration) {
+	s.requestTracker.Track(msg.RequestId, msg.From, workerID, capability, msg.Metadata[CorrelationIDMetadata])
+	if dedupKey != "" {
+		s.requestTracker.SetDedupKey(msg.RequestId, dedupKey)
+	}
+	if cacheKey != "" {
+		s.requestTracker.SetCacheKey(msg.RequestId, cacheKey, cacheTTL)
+	}
+}
+
+// serveFromCache trả lời request bằng response đã cache (hit là true).
+// Khi capability cacheable, key và ttl dùng để cache response của request;
+// key rỗng khi cache tắt hoặc capability không cacheable. Request có
+// metadata cache_bypass=true luôn đi tới worker, response mới vẫn được cache.
+func (s *Server) serveFromCache(msg *proto.Message, namespace, capability string) (key string, ttl time.Duration, hit bool) {
+	if !s.cache.Enabled() {
+		return "", 0, false
+	}
+	target := ""
+	if msg.To != "" && msg.To != "hub" {
+		target = msg.To
+	}
+	if ttl = s.registry.CacheTTL(namespace, target, capability); ttl == 0 {
+		return "", 0, false
+	}
+	key, ok := cacheKey(namespace, capability, target, msg)
+	if !ok {
+		return "", 0, false
+	}
+	if msg.Metadata[CacheBypassMetadata] == "true" {
+		return key, ttl, false
+	}
+
+	cached, found := s.cache.Get(key, capability)
+	if !found {
+		return key, ttl, false
+	}
+	s.forwardToWaiter(cached, Waiter{
+		RequesterID:   msg.From,
+		RequestID:     msg.RequestId,
+		MessageID:     msg.Id,
+		CorrelationID: msg.Metadata[CorrelationIDMetadata],
+	})
+	return key, ttl, true
+}
+
 // handleWorkerCall routes worker-to-worker calls
 func (s *Server) handleWorkerCall(msg *proto.Message) {
-	fmt.Printf("🔗 Worker-to-Worker call: %s → %s (capability: %s)\n", msg.From, msg.To, msg.Channel)
+	fields := messageFields(msg)
 
 	// Validate target worker exists
 	targetWorker := msg.To
 	if targetWorker == "" {
-		fmt.Printf("❌ Worker call missing target worker\n")
-		s.sendErrorResponse(msg, "Target worker not specified")
+		logger.Emoji("❌").WithFields(fields).Warn("worker call missing target worker")
+		s.sendErrorResponse(msg, apierr.New(apierr.CodeValidation, "Target worker not specified"))
 		return
 	}
 
 	// Check if target worker is registered
 	if !s.connMgr.Has(targetWorker) {
-		fmt.Printf("❌ Target worker not found: %s\n", targetWorker)
-		s.sendErrorResponse(msg, fmt.Sprintf("Worker %s not found or offline", targetWorker))
+		logger.Emoji("❌").WithFields(fields).Warn("worker call target not found")
+		s.sendErrorResponse(msg, apierr.Newf(apierr.CodeWorkerNotFound, "Worker %s not found or offline", targetWorker).
+			WithDetail("worker_id", targetWorker))
+		return
+	}
+
+	// Workers in another namespace need a grant, like requests
+	if apiErr := s.checkNamespace(msg, s.namespaceOf(targetWorker)); apiErr != nil {
+		s.sendErrorResponse(msg, apiErr)
 		return
 	}
 
 	// Validate capability
-	capability := msg.Channel
-	if capability == "" {
-		// Try to get from metadata
-		if cap, ok := msg.Metadata["capability"]; ok {
-			capability = cap
-			msg.Channel = cap
-		} else {
-			fmt.Printf("❌ Worker call missing capability\n")
-			s.sendErrorResponse(msg, "Capability not specified")
-			return
-		}
+	capability, err := envelope.Capability(msg)
+	if err != nil {
+		logger.Emoji("❌").WithFields(fields).Warn("worker call missing capability")
+		s.sendErrorResponse(msg, apierr.New(apierr.CodeValidation, "Capability not specified"))
+		return
 	}
+	msg.Channel = capability
+
+	fields["capability"] = capability
 
 	// Check if target worker has the capability
-	workerForCap, found := s.registry.GetWorkerForCapability(capability)
-	if !found || workerForCap != targetWorker {
-		fmt.Printf("⚠️  Warning: Worker %s may not have capability %s\n", targetWorker, capability)
+	if !s.registry.WorkerHasCapability(targetWorker, capability) {
+		logger.Emoji("⚠️").WithFields(fields).Warn("target worker may not have capability")
 	}
 
-	fmt.Printf("✅ Forwarding worker call to %s\n", targetWorker)
+	logger.Emoji("🔗").WithFields(fields).Info("worker call forwarded")
 
-	// Forward the message to target worker
+	// Forward the message to target worker; the reply is matched by the
+	// call's ID (see handleResponse)
+	s.requestTracker.TrackWorkerCall(msg.Id, msg.From, targetWorker, capability)
 	s.dispatcher.Dispatch(msg)
 }
 
-// handleResponse routes responses back to original requester
+// handleResponse routes responses back to original requester. Only the
+// worker a request or worker call was sent to may answer it; responses
+// from anyone else are dead-lettered, so they cannot reach the requester,
+// the result cache or another worker's circuit breaker.
 func (s *Server) handleResponse(msg *proto.Message) {
-	fmt.Printf("📬 Response: %s → %s (request_id: %s)\n", msg.From, msg.To, msg.RequestId)
+	// Replies to worker-to-worker calls carry the call's ID in
+	// Metadata["request_id"]. They go back to the calling worker and never
+	// complete a client request, even when the call copied the RequestId
+	// of the client request that triggered it.
+	if callID := msg.Metadata[envelope.RequestIDKey]; callID != "" {
+		if call, found := s.requestTracker.GetWorkerCall(callID); found && call.TargetID != msg.From {
+			s.rejectResponse(msg, call.TargetID)
+			return
+		}
+		if call, found := s.requestTracker.CompleteWorkerCall(callID); found {
+			msg.To = call.CallerID
+			fields := messageFields(msg)
+			fields["capability"] = call.Capability
+			fields["duration_ms"] = time.Since(call.CreatedAt).Milliseconds()
+			logger.Emoji("📬").WithFields(fields).Info("worker call reply routed")
+			s.forwardResponse(msg)
+			return
+		}
+	}
 
-	// If request_id is present, use it to find original requester
+	// Otherwise the request tracker knows the client that sent the request
 	if msg.RequestId != "" {
-		if requesterID, found := s.requestTracker.GetRequester(msg.RequestId); found {
-			fmt.Printf("🔍 Found original requester via request_id %s: %s\n", msg.RequestId, requesterID)
-			
+		if info, found := s.requestTracker.Get(msg.RequestId); found {
+			if info.WorkerID != msg.From {
+				s.rejectResponse(msg, info.WorkerID)
+				return
+			}
+
 			// Override To field with original requester
-			msg.To = requesterID
+			msg.To = info.RequesterID
+
+			// Workers that don't echo the correlation ID get the request's
+			if info.CorrelationID != "" && msg.Metadata[CorrelationIDMetadata] == "" {
+				if msg.Metadata == nil {
+					msg.Metadata = make(map[string]string)
+				}
+				msg.Metadata[CorrelationIDMetadata] = info.CorrelationID
+			}
+			fields := messageFields(msg)
+			fields["capability"] = info.Capability
+			fields["duration_ms"] = time.Since(info.CreatedAt).Milliseconds()
+			logger.Emoji("📬").WithFields(fields).Info("response routed")
+
+			// Cho requester biết capability đã deprecated
+			if cap, ok := s.registry.GetCapability(info.WorkerID, info.Capability); ok && cap.Deprecated {
+				if msg.Metadata == nil {
+					msg.Metadata = make(map[string]string)
+				}
+				msg.Metadata[DeprecatedMetadata] = cap.Name
+				if cap.DeprecationMessage != "" {
+					msg.Metadata[DeprecationMessageMetadata] = cap.DeprecationMessage
+				}
+			}
 			
-			// Complete tracking (remove from map)
-			s.requestTracker.Complete(msg.RequestId)
-			fmt.Printf("✅ Request %s completed and removed from tracking\n", msg.RequestId)
+			s.recordWorkerResult(info.WorkerID, msg)
+			_, failed := envelope.Error(msg)
+			s.latency.Record(info.Capability, time.Since(info.CreatedAt), failed)
+			if info.CacheKey != "" && !failed {
+				s.cache.Put(info.CacheKey, info.Capability, msg, info.CacheTTL)
+			}
+
+			// Complete tracking (remove from map); duplicates get a copy
+			for _, waiter := range s.requestTracker.Complete(msg.RequestId) {
+				s.forwardToWaiter(msg, waiter)
+			}
 		} else {
-			fmt.Printf("⚠️  Request %s not found in tracker (may be expired or already completed)\n", msg.RequestId)
+			logger.Emoji("⚠️").WithFields(messageFields(msg)).
+				Debug("request not found in tracker (may be expired or already completed)")
 		}
 	}
+	s.forwardResponse(msg)
+}
 
+// rejectResponse dead-letters a response to a request that was sent to
+// workerID, not to the response's sender
+func (s *Server) rejectResponse(msg *proto.Message, workerID string) {
+	s.router.RecordDeadLetter()
+	fields := messageFields(msg)
+	fields["worker_id"] = workerID
+	logger.Emoji("⛔").WithFields(fields).Warn("response from a client the request was not sent to, dropping")
+}
+
+// forwardResponse delivers a response to msg.To
+func (s *Server) forwardResponse(msg *proto.Message) {
 	// Validate target
 	if msg.To == "" {
-		fmt.Printf("❌ Response missing target\n")
+		logger.Emoji("❌").WithFields(messageFields(msg)).Warn("response missing target")
 		return
 	}
 
 	// Check if target is connected
 	if !s.connMgr.Has(msg.To) {
-		fmt.Printf("❌ Response target not connected: %s\n", msg.To)
+		s.router.RecordDeadLetter()
+		logger.Emoji("❌").WithFields(messageFields(msg)).Warn("response target not connected")
 		return
 	}
 
 	// Forward response
 	s.dispatcher.Dispatch(msg)
-	fmt.Printf("✅ Response delivered to %s\n", msg.To)
 }
 
 // sendErrorResponse sends an error response back to requester
-func (s *Server) sendErrorResponse(originalMsg *proto.Message, errorMessage string) {
+func (s *Server) sendErrorResponse(originalMsg *proto.Message, apiErr *apierr.ErrorResponse) {
 	errorMsg := &proto.Message{
-		Id:        fmt.Sprintf("error-%d", time.Now().UnixNano()),
+		Id:        utils.PrefixedID("error"),
 		From:      "hub",
 		To:        originalMsg.From,
 		Type:      proto.MessageType_RESPONSE,
-		Content:   fmt.Sprintf(`{"error":"%s"}`, errorMessage),
 		Timestamp: time.Now().Format(time.RFC3339),
 		Metadata: map[string]string{
 			"original_message_id": originalMsg.Id,
 		},
 	}
+	envelope.SetError(errorMsg, apiErr)
+	copyCorrelation(originalMsg, errorMsg)
+	s.dispatcher.Dispatch(errorMsg)
+}
+
+// replyError answers a request in place, keeping its id and request_id so
+// the caller can correlate the failure
+func (s *Server) replyError(msg *proto.Message, apiErr *apierr.ErrorResponse) {
+	errorMsg := &proto.Message{
+		Id:        msg.Id,
+		RequestId: msg.RequestId,
+		From:      "hub",
+		To:        msg.From,
+		Type:      proto.MessageType_RESPONSE,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Metadata: map[string]string{
+			"status_code": fmt.Sprintf("%d", apiErr.HTTPStatus()),
+		},
+	}
+	envelope.SetError(errorMsg, apiErr)
+	copyCorrelation(msg, errorMsg)
 	s.dispatcher.Dispatch(errorMsg)
 }
@@ -0,0 +1,255 @@
+package hub
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// workerStatus is the registry status of workerID, "" if not registered
+func (h *testHub) workerStatus(workerID string) string {
+	for _, worker := range h.registry.GetAllWorkers() {
+		if worker.ID == workerID {
+			return worker.Status
+		}
+	}
+	return ""
+}
+
+// expectDrain fails unless the next message to worker is a drain command
+func expectDrain(t *testing.T, worker *testClient) {
+	t.Helper()
+	if msg := worker.next(); msg.Type != proto.MessageType_CONTROL || msg.Action != ControlActionDrain {
+		t.Fatalf("worker got %s %s, want CONTROL %s", msg.Type, msg.Action, ControlActionDrain)
+	}
+}
+
+func TestDrainRequiresAdminForOtherWorkers(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.AuthRequired = true
+		cfg.AdminClients = []string{"admin"}
+	})
+	worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "echo"})
+	other := h.connectClient(t, "other", nil)
+
+	if code := errorCode(other.control(ControlActionDrain, map[string]string{"worker_id": "w1"})); code != apierr.CodeForbidden {
+		t.Fatalf("drain by non-admin: got %q, want %s", code, apierr.CodeForbidden)
+	}
+	if status := h.workerStatus("w1"); status != WorkerStatusOnline {
+		t.Fatalf("worker status after rejected drain: %q", status)
+	}
+	worker.expectNothing(100 * time.Millisecond)
+
+	admin := h.connectClient(t, "admin", nil)
+	if code := errorCode(admin.control(ControlActionDrain, map[string]string{"worker_id": "w1"})); code != "" {
+		t.Fatalf("drain by admin failed: %s", code)
+	}
+	expectDrain(t, worker)
+	if status := h.workerStatus("w1"); status != WorkerStatusDraining {
+		t.Fatalf("worker status after drain: %q, want %s", status, WorkerStatusDraining)
+	}
+}
+
+func TestDrainSelfWithoutAdmin(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.AuthRequired = true
+		cfg.AdminClients = []string{"admin"}
+	})
+	worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "echo"})
+
+	worker.send(&proto.Message{To: "hub", Type: proto.MessageType_CONTROL, Action: ControlActionDrain})
+	expectDrain(t, worker)
+	if status := h.workerStatus("w1"); status != WorkerStatusDraining {
+		t.Fatalf("worker status after drain: %q, want %s", status, WorkerStatusDraining)
+	}
+}
+
+func TestDrainRejectsOtherNamespace(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.AuthRequired = true
+		cfg.AdminClients = []string{"admin"}
+		cfg.NamespaceGrants = []string{"tenant-a:default"}
+	})
+	worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "echo"})
+	admin := h.connectClient(t, "admin", map[string]string{NamespaceMetadata: "tenant-a"})
+
+	if code := errorCode(admin.control(ControlActionDrain, map[string]string{"worker_id": "w1"})); code != apierr.CodeForbidden {
+		t.Fatalf("drain across namespaces: got %q, want %s", code, apierr.CodeForbidden)
+	}
+	if status := h.workerStatus("w1"); status != WorkerStatusOnline {
+		t.Fatalf("worker status after rejected drain: %q", status)
+	}
+	worker.expectNothing(100 * time.Millisecond)
+}
+
+func TestAdminActionsClosedWithoutAdminClients(t *testing.T) {
+	h := newTestHub(t, nil)
+	client := h.connectClient(t, "c1", nil)
+
+	for _, action := range []string{"list_connections", ControlActionListWorkers, ControlActionListChannels, ControlActionUnregisterWorker} {
+		if code := errorCode(client.control(action, map[string]string{})); code != apierr.CodeForbidden {
+			t.Errorf("%s without ADMIN_CLIENTS: got %q, want %s", action, code, apierr.CodeForbidden)
+		}
+	}
+}
+
+// Admin rights belong to the exact client ID: a stream claiming the ID of
+// one of Invoke's temporary connections gets none from the client it names
+func TestAdminRightsNotTakenFromInvokeIDs(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.AuthRequired = true
+		cfg.AdminClients = []string{"admin"}
+	})
+	worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "echo"})
+	impostor := h.connectClient(t, "admin"+invokeSeparator+"7", nil)
+
+	for _, action := range []string{ControlActionDrain, "list_connections", ControlActionListWorkers, ControlActionListChannels, ControlActionUnregisterWorker, ControlActionWorkerLogs} {
+		if code := errorCode(impostor.control(action, map[string]string{"worker_id": "w1"})); code != apierr.CodeForbidden {
+			t.Errorf("%s by %s: got %q, want %s", action, impostor.id, code, apierr.CodeForbidden)
+		}
+	}
+	sent := impostor.send(&proto.Message{To: "hub", Type: proto.MessageType_SUBSCRIBE, Channel: LogsChannelPrefix + "w1"})
+	if reply := impostor.next(); reply.Id != sent.Id || errorCode(reply) != apierr.CodeForbidden {
+		t.Errorf("log subscription by %s: got %q, want %s", impostor.id, errorCode(reply), apierr.CodeForbidden)
+	}
+	if status := h.workerStatus("w1"); status != WorkerStatusOnline {
+		t.Fatalf("worker status: %q, want %s", status, WorkerStatusOnline)
+	}
+	worker.expectNothing(50 * time.Millisecond)
+
+	admin := h.connectClient(t, "admin", nil)
+	if code := errorCode(admin.control(ControlActionListWorkers, map[string]string{})); code != "" {
+		t.Fatalf("list_workers by admin failed: %s", code)
+	}
+}
+
+// Only the worker a request was routed to may answer it: a forged response
+// must not reach the requester or the result cache
+func TestResponseFromOtherClientDropped(t *testing.T) {
+	h := newTestHub(t, nil)
+	worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "echo", Cacheable: true})
+	client := h.connectClient(t, "c1", nil)
+	forger := h.connectClient(t, "forger", nil)
+
+	client.request("echo", `{"text":"hi"}`)
+	req := worker.next()
+	forger.send(&proto.Message{To: "c1", Type: proto.MessageType_RESPONSE, RequestId: req.RequestId, Content: `{"text":"forged"}`})
+	client.expectNothing(100 * time.Millisecond)
+
+	worker.reply(req, `{"text":"hi"}`)
+	if resp := client.next(); resp.Content != `{"text":"hi"}` || resp.From != "w1" {
+		t.Fatalf("requester got %q from %s, want the worker's response", resp.Content, resp.From)
+	}
+
+	// The cached response is the worker's
+	client.request("echo", `{"text":"hi"}`)
+	if resp := client.next(); resp.Content != `{"text":"hi"}` {
+		t.Fatalf("cache hit returned %q", resp.Content)
+	}
+	worker.expectNothing(50 * time.Millisecond)
+}
+
+func TestWorkerCallReplyFromOtherClientDropped(t *testing.T) {
+	h := newTestHub(t, nil)
+	caller := h.connectWorker(t, "caller", "", ServiceCapability{Name: "compose"})
+	target := h.connectWorker(t, "target", "", ServiceCapability{Name: "echo"})
+	forger := h.connectClient(t, "forger", nil)
+
+	call := &proto.Message{To: "target", Type: proto.MessageType_WORKER_CALL, Content: `{}`}
+	call.Metadata = map[string]string{"capability": "echo"}
+	caller.send(call)
+	got := target.next()
+
+	forger.send(&proto.Message{To: "caller", Type: proto.MessageType_RESPONSE, Content: `{"forged":true}`,
+		Metadata: map[string]string{"request_id": got.Id}})
+	caller.expectNothing(100 * time.Millisecond)
+
+	target.reply(got, `{"ok":true}`)
+	if resp := caller.next(); resp.Content != `{"ok":true}` || resp.From != "target" {
+		t.Fatalf("caller got %q from %s, want the target's reply", resp.Content, resp.From)
+	}
+}
+
+// A worker call that copies the RequestId of the client request it serves
+// is answered to the calling worker; the client only gets the caller's
+// own response
+func TestWorkerCallReplyNotRoutedToClient(t *testing.T) {
+	h := newTestHub(t, nil)
+	caller := h.connectWorker(t, "caller", "", ServiceCapability{Name: "compose"})
+	target := h.connectWorker(t, "target", "", ServiceCapability{Name: "echo"})
+	client := h.connectClient(t, "c1", nil)
+
+	client.request("compose", `{"text":"hi"}`)
+	req := caller.next()
+	call := &proto.Message{To: "target", Type: proto.MessageType_WORKER_CALL, RequestId: req.RequestId, Content: `{}`}
+	call.Metadata = map[string]string{"capability": "echo"}
+	caller.send(call)
+	got := target.next()
+	if got.RequestId != req.RequestId {
+		t.Fatalf("call carries RequestId %q, want the client's %q", got.RequestId, req.RequestId)
+	}
+
+	target.reply(got, `{"part":1}`)
+	if resp := caller.next(); resp.Content != `{"part":1}` || resp.From != "target" {
+		t.Fatalf("caller got %q from %s, want the target's reply", resp.Content, resp.From)
+	}
+	client.expectNothing(100 * time.Millisecond)
+
+	caller.reply(req, `{"text":"composed"}`)
+	if resp := client.next(); resp.Content != `{"text":"composed"}` || resp.From != "caller" {
+		t.Fatalf("client got %q from %s, want the caller's response", resp.Content, resp.From)
+	}
+	if n := h.requestTracker.PendingCount("caller"); n != 0 {
+		t.Errorf("%d requests still tracked on caller", n)
+	}
+}
+
+// A response without a worker call ID goes to the client the request
+// tracker recorded, whatever To says
+func TestClientResponseRoutedByTracker(t *testing.T) {
+	h := newTestHub(t, nil)
+	worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "echo"})
+	client := h.connectClient(t, "c1", nil)
+	other := h.connectClient(t, "c2", nil)
+
+	client.request("echo", `{"text":"hi"}`)
+	req := worker.next()
+	worker.send(&proto.Message{To: "c2", Type: proto.MessageType_RESPONSE, RequestId: req.RequestId, Content: `{"text":"hi"}`})
+	if resp := client.next(); resp.Content != `{"text":"hi"}` || resp.RequestId != req.RequestId {
+		t.Fatalf("client got %q for %s", resp.Content, resp.RequestId)
+	}
+	other.expectNothing(50 * time.Millisecond)
+}
+
+// Binary (msgpack) content travels in BinaryContent both ways
+func TestBinaryContentForwarded(t *testing.T) {
+	h := newTestHub(t, nil)
+	worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "ocr"})
+	client := h.connectClient(t, "c1", nil)
+
+	payload := []byte{0x81, 0xa5, 'i', 'm', 'a', 'g', 'e', 0xc4, 0x02, 0xff, 0x00}
+	req := &proto.Message{Type: proto.MessageType_REQUEST, Action: "request", BinaryContent: payload,
+		Metadata: map[string]string{codec.MetadataKey: codec.MsgPack}}
+	req.Id = "req-binary"
+	req.RequestId = req.Id
+	envelope.SetCapability(req, "ocr")
+	client.send(req)
+
+	got := worker.next()
+	if !bytes.Equal(got.BinaryContent, payload) || got.Content != "" {
+		t.Fatalf("worker got content %q, binary %x", got.Content, got.BinaryContent)
+	}
+
+	reply := &proto.Message{To: "c1", Type: proto.MessageType_RESPONSE, RequestId: got.RequestId, BinaryContent: payload,
+		Metadata: map[string]string{envelope.RequestIDKey: got.Id, codec.MetadataKey: codec.MsgPack}}
+	worker.send(reply)
+	if resp := client.next(); !bytes.Equal(resp.BinaryContent, payload) {
+		t.Fatalf("client got content %q, binary %x", resp.Content, resp.BinaryContent)
+	}
+}
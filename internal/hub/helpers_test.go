@@ -0,0 +1,230 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// testTimeout bounds every wait for a message from the hub
+const testTimeout = 2 * time.Second
+
+func TestMain(m *testing.M) {
+	logger.Init("error")
+	os.Exit(m.Run())
+}
+
+// testHub is a Server listening on an in-memory connection
+type testHub struct {
+	*Server
+	client proto.HubServiceClient
+}
+
+// trustClaimedIDs authenticates every stream as the client ID its first
+// message claims, for tests that need AUTH_REQUIRED without credentials
+var trustClaimedIDs = AuthenticatorFunc(func(msg *proto.Message) (string, error) {
+	return msg.From, nil
+})
+
+// newTestHub starts a hub with the default configuration, changed by
+// configure if not nil. With AuthRequired, streams authenticate with
+// trustClaimedIDs. The hub is shut down when the test ends.
+func newTestHub(t *testing.T, configure func(*config.Config)) *testHub {
+	t.Helper()
+	cfg := config.Default()
+	cfg.FileStorePath = t.TempDir()
+	if configure != nil {
+		configure(cfg)
+	}
+	s := NewServer(cfg)
+	if cfg.AuthRequired {
+		s.SetAuthenticator(trustClaimedIDs)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	go s.server.Serve(lis)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial test hub: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return &testHub{Server: s, client: proto.NewHubServiceClient(conn)}
+}
+
+// testClient is one Connect stream to a testHub
+type testClient struct {
+	t      *testing.T
+	id     string
+	stream proto.HubService_ConnectClient
+	recv   chan *proto.Message
+	cancel context.CancelFunc
+}
+
+// connect opens a stream whose first message is first and returns once the
+// hub has answered it
+func (h *testHub) connect(t *testing.T, first *proto.Message) *testClient {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := h.client.Connect(ctx)
+	if err != nil {
+		cancel()
+		t.Fatalf("connect %s: %v", first.From, err)
+	}
+	c := &testClient{t: t, id: first.From, stream: stream, recv: make(chan *proto.Message, 1024), cancel: cancel}
+	go func() {
+		defer close(c.recv)
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			c.recv <- msg
+		}
+	}()
+	t.Cleanup(func() {
+		stream.CloseSend()
+		cancel()
+	})
+
+	c.send(first)
+	if reply := c.next(); reply.Error != nil {
+		t.Fatalf("connect %s: %s: %s", first.From, reply.Error.Code, reply.Error.Message)
+	}
+	return c
+}
+
+// connectClient opens a client stream with an AUTH handshake
+func (h *testHub) connectClient(t *testing.T, id string, metadata map[string]string) *testClient {
+	t.Helper()
+	return h.connect(t, &proto.Message{From: id, Type: proto.MessageType_AUTH, Metadata: metadata})
+}
+
+// connectWorker opens a stream registering worker id with capabilities
+func (h *testHub) connectWorker(t *testing.T, id, namespace string, capabilities ...ServiceCapability) *testClient {
+	t.Helper()
+	content, _ := json.Marshal(map[string]interface{}{
+		"worker_id":    id,
+		"worker_type":  "test",
+		"capabilities": capabilities,
+		"namespace":    namespace,
+	})
+	return h.connect(t, &proto.Message{From: id, Type: proto.MessageType_REGISTER, Content: string(content)})
+}
+
+// disconnect closes the stream as a client going away does
+func (c *testClient) disconnect() {
+	c.stream.CloseSend()
+	c.cancel()
+}
+
+// send sends msg, filling in an id, the sender and a timestamp if unset
+func (c *testClient) send(msg *proto.Message) *proto.Message {
+	c.t.Helper()
+	if msg.Id == "" {
+		msg.Id = utils.PrefixedID("test")
+	}
+	if msg.From == "" {
+		msg.From = c.id
+	}
+	if msg.Timestamp == "" {
+		msg.Timestamp = time.Now().Format(time.RFC3339)
+	}
+	if err := c.stream.Send(msg); err != nil {
+		c.t.Fatalf("%s send: %v", c.id, err)
+	}
+	return msg
+}
+
+// next returns the next message the hub sent to c
+func (c *testClient) next() *proto.Message {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.recv:
+		if !ok {
+			c.t.Fatalf("%s: stream closed", c.id)
+		}
+		return msg
+	case <-time.After(testTimeout):
+		c.t.Fatalf("%s: no message within %v", c.id, testTimeout)
+		return nil
+	}
+}
+
+// expectNothing fails if the hub sends c a message within wait
+func (c *testClient) expectNothing(wait time.Duration) {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.recv:
+		if ok {
+			c.t.Fatalf("%s: unexpected message %s %s: %q", c.id, msg.Type, msg.Action, msg.Content)
+		}
+	case <-time.After(wait):
+	}
+}
+
+// control sends a CONTROL message to the hub and returns its reply
+func (c *testClient) control(action string, payload interface{}) *proto.Message {
+	c.t.Helper()
+	content, _ := json.Marshal(payload)
+	sent := c.send(&proto.Message{To: "hub", Type: proto.MessageType_CONTROL, Action: action, Content: string(content)})
+	for {
+		reply := c.next()
+		if reply.Id == sent.Id || reply.RequestId == sent.Id || reply.Metadata["original_message_id"] == sent.Id {
+			return reply
+		}
+	}
+}
+
+// request sends a REQUEST for capability and returns the message
+func (c *testClient) request(capability, content string) *proto.Message {
+	c.t.Helper()
+	msg := &proto.Message{Type: proto.MessageType_REQUEST, Action: "request", Content: content}
+	msg.Id = utils.PrefixedID("req")
+	msg.RequestId = msg.Id
+	envelope.SetCapability(msg, capability)
+	return c.send(msg)
+}
+
+// reply answers req as a worker does, echoing its ID in
+// Metadata["request_id"]
+func (c *testClient) reply(req *proto.Message, content string) *proto.Message {
+	c.t.Helper()
+	return c.send(&proto.Message{
+		To:        req.From,
+		Type:      proto.MessageType_RESPONSE,
+		RequestId: req.RequestId,
+		Content:   content,
+		Metadata:  map[string]string{envelope.RequestIDKey: req.Id},
+	})
+}
+
+// errorCode is the error code reply carries, "" if it succeeded
+func errorCode(reply *proto.Message) apierr.Code {
+	if apiErr, failed := envelope.Error(reply); failed {
+		return apiErr.Code
+	}
+	return ""
+}
@@ -0,0 +1,170 @@
+package hub
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// invokeSeparator nối client ID với số thứ tự của lời gọi Invoke thành ID
+// của kết nối tạm nhận response, vd: web-api/invoke-42
+const invokeSeparator = "/invoke-"
+
+// invokeSeq đánh số các lời gọi Invoke
+var invokeSeq uint64
+
+// callerID trả về client đã gọi Invoke nếu clientID là kết nối tạm của
+// Invoke, nếu không trả về chính clientID. Rate limit và dedup tính theo
+// caller, không theo từng lời gọi.
+func callerID(clientID string) string {
+	if i := strings.Index(clientID, invokeSeparator); i > 0 {
+		return clientID[:i]
+	}
+	return clientID
+}
+
+// invokeStream thay cho stream gRPC của kết nối tạm: writer của
+// ConnectionManager đẩy message vào replies. Chỉ Send và Context được dùng.
+type invokeStream struct {
+	proto.HubService_ConnectServer
+	ctx     context.Context
+	replies chan *proto.Message
+}
+
+func (st *invokeStream) Send(msg *proto.Message) error {
+	select {
+	case st.replies <- msg:
+		return nil
+	case <-st.ctx.Done():
+		return st.ctx.Err()
+	}
+}
+
+func (st *invokeStream) Context() context.Context {
+	return st.ctx
+}
+
+// Invoke route một request tới worker như REQUEST trên stream và chờ
+// response, cho client không muốn giữ stream. Request đi qua cùng pipeline
+// (namespace, rate limit, dedup, cache, circuit breaker): hub tạo một kết
+// nối tạm cho lời gọi để response, lỗi và cache hit quay về đây.
+//
+// Lỗi của request (kể cả TIMEOUT khi hết timeout_ms hoặc REQUEST_TIMEOUT)
+// nằm trong InvokeResponse.Error như trên stream; lỗi gRPC chỉ dành cho
+// xác thực và deadline/cancel của chính lời gọi.
+func (s *Server) Invoke(ctx context.Context, req *proto.InvokeRequest) (*proto.InvokeResponse, error) {
+	metadata := make(map[string]string, len(req.Metadata)+1)
+	for key, value := range req.Metadata {
+		metadata[key] = value
+	}
+
+	clientID, namespace, err := s.authenticateCall(req.ClientId, metadata)
+	if err != nil {
+		logger.Emoji("⛔").WithFields(logger.Fields{"client_id": req.ClientId}).WithError(err).Warn("invoke rejected")
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	delete(metadata, AuthTokenKey)
+	delete(metadata, envelope.StreamKey) // progress không có chỗ trong response đơn
+
+	msg := &proto.Message{
+		Id:            utils.PrefixedID("invoke"),
+		From:          clientID + invokeSeparator + strconv.FormatUint(atomic.AddUint64(&invokeSeq, 1), 10),
+		To:            req.To,
+		Type:          proto.MessageType_REQUEST,
+		Action:        "request",
+		Content:       req.Content,
+		BinaryContent: req.BinaryContent,
+		Metadata:      metadata,
+		Timestamp:     time.Now().Format(time.RFC3339),
+	}
+	msg.RequestId = msg.Id
+	if req.Capability != "" {
+		envelope.SetCapability(msg, req.Capability)
+	}
+
+	timeout := s.config.RequestTimeout
+	if req.TimeoutMs > 0 {
+		timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+	callCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stream := &invokeStream{ctx: ctx, replies: make(chan *proto.Message, 1)}
+	if _, err := s.connMgr.Add(msg.From, stream); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer func() {
+		cancel() // trước: writer có thể đang chờ trong Send
+		s.connMgr.RemoveStream(msg.From, stream)
+	}()
+	s.connMgr.SetNamespace(msg.From, namespace)
+
+	s.handleMessage(msg)
+
+	for {
+		select {
+		case reply := <-stream.replies:
+			if !envelope.IsResponse(reply) {
+				continue // vd: BROADCAST gửi tới mọi kết nối
+			}
+			return invokeResponse(msg, reply), nil
+		case <-ctx.Done():
+			if err := callCtx.Err(); err != nil {
+				return nil, status.FromContextError(err).Err()
+			}
+			logger.Emoji("⌛").WithFields(messageFields(msg)).Warn("invoke timed out waiting for response")
+			reply := &proto.Message{RequestId: msg.RequestId, From: "hub"}
+			envelope.SetError(reply, apierr.Newf(apierr.CodeTimeout, "No response within %v", timeout).
+				WithDetail("request_id", msg.RequestId))
+			return invokeResponse(msg, reply), nil
+		}
+	}
+}
+
+// authenticateCall xác thực lời gọi unary (Invoke, Discover) như message
+// AUTH đầu tiên của stream: trả về client ID và namespace của caller
+func (s *Server) authenticateCall(clientID string, metadata map[string]string) (string, string, error) {
+	auth := &proto.Message{From: clientID, Type: proto.MessageType_AUTH, Metadata: metadata}
+	clientID, err := s.authenticate(auth)
+	if err != nil {
+		return "", "", err
+	}
+	namespace, err := s.resolveNamespace(clientID, auth)
+	if err != nil {
+		return "", "", err
+	}
+	return clientID, namespace, nil
+}
+
+// invokeResponse chuyển response của request msg thành InvokeResponse:
+// content đã giải nén, lỗi luôn nằm trong Error (kể cả của worker cũ chỉ
+// ghi lỗi vào content)
+func invokeResponse(msg, reply *proto.Message) *proto.InvokeResponse {
+	if err := codec.Decompress(reply); err != nil {
+		logger.Emoji("⚠️").WithFields(messageFields(reply)).WithError(err).Warn("failed to decompress invoke response")
+	}
+	if apiErr, failed := envelope.Error(reply); failed && reply.Error == nil {
+		envelope.SetError(reply, apiErr)
+	}
+	return &proto.InvokeResponse{
+		RequestId:     msg.RequestId,
+		From:          reply.From,
+		Content:       reply.Content,
+		BinaryContent: reply.BinaryContent,
+		Metadata:      reply.Metadata,
+		Error:         reply.Error,
+	}
+}
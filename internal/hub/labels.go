@@ -0,0 +1,93 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LabelSelectorMetadata là request metadata key chứa label selector, vd:
+// "region=eu,gpu=true". Chỉ áp dụng khi hub chọn worker (To rỗng).
+const LabelSelectorMetadata = "label_selector"
+
+var (
+	// ErrNoMatchingWorker: có worker online cho capability nhưng không worker
+	// nào khớp label selector
+	ErrNoMatchingWorker = errors.New("no worker matches the label selector")
+	// ErrInvalidLabel: label hoặc selector sai cú pháp
+	ErrInvalidLabel = errors.New("invalid label")
+)
+
+// normalizeLabels bỏ khoảng trắng thừa và kiểm tra key/value không rỗng
+// (value được rỗng) và không chứa "=" hay ","
+func normalizeLabels(labels map[string]string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	normalized := make(map[string]string, len(labels))
+	for key, value := range labels {
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "" || strings.ContainsAny(key, "=,") || strings.ContainsAny(value, "=,") {
+			return nil, fmt.Errorf("%w %q=%q (keys must be non-empty; keys and values cannot contain '=' or ',')",
+				ErrInvalidLabel, key, value)
+		}
+		normalized[key] = value
+	}
+	return normalized, nil
+}
+
+// ParseLabelSelector đọc selector dạng "key=value,key2=value2"; chuỗi rỗng
+// là selector rỗng (khớp mọi worker)
+func ParseLabelSelector(selector string) (map[string]string, error) {
+	if strings.TrimSpace(selector) == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, term := range strings.Split(selector, ",") {
+		key, value, found := strings.Cut(term, "=")
+		if !found {
+			return nil, fmt.Errorf("%w selector term %q (expected key=value)", ErrInvalidLabel, strings.TrimSpace(term))
+		}
+		labels[key] = value
+	}
+	return normalizeLabels(labels)
+}
+
+// FormatLabelSelector là dạng chuỗi của selector, key theo thứ tự alphabet
+func FormatLabelSelector(selector map[string]string) string {
+	keys := make([]string, 0, len(selector))
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		terms[i] = key + "=" + selector[key]
+	}
+	return strings.Join(terms, ",")
+}
+
+// MatchesLabels kiểm tra worker có mọi label trong selector
+func (w *WorkerInfo) MatchesLabels(selector map[string]string) bool {
+	for key, value := range selector {
+		if actual, ok := w.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// withLabels lọc candidates khớp selector
+func withLabels(candidates []*WorkerInfo, selector map[string]string) []*WorkerInfo {
+	if len(selector) == 0 {
+		return candidates
+	}
+	matching := candidates[:0:0]
+	for _, candidate := range candidates {
+		if candidate.MatchesLabels(selector) {
+			matching = append(matching, candidate)
+		}
+	}
+	return matching
+}
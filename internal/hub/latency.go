@@ -0,0 +1,146 @@
+package hub
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyWindow là số mẫu gần nhất giữ cho mỗi capability
+const DefaultLatencyWindow = 1024
+
+// LatencyStats đo thời gian từ lúc dispatch request tới khi có response,
+// theo capability. Percentile tính trên cửa sổ các mẫu gần nhất nên bộ nhớ
+// cố định cho mỗi capability.
+type LatencyStats struct {
+	mu     sync.Mutex
+	window int
+	caps   map[string]*latencyWindow
+}
+
+type latencyWindow struct {
+	samples  []time.Duration // ring buffer
+	next     int
+	count    int64 // tổng số response từ khi hub chạy
+	errors   int64 // response là lỗi
+	timeouts int64 // request hết hạn không có response
+}
+
+// CapabilityLatency là thống kê của một capability
+type CapabilityLatency struct {
+	Count    int64   `json:"count"`
+	Errors   int64   `json:"errors"`
+	Timeouts int64   `json:"timeouts"`
+	Samples  int     `json:"samples"` // số mẫu dùng để tính percentile
+	MeanMs   float64 `json:"mean_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+func NewLatencyStats(window int) *LatencyStats {
+	if window < 1 {
+		window = DefaultLatencyWindow
+	}
+	return &LatencyStats{
+		window: window,
+		caps:   make(map[string]*latencyWindow),
+	}
+}
+
+// Record ghi một response của capability
+func (ls *LatencyStats) Record(capability string, duration time.Duration, failed bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	w := ls.get(capability)
+	w.count++
+	if failed {
+		w.errors++
+	}
+	if len(w.samples) < ls.window {
+		w.samples = append(w.samples, duration)
+		return
+	}
+	w.samples[w.next] = duration
+	w.next = (w.next + 1) % ls.window
+}
+
+// RecordTimeout ghi một request hết hạn mà không có response
+func (ls *LatencyStats) RecordTimeout(capability string) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.get(capability).timeouts++
+}
+
+// Snapshot trả về thống kê theo capability
+func (ls *LatencyStats) Snapshot() map[string]CapabilityLatency {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	stats := make(map[string]CapabilityLatency, len(ls.caps))
+	for capability, w := range ls.caps {
+		stats[capability] = w.summary()
+	}
+	return stats
+}
+
+// Window trả về số mẫu tối đa cho mỗi capability
+func (ls *LatencyStats) Window() int {
+	return ls.window
+}
+
+// get trả về cửa sổ của capability (caller giữ ls.mu)
+func (ls *LatencyStats) get(capability string) *latencyWindow {
+	w, exists := ls.caps[capability]
+	if !exists {
+		w = &latencyWindow{}
+		ls.caps[capability] = w
+	}
+	return w
+}
+
+func (w *latencyWindow) summary() CapabilityLatency {
+	summary := CapabilityLatency{
+		Count:    w.count,
+		Errors:   w.errors,
+		Timeouts: w.timeouts,
+		Samples:  len(w.samples),
+	}
+	if len(w.samples) == 0 {
+		return summary
+	}
+
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	summary.MeanMs = milliseconds(total / time.Duration(len(sorted)))
+	summary.P50Ms = milliseconds(percentile(sorted, 0.50))
+	summary.P95Ms = milliseconds(percentile(sorted, 0.95))
+	summary.P99Ms = milliseconds(percentile(sorted, 0.99))
+	summary.MaxMs = milliseconds(sorted[len(sorted)-1])
+	return summary
+}
+
+// percentile theo nearest-rank trên mảng đã sắp xếp
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,88 @@
+package hub
+
+import (
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// CorrelationIDMetadata is the metadata key that follows a request from
+// the client through the hub and worker(s) and back in the response
+const CorrelationIDMetadata = "correlation_id"
+
+// MessageHandler xử lý một message nhận từ stream
+type MessageHandler func(msg *proto.Message)
+
+// MessageMiddleware bọc MessageHandler, chạy trước khi message được route
+type MessageMiddleware func(next MessageHandler) MessageHandler
+
+// Use thêm middleware vào pipeline (chạy theo thứ tự thêm vào).
+// Must be called before Start.
+func (s *Server) Use(middlewares ...MessageMiddleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+
+	handler := MessageHandler(s.routeMessage)
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		handler = s.middlewares[i](handler)
+	}
+	s.pipeline = handler
+}
+
+// correlationMiddleware gán correlation ID cho request chưa có. Responses
+// được gán lại ID của request trong handleResponse.
+func correlationMiddleware(next MessageHandler) MessageHandler {
+	return func(msg *proto.Message) {
+		switch msg.Type {
+		case proto.MessageType_REQUEST, proto.MessageType_WORKER_CALL, proto.MessageType_CONTROL:
+			if msg.Metadata[CorrelationIDMetadata] == "" {
+				if msg.Metadata == nil {
+					msg.Metadata = make(map[string]string)
+				}
+				msg.Metadata[CorrelationIDMetadata] = utils.GenerateID()
+			}
+		}
+		next(msg)
+	}
+}
+
+// requestLogMiddleware ghi log (debug) khi hub nhận message; route/respond được log ở handlers
+func requestLogMiddleware(next MessageHandler) MessageHandler {
+	return func(msg *proto.Message) {
+		logger.WithFields(messageFields(msg)).Debug("message received")
+		next(msg)
+	}
+}
+
+// messageFields là các field log chung cho một message
+func messageFields(msg *proto.Message) logger.Fields {
+	fields := logger.Fields{
+		"msg_id": msg.Id,
+		"type":   msg.Type.String(),
+		"from":   msg.From,
+	}
+	if msg.To != "" {
+		fields["to"] = msg.To
+	}
+	if msg.RequestId != "" {
+		fields["request_id"] = msg.RequestId
+	}
+	if id := msg.Metadata[CorrelationIDMetadata]; id != "" {
+		fields["correlation_id"] = id
+	}
+	if capability := msg.Metadata["capability"]; capability != "" {
+		fields["capability"] = capability
+	}
+	return fields
+}
+
+// copyCorrelation chuyển correlation ID của request sang reply
+func copyCorrelation(request, reply *proto.Message) {
+	id := request.Metadata[CorrelationIDMetadata]
+	if id == "" {
+		return
+	}
+	if reply.Metadata == nil {
+		reply.Metadata = make(map[string]string)
+	}
+	reply.Metadata[CorrelationIDMetadata] = id
+}
@@ -0,0 +1,107 @@
+package hub
+
+import (
+	"strings"
+
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// DefaultNamespace là namespace của client/worker không khai báo namespace
+const DefaultNamespace = "default"
+
+// NamespaceMetadata là metadata key chứa namespace: trên message đầu tiên
+// của stream (khi hub không bật auth) hoặc trên request/worker call để gọi
+// sang namespace khác (cần được cấp quyền qua NAMESPACE_GRANTS)
+const NamespaceMetadata = "namespace"
+
+// NamespaceResolver được Authenticator implement khi namespace của client
+// lấy từ credentials thay vì từ metadata
+type NamespaceResolver interface {
+	Namespace(clientID string) (string, error)
+}
+
+// normalizeNamespace bỏ khoảng trắng; rỗng là DefaultNamespace
+func normalizeNamespace(namespace string) string {
+	if namespace = strings.TrimSpace(namespace); namespace == "" {
+		return DefaultNamespace
+	}
+	return namespace
+}
+
+// namespaceGrants: namespace gọi -> các namespace được phép gọi tới. Key "*"
+// áp dụng cho mọi namespace.
+type namespaceGrants map[string]map[string]bool
+
+// parseNamespaceGrants đọc các mục "from:to" (vd: "tenant-a:shared",
+// "*:public"); mục sai cú pháp bị bỏ qua (config đã kiểm tra)
+func parseNamespaceGrants(entries []string) namespaceGrants {
+	grants := make(namespaceGrants)
+	for _, entry := range entries {
+		from, to, found := strings.Cut(entry, ":")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !found || from == "" || to == "" {
+			continue
+		}
+		if grants[from] == nil {
+			grants[from] = make(map[string]bool)
+		}
+		grants[from][to] = true
+	}
+	return grants
+}
+
+// allows kiểm tra namespace from có được gọi worker trong namespace to
+func (g namespaceGrants) allows(from, to string) bool {
+	return from == to || g[from][to] || g["*"][to]
+}
+
+// namespaceOf trả về namespace của client: của worker nếu client đã đăng ký
+// làm worker, nếu không thì namespace gắn với connection
+func (s *Server) namespaceOf(clientID string) string {
+	if namespace, ok := s.registry.WorkerNamespace(clientID); ok {
+		return namespace
+	}
+	return s.connMgr.Namespace(clientID)
+}
+
+// resolveNamespace xác định namespace cho stream mới: từ authenticator nếu
+// nó implement NamespaceResolver, nếu không từ metadata của message đầu tiên
+func (s *Server) resolveNamespace(clientID string, firstMsg *proto.Message) (string, error) {
+	if resolver, ok := s.authenticator.(NamespaceResolver); ok {
+		namespace, err := resolver.Namespace(clientID)
+		if err != nil {
+			return "", err
+		}
+		return normalizeNamespace(namespace), nil
+	}
+	return normalizeNamespace(firstMsg.Metadata[NamespaceMetadata]), nil
+}
+
+// targetNamespace là namespace request muốn gọi tới: Metadata["namespace"]
+// nếu có, nếu không là namespace của người gửi
+func (s *Server) targetNamespace(msg *proto.Message) string {
+	if namespace := strings.TrimSpace(msg.Metadata[NamespaceMetadata]); namespace != "" {
+		return namespace
+	}
+	return s.namespaceOf(msg.From)
+}
+
+// checkNamespace trả lỗi FORBIDDEN nếu người gửi msg không được gọi sang
+// namespace target
+func (s *Server) checkNamespace(msg *proto.Message, target string) *apierr.ErrorResponse {
+	return s.checkNamespaceFrom(msg.From, s.namespaceOf(msg.From), target)
+}
+
+// checkNamespaceFrom là checkNamespace cho clientID thuộc namespace from
+func (s *Server) checkNamespaceFrom(clientID, from, target string) *apierr.ErrorResponse {
+	if s.grants.allows(from, target) {
+		return nil
+	}
+	logger.Emoji("⛔").WithFields(logger.Fields{"client_id": clientID, "namespace": from, "target_namespace": target}).
+		Warn("cross-namespace call rejected")
+	return apierr.Newf(apierr.CodeForbidden, "namespace %s may not call namespace %s", from, target).
+		WithDetail("namespace", from).
+		WithDetail("target_namespace", target)
+}
@@ -0,0 +1,124 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket holds the limiter state for one client/capability pair
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter enforces a token bucket per client and capability
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens added per second
+	burst   float64 // bucket capacity
+	idleTTL time.Duration
+	buckets map[string]*tokenBucket // client_id + capability -> bucket
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRateLimiter creates a limiter allowing rate requests per second with
+// bursts of up to burst requests. A rate <= 0 disables limiting.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	rl := &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		idleTTL: 10 * time.Minute,
+		buckets: make(map[string]*tokenBucket),
+		stop:    make(chan struct{}),
+	}
+
+	// Start cleanup goroutine
+	if rate > 0 {
+		go rl.cleanupIdle()
+	}
+
+	return rl
+}
+
+// Allow consumes a token for clientID/capability and reports whether the
+// call is within the limit
+func (rl *RateLimiter) Allow(clientID, capability string) bool {
+	return rl.allowAt(clientID+"|"+capability, time.Now())
+}
+
+func (rl *RateLimiter) allowAt(key string, now time.Time) bool {
+	if rl.rate <= 0 {
+		return true
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	bucket, exists := rl.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: rl.burst, lastSeen: now}
+		rl.buckets[key] = bucket
+	}
+
+	// Refill based on elapsed time
+	elapsed := now.Sub(bucket.lastSeen).Seconds()
+	if elapsed > 0 {
+		bucket.tokens += elapsed * rl.rate
+		if bucket.tokens > rl.burst {
+			bucket.tokens = rl.burst
+		}
+	}
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// cleanupIdle removes buckets that have not been used recently
+func (rl *RateLimiter) cleanupIdle() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.stop:
+			return
+		case <-ticker.C:
+		}
+
+		rl.mu.Lock()
+		now := time.Now()
+		for key, bucket := range rl.buckets {
+			if now.Sub(bucket.lastSeen) > rl.idleTTL {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// Stop ends the cleanup goroutine
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() { close(rl.stop) })
+}
+
+// GetStats returns current limiter statistics
+func (rl *RateLimiter) GetStats() map[string]interface{} {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return map[string]interface{}{
+		"rate_per_second": rl.rate,
+		"burst":           rl.burst,
+		"active_buckets":  len(rl.buckets),
+	}
+}
@@ -0,0 +1,85 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	rl := NewRateLimiter(1, 5)
+	defer rl.Stop()
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if !rl.allowAt("c1|ocr", now) {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	if rl.allowAt("c1|ocr", now) {
+		t.Fatal("request past the burst allowed")
+	}
+	// Buckets are per client and capability
+	if !rl.allowAt("c2|ocr", now) || !rl.allowAt("c1|echo", now) {
+		t.Fatal("another client or capability shared the exhausted bucket")
+	}
+}
+
+func TestRateLimiterSteadyState(t *testing.T) {
+	rl := NewRateLimiter(10, 1)
+	defer rl.Stop()
+	start := time.Now()
+
+	// One token every 100ms: ten seconds of requests every 50ms get half
+	// through
+	allowed := 0
+	for i := 0; i < 200; i++ {
+		if rl.allowAt("c1|ocr", start.Add(time.Duration(i)*50*time.Millisecond)) {
+			allowed++
+		}
+	}
+	if allowed != 100 {
+		t.Fatalf("%d of 200 requests at 20/s allowed with a 10/s limit, want 100", allowed)
+	}
+
+	// An idle bucket refills only up to the burst
+	later := start.Add(time.Hour)
+	if !rl.allowAt("c1|ocr", later) || rl.allowAt("c1|ocr", later) {
+		t.Fatal("idle bucket refilled past its burst of 1")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	rl := NewRateLimiter(0, 1)
+	defer rl.Stop()
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if !rl.allowAt("c1|ocr", now) {
+			t.Fatal("request rejected with limiting disabled")
+		}
+	}
+}
+
+func TestRequestsOverRateLimitRejected(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.RateLimit = 0.001
+		cfg.RateLimitBurst = 2
+	})
+	worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "echo"})
+	client := h.connectClient(t, "c1", nil)
+
+	for i := 0; i < 2; i++ {
+		client.request("echo", `{}`)
+		worker.reply(worker.next(), `{}`)
+		if code := errorCode(client.next()); code != "" {
+			t.Fatalf("request %d within the burst failed: %s", i+1, code)
+		}
+	}
+	client.request("echo", `{}`)
+	if code := errorCode(client.next()); code != apierr.CodeRateLimited {
+		t.Fatalf("request past the burst: got %q, want %s", code, apierr.CodeRateLimited)
+	}
+	worker.expectNothing(50 * time.Millisecond)
+}
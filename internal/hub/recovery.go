@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/internal/models"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// ActionHubRestarted là Action của response hub gửi cho request bị mất khi
+// hub khởi động lại; requester nên gửi lại request
+const ActionHubRestarted = "hub_restarted"
+
+// interruptedRequests giữ các request còn dang dở lúc hub dừng, theo
+// requester, cho tới khi requester kết nối lại
+type interruptedRequests struct {
+	mu       sync.Mutex
+	requests map[string][]*models.PendingRequest // requester_id -> requests
+}
+
+// SetRequestStore lưu request đang chờ response vào store (PERSIST_REQUESTS)
+func (s *Server) SetRequestStore(store RequestStore) {
+	s.requestTracker.SetStore(store)
+}
+
+// RecoverRequests nhận các request đã lưu từ lần chạy trước; mỗi requester
+// được báo hub_restarted khi kết nối lại. Request đã hết hạn bị bỏ qua.
+// Phải gọi trước Start.
+func (s *Server) RecoverRequests(pending []*models.PendingRequest) {
+	s.interrupted.mu.Lock()
+	defer s.interrupted.mu.Unlock()
+
+	if s.interrupted.requests == nil {
+		s.interrupted.requests = make(map[string][]*models.PendingRequest)
+	}
+	now := time.Now()
+	recovered := 0
+	for _, req := range pending {
+		if now.After(req.ExpiresAt) {
+			continue
+		}
+		s.interrupted.requests[req.RequesterID] = append(s.interrupted.requests[req.RequesterID], req)
+		recovered++
+	}
+	logger.Emoji("♻️").WithFields(logger.Fields{"recovered": recovered, "expired": len(pending) - recovered}).
+		Info("loaded requests interrupted by restart")
+}
+
+// notifyInterrupted báo requester các request của nó bị mất khi hub khởi
+// động lại, bằng một error response UNAVAILABLE cho từng request
+func (s *Server) notifyInterrupted(requesterID string) {
+	s.interrupted.mu.Lock()
+	requests := s.interrupted.requests[requesterID]
+	delete(s.interrupted.requests, requesterID)
+	s.interrupted.mu.Unlock()
+
+	now := time.Now()
+	for _, req := range requests {
+		if now.After(req.ExpiresAt) {
+			continue
+		}
+		apiErr := apierr.Newf(apierr.CodeUnavailable, "hub restarted before request %s was answered, please retry", req.RequestID).
+			WithDetail("reason", ActionHubRestarted).
+			WithDetail("capability", req.Capability)
+		msg := &proto.Message{
+			Id:        fmt.Sprintf("restart-%s", req.RequestID),
+			RequestId: req.RequestID,
+			From:      "hub",
+			To:        requesterID,
+			Type:      proto.MessageType_RESPONSE,
+			Action:    ActionHubRestarted,
+			Timestamp: now.Format(time.RFC3339),
+			Metadata: map[string]string{
+				"status_code": fmt.Sprintf("%d", apiErr.HTTPStatus()),
+			},
+		}
+		envelope.SetError(msg, apiErr)
+		if req.CorrelationID != "" {
+			msg.Metadata[CorrelationIDMetadata] = req.CorrelationID
+		}
+		s.dispatcher.Dispatch(msg)
+		logger.Emoji("♻️").WithFields(logger.Fields{"request_id": req.RequestID, "requester_id": requesterID, "capability": req.Capability}).
+			Info("told requester to retry request interrupted by restart")
+	}
+}
@@ -3,10 +3,26 @@ package hub
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"deepapp_golang_grpc_hub/pkg/logger"
 )
 
+// internalCapabilityPrefix marks built-in capabilities (e.g. "__health") that
+// are routable but not listed in discovery
+const internalCapabilityPrefix = "__"
+
+// IsInternalCapability reports whether a capability is a built-in one
+func IsInternalCapability(name string) bool {
+	return strings.HasPrefix(name, internalCapabilityPrefix)
+}
+
 // ServiceCapability định nghĩa khả năng của một service
 type ServiceCapability struct {
 	Name          string `json:"name"`          // Tên capability (vd: "hello", "image_analysis")
@@ -16,39 +32,292 @@ type ServiceCapability struct {
 	HTTPMethod    string `json:"http_method"`               // GET, POST, PUT, DELETE
 	AcceptsFile   bool   `json:"accepts_file"`              // Có nhận file upload không
 	FileFieldName string `json:"file_field_name,omitempty"` // Tên field cho file
+	Tags          []string `json:"tags,omitempty"`           // Nhóm capability (vd: "ocr", "text")
+
+	// Capability cũ vẫn route bình thường nhưng được đánh dấu trong discovery/swagger
+	Deprecated         bool   `json:"deprecated,omitempty"`
+	DeprecationMessage string `json:"deprecation_message,omitempty"` // vd: "use ocr_v2"
+
+	// Example là request mẫu (JSON object) cho "Try it out" của Swagger UI
+	Example string `json:"example,omitempty"`
+
+	// SchemaChanged: input/output schema khác lần đăng ký trước của worker
+	SchemaChanged bool `json:"schema_changed,omitempty"`
+
+	// DefaultTimeoutMs: thời gian chờ (ms) client nên dùng khi không tự đặt
+	// timeout; 0 là dùng timeout mặc định của client
+	DefaultTimeoutMs int64 `json:"default_timeout_ms,omitempty"`
+
+	// Cacheable: kết quả chỉ phụ thuộc input (vd: hash_text) nên hub được trả
+	// response thành công đã cache trong CacheTTLSeconds (0 là DefaultCacheTTL)
+	Cacheable       bool  `json:"cacheable,omitempty"`
+	CacheTTLSeconds int64 `json:"cache_ttl_seconds,omitempty"`
+
+	// Disabled: worker tạm tắt capability (vd: đang reload model); worker vẫn
+	// đăng ký nhưng capability không có trong index nên không được route
+	Disabled bool `json:"disabled,omitempty"`
 }
 
+// HasTag kiểm tra capability có tag (không phân biệt hoa thường)
+func (c ServiceCapability) HasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Worker statuses. Chỉ worker online được chọn cho capability; worker
+// draining vẫn giữ kết nối để hoàn tất các request đang chạy.
+const (
+	WorkerStatusOnline   = "online"
+	WorkerStatusDraining = "draining"
+)
+
 // WorkerInfo thông tin về worker
 type WorkerInfo struct {
 	ID           string               `json:"id"`
+	Namespace    string               `json:"namespace"` // tenant; request chỉ route tới worker cùng namespace
 	Type         string               `json:"type"` // python, go, nodejs, etc
-	Status       string               `json:"status"` // online, busy, offline
+	Status       string               `json:"status"` // online, draining, busy, offline
 	Capabilities []ServiceCapability  `json:"capabilities"`
 	Metadata     map[string]interface{} `json:"metadata,omitempty"`
 	RegisteredAt string               `json:"registered_at"`
 	LastSeen     string               `json:"last_seen"`
+
+	// Số request tối đa worker xử lý đồng thời (0 = không giới hạn), lấy
+	// từ metadata "max_concurrency" khi đăng ký
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+
+	// Tỉ trọng traffic với strategy "weighted" (mặc định 1), lấy từ
+	// metadata "weight" khi đăng ký
+	Weight float64 `json:"weight"`
+
+	// Labels có cấu trúc (vd: region=eu, gpu=true) dùng để chọn worker theo
+	// label selector
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Trạng thái circuit breaker, chỉ có trong GetPublicWorkers
+	BreakerState string `json:"breaker_state,omitempty"`
+
+	// Thống kê worker tự báo qua metadata khi đăng ký và qua update_metadata
+	StartedAt       string `json:"started_at,omitempty"`
+	RequestsHandled int64  `json:"requests_handled,omitempty"`
+	ErrorsReturned  int64  `json:"errors_returned,omitempty"`
+}
+
+// MaxConcurrencyMetadata là key trong registration metadata cho MaxConcurrency
+const MaxConcurrencyMetadata = "max_concurrency"
+
+// maxConcurrency đọc giới hạn từ metadata (số hoặc chuỗi số)
+func maxConcurrency(metadata map[string]interface{}) int {
+	switch v := metadata[MaxConcurrencyMetadata].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
+
+// Metadata keys cho thống kê của worker (xem applyWorkerStats)
+const (
+	StartedAtMetadata       = "started_at" // RFC3339
+	RequestsHandledMetadata = "requests_handled"
+	ErrorsReturnedMetadata  = "errors_returned"
+)
+
+// applyWorkerStats đọc StartedAt, RequestsHandled và ErrorsReturned từ
+// metadata (số hoặc chuỗi số)
+func applyWorkerStats(info *WorkerInfo) {
+	info.StartedAt, _ = info.Metadata[StartedAtMetadata].(string)
+	info.RequestsHandled = metadataInt(info.Metadata, RequestsHandledMetadata)
+	info.ErrorsReturned = metadataInt(info.Metadata, ErrorsReturnedMetadata)
+}
+
+func metadataInt(metadata map[string]interface{}, key string) int64 {
+	switch v := metadata[key].(type) {
+	case float64:
+		return int64(v)
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+var (
+	// ErrNoWorker: không có worker online nào có capability
+	ErrNoWorker = errors.New("no worker available")
+	// ErrWorkersBusy: mọi worker có capability đều đã đạt MaxConcurrency
+	ErrWorkersBusy = errors.New("all workers at capacity")
+	// ErrCircuitOpen: mọi worker còn chỗ trống đều đang mở circuit breaker
+	ErrCircuitOpen = errors.New("all workers have an open circuit breaker")
+	// ErrInvalidHTTPMethod: capability khai báo http_method không hỗ trợ
+	ErrInvalidHTTPMethod = errors.New("unsupported http_method")
+	// ErrInvalidExample: example của capability không phải JSON object
+	ErrInvalidExample = errors.New("invalid example")
+	// ErrInvalidTimeout: default_timeout_ms của capability âm
+	ErrInvalidTimeout = errors.New("invalid default_timeout_ms")
+	// ErrInvalidCacheTTL: cache_ttl_seconds của capability âm
+	ErrInvalidCacheTTL = errors.New("invalid cache_ttl_seconds")
+	// ErrWorkerNotFound: worker chưa đăng ký
+	ErrWorkerNotFound = errors.New("worker not registered")
+	// ErrUnknownCapability: worker không đăng ký capability
+	ErrUnknownCapability = errors.New("worker does not provide the capability")
+)
+
+// HTTPMethods là các http_method capability được khai báo; để trống là POST
+var HTTPMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// normalizeHTTPMethods viết hoa http_method của các capability (mặc định
+// POST) và trả lỗi nếu có method không hỗ trợ
+func normalizeHTTPMethods(caps []ServiceCapability) error {
+	for i := range caps {
+		method := strings.ToUpper(strings.TrimSpace(caps[i].HTTPMethod))
+		if method == "" {
+			method = "POST"
+		}
+		supported := false
+		for _, m := range HTTPMethods {
+			if m == method {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("%w %q on capability %s (use one of %s)",
+				ErrInvalidHTTPMethod, caps[i].HTTPMethod, caps[i].Name, strings.Join(HTTPMethods, ", "))
+		}
+		caps[i].HTTPMethod = method
+	}
+	return nil
+}
+
+// validateExamples trả lỗi nếu example của capability nào đó không phải
+// JSON object
+func validateExamples(caps []ServiceCapability) error {
+	for _, cap := range caps {
+		if strings.TrimSpace(cap.Example) == "" {
+			continue
+		}
+		var example map[string]interface{}
+		if err := json.Unmarshal([]byte(cap.Example), &example); err != nil {
+			return fmt.Errorf("%w on capability %s: must be a JSON object", ErrInvalidExample, cap.Name)
+		}
+	}
+	return nil
+}
+
+// validateTimeouts trả lỗi nếu default_timeout_ms hoặc cache_ttl_seconds
+// của capability nào đó âm
+func validateTimeouts(caps []ServiceCapability) error {
+	for _, cap := range caps {
+		if cap.DefaultTimeoutMs < 0 {
+			return fmt.Errorf("%w on capability %s: must not be negative", ErrInvalidTimeout, cap.Name)
+		}
+		if cap.CacheTTLSeconds < 0 {
+			return fmt.Errorf("%w on capability %s: must not be negative", ErrInvalidCacheTTL, cap.Name)
+		}
+	}
+	return nil
 }
 
 // ServiceRegistry quản lý workers và capabilities
 type ServiceRegistry struct {
 	mu            sync.RWMutex
 	workers       map[string]*WorkerInfo              // worker_id -> info
-	capabilities  map[string][]string                 // capability_name -> []worker_ids
+	capabilities  map[string]map[string][]string      // namespace -> capability_name -> []worker_ids
 	db            *sql.DB                             // Database connection
+	selector      WorkerSelector                      // Chọn worker khi có nhiều candidates
+	load          LoadFunc                            // Số request đang chạy trên worker (nil = không giới hạn)
+	onChange      ChangeFunc                          // Gọi sau khi capabilities/status của worker thay đổi
+	breaker       *CircuitBreaker                     // Bỏ qua worker lỗi liên tục (nil = tắt)
+
+	// Từ chối registration đổi schema của capability đã có (ErrSchemaDrift)
+	strictSchemas bool
+}
+
+// ChangeFunc được gọi (ngoài lock) sau khi worker đăng ký, gỡ đăng ký hoặc
+// đổi status
+type ChangeFunc func(change RegistryChange)
+
+// Các loại thay đổi trong RegistryChange.Event
+const (
+	ChangeWorkerAdded   = "worker_added"
+	ChangeWorkerRemoved = "worker_removed"
+	ChangeWorkerUpdated = "worker_updated" // đăng ký lại hoặc đổi status
+)
+
+// RegistryChange mô tả một thay đổi của registry dưới dạng delta: các
+// capability mà worker vừa thêm/bỏ (khi worker_added/worker_removed là toàn
+// bộ capabilities của nó)
+type RegistryChange struct {
+	Event               string   `json:"event"`
+	WorkerID            string   `json:"worker_id"`
+	Namespace           string   `json:"namespace"`
+	Status              string   `json:"status,omitempty"`
+	AddedCapabilities   []string `json:"added_capabilities,omitempty"`
+	RemovedCapabilities []string `json:"removed_capabilities,omitempty"`
+}
+
+// capabilityDelta trả về tên capabilities có trong after mà không có trong
+// before (added) và ngược lại (removed), sorted. Capabilities nội bộ bị bỏ
+// qua như trong discovery; capabilities bị tắt được coi như không có.
+func capabilityDelta(before, after []ServiceCapability) (added, removed []string) {
+	beforeNames := make(map[string]bool, len(before))
+	for _, cap := range before {
+		if !IsInternalCapability(cap.Name) && !cap.Disabled {
+			beforeNames[cap.Name] = true
+		}
+	}
+	afterNames := make(map[string]bool, len(after))
+	for _, cap := range after {
+		if IsInternalCapability(cap.Name) || cap.Disabled {
+			continue
+		}
+		afterNames[cap.Name] = true
+		if !beforeNames[cap.Name] {
+			added = append(added, cap.Name)
+		}
+	}
+	for name := range beforeNames {
+		if !afterNames[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
 }
 
 func NewServiceRegistry() *ServiceRegistry {
 	return &ServiceRegistry{
 		workers:      make(map[string]*WorkerInfo),
-		capabilities: make(map[string][]string),
+		capabilities: make(map[string]map[string][]string),
+		selector:     NewRoundRobinSelector(),
 	}
 }
 
 func NewServiceRegistryWithDB(db *sql.DB) *ServiceRegistry {
+	return NewServiceRegistryWithSelector(db, NewRoundRobinSelector())
+}
+
+// NewServiceRegistryWithSelector tạo registry với selection strategy tùy chọn (db có thể nil)
+func NewServiceRegistryWithSelector(db *sql.DB, selector WorkerSelector) *ServiceRegistry {
 	sr := &ServiceRegistry{
 		workers:      make(map[string]*WorkerInfo),
-		capabilities: make(map[string][]string),
+		capabilities: make(map[string]map[string][]string),
 		db:           db,
+		selector:     selector,
 	}
 	
 	// Load existing workers from database on startup
@@ -65,45 +334,60 @@ func (sr *ServiceRegistry) loadFromDatabase() {
 
 	// Load workers
 	rows, err := sr.db.Query(`
-		SELECT id, type, status, metadata, registered_at, last_seen
+		SELECT id, namespace, type, status, metadata, labels, registered_at, last_seen
 		FROM workers WHERE status = 'online'
 	`)
 	if err != nil {
+		logger.Emoji("❌").WithError(err).Error("failed to load workers from database")
 		return
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var info WorkerInfo
-		var metadataJSON sql.NullString
+		var namespace, metadataJSON, labelsJSON sql.NullString
 		
-		err := rows.Scan(&info.ID, &info.Type, &info.Status, &metadataJSON,
+		err := rows.Scan(&info.ID, &namespace, &info.Type, &info.Status, &metadataJSON, &labelsJSON,
 			&info.RegisteredAt, &info.LastSeen)
 		if err != nil {
+			logger.Emoji("⚠️").WithError(err).Warn("skipping unreadable worker row")
 			continue
 		}
 
 		if metadataJSON.Valid {
 			json.Unmarshal([]byte(metadataJSON.String), &info.Metadata)
 		}
+		info.Namespace = normalizeNamespace(namespace.String)
+		if labelsJSON.Valid && labelsJSON.String != "" {
+			json.Unmarshal([]byte(labelsJSON.String), &info.Labels)
+		}
+		info.MaxConcurrency = maxConcurrency(info.Metadata)
+		applyWorkerStats(&info)
+		info.Weight = workerWeight(info.Metadata)
 
 		// Load capabilities for this worker
 		capRows, err := sr.db.Query(`
 			SELECT name, description, input_schema, output_schema,
-				http_method, accepts_file, file_field_name
+				http_method, accepts_file, file_field_name, tags,
+				deprecated, deprecation_message, example, default_timeout_ms,
+				cacheable, cache_ttl_seconds
 			FROM capabilities WHERE worker_id = ?
 		`, info.ID)
 		if err != nil {
+			logger.Emoji("⚠️").WithField("worker_id", info.ID).WithError(err).Warn("failed to load capabilities")
 			continue
 		}
 
 		for capRows.Next() {
 			var cap ServiceCapability
-			var inputSchema, outputSchema, httpMethod, fileFieldName sql.NullString
-			var acceptsFile sql.NullBool
+			var inputSchema, outputSchema, httpMethod, fileFieldName, tagsJSON, deprecationMessage, example sql.NullString
+			var acceptsFile, deprecated, cacheable sql.NullBool
+			var defaultTimeoutMs, cacheTTLSeconds sql.NullInt64
 
 			err := capRows.Scan(&cap.Name, &cap.Description, &inputSchema, &outputSchema,
-				&httpMethod, &acceptsFile, &fileFieldName)
+				&httpMethod, &acceptsFile, &fileFieldName, &tagsJSON,
+				&deprecated, &deprecationMessage, &example, &defaultTimeoutMs,
+				&cacheable, &cacheTTLSeconds)
 			if err != nil {
 				continue
 			}
@@ -125,53 +409,99 @@ func (sr *ServiceRegistry) loadFromDatabase() {
 			if fileFieldName.Valid {
 				cap.FileFieldName = fileFieldName.String
 			}
+			if tagsJSON.Valid && tagsJSON.String != "" {
+				json.Unmarshal([]byte(tagsJSON.String), &cap.Tags)
+			}
+			cap.Deprecated = deprecated.Valid && deprecated.Bool
+			if deprecationMessage.Valid {
+				cap.DeprecationMessage = deprecationMessage.String
+			}
+			if example.Valid {
+				cap.Example = example.String
+			}
+			cap.DefaultTimeoutMs = defaultTimeoutMs.Int64
+			cap.Cacheable = cacheable.Bool
+			cap.CacheTTLSeconds = cacheTTLSeconds.Int64
 
 			info.Capabilities = append(info.Capabilities, cap)
 		}
 		capRows.Close()
 
 		sr.workers[info.ID] = &info
-
-		// Index capabilities
-		for _, cap := range info.Capabilities {
-			sr.capabilities[cap.Name] = append(sr.capabilities[cap.Name], info.ID)
-		}
+		sr.indexWorker(info.ID, &info)
 	}
 }
 
-// RegisterWorker đăng ký worker với capabilities
-func (sr *ServiceRegistry) RegisterWorker(workerID string, info *WorkerInfo) {
+// RegisterWorker đăng ký worker với capabilities. Registration có
+// http_method không hỗ trợ (ErrInvalidHTTPMethod) hoặc label sai cú pháp
+// (ErrInvalidLabel), example không phải JSON object (ErrInvalidExample)
+// hoặc default_timeout_ms/cache_ttl_seconds âm (ErrInvalidTimeout,
+// ErrInvalidCacheTTL) bị từ chối, cũng như registration đổi schema ở strict mode
+// (ErrSchemaDrift).
+func (sr *ServiceRegistry) RegisterWorker(workerID string, info *WorkerInfo) error {
+	if err := normalizeHTTPMethods(info.Capabilities); err != nil {
+		return err
+	}
+	if err := validateExamples(info.Capabilities); err != nil {
+		return err
+	}
+	if err := validateTimeouts(info.Capabilities); err != nil {
+		return err
+	}
+	if err := sr.checkSchemaDrift(workerID, info.Capabilities); err != nil {
+		return err
+	}
+	labels, err := normalizeLabels(info.Labels)
+	if err != nil {
+		return err
+	}
+	info.Labels = labels
+	info.Namespace = normalizeNamespace(info.Namespace)
+
+	change := RegistryChange{Event: ChangeWorkerAdded, WorkerID: workerID, Namespace: info.Namespace, Status: info.Status}
+	defer func() { sr.notifyChange(change) }()
 	sr.mu.Lock()
 	defer sr.mu.Unlock()
 
-	sr.workers[workerID] = info
-
-	// Index capabilities in memory
-	for _, cap := range info.Capabilities {
-		if _, exists := sr.capabilities[cap.Name]; !exists {
-			sr.capabilities[cap.Name] = []string{}
-		}
-		sr.capabilities[cap.Name] = append(sr.capabilities[cap.Name], workerID)
+	// Re-registration replaces the previous capability set
+	var previous []ServiceCapability
+	if old, exists := sr.workers[workerID]; exists {
+		sr.unindexWorker(workerID, old)
+		previous = old.Capabilities
+		change.Event = ChangeWorkerUpdated
 	}
+	change.AddedCapabilities, change.RemovedCapabilities = capabilityDelta(previous, info.Capabilities)
+
+	info.Weight = workerWeight(info.Metadata)
+	applyWorkerStats(info)
+	sr.workers[workerID] = info
+	sr.indexWorker(workerID, info)
 
 	// Persist to database
 	if sr.db != nil {
 		sr.persistWorkerToDB(workerID, info)
 	}
+	return nil
 }
 
 // persistWorkerToDB saves worker and capabilities to database
 func (sr *ServiceRegistry) persistWorkerToDB(workerID string, info *WorkerInfo) {
 	// Insert or update worker
 	metadataJSON, _ := json.Marshal(info.Metadata)
+	var labelsJSON sql.NullString
+	if len(info.Labels) > 0 {
+		data, _ := json.Marshal(info.Labels)
+		labelsJSON = sql.NullString{String: string(data), Valid: true}
+	}
 	
 	_, err := sr.db.Exec(`
-		INSERT OR REPLACE INTO workers (id, type, status, metadata, registered_at, last_seen)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, workerID, info.Type, info.Status, string(metadataJSON), 
+		INSERT OR REPLACE INTO workers (id, namespace, type, status, metadata, labels, registered_at, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, workerID, info.Namespace, info.Type, info.Status, string(metadataJSON), labelsJSON,
 		time.Now(), time.Now())
 	
 	if err != nil {
+		logger.Emoji("❌").WithField("worker_id", workerID).WithError(err).Error("failed to persist worker")
 		return
 	}
 
@@ -180,71 +510,432 @@ func (sr *ServiceRegistry) persistWorkerToDB(workerID string, info *WorkerInfo)
 
 	// Insert new capabilities
 	for _, cap := range info.Capabilities {
-		sr.db.Exec(`
+		var tagsJSON sql.NullString
+		if len(cap.Tags) > 0 {
+			data, _ := json.Marshal(cap.Tags)
+			tagsJSON = sql.NullString{String: string(data), Valid: true}
+		}
+
+		_, err := sr.db.Exec(`
 			INSERT INTO capabilities 
-			(worker_id, name, description, input_schema, output_schema, http_method, accepts_file, file_field_name)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			(worker_id, name, description, input_schema, output_schema, http_method, accepts_file, file_field_name, tags,
+				deprecated, deprecation_message, example, default_timeout_ms, cacheable, cache_ttl_seconds)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		`, workerID, cap.Name, cap.Description, cap.InputSchema, cap.OutputSchema,
-			cap.HTTPMethod, cap.AcceptsFile, cap.FileFieldName)
+			cap.HTTPMethod, cap.AcceptsFile, cap.FileFieldName, tagsJSON,
+			cap.Deprecated, cap.DeprecationMessage, cap.Example, cap.DefaultTimeoutMs, cap.Cacheable, cap.CacheTTLSeconds)
+		if err != nil {
+			logger.Emoji("❌").WithFields(logger.Fields{"worker_id": workerID, "capability": cap.Name}).
+				WithError(err).Error("failed to persist capability")
+		}
 	}
 }
 
 // UnregisterWorker gỡ đăng ký worker
 func (sr *ServiceRegistry) UnregisterWorker(workerID string) {
 	sr.mu.Lock()
-	defer sr.mu.Unlock()
-
 	info, exists := sr.workers[workerID]
-	if !exists {
-		return
+	if exists {
+		sr.unindexWorker(workerID, info)
+		delete(sr.workers, workerID)
+		if sr.breaker != nil {
+			sr.breaker.Remove(workerID)
+		}
+	}
+	sr.mu.Unlock()
+
+	if exists {
+		_, removed := capabilityDelta(info.Capabilities, nil)
+		sr.notifyChange(RegistryChange{Event: ChangeWorkerRemoved, WorkerID: workerID, Namespace: info.Namespace, RemovedCapabilities: removed})
 	}
+}
 
-	// Remove from capabilities index
+// DeleteWorker gỡ đăng ký worker và xóa worker cùng capabilities của nó
+// khỏi database, kể cả khi worker chỉ còn trong database (vd: ghost worker
+// nạp lại sau khi hub restart). Trả về các capabilities đã bị gỡ và false
+// nếu không tìm thấy worker.
+func (sr *ServiceRegistry) DeleteWorker(workerID string) ([]string, bool) {
+	sr.mu.RLock()
+	info, registered := sr.workers[workerID]
+	sr.mu.RUnlock()
+
+	var removed []string
+	if registered {
+		_, removed = capabilityDelta(info.Capabilities, nil)
+		sr.UnregisterWorker(workerID)
+	}
+
+	persisted := false
+	if sr.db != nil {
+		sr.db.Exec(`DELETE FROM capabilities WHERE worker_id = ?`, workerID)
+		if result, err := sr.db.Exec(`DELETE FROM workers WHERE id = ?`, workerID); err != nil {
+			logger.Emoji("❌").WithField("worker_id", workerID).WithError(err).Error("failed to delete worker")
+		} else if n, _ := result.RowsAffected(); n > 0 {
+			persisted = true
+		}
+	}
+	return removed, registered || persisted
+}
+
+// indexWorker thêm worker vào capabilities index của namespace của nó,
+// trừ các capabilities bị tắt (caller giữ lock)
+func (sr *ServiceRegistry) indexWorker(workerID string, info *WorkerInfo) {
+	index := sr.capabilities[info.Namespace]
+	if index == nil {
+		index = make(map[string][]string)
+		sr.capabilities[info.Namespace] = index
+	}
 	for _, cap := range info.Capabilities {
-		workers := sr.capabilities[cap.Name]
+		if cap.Disabled {
+			continue
+		}
+		index[cap.Name] = append(index[cap.Name], workerID)
+	}
+}
+
+// unindexWorker xóa worker khỏi capabilities index (caller giữ lock)
+func (sr *ServiceRegistry) unindexWorker(workerID string, info *WorkerInfo) {
+	index := sr.capabilities[info.Namespace]
+	for _, cap := range info.Capabilities {
+		workers := index[cap.Name]
 		for i, wid := range workers {
 			if wid == workerID {
-				sr.capabilities[cap.Name] = append(workers[:i], workers[i+1:]...)
+				index[cap.Name] = append(workers[:i], workers[i+1:]...)
 				break
 			}
 		}
+		if len(index[cap.Name]) == 0 {
+			delete(index, cap.Name)
+		}
 	}
+	if len(index) == 0 {
+		delete(sr.capabilities, info.Namespace)
+	}
+}
+
+// SetSelector thay đổi selection strategy
+func (sr *ServiceRegistry) SetSelector(selector WorkerSelector) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.selector = selector
+}
+
+// SetLoadFunc đặt nguồn in-flight count dùng để bỏ qua worker đã đạt
+// MaxConcurrency
+func (sr *ServiceRegistry) SetLoadFunc(load LoadFunc) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.load = load
+}
+
+// SetOnChange đặt hàm được gọi mỗi khi worker đăng ký, gỡ đăng ký hoặc đổi
+// status (ví dụ để báo gateways xóa discovery cache)
+func (sr *ServiceRegistry) SetOnChange(fn ChangeFunc) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.onChange = fn
+}
 
-	delete(sr.workers, workerID)
+// notifyChange gọi onChange; không được gọi khi đang giữ sr.mu
+func (sr *ServiceRegistry) notifyChange(change RegistryChange) {
+	sr.mu.RLock()
+	fn := sr.onChange
+	sr.mu.RUnlock()
+	if fn != nil {
+		fn(change)
+	}
 }
 
-// GetWorkerForCapability trả về worker ID có capability (load balancing đơn giản)
+// GetWorkerForCapability trả về worker ID có capability, chọn bởi selector
 func (sr *ServiceRegistry) GetWorkerForCapability(capabilityName string) (string, bool) {
+	return sr.GetWorkerForCapabilityWithKey(capabilityName, "")
+}
+
+// GetWorkerForCapabilityWithKey giống GetWorkerForCapability, nhưng với
+// KeyedSelector thì cùng sessionKey sẽ vào cùng worker (khi worker còn online)
+func (sr *ServiceRegistry) GetWorkerForCapabilityWithKey(capabilityName, sessionKey string) (string, bool) {
+	workerID, err := sr.SelectWorker(capabilityName, sessionKey)
+	return workerID, err == nil
+}
+
+// SelectWorker chọn worker như GetWorkerForCapabilityWithKey, bỏ qua worker
+// đã đạt MaxConcurrency. Trả về ErrWorkersBusy nếu mọi worker đều bận (caller
+// nên retry), ErrNoWorker nếu không có worker nào.
+func (sr *ServiceRegistry) SelectWorker(capabilityName, sessionKey string) (string, error) {
+	return sr.SelectWorkerMatching(capabilityName, sessionKey, nil)
+}
+
+// SelectWorkerMatching giống SelectWorker nhưng chỉ chọn trong các worker
+// khớp label selector; trả về ErrNoMatchingWorker nếu có worker online cho
+// capability nhưng không worker nào khớp
+func (sr *ServiceRegistry) SelectWorkerMatching(capabilityName, sessionKey string, selector map[string]string) (string, error) {
+	return sr.SelectWorkerIn(DefaultNamespace, capabilityName, sessionKey, selector)
+}
+
+// SelectWorkerIn giống SelectWorkerMatching nhưng chọn trong namespace
+func (sr *ServiceRegistry) SelectWorkerIn(namespace, capabilityName, sessionKey string, selector map[string]string) (string, error) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	candidates := sr.onlineWorkersFor(namespace, capabilityName)
+	if len(candidates) == 0 {
+		return "", ErrNoWorker
+	}
+	candidates = withLabels(candidates, selector)
+	if len(candidates) == 0 {
+		return "", ErrNoMatchingWorker
+	}
+	candidates = sr.withCapacity(candidates)
+	if len(candidates) == 0 {
+		return "", ErrWorkersBusy
+	}
+	candidates = sr.withClosedBreaker(candidates)
+	if len(candidates) == 0 {
+		return "", ErrCircuitOpen
+	}
+
+	var selected *WorkerInfo
+	var ok bool
+	if keyed, isKeyed := sr.selector.(KeyedSelector); isKeyed && sessionKey != "" {
+		selected, ok = keyed.SelectKey(sessionKey, candidates)
+	} else {
+		selected, ok = sr.selector.Select(candidates)
+	}
+	if !ok {
+		return "", ErrNoWorker
+	}
+	if sr.breaker != nil {
+		sr.breaker.Acquire(selected.ID)
+	}
+	return selected.ID, nil
+}
+
+// SelectWorkers trả về các worker online có capability và khớp selector
+// (selector rỗng khớp mọi worker), theo thứ tự đăng ký
+func (sr *ServiceRegistry) SelectWorkers(capabilityName string, selector map[string]string) []*WorkerInfo {
+	return sr.SelectWorkersIn(DefaultNamespace, capabilityName, selector)
+}
+
+// SelectWorkersIn giống SelectWorkers nhưng trong namespace
+func (sr *ServiceRegistry) SelectWorkersIn(namespace, capabilityName string, selector map[string]string) []*WorkerInfo {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	matching := withLabels(sr.onlineWorkersFor(namespace, capabilityName), selector)
+	workers := make([]*WorkerInfo, len(matching))
+	for i, info := range matching {
+		copied := *info
+		workers[i] = &copied
+	}
+	return workers
+}
+
+// withClosedBreaker bỏ các worker đang mở circuit breaker
+func (sr *ServiceRegistry) withClosedBreaker(candidates []*WorkerInfo) []*WorkerInfo {
+	if sr.breaker == nil {
+		return candidates
+	}
+	ready := candidates[:0:0]
+	for _, candidate := range candidates {
+		if sr.breaker.Ready(candidate.ID) {
+			ready = append(ready, candidate)
+		}
+	}
+	return ready
+}
+
+// SetCircuitBreaker bật circuit breaker cho việc chọn worker (nil để tắt)
+func (sr *ServiceRegistry) SetCircuitBreaker(breaker *CircuitBreaker) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.breaker = breaker
+}
+
+// RecordWorkerResult cập nhật breaker sau một response (failed = worker
+// lỗi hoặc timeout) và trả về true nếu breaker vừa mở
+func (sr *ServiceRegistry) RecordWorkerResult(workerID string, failed bool) bool {
+	sr.mu.RLock()
+	breaker := sr.breaker
+	sr.mu.RUnlock()
+	if breaker == nil {
+		return false
+	}
+	if !failed {
+		breaker.RecordSuccess(workerID)
+		return false
+	}
+	return breaker.RecordFailure(workerID)
+}
+
+// ReleaseBreaker trả lượt thử half-open của worker khi response không cho
+// biết worker có khỏe không
+func (sr *ServiceRegistry) ReleaseBreaker(workerID string) {
+	sr.mu.RLock()
+	breaker := sr.breaker
+	sr.mu.RUnlock()
+	if breaker != nil {
+		breaker.Release(workerID)
+	}
+}
+
+// BreakerStates trả về các worker có breaker không đóng (worker_id -> state)
+func (sr *ServiceRegistry) BreakerStates() map[string]string {
+	sr.mu.RLock()
+	breaker := sr.breaker
+	sr.mu.RUnlock()
+	if breaker == nil {
+		return map[string]string{}
+	}
+	return breaker.States()
+}
+
+// withCapacity lọc bỏ worker có in-flight >= MaxConcurrency (caller giữ lock).
+// Giới hạn là best effort: hai request chọn cùng lúc có thể vượt một chút.
+func (sr *ServiceRegistry) withCapacity(candidates []*WorkerInfo) []*WorkerInfo {
+	if sr.load == nil {
+		return candidates
+	}
+	available := candidates[:0:0]
+	for _, candidate := range candidates {
+		if candidate.MaxConcurrency <= 0 || sr.load(candidate.ID) < candidate.MaxConcurrency {
+			available = append(available, candidate)
+		}
+	}
+	return available
+}
+
+// GetCapability trả về capability mà worker đã đăng ký
+func (sr *ServiceRegistry) GetCapability(workerID, capabilityName string) (ServiceCapability, bool) {
 	sr.mu.RLock()
 	defer sr.mu.RUnlock()
 
-	workers, exists := sr.capabilities[capabilityName]
-	if !exists || len(workers) == 0 {
-		return "", false
+	if info, exists := sr.workers[workerID]; exists {
+		for _, cap := range info.Capabilities {
+			if cap.Name == capabilityName {
+				return cap, true
+			}
+		}
+	}
+	return ServiceCapability{}, false
+}
+
+// CacheTTL trả về thời gian cache kết quả của capability, 0 nếu không được
+// cache. Với workerID (request chỉ định worker) dùng khai báo của worker
+// đó; nếu không, mọi worker online trong namespace phải khai báo cacheable
+// và TTL nhỏ nhất được dùng.
+func (sr *ServiceRegistry) CacheTTL(namespace, workerID, capabilityName string) time.Duration {
+	if workerID != "" {
+		cap, found := sr.GetCapability(workerID, capabilityName)
+		if !found {
+			return 0
+		}
+		return cacheTTL(cap)
 	}
 
-	// Simple round-robin: chọn worker đầu tiên có status online
-	for _, workerID := range workers {
-		if info, ok := sr.workers[workerID]; ok && info.Status == "online" {
-			return workerID, true
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	var ttl time.Duration
+	for _, info := range sr.onlineWorkersFor(namespace, capabilityName) {
+		for _, cap := range info.Capabilities {
+			if cap.Name != capabilityName {
+				continue
+			}
+			capTTL := cacheTTL(cap)
+			if capTTL == 0 {
+				return 0
+			}
+			if ttl == 0 || capTTL < ttl {
+				ttl = capTTL
+			}
 		}
 	}
+	return ttl
+}
 
+// cacheTTL là thời gian cache capability khai báo, 0 nếu không cacheable
+func cacheTTL(cap ServiceCapability) time.Duration {
+	if !cap.Cacheable {
+		return 0
+	}
+	if cap.CacheTTLSeconds == 0 {
+		return DefaultCacheTTL
+	}
+	return time.Duration(cap.CacheTTLSeconds) * time.Second
+}
+
+// WorkerHasCapability kiểm tra worker có đăng ký capability không
+func (sr *ServiceRegistry) WorkerHasCapability(workerID, capabilityName string) bool {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	info, exists := sr.workers[workerID]
+	if !exists {
+		return false
+	}
+	for _, wid := range sr.capabilities[info.Namespace][capabilityName] {
+		if wid == workerID {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkerNamespace trả về namespace của worker đã đăng ký
+func (sr *ServiceRegistry) WorkerNamespace(workerID string) (string, bool) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	if info, exists := sr.workers[workerID]; exists {
+		return info.Namespace, true
+	}
 	return "", false
 }
 
-// GetAllCapabilities trả về tất cả capabilities available
+// onlineWorkersFor trả về workers online trong namespace có capability, theo
+// thứ tự đăng ký (caller giữ lock)
+func (sr *ServiceRegistry) onlineWorkersFor(namespace, capabilityName string) []*WorkerInfo {
+	workerIDs := sr.capabilities[namespace][capabilityName]
+	candidates := make([]*WorkerInfo, 0, len(workerIDs))
+	for _, workerID := range workerIDs {
+		if info, ok := sr.workers[workerID]; ok && info.Status == WorkerStatusOnline {
+			candidates = append(candidates, info)
+		}
+	}
+	return candidates
+}
+
+// AllNamespaces thay cho namespace trong các hàm *In để lấy mọi namespace
+const AllNamespaces = "*"
+
+// inNamespace kiểm tra worker thuộc namespace (hoặc namespace là AllNamespaces)
+func inNamespace(info *WorkerInfo, namespace string) bool {
+	return namespace == AllNamespaces || info.Namespace == namespace
+}
+
+// GetAllCapabilities trả về tất cả capabilities available, mọi namespace
 func (sr *ServiceRegistry) GetAllCapabilities() map[string]ServiceCapability {
+	return sr.GetCapabilitiesIn(AllNamespaces)
+}
+
+// GetCapabilitiesIn trả về capabilities available trong namespace
+func (sr *ServiceRegistry) GetCapabilitiesIn(namespace string) map[string]ServiceCapability {
 	sr.mu.RLock()
 	defer sr.mu.RUnlock()
 
 	result := make(map[string]ServiceCapability)
 	
-	for _, worker := range sr.workers {
-		if worker.Status != "online" {
+	// Khi nhiều worker cùng capability, worker có ID nhỏ nhất thắng
+	workers := sr.sortedWorkers()
+	for i := len(workers) - 1; i >= 0; i-- {
+		worker := workers[i]
+		if worker.Status != WorkerStatusOnline || !inNamespace(worker, namespace) {
 			continue
 		}
 		for _, cap := range worker.Capabilities {
+			if IsInternalCapability(cap.Name) || cap.Disabled {
+				continue
+			}
 			result[cap.Name] = cap
 		}
 	}
@@ -252,26 +943,210 @@ func (sr *ServiceRegistry) GetAllCapabilities() map[string]ServiceCapability {
 	return result
 }
 
-// GetAllWorkers trả về tất cả workers
-func (sr *ServiceRegistry) GetAllWorkers() []*WorkerInfo {
+// GetCapabilityProviders trả về, cho mỗi capability (trừ nội bộ), danh sách
+// worker IDs cung cấp nó theo thứ tự ID, kể cả workers không online
+func (sr *ServiceRegistry) GetCapabilityProviders() map[string][]string {
+	return sr.GetCapabilityProvidersIn(AllNamespaces)
+}
+
+// GetCapabilityProvidersIn giống GetCapabilityProviders nhưng trong namespace
+func (sr *ServiceRegistry) GetCapabilityProvidersIn(namespace string) map[string][]string {
 	sr.mu.RLock()
 	defer sr.mu.RUnlock()
 
+	result := make(map[string][]string)
+	for ns, index := range sr.capabilities {
+		if namespace != AllNamespaces && ns != namespace {
+			continue
+		}
+		for name, workerIDs := range index {
+			if IsInternalCapability(name) || len(workerIDs) == 0 {
+				continue
+			}
+			result[name] = append(result[name], workerIDs...)
+		}
+	}
+	// Thứ tự đăng ký phụ thuộc vào thứ tự workers kết nối lại
+	for _, workerIDs := range result {
+		sort.Strings(workerIDs)
+	}
+	return result
+}
+
+// GetCapabilitiesByTag trả về các capabilities available có tag
+func (sr *ServiceRegistry) GetCapabilitiesByTag(tag string) map[string]ServiceCapability {
+	return sr.GetCapabilitiesByTagIn(AllNamespaces, tag)
+}
+
+// GetCapabilitiesByTagIn giống GetCapabilitiesByTag nhưng trong namespace
+func (sr *ServiceRegistry) GetCapabilitiesByTagIn(namespace, tag string) map[string]ServiceCapability {
+	result := make(map[string]ServiceCapability)
+	for name, cap := range sr.GetCapabilitiesIn(namespace) {
+		if cap.HasTag(tag) {
+			result[name] = cap
+		}
+	}
+	return result
+}
+
+// GetAllWorkers trả về tất cả workers, sắp xếp theo ID (giữ cho tương
+// thích; dùng ListWorkers để lọc và phân trang)
+func (sr *ServiceRegistry) GetAllWorkers() []*WorkerInfo {
+	list, _ := sr.ListWorkers(WorkerFilter{})
+	return list.Workers
+}
+
+// GetPublicWorkers trả về workers (theo ID) với capabilities nội bộ đã được ẩn
+func (sr *ServiceRegistry) GetPublicWorkers() []*WorkerInfo {
+	return sr.GetPublicWorkersIn(AllNamespaces)
+}
+
+// GetPublicWorkersIn giống GetPublicWorkers nhưng trong namespace
+func (sr *ServiceRegistry) GetPublicWorkersIn(namespace string) []*WorkerInfo {
+	list, _ := sr.ListWorkers(WorkerFilter{Namespace: namespace, Public: true})
+	return list.Workers
+}
+
+// sortedWorkers trả về workers theo ID để discovery/swagger ổn định giữa các
+// lần gọi (caller giữ lock)
+func (sr *ServiceRegistry) sortedWorkers() []*WorkerInfo {
 	workers := make([]*WorkerInfo, 0, len(sr.workers))
 	for _, info := range sr.workers {
 		workers = append(workers, info)
 	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
 	return workers
 }
 
 // UpdateWorkerStatus cập nhật status của worker
 func (sr *ServiceRegistry) UpdateWorkerStatus(workerID, status string) {
 	sr.mu.Lock()
-	defer sr.mu.Unlock()
-
-	if info, exists := sr.workers[workerID]; exists {
+	info, exists := sr.workers[workerID]
+	changed := exists && info.Status != status
+	var namespace string
+	if changed {
 		info.Status = status
+		namespace = info.Namespace
+	}
+	sr.mu.Unlock()
+
+	if changed {
+		sr.notifyChange(RegistryChange{Event: ChangeWorkerUpdated, WorkerID: workerID, Namespace: namespace, Status: status})
+	}
+}
+
+// MarkDraining chuyển worker sang draining để không nhận request mới;
+// trả về false nếu worker chưa đăng ký
+func (sr *ServiceRegistry) MarkDraining(workerID string) bool {
+	sr.mu.Lock()
+	info, exists := sr.workers[workerID]
+	var namespace string
+	if exists {
+		info.Status = WorkerStatusDraining
+		namespace = info.Namespace
+	}
+	sr.mu.Unlock()
+
+	if exists {
+		sr.notifyChange(RegistryChange{Event: ChangeWorkerUpdated, WorkerID: workerID, Namespace: namespace, Status: WorkerStatusDraining})
+	}
+	return exists
+}
+
+// UpdateWorkerMetadata vá metadata của worker mà không đăng ký lại: key
+// có giá trị nil bị xóa, các key khác được ghi đè. LastSeen,
+// MaxConcurrency, Weight và thống kê được cập nhật theo metadata mới;
+// capabilities giữ nguyên. Trả về metadata sau khi vá và false nếu worker chưa đăng ký.
+func (sr *ServiceRegistry) UpdateWorkerMetadata(workerID string, patch map[string]interface{}) (map[string]interface{}, bool) {
+	sr.mu.Lock()
+	info, exists := sr.workers[workerID]
+	if !exists {
+		sr.mu.Unlock()
+		return nil, false
+	}
+	// Copy-on-write: bản cũ có thể đang được marshal ngoài lock
+	metadata := make(map[string]interface{}, len(info.Metadata)+len(patch))
+	for key, value := range info.Metadata {
+		metadata[key] = value
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(metadata, key)
+		} else {
+			metadata[key] = value
+		}
+	}
+	now := time.Now()
+	info.Metadata = metadata
+	info.LastSeen = now.Format(time.RFC3339)
+	info.MaxConcurrency = maxConcurrency(metadata)
+	info.Weight = workerWeight(metadata)
+	applyWorkerStats(info)
+	sr.mu.Unlock()
+
+	if sr.db != nil {
+		metadataJSON, _ := json.Marshal(metadata)
+		if _, err := sr.db.Exec(`UPDATE workers SET metadata = ?, last_seen = ? WHERE id = ?`,
+			string(metadataJSON), now, workerID); err != nil {
+			logger.Emoji("❌").WithField("worker_id", workerID).WithError(err).Error("failed to update worker metadata")
+		}
 	}
+	return metadata, true
+}
+
+// SetCapabilityEnabled tắt (enabled=false) hoặc bật lại một capability của
+// worker mà không gỡ worker: capability bị tắt ra khỏi index nên không được
+// chọn hay liệt kê trong discovery, các capabilities khác vẫn route bình
+// thường. Trả về false nếu capability đã ở trạng thái đó. Worker đăng ký
+// lại thì trạng thái theo registration mới.
+func (sr *ServiceRegistry) SetCapabilityEnabled(workerID, capabilityName string, enabled bool) (bool, error) {
+	sr.mu.Lock()
+	info, exists := sr.workers[workerID]
+	if !exists {
+		sr.mu.Unlock()
+		return false, ErrWorkerNotFound
+	}
+	i := -1
+	for j, cap := range info.Capabilities {
+		if cap.Name == capabilityName {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		sr.mu.Unlock()
+		return false, fmt.Errorf("%w: %s", ErrUnknownCapability, capabilityName)
+	}
+	if info.Capabilities[i].Disabled == !enabled {
+		sr.mu.Unlock()
+		return false, nil
+	}
+
+	// Copy-on-write: bản cũ có thể đang được đọc ngoài lock
+	before := info.Capabilities
+	after := make([]ServiceCapability, len(before))
+	copy(after, before)
+	after[i].Disabled = !enabled
+
+	sr.unindexWorker(workerID, info)
+	info.Capabilities = after
+	sr.indexWorker(workerID, info)
+	change := RegistryChange{
+		Event:     ChangeWorkerUpdated,
+		WorkerID:  workerID,
+		Namespace: info.Namespace,
+		Status:    info.Status,
+	}
+	change.AddedCapabilities, change.RemovedCapabilities = capabilityDelta(before, after)
+	sr.mu.Unlock()
+
+	logger.Emoji("🔀").WithFields(logger.Fields{
+		"worker_id":  workerID,
+		"capability": capabilityName,
+		"enabled":    enabled,
+	}).Info("capability state changed")
+	sr.notifyChange(change)
+	return true, nil
 }
 
 // ToJSON serialize registry to JSON
@@ -2,7 +2,11 @@ package hub
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"deepapp_golang_grpc_hub/internal/models"
+	"deepapp_golang_grpc_hub/pkg/logger"
 )
 
 // RequestInfo stores information about a pending request
@@ -11,20 +15,82 @@ type RequestInfo struct {
 	RequesterID string // Original client who made the request
 	WorkerID    string // Worker processing the request
 	Capability  string
+	CorrelationID string
 	CreatedAt   time.Time
 	ExpiresAt   time.Time
+
+	DedupKey string   // requester|idempotency_key, empty if not deduplicated
+	Waiters  []Waiter // duplicates waiting for this request's response
+
+	CacheKey string        // result cache key, empty if not cacheable
+	CacheTTL time.Duration // how long the response may be cached
+}
+
+// WorkerCallInfo stores a worker-to-worker call waiting for its reply
+type WorkerCallInfo struct {
+	CallID     string // ID of the WORKER_CALL message
+	CallerID   string // Worker that made the call
+	TargetID   string // Worker handling it
+	Capability string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// Waiter is a duplicate request attached to one already in flight; it
+// receives a copy of that request's response
+type Waiter struct {
+	RequesterID   string
+	RequestID     string
+	MessageID     string
+	CorrelationID string
 }
 
+// RequestStore persists tracked requests so they survive a hub restart
+// (see Server.RecoverRequests)
+type RequestStore interface {
+	Save(req *models.PendingRequest) error
+	Delete(requestID string) error
+}
+
+// persistQueueSize is how many store writes may wait for the database
+// before further ones are dropped
+const persistQueueSize = 1024
+
+// DefaultRequestTimeout is how long a request waits for a response before
+// it is dropped
+const DefaultRequestTimeout = 5 * time.Minute
+
 // RequestTracker tracks active requests and routes responses back
 type RequestTracker struct {
 	mu       sync.RWMutex
+	timeout  time.Duration
 	requests map[string]*RequestInfo // request_id -> RequestInfo
+	inFlight map[string]int          // worker_id -> active requests
+	dedup    map[string]string       // dedup key -> request_id
+	calls    map[string]*WorkerCallInfo // call message id -> worker call
+	onExpire func(info RequestInfo)  // called for requests that never got a response
+	store    RequestStore            // nil disables persistence
+	persist  chan storeOp            // store writes, queued in lock order
+	dropped  atomic.Int64            // store writes dropped because persist was full
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewRequestTracker creates a new request tracker
 func NewRequestTracker() *RequestTracker {
+	return NewRequestTrackerWithTimeout(DefaultRequestTimeout)
+}
+
+// NewRequestTrackerWithTimeout creates a tracker that drops requests left
+// without a response for timeout
+func NewRequestTrackerWithTimeout(timeout time.Duration) *RequestTracker {
 	tracker := &RequestTracker{
+		timeout:  timeout,
 		requests: make(map[string]*RequestInfo),
+		inFlight: make(map[string]int),
+		dedup:    make(map[string]string),
+		calls:    make(map[string]*WorkerCallInfo),
+		stop:     make(chan struct{}),
 	}
 	
 	// Start cleanup goroutine
@@ -34,18 +100,138 @@ func NewRequestTracker() *RequestTracker {
 }
 
 // Track registers a new request
-func (rt *RequestTracker) Track(requestID, requesterID, workerID, capability string) {
+func (rt *RequestTracker) Track(requestID, requesterID, workerID, capability, correlationID string) {
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
 	
-	rt.requests[requestID] = &RequestInfo{
+	if old, exists := rt.requests[requestID]; exists {
+		rt.remove(old)
+	}
+	rt.inFlight[workerID]++
+
+	info := &RequestInfo{
 		RequestID:   requestID,
 		RequesterID: requesterID,
 		WorkerID:    workerID,
 		Capability:  capability,
+		CorrelationID: correlationID,
 		CreatedAt:   time.Now(),
-		ExpiresAt:   time.Now().Add(5 * time.Minute), // 5 minute timeout
+		ExpiresAt:   time.Now().Add(rt.timeout),
+	}
+	rt.requests[requestID] = info
+	rt.enqueue(storeOp{save: &models.PendingRequest{
+		RequestID:     info.RequestID,
+		RequesterID:   info.RequesterID,
+		WorkerID:      info.WorkerID,
+		Capability:    info.Capability,
+		CorrelationID: info.CorrelationID,
+		CreatedAt:     info.CreatedAt,
+		ExpiresAt:     info.ExpiresAt,
+	}})
+}
+
+// storeOp is a write to a RequestStore: save a request or delete one
+type storeOp struct {
+	store  RequestStore
+	save   *models.PendingRequest
+	delete string
+}
+
+// SetStore persists requests tracked from now on to store. Writes happen
+// on a background goroutine in the order requests are tracked and removed.
+func (rt *RequestTracker) SetStore(store RequestStore) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.store = store
+	if rt.persist == nil {
+		rt.persist = make(chan storeOp, persistQueueSize)
+		go rt.persistLoop()
+	}
+}
+
+// enqueue queues a store write (caller holds the lock). It never blocks:
+// with the database persistQueueSize writes behind, the write is dropped
+// and counted rather than stalling routing, which holds the same lock.
+func (rt *RequestTracker) enqueue(op storeOp) {
+	if rt.store == nil {
+		return
+	}
+	op.store = rt.store
+	select {
+	case rt.persist <- op:
+	default:
+		rt.dropped.Add(1)
+		requestID := op.delete
+		if op.save != nil {
+			requestID = op.save.RequestID
+		}
+		logger.Emoji("🚧").WithField("request_id", requestID).Warn("request store queue full, dropping write")
+	}
+}
+
+// persistLoop applies queued store writes
+func (rt *RequestTracker) persistLoop() {
+	for op := range rt.persist {
+		var err error
+		requestID := op.delete
+		if op.save != nil {
+			requestID = op.save.RequestID
+			err = op.store.Save(op.save)
+		} else {
+			err = op.store.Delete(op.delete)
+		}
+		if err != nil {
+			logger.Emoji("⚠️").WithError(err).WithField("request_id", requestID).Warn("failed to persist request state")
+		}
+	}
+}
+
+// SetExpireFunc sets the function called (outside the lock) for each
+// request dropped by cleanup because no response arrived in time
+func (rt *RequestTracker) SetExpireFunc(fn func(info RequestInfo)) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.onExpire = fn
+}
+
+// SetDedupKey lets later requests with the same key attach to requestID
+// while it is in flight (see Attach)
+func (rt *RequestTracker) SetDedupKey(requestID, key string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if info, exists := rt.requests[requestID]; exists {
+		info.DedupKey = key
+		rt.dedup[key] = requestID
+	}
+}
+
+// SetCacheKey marks requestID's response as cacheable under key for ttl
+func (rt *RequestTracker) SetCacheKey(requestID, key string, ttl time.Duration) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if info, exists := rt.requests[requestID]; exists {
+		info.CacheKey = key
+		info.CacheTTL = ttl
+	}
+}
+
+// Attach adds waiter to the in-flight request with the same dedup key if
+// it started less than window ago, and returns that request's ID. A resend
+// of the in-flight request itself is absorbed without adding a waiter.
+func (rt *RequestTracker) Attach(key string, window time.Duration, waiter Waiter) (string, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	info, exists := rt.requests[rt.dedup[key]]
+	if !exists || time.Since(info.CreatedAt) > window {
+		return "", false
 	}
+	if waiter.RequestID != info.RequestID {
+		info.Waiters = append(info.Waiters, waiter)
+	}
+	return info.RequestID, true
 }
 
 // GetRequester retrieves the original requester for a request_id
@@ -59,12 +245,103 @@ func (rt *RequestTracker) GetRequester(requestID string) (requesterID string, fo
 	return "", false
 }
 
-// Complete removes a request from tracking
-func (rt *RequestTracker) Complete(requestID string) {
+// Get returns the tracking info for a request
+func (rt *RequestTracker) Get(requestID string) (RequestInfo, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	if info, exists := rt.requests[requestID]; exists {
+		return *info, true
+	}
+	return RequestInfo{}, false
+}
+
+// Complete removes a request from tracking and returns the duplicates
+// waiting for its response
+func (rt *RequestTracker) Complete(requestID string) []Waiter {
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
 	
-	delete(rt.requests, requestID)
+	if info, exists := rt.requests[requestID]; exists {
+		rt.remove(info)
+		return info.Waiters
+	}
+	return nil
+}
+
+// TrackWorkerCall registers a worker-to-worker call so its reply is routed
+// back to the caller. Worker calls are not persisted and do not count
+// towards PendingCount; unanswered ones are dropped after the timeout.
+func (rt *RequestTracker) TrackWorkerCall(callID, callerID, targetID, capability string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	now := time.Now()
+	rt.calls[callID] = &WorkerCallInfo{
+		CallID:     callID,
+		CallerID:   callerID,
+		TargetID:   targetID,
+		Capability: capability,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(rt.timeout),
+	}
+}
+
+// GetWorkerCall returns the tracking info for a worker call
+func (rt *RequestTracker) GetWorkerCall(callID string) (WorkerCallInfo, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	if info, exists := rt.calls[callID]; exists {
+		return *info, true
+	}
+	return WorkerCallInfo{}, false
+}
+
+// CompleteWorkerCall removes a worker call from tracking and returns it
+func (rt *RequestTracker) CompleteWorkerCall(callID string) (WorkerCallInfo, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if info, exists := rt.calls[callID]; exists {
+		delete(rt.calls, callID)
+		return *info, true
+	}
+	return WorkerCallInfo{}, false
+}
+
+// PendingCount returns the number of requests in flight on a worker
+func (rt *RequestTracker) PendingCount(workerID string) int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	return rt.inFlight[workerID]
+}
+
+// ActiveCount returns the number of requests waiting for a response
+func (rt *RequestTracker) ActiveCount() int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return len(rt.requests)
+}
+
+// remove stops tracking info (caller holds the lock)
+func (rt *RequestTracker) remove(info *RequestInfo) {
+	rt.release(info)
+	delete(rt.requests, info.RequestID)
+	rt.enqueue(storeOp{delete: info.RequestID})
+	if info.DedupKey != "" && rt.dedup[info.DedupKey] == info.RequestID {
+		delete(rt.dedup, info.DedupKey)
+	}
+}
+
+// release drops info from the per-worker count (caller holds the lock)
+func (rt *RequestTracker) release(info *RequestInfo) {
+	if rt.inFlight[info.WorkerID] <= 1 {
+		delete(rt.inFlight, info.WorkerID)
+	} else {
+		rt.inFlight[info.WorkerID]--
+	}
 }
 
 // cleanupExpired removes expired requests periodically
@@ -72,24 +349,51 @@ func (rt *RequestTracker) cleanupExpired() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 	
-	for range ticker.C {
+	for {
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+		}
+
 		rt.mu.Lock()
 		now := time.Now()
-		for requestID, info := range rt.requests {
+		var expired []RequestInfo
+		for _, info := range rt.requests {
 			if now.After(info.ExpiresAt) {
-				delete(rt.requests, requestID)
+				rt.remove(info)
+				expired = append(expired, *info)
 			}
 		}
+		for callID, call := range rt.calls {
+			if now.After(call.ExpiresAt) {
+				delete(rt.calls, callID)
+			}
+		}
+		onExpire := rt.onExpire
 		rt.mu.Unlock()
+
+		if onExpire != nil {
+			for _, info := range expired {
+				onExpire(info)
+			}
+		}
 	}
 }
 
+// Stop ends the cleanup goroutine; pending requests stay tracked
+func (rt *RequestTracker) Stop() {
+	rt.stopOnce.Do(func() { close(rt.stop) })
+}
+
 // GetStats returns current tracking statistics
 func (rt *RequestTracker) GetStats() map[string]interface{} {
 	rt.mu.RLock()
 	defer rt.mu.RUnlock()
 	
 	return map[string]interface{}{
-		"active_requests": len(rt.requests),
+		"active_requests":     len(rt.requests),
+		"active_worker_calls": len(rt.calls),
+		"persist_dropped":     rt.dropped.Load(),
 	}
 }
@@ -0,0 +1,209 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/internal/models"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+)
+
+// blockingStore is a RequestStore whose writes wait until unblock is closed
+type blockingStore struct {
+	unblock chan struct{}
+
+	mu     sync.Mutex
+	saved  map[string]bool
+	writes int
+}
+
+func newBlockingStore() *blockingStore {
+	return &blockingStore{unblock: make(chan struct{}), saved: make(map[string]bool)}
+}
+
+func (s *blockingStore) Save(req *models.PendingRequest) error {
+	<-s.unblock
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved[req.RequestID] = true
+	s.writes++
+	return nil
+}
+
+func (s *blockingStore) Delete(requestID string) error {
+	<-s.unblock
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.saved, requestID)
+	s.writes++
+	return nil
+}
+
+// waitWrites waits until n writes have been applied and returns the saved
+// request IDs
+func (s *blockingStore) waitWrites(t *testing.T, n int) map[string]bool {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		s.mu.Lock()
+		writes, saved := s.writes, s.saved
+		s.mu.Unlock()
+		if writes >= n {
+			return saved
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("store applied %d writes, want %d", writes, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// A database that stops keeping up must not stall tracking, which every
+// routed request goes through
+func TestTrackerDoesNotWaitForStore(t *testing.T) {
+	rt := NewRequestTracker()
+	defer rt.Stop()
+	store := newBlockingStore()
+	rt.SetStore(store)
+
+	requests := persistQueueSize + 100
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < requests; i++ {
+			id := fmt.Sprintf("req-%d", i)
+			rt.Track(id, "client", "w1", "echo", "")
+			if _, ok := rt.Get(id); !ok {
+				t.Errorf("%s not tracked", id)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("Track blocked on a store that is not keeping up")
+	}
+
+	if pending := rt.PendingCount("w1"); pending != requests {
+		t.Fatalf("PendingCount = %d, want %d", pending, requests)
+	}
+	dropped := rt.GetStats()["persist_dropped"].(int64)
+	if dropped == 0 {
+		t.Fatal("no store write counted as dropped")
+	}
+	close(store.unblock)
+}
+
+func TestTrackerPersistsInOrder(t *testing.T) {
+	rt := NewRequestTracker()
+	defer rt.Stop()
+	store := newBlockingStore()
+	close(store.unblock)
+	rt.SetStore(store)
+
+	for i := 0; i < 10; i++ {
+		rt.Track(fmt.Sprintf("req-%d", i), "client", "w1", "echo", "")
+	}
+	for i := 0; i < 10; i += 2 {
+		rt.Complete(fmt.Sprintf("req-%d", i))
+	}
+
+	saved := store.waitWrites(t, 15)
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("req-%d", i)
+		if pending := i%2 == 1; saved[id] != pending {
+			t.Errorf("%s stored: %v, want %v", id, saved[id], pending)
+		}
+	}
+	if dropped := rt.GetStats()["persist_dropped"].(int64); dropped != 0 {
+		t.Fatalf("%d store writes dropped with the store keeping up", dropped)
+	}
+}
+
+// idempotentRequest sends a REQUEST for capability marked with an
+// idempotency key
+func (c *testClient) idempotentRequest(capability, content, key string) *proto.Message {
+	c.t.Helper()
+	msg := &proto.Message{Type: proto.MessageType_REQUEST, Action: "request", Content: content,
+		Metadata: map[string]string{IdempotencyKeyMetadata: key}}
+	msg.Id = utils.PrefixedID("req")
+	msg.RequestId = msg.Id
+	envelope.SetCapability(msg, capability)
+	return c.send(msg)
+}
+
+// Two identical requests in flight at once reach the worker once, and each
+// gets the single response under its own request ID
+func TestDuplicateRequestsDispatchedOnce(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.DedupWindow = time.Minute
+	})
+	worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "ocr"})
+	client := h.connectClient(t, "c1", nil)
+
+	first := client.idempotentRequest("ocr", `{"page":1}`, "scan-42")
+	retry := client.idempotentRequest("ocr", `{"page":1}`, "scan-42")
+	req := worker.next()
+	if req.RequestId != first.RequestId {
+		t.Fatalf("worker got request %s, want %s", req.RequestId, first.RequestId)
+	}
+	worker.expectNothing(50 * time.Millisecond)
+
+	worker.reply(req, `{"text":"xin chào"}`)
+	got := make(map[string]*proto.Message)
+	for i := 0; i < 2; i++ {
+		resp := client.next()
+		got[resp.RequestId] = resp
+	}
+	for _, sent := range []*proto.Message{first, retry} {
+		resp, ok := got[sent.RequestId]
+		if !ok {
+			t.Fatalf("no response for %s, got %v", sent.RequestId, got)
+		}
+		if resp.Content != `{"text":"xin chào"}` || resp.Metadata[envelope.RequestIDKey] != sent.Id {
+			t.Errorf("response to %s: %q for message %s", sent.RequestId, resp.Content, resp.Metadata[envelope.RequestIDKey])
+		}
+	}
+	if n := h.requestTracker.PendingCount("w1"); n != 0 {
+		t.Errorf("%d requests still tracked on w1", n)
+	}
+
+	// Once answered, the same key is dispatched again
+	again := client.idempotentRequest("ocr", `{"page":1}`, "scan-42")
+	req = worker.next()
+	if req.RequestId != again.RequestId {
+		t.Fatalf("worker got request %s, want %s", req.RequestId, again.RequestId)
+	}
+	worker.reply(req, `{}`)
+	client.next()
+}
+
+// The key only deduplicates requests of the same client, and not at all
+// with the window disabled
+func TestDuplicateRequestsNotMerged(t *testing.T) {
+	for _, window := range []time.Duration{time.Minute, 0} {
+		h := newTestHub(t, func(cfg *config.Config) {
+			cfg.DedupWindow = window
+		})
+		worker := h.connectWorker(t, "w1", "", ServiceCapability{Name: "ocr"})
+		c1 := h.connectClient(t, "c1", nil)
+		c2 := h.connectClient(t, "c2", nil)
+
+		c1.idempotentRequest("ocr", `{}`, "scan-42")
+		c2.idempotentRequest("ocr", `{}`, "scan-42")
+		want := 2
+		if window == 0 {
+			c1.idempotentRequest("ocr", `{}`, "scan-42")
+			want = 3
+		}
+		for i := 0; i < want; i++ {
+			worker.reply(worker.next(), `{}`)
+		}
+		worker.expectNothing(50 * time.Millisecond)
+	}
+}
@@ -1,18 +1,22 @@
 package hub
 
 import (
+	"sync/atomic"
+
 	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/logger"
 )
 
 type Router struct {
-	connMgr *ConnectionManager
-	subMgr  *SubscriberManager
+	connMgr     *ConnectionManager
+	channels    *ChannelManager
+	deadLetters int64 // messages that could not be delivered
 }
 
-func NewRouter(connMgr *ConnectionManager, subMgr *SubscriberManager) *Router {
+func NewRouter(connMgr *ConnectionManager, channels *ChannelManager) *Router {
 	return &Router{
-		connMgr: connMgr,
-		subMgr:  subMgr,
+		connMgr:  connMgr,
+		channels: channels,
 	}
 }
 
@@ -24,22 +28,40 @@ func (r *Router) Route(msg *proto.Message) {
 		r.routeBroadcast(msg)
 	case proto.MessageType_CHANNEL:
 		r.routeChannel(msg)
-	case proto.MessageType_REQUEST, proto.MessageType_RESPONSE, proto.MessageType_WORKER_CALL:
-		// Route requests, responses, and worker-to-worker calls as direct messages
+	case proto.MessageType_REQUEST, proto.MessageType_RESPONSE, proto.MessageType_ERROR, proto.MessageType_WORKER_CALL, proto.MessageType_CONTROL, proto.MessageType_ACK:
+		// Route requests, responses, worker-to-worker calls, acks and
+		// hub-forwarded control messages (e.g. drain) as direct messages
 		r.routeDirect(msg)
 	}
 }
 
 func (r *Router) routeDirect(msg *proto.Message) {
-	if stream, exists := r.connMgr.Get(msg.To); exists {
-		stream.Send(msg)
+	if err := r.connMgr.Send(msg.To, msg); err != nil {
+		r.RecordDeadLetter()
+		logger.Emoji("❌").WithFields(logger.Fields{"msg_id": msg.Id, "to": msg.To}).WithError(err).Warn("failed to deliver message")
 	}
 }
 
 func (r *Router) routeBroadcast(msg *proto.Message) {
-	r.connMgr.Broadcast(msg)
+	for clientID, err := range r.connMgr.Broadcast(msg) {
+		r.RecordDeadLetter()
+		logger.Emoji("❌").WithFields(logger.Fields{"msg_id": msg.Id, "to": clientID}).WithError(err).Warn("failed to broadcast message")
+	}
+}
+
+// RecordDeadLetter counts a message that could not be delivered
+func (r *Router) RecordDeadLetter() {
+	atomic.AddInt64(&r.deadLetters, 1)
+}
+
+// DeadLetterCount returns the number of undeliverable messages so far
+func (r *Router) DeadLetterCount() int64 {
+	return atomic.LoadInt64(&r.deadLetters)
 }
 
 func (r *Router) routeChannel(msg *proto.Message) {
-	r.subMgr.Publish(msg.Channel, msg)
-}
\ No newline at end of file
+	for clientID, err := range r.channels.Publish(msg.Channel, msg) {
+		r.RecordDeadLetter()
+		logger.Emoji("❌").WithFields(logger.Fields{"msg_id": msg.Id, "channel": msg.Channel, "to": clientID}).WithError(err).Warn("failed to publish message")
+	}
+}
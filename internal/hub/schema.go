@@ -0,0 +1,127 @@
+package hub
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// ErrSchemaDrift: ở strict mode, worker đăng ký lại với input/output schema
+// khác schema đã lưu của cùng capability
+var ErrSchemaDrift = errors.New("capability schema changed")
+
+// SetStrictSchemas bật strict mode: registration đổi schema của capability
+// đã có bị từ chối (ErrSchemaDrift) thay vì chỉ log warning
+func (sr *ServiceRegistry) SetStrictSchemas(strict bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.strictSchemas = strict
+}
+
+// previousSchemas trả về capabilities đã đăng ký trước đó của worker: trong
+// registry nếu worker còn đăng ký, nếu không thì từ database (vd: worker
+// reconnect sau khi deploy)
+func (sr *ServiceRegistry) previousSchemas(workerID string) map[string]ServiceCapability {
+	previous := make(map[string]ServiceCapability)
+	sr.mu.RLock()
+	info, exists := sr.workers[workerID]
+	if exists {
+		for _, cap := range info.Capabilities {
+			previous[cap.Name] = cap
+		}
+	}
+	sr.mu.RUnlock()
+	if exists || sr.db == nil {
+		return previous
+	}
+
+	rows, err := sr.db.Query(`SELECT name, input_schema, output_schema FROM capabilities WHERE worker_id = ?`, workerID)
+	if err != nil {
+		logger.Emoji("❌").WithField("worker_id", workerID).WithError(err).Error("failed to load previous schemas")
+		return previous
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cap ServiceCapability
+		var inputSchema, outputSchema sql.NullString
+		if err := rows.Scan(&cap.Name, &inputSchema, &outputSchema); err != nil {
+			continue
+		}
+		cap.InputSchema = inputSchema.String
+		cap.OutputSchema = outputSchema.String
+		previous[cap.Name] = cap
+	}
+	return previous
+}
+
+// detectSchemaDrift so schema của caps với lần đăng ký trước, đặt
+// SchemaChanged cho các capability bị đổi và trả về mô tả thay đổi (vd:
+// "ocr.input_schema"). Capability mới hoặc trước đó không có schema không
+// tính là drift.
+func detectSchemaDrift(previous map[string]ServiceCapability, caps []ServiceCapability) []string {
+	var drift []string
+	for i := range caps {
+		caps[i].SchemaChanged = false
+		old, ok := previous[caps[i].Name]
+		if !ok {
+			continue
+		}
+		if schemaChanged(old.InputSchema, caps[i].InputSchema) {
+			drift = append(drift, caps[i].Name+".input_schema")
+			caps[i].SchemaChanged = true
+		}
+		if schemaChanged(old.OutputSchema, caps[i].OutputSchema) {
+			drift = append(drift, caps[i].Name+".output_schema")
+			caps[i].SchemaChanged = true
+		}
+	}
+	sort.Strings(drift)
+	return drift
+}
+
+// schemaChanged so hai JSON schema bỏ qua khoảng trắng và thứ tự key
+func schemaChanged(old, new string) bool {
+	if strings.TrimSpace(old) == "" {
+		return false
+	}
+	return canonicalSchema(old) != canonicalSchema(new)
+}
+
+// canonicalSchema là dạng JSON chuẩn hóa của schema (chuỗi gốc nếu không
+// phải JSON hợp lệ)
+func canonicalSchema(schema string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(schema), &value); err != nil {
+		return strings.TrimSpace(schema)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// checkSchemaDrift log warning khi worker đăng ký lại với schema khác và trả
+// ErrSchemaDrift ở strict mode
+func (sr *ServiceRegistry) checkSchemaDrift(workerID string, caps []ServiceCapability) error {
+	drift := detectSchemaDrift(sr.previousSchemas(workerID), caps)
+	if len(drift) == 0 {
+		return nil
+	}
+
+	sr.mu.RLock()
+	strict := sr.strictSchemas
+	sr.mu.RUnlock()
+
+	logger.Emoji("⚠️").WithFields(logger.Fields{
+		"worker_id": workerID,
+		"changed":   drift,
+		"strict":    strict,
+	}).Warn("capability schema drift on re-registration")
+	if strict {
+		return fmt.Errorf("%w: %s", ErrSchemaDrift, strings.Join(drift, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package hub
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Selection strategies accepted by NewSelector
+const (
+	StrategyRoundRobin       = "round_robin"
+	StrategyLeastConnections = "least_connections"
+	StrategyRandom           = "random"
+	StrategyWeighted         = "weighted"
+	StrategyConsistentHash   = "consistent_hash"
+)
+
+// WeightMetadataKey is the registration metadata field stored as
+// WorkerInfo.Weight and used by WeightedSelector
+const WeightMetadataKey = "weight"
+
+// WorkerSelector chọn một worker trong các candidates online có capability.
+// Select được gọi khi registry đang giữ lock, nên không được gọi lại registry.
+type WorkerSelector interface {
+	Select(candidates []*WorkerInfo) (*WorkerInfo, bool)
+}
+
+// LoadFunc returns the number of requests in flight on a worker
+type LoadFunc func(workerID string) int
+
+// NewSelector creates the selector for a strategy name. load is only
+// used by least_connections.
+func NewSelector(strategy string, load LoadFunc) (WorkerSelector, error) {
+	switch strategy {
+	case "", StrategyRoundRobin:
+		return NewRoundRobinSelector(), nil
+	case StrategyLeastConnections:
+		return NewLeastConnectionsSelector(load), nil
+	case StrategyRandom:
+		return NewRandomSelector(), nil
+	case StrategyWeighted:
+		return NewWeightedSelector(), nil
+	case StrategyConsistentHash:
+		return NewConsistentHashSelector(NewRoundRobinSelector()), nil
+	default:
+		return nil, fmt.Errorf("unknown selection strategy: %s", strategy)
+	}
+}
+
+// RoundRobinSelector rotates through the candidates. Each distinct
+// candidate set has its own position, so capabilities don't skew each other.
+type RoundRobinSelector struct {
+	mu   sync.Mutex
+	next map[string]int // joined candidate IDs -> next index
+}
+
+func NewRoundRobinSelector() *RoundRobinSelector {
+	return &RoundRobinSelector{next: make(map[string]int)}
+}
+
+func (s *RoundRobinSelector) Select(candidates []*WorkerInfo) (*WorkerInfo, bool) {
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	key := candidateKey(candidates)
+
+	s.mu.Lock()
+	i := s.next[key] % len(candidates)
+	s.next[key] = i + 1
+	s.mu.Unlock()
+
+	return candidates[i], true
+}
+
+// LeastConnectionsSelector picks the candidate with the fewest requests in
+// flight; ties go to the earlier candidate
+type LeastConnectionsSelector struct {
+	load LoadFunc
+}
+
+func NewLeastConnectionsSelector(load LoadFunc) *LeastConnectionsSelector {
+	return &LeastConnectionsSelector{load: load}
+}
+
+func (s *LeastConnectionsSelector) Select(candidates []*WorkerInfo) (*WorkerInfo, bool) {
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	if s.load == nil {
+		return candidates[0], true
+	}
+
+	best, bestLoad := candidates[0], s.load(candidates[0].ID)
+	for _, candidate := range candidates[1:] {
+		if load := s.load(candidate.ID); load < bestLoad {
+			best, bestLoad = candidate, load
+		}
+	}
+	return best, true
+}
+
+// RandomSelector picks a candidate uniformly at random
+type RandomSelector struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func NewRandomSelector() *RandomSelector {
+	return &RandomSelector{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *RandomSelector) Select(candidates []*WorkerInfo) (*WorkerInfo, bool) {
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	i := s.rnd.Intn(len(candidates))
+	s.mu.Unlock()
+
+	return candidates[i], true
+}
+
+// WeightedSelector picks a candidate at random in proportion to its
+// Weight, parsed from the "weight" registration metadata (default 1; 0
+// only receives traffic when every candidate has weight 0)
+type WeightedSelector struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func NewWeightedSelector() *WeightedSelector {
+	return &WeightedSelector{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *WeightedSelector) Select(candidates []*WorkerInfo) (*WorkerInfo, bool) {
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	total := 0.0
+	weights := make([]float64, len(candidates))
+	for i, candidate := range candidates {
+		weights[i] = candidate.Weight
+		total += weights[i]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if total <= 0 {
+		return candidates[s.rnd.Intn(len(candidates))], true
+	}
+
+	r := s.rnd.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return candidates[i], true
+		}
+		r -= weight
+	}
+	return candidates[len(candidates)-1], true
+}
+
+// workerWeight reads the weight from registration metadata (number or
+// numeric string); missing or unparseable weights are 1, negative ones 0
+func workerWeight(metadata map[string]interface{}) float64 {
+	var weight float64 = 1
+	switch v := metadata[WeightMetadataKey].(type) {
+	case float64:
+		weight = v
+	case int:
+		weight = float64(v)
+	case string:
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+			weight = parsed
+		}
+	}
+	if weight < 0 {
+		return 0
+	}
+	return weight
+}
+
+func candidateKey(candidates []*WorkerInfo) string {
+	ids := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		ids[i] = candidate.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+// SessionKeyMetadata is the request metadata key used for sticky routing
+const SessionKeyMetadata = "session_key"
+
+// KeyedSelector is implemented by selectors that route by a request key,
+// so the same key keeps landing on the same worker
+type KeyedSelector interface {
+	WorkerSelector
+	SelectKey(key string, candidates []*WorkerInfo) (*WorkerInfo, bool)
+}
+
+// DefaultHashReplicas is the number of ring points per worker
+const DefaultHashReplicas = 100
+
+// maxCachedRings bounds the ring cache (one ring per candidate set)
+const maxCachedRings = 64
+
+// ConsistentHashSelector maps session keys onto a hash ring of the
+// candidates. When a worker leaves, only the keys it owned move; requests
+// without a key use the fallback selector.
+type ConsistentHashSelector struct {
+	fallback WorkerSelector
+	replicas int
+
+	mu    sync.Mutex
+	rings map[string]*hashRing // joined candidate IDs -> ring
+}
+
+type hashRing struct {
+	points []uint32
+	owners map[uint32]int // point -> candidate index
+}
+
+func NewConsistentHashSelector(fallback WorkerSelector) *ConsistentHashSelector {
+	return NewConsistentHashSelectorWithReplicas(fallback, DefaultHashReplicas)
+}
+
+func NewConsistentHashSelectorWithReplicas(fallback WorkerSelector, replicas int) *ConsistentHashSelector {
+	if fallback == nil {
+		fallback = NewRoundRobinSelector()
+	}
+	if replicas <= 0 {
+		replicas = DefaultHashReplicas
+	}
+	return &ConsistentHashSelector{
+		fallback: fallback,
+		replicas: replicas,
+		rings:    make(map[string]*hashRing),
+	}
+}
+
+func (s *ConsistentHashSelector) Select(candidates []*WorkerInfo) (*WorkerInfo, bool) {
+	return s.fallback.Select(candidates)
+}
+
+func (s *ConsistentHashSelector) SelectKey(key string, candidates []*WorkerInfo) (*WorkerInfo, bool) {
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	if key == "" {
+		return s.fallback.Select(candidates)
+	}
+
+	ring := s.ring(candidates)
+	h := hashKey(key)
+	i := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= h })
+	if i == len(ring.points) {
+		i = 0
+	}
+	return candidates[ring.owners[ring.points[i]]], true
+}
+
+// ring returns the (cached) ring for a candidate set
+func (s *ConsistentHashSelector) ring(candidates []*WorkerInfo) *hashRing {
+	key := candidateKey(candidates)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ring, ok := s.rings[key]; ok {
+		return ring
+	}
+
+	ring := &hashRing{
+		points: make([]uint32, 0, len(candidates)*s.replicas),
+		owners: make(map[uint32]int, len(candidates)*s.replicas),
+	}
+	// Points depend only on the worker ID, so a worker keeps its ranges
+	// when others join or leave
+	for i, candidate := range candidates {
+		for r := 0; r < s.replicas; r++ {
+			point := hashKey(candidate.ID + "#" + strconv.Itoa(r))
+			if _, taken := ring.owners[point]; taken {
+				continue
+			}
+			ring.owners[point] = i
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(a, b int) bool { return ring.points[a] < ring.points[b] })
+
+	if len(s.rings) >= maxCachedRings {
+		s.rings = make(map[string]*hashRing)
+	}
+	s.rings[key] = ring
+	return ring
+}
+
+// hashKey uses SHA-256 rather than a short checksum: similar worker IDs
+// ("w#1", "w#2") would otherwise cluster on the ring
+func hashKey(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,270 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/internal/proto"
+)
+
+// testWorkers returns candidates with the given IDs and weight 1
+func testWorkers(ids ...string) []*WorkerInfo {
+	workers := make([]*WorkerInfo, len(ids))
+	for i, id := range ids {
+		workers[i] = &WorkerInfo{ID: id, Weight: 1}
+	}
+	return workers
+}
+
+// sessionKeys returns n distinct session keys
+func sessionKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("tenant-%d", i)
+	}
+	return keys
+}
+
+// assignments maps each key to the worker s picks for it
+func assignments(t *testing.T, s KeyedSelector, keys []string, candidates []*WorkerInfo) map[string]string {
+	t.Helper()
+	owners := make(map[string]string, len(keys))
+	for _, key := range keys {
+		worker, ok := s.SelectKey(key, candidates)
+		if !ok {
+			t.Fatalf("no worker for %s", key)
+		}
+		owners[key] = worker.ID
+	}
+	return owners
+}
+
+func TestConsistentHashKeepsKeysOnTheirWorker(t *testing.T) {
+	s := NewConsistentHashSelector(nil)
+	candidates := testWorkers("w1", "w2", "w3", "w4", "w5")
+	keys := sessionKeys(1000)
+
+	first := assignments(t, s, keys, candidates)
+	perWorker := make(map[string]int)
+	for _, owner := range first {
+		perWorker[owner]++
+	}
+	for _, worker := range candidates {
+		if perWorker[worker.ID] == 0 {
+			t.Errorf("%s owns no keys: %v", worker.ID, perWorker)
+		}
+	}
+
+	// Same owners on every call, whatever order the registry lists them in
+	reordered := testWorkers("w5", "w3", "w1", "w4", "w2")
+	for key, owner := range assignments(t, s, keys, reordered) {
+		if owner != first[key] {
+			t.Fatalf("%s moved from %s to %s", key, first[key], owner)
+		}
+	}
+}
+
+func TestConsistentHashMovesOnlyDepartingWorkersKeys(t *testing.T) {
+	s := NewConsistentHashSelector(nil)
+	keys := sessionKeys(1000)
+	before := assignments(t, s, keys, testWorkers("w1", "w2", "w3", "w4", "w5"))
+	after := assignments(t, s, keys, testWorkers("w1", "w2", "w4", "w5"))
+
+	moved := 0
+	for _, key := range keys {
+		switch {
+		case before[key] == "w3":
+			moved++
+		case after[key] != before[key]:
+			t.Errorf("%s moved from %s to %s though its worker stayed", key, before[key], after[key])
+		}
+	}
+	if moved == 0 {
+		t.Fatal("w3 owned no keys")
+	}
+
+	// When w3 comes back it gets exactly its keys back
+	for key, owner := range assignments(t, s, keys, testWorkers("w1", "w2", "w3", "w4", "w5")) {
+		if owner != before[key] {
+			t.Errorf("%s is on %s after w3 rejoined, was on %s", key, owner, before[key])
+		}
+	}
+}
+
+func TestConsistentHashWithoutKeyUsesFallback(t *testing.T) {
+	s := NewConsistentHashSelector(NewRoundRobinSelector())
+	candidates := testWorkers("w1", "w2", "w3")
+	for i := 0; i < 6; i++ {
+		worker, _ := s.SelectKey("", candidates)
+		if want := candidates[i%3].ID; worker.ID != want {
+			t.Fatalf("request %d without a key went to %s, want %s", i, worker.ID, want)
+		}
+	}
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	s := NewRoundRobinSelector()
+	ocr := testWorkers("w1", "w2", "w3")
+	echo := testWorkers("w1", "w2")
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		worker, _ := s.Select(ocr)
+		got = append(got, worker.ID)
+		// Another candidate set has its own position
+		s.Select(echo)
+	}
+	if fmt.Sprint(got) != "[w1 w2 w3 w1]" {
+		t.Fatalf("round robin picked %v", got)
+	}
+}
+
+func TestLeastConnectionsSelector(t *testing.T) {
+	load := map[string]int{"w1": 3, "w2": 1, "w3": 1}
+	s := NewLeastConnectionsSelector(func(workerID string) int { return load[workerID] })
+	candidates := testWorkers("w1", "w2", "w3")
+
+	if worker, _ := s.Select(candidates); worker.ID != "w2" {
+		t.Fatalf("picked %s, want w2 (fewest in flight, first of the tie)", worker.ID)
+	}
+	load["w2"] = 2
+	if worker, _ := s.Select(candidates); worker.ID != "w3" {
+		t.Fatalf("picked %s, want w3 once w2 got busier", worker.ID)
+	}
+	if worker, _ := NewLeastConnectionsSelector(nil).Select(candidates); worker.ID != "w1" {
+		t.Fatalf("without load picked %s, want the first candidate", worker.ID)
+	}
+}
+
+func TestWeightedSelector(t *testing.T) {
+	s := NewWeightedSelector()
+	s.rnd = rand.New(rand.NewSource(1))
+	candidates := testWorkers("heavy", "light", "off")
+	candidates[0].Weight = 3
+	candidates[2].Weight = 0
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		worker, _ := s.Select(candidates)
+		counts[worker.ID]++
+	}
+	if counts["off"] != 0 {
+		t.Errorf("worker with weight 0 picked %d times", counts["off"])
+	}
+	if ratio := float64(counts["heavy"]) / float64(counts["light"]); ratio < 2.7 || ratio > 3.3 {
+		t.Errorf("weights 3:1 picked %v", counts)
+	}
+
+	// All weights 0: every candidate still gets traffic
+	idle := testWorkers("a", "b")
+	idle[0].Weight, idle[1].Weight = 0, 0
+	counts = make(map[string]int)
+	for i := 0; i < 100; i++ {
+		worker, _ := s.Select(idle)
+		counts[worker.ID]++
+	}
+	if counts["a"] == 0 || counts["b"] == 0 {
+		t.Errorf("zero weights picked %v", counts)
+	}
+}
+
+func TestWorkerWeight(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		want  float64
+	}{
+		{nil, 1},
+		{2.5, 2.5},
+		{4, 4},
+		{"0.5", 0.5},
+		{"heavy", 1},
+		{-2.0, 0},
+	} {
+		metadata := map[string]interface{}{}
+		if tc.value != nil {
+			metadata[WeightMetadataKey] = tc.value
+		}
+		if got := workerWeight(metadata); got != tc.want {
+			t.Errorf("weight %v: got %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestSelectorsWithoutCandidates(t *testing.T) {
+	for _, strategy := range []string{StrategyRoundRobin, StrategyLeastConnections, StrategyRandom, StrategyWeighted, StrategyConsistentHash} {
+		s, err := NewSelector(strategy, func(string) int { return 0 })
+		if err != nil {
+			t.Fatalf("%s: %v", strategy, err)
+		}
+		if _, ok := s.Select(nil); ok {
+			t.Errorf("%s selected a worker out of none", strategy)
+		}
+	}
+	if _, err := NewSelector("fastest", nil); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+}
+
+// connectWeightedWorker registers worker id offering capability with weight
+// in its registration metadata (omitted if nil)
+func (h *testHub) connectWeightedWorker(t *testing.T, id, capability string, weight interface{}) *testClient {
+	t.Helper()
+	metadata := map[string]interface{}{}
+	if weight != nil {
+		metadata[WeightMetadataKey] = weight
+	}
+	content, _ := json.Marshal(map[string]interface{}{
+		"worker_id":    id,
+		"worker_type":  "test",
+		"capabilities": []ServiceCapability{{Name: capability}},
+		"metadata":     metadata,
+	})
+	return h.connect(t, &proto.Message{From: id, Type: proto.MessageType_REGISTER, Content: string(content)})
+}
+
+// Weights from registration metadata are stored on the worker and listed in
+// discovery
+func TestRegisteredWeightInDiscovery(t *testing.T) {
+	h := newTestHub(t, nil)
+	h.connectWeightedWorker(t, "gpu", "ocr", 10)
+	h.connectWeightedWorker(t, "cpu", "ocr", nil)
+	h.connectWeightedWorker(t, "slow", "ocr", "0.5")
+	client := h.connectClient(t, "c1", nil)
+
+	want := map[string]float64{"cpu": 1, "gpu": 10, "slow": 0.5}
+	_, result := client.discover()
+	for _, worker := range result.Workers {
+		if worker.Weight != want[worker.ID] {
+			t.Errorf("worker %s has weight %v, want %v", worker.ID, worker.Weight, want[worker.ID])
+		}
+	}
+	if len(result.Providers["ocr"]) != len(want) {
+		t.Fatalf("ocr providers: %+v", result.Providers["ocr"])
+	}
+	for _, provider := range result.Providers["ocr"] {
+		if provider.Weight != want[provider.WorkerID] {
+			t.Errorf("provider %s has weight %v, want %v", provider.WorkerID, provider.Weight, want[provider.WorkerID])
+		}
+	}
+}
+
+// The weighted strategy routes by the registered weight
+func TestWeightedStrategyUsesRegisteredWeight(t *testing.T) {
+	h := newTestHub(t, func(cfg *config.Config) {
+		cfg.SelectionStrategy = StrategyWeighted
+	})
+	on := h.connectWeightedWorker(t, "on", "ocr", 1)
+	off := h.connectWeightedWorker(t, "off", "ocr", 0)
+	client := h.connectClient(t, "c1", nil)
+
+	for i := 0; i < 20; i++ {
+		client.request("ocr", `{}`)
+		on.reply(on.next(), `{}`)
+		client.next()
+	}
+	off.expectNothing(50 * time.Millisecond)
+}
@@ -1,170 +1,393 @@
 package hub
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	// gzip-compressed streams (GRPC_COMPRESSION on clients and workers) are
+	// accepted and answered compressed; other streams stay uncompressed
+	_ "google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
+	"deepapp_golang_grpc_hub/internal/codec"
 	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/internal/envelope"
 	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/pkg/logger"
 )
 
+// Version is the hub version reported by system_health
+const Version = "1.0.0"
+
 type Server struct {
 	proto.UnimplementedHubServiceServer
 	config         *config.Config
 	server         *grpc.Server
 	connMgr        *ConnectionManager
 	router         *Router
-	subMgr         *SubscriberManager
+	channels       *ChannelManager // subscribers and history of each channel
 	dispatcher     *Dispatcher
 	handler        *Handler
 	registry       *ServiceRegistry // Service registry with DB persistence
 	requestTracker *RequestTracker  // Track request_id to requester mapping
+	rateLimiter    *RateLimiter     // Per-client, per-capability request limits
+	latency        *LatencyStats    // Response times per capability
+	files          *FileStorage     // Uploaded files and their filename and MIME type
+	cache          *ResultCache     // Responses of cacheable capabilities
+	authenticator  Authenticator    // nil disables stream authentication
+	grants         namespaceGrants  // Cross-namespace calls allowed by config
+	middlewares    []MessageMiddleware
+	pipeline       MessageHandler // middlewares wrapped around routeMessage
+	startedAt      time.Time
+
+	interrupted interruptedRequests // requests lost in the last restart, by requester
+
+	shuttingDown atomic.Bool    // set by Shutdown; new requests are refused
+	streams      sync.WaitGroup // Connect calls still running
 }
 
 func NewServer(cfg *config.Config) *Server {
-	fmt.Println("Creating ConnectionManager...")
-	connMgr := NewConnectionManager()
-	fmt.Println("Creating SubscriberManager...")
-	subMgr := NewSubscriberManager()
-	fmt.Println("Creating ServiceRegistry...")
+	logger.Debug("Creating ConnectionManager...")
+	connMgr := NewConnectionManagerWithPolicy(SendPolicy(cfg.SendPolicy), cfg.SendBufferSize, cfg.SendTimeout)
+	connMgr.SetCollisionPolicy(CollisionPolicy(cfg.IDCollisionPolicy))
+	logger.Debug("Creating ChannelManager...")
+	channels := NewChannelManager(NewSubscriberManager(connMgr), cfg.ChannelHistorySize)
+	logger.Debug("Creating ServiceRegistry...")
 	registry := NewServiceRegistry()
-	fmt.Println("Creating RequestTracker...")
-	requestTracker := NewRequestTracker()
-	fmt.Println("Creating Router...")
-	router := NewRouter(connMgr, subMgr)
-	fmt.Println("Creating Dispatcher...")
-	dispatcher := NewDispatcher(router)
-	fmt.Println("Creating Handler...")
+	logger.Debug("Creating RequestTracker...")
+	requestTracker := NewRequestTrackerWithTimeout(cfg.RequestTimeout)
+	logger.Debug("Creating Router...")
+	router := NewRouter(connMgr, channels)
+	logger.Debug("Creating Dispatcher...")
+	dispatcher := NewDispatcherWithPolicy(router, cfg.DispatchWorkers, cfg.DispatchQueueSize, OverflowPolicy(cfg.DispatchOverflowPolicy), cfg.DispatchBlockTimeout)
+	logger.Debug("Creating Handler...")
 	handler := NewHandler(nil) // TODO: add repo
 
-	fmt.Println("Creating gRPC server...")
+	logger.Debug("Creating gRPC server...")
 	s := &Server{
 		config:         cfg,
-		server:         grpc.NewServer(),
+		server:         grpc.NewServer(serverOptions(cfg)...),
 		connMgr:        connMgr,
 		router:         router,
-		subMgr:         subMgr,
+		channels:       channels,
 		dispatcher:     dispatcher,
 		handler:        handler,
 		registry:       registry,
 		requestTracker: requestTracker,
+		rateLimiter:    NewRateLimiter(cfg.RateLimit, cfg.RateLimitBurst),
+		latency:        NewLatencyStats(DefaultLatencyWindow),
+		files:          newFileStorage(cfg),
+		cache:          NewResultCache(cfg.ResultCacheSize),
+		grants:         parseNamespaceGrants(cfg.NamespaceGrants),
+		startedAt:      time.Now(),
+	}
+
+	s.Use(correlationMiddleware, requestLogMiddleware)
+	registry.SetLoadFunc(requestTracker.PendingCount)
+	registry.SetOnChange(s.notifyCapabilitiesChanged)
+	registry.SetStrictSchemas(cfg.StrictSchemas)
+	if cfg.BreakerThreshold > 0 {
+		registry.SetCircuitBreaker(NewCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown))
 	}
+	requestTracker.SetExpireFunc(s.handleRequestExpired)
 
-	fmt.Println("Registering HubService...")
+	logger.Debug("Registering HubService...")
 	proto.RegisterHubServiceServer(s.server, s)
-	fmt.Println("Registering reflection...")
+	logger.Debug("Registering reflection...")
 	reflection.Register(s.server)
 
-	fmt.Println("Server fully initialized")
+	if err := s.SetSelectionStrategy(cfg.SelectionStrategy); err != nil {
+		logger.Emoji("⚠️").WithError(err).Warn("invalid selection strategy, using round_robin")
+	}
+
+	logger.Debug("Server fully initialized")
 	return s
 }
 
 func NewServerWithRegistry(cfg *config.Config, registry *ServiceRegistry) *Server {
-	fmt.Println("Creating ConnectionManager...")
-	connMgr := NewConnectionManager()
-	fmt.Println("Creating SubscriberManager...")
-	subMgr := NewSubscriberManager()
-	fmt.Println("Creating RequestTracker...")
-	requestTracker := NewRequestTracker()
-	fmt.Println("Creating Router...")
-	router := NewRouter(connMgr, subMgr)
-	fmt.Println("Creating Dispatcher...")
-	dispatcher := NewDispatcher(router)
-	fmt.Println("Creating Handler...")
+	logger.Debug("Creating ConnectionManager...")
+	connMgr := NewConnectionManagerWithPolicy(SendPolicy(cfg.SendPolicy), cfg.SendBufferSize, cfg.SendTimeout)
+	connMgr.SetCollisionPolicy(CollisionPolicy(cfg.IDCollisionPolicy))
+	logger.Debug("Creating ChannelManager...")
+	channels := NewChannelManager(NewSubscriberManager(connMgr), cfg.ChannelHistorySize)
+	logger.Debug("Creating RequestTracker...")
+	requestTracker := NewRequestTrackerWithTimeout(cfg.RequestTimeout)
+	logger.Debug("Creating Router...")
+	router := NewRouter(connMgr, channels)
+	logger.Debug("Creating Dispatcher...")
+	dispatcher := NewDispatcherWithPolicy(router, cfg.DispatchWorkers, cfg.DispatchQueueSize, OverflowPolicy(cfg.DispatchOverflowPolicy), cfg.DispatchBlockTimeout)
+	logger.Debug("Creating Handler...")
 	handler := NewHandler(nil)
 
-	fmt.Println("Creating gRPC server...")
+	logger.Debug("Creating gRPC server...")
 	s := &Server{
 		config:         cfg,
-		server:         grpc.NewServer(),
+		server:         grpc.NewServer(serverOptions(cfg)...),
 		connMgr:        connMgr,
 		router:         router,
-		subMgr:         subMgr,
+		channels:       channels,
 		dispatcher:     dispatcher,
 		handler:        handler,
 		registry:       registry,
 		requestTracker: requestTracker,
+		rateLimiter:    NewRateLimiter(cfg.RateLimit, cfg.RateLimitBurst),
+		latency:        NewLatencyStats(DefaultLatencyWindow),
+		files:          newFileStorage(cfg),
+		cache:          NewResultCache(cfg.ResultCacheSize),
+		grants:         parseNamespaceGrants(cfg.NamespaceGrants),
+		startedAt:      time.Now(),
+	}
+
+	s.Use(correlationMiddleware, requestLogMiddleware)
+	registry.SetLoadFunc(requestTracker.PendingCount)
+	registry.SetOnChange(s.notifyCapabilitiesChanged)
+	registry.SetStrictSchemas(cfg.StrictSchemas)
+	if cfg.BreakerThreshold > 0 {
+		registry.SetCircuitBreaker(NewCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown))
 	}
+	requestTracker.SetExpireFunc(s.handleRequestExpired)
 
-	fmt.Println("Registering HubService...")
+	logger.Debug("Registering HubService...")
 	proto.RegisterHubServiceServer(s.server, s)
-	fmt.Println("Registering reflection...")
+	logger.Debug("Registering reflection...")
 	reflection.Register(s.server)
 
-	fmt.Println("Server fully initialized with custom registry")
+	logger.Debug("Server fully initialized with custom registry")
 	return s
 }
 
+// serverOptions applies the configured message size limits. Payloads
+// above them must go through UploadFile (see codec.Offload).
+func serverOptions(cfg *config.Config) []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if cfg.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(cfg.MaxSendMsgSize))
+	}
+	return opts
+}
+
+// SetAuthenticator requires every stream to authenticate with its first
+// message. Must be called before Start.
+func (s *Server) SetAuthenticator(a Authenticator) {
+	s.authenticator = a
+}
+
+// SetSelectionStrategy chooses how the registry picks among workers that
+// offer the same capability (see NewSelector)
+func (s *Server) SetSelectionStrategy(strategy string) error {
+	selector, err := NewSelector(strategy, s.requestTracker.PendingCount)
+	if err != nil {
+		return err
+	}
+	s.registry.SetSelector(selector)
+	return nil
+}
+
 func (s *Server) Start() error {
 	lis, err := net.Listen("tcp", ":"+s.config.Port)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("✓ Server is now listening on port %s\n", s.config.Port)
-	fmt.Println("Server is ready to accept connections...")
+	logger.Emoji("✓").WithField("port", s.config.Port).Info("server listening")
 	return s.server.Serve(lis)
 }
 
+// Stop shuts the hub down gracefully, waiting at most the configured
+// shutdown timeout for in-flight requests (see Shutdown)
 func (s *Server) Stop() {
-	s.server.GracefulStop()
+	timeout := s.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s.Shutdown(ctx)
 }
 
 func (s *Server) Connect(stream proto.HubService_ConnectServer) error {
+	s.streams.Add(1)
+	defer s.streams.Done()
+
 	// Wait for first message to get client ID
 	firstMsg, err := stream.Recv()
 	if err != nil {
 		return err
 	}
 
-	clientID := firstMsg.From
-	if clientID == "" {
-		clientID = "client-" + fmt.Sprintf("%d", time.Now().UnixNano())
+	clientID, err := s.authenticate(firstMsg)
+	var namespace string
+	if err == nil {
+		namespace, err = s.resolveNamespace(clientID, firstMsg)
+	}
+	if err != nil {
+		logger.Emoji("⛔").WithFields(logger.Fields{"client_id": firstMsg.From}).WithError(err).Warn("stream rejected")
+		apiErr := apierr.New(apierr.CodeUnauthenticated, err.Error())
+		reply := &proto.Message{
+			Id:        firstMsg.Id,
+			From:      "hub",
+			To:        firstMsg.From,
+			Type:      proto.MessageType_RESPONSE,
+			Timestamp: time.Now().Format(time.RFC3339),
+		}
+		envelope.SetError(reply, apiErr)
+		stream.Send(reply)
+		return status.Error(codes.Unauthenticated, err.Error())
 	}
+	firstMsg.From = clientID
 
-	fmt.Printf("✓ Client connected: %s\n", clientID)
-	s.connMgr.Add(clientID, stream)
+	sendErrs, err := s.connMgr.Add(clientID, stream)
+	if err != nil {
+		apiErr := apierr.Newf(apierr.CodeConflict, "client id %s is already connected", clientID)
+		reply := &proto.Message{
+			Id:        firstMsg.Id,
+			From:      "hub",
+			To:        clientID,
+			Type:      proto.MessageType_RESPONSE,
+			Timestamp: time.Now().Format(time.RFC3339),
+		}
+		envelope.SetError(reply, apiErr)
+		stream.Send(reply)
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	s.connMgr.SetNamespace(clientID, namespace)
+	logger.Emoji("✓").WithFields(logger.Fields{"client_id": clientID, "namespace": namespace}).Info("client connected")
+	s.notifyInterrupted(clientID)
 	defer func() {
-		s.connMgr.Remove(clientID)
-		s.registry.UnregisterWorker(clientID)
-		fmt.Printf("✗ Client disconnected: %s\n", clientID)
+		// A stream that was taken over must not remove its replacement
+		if s.connMgr.RemoveStream(clientID, stream) {
+			s.registry.UnregisterWorker(clientID)
+			s.channels.UnsubscribeAll(clientID)
+		}
+		logger.Emoji("✗").WithField("client_id", clientID).Info("client disconnected")
 	}()
 
 	// Process first message (could be registration)
+	s.connMgr.Touch(clientID, firstMsg)
 	s.handleMessage(firstMsg)
 
 	// Continue receiving messages
-	for {
-		msg, err := stream.Recv()
-		if err != nil {
-			return err
+	recvErrs := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErrs <- err
+				return
+			}
+
+			// Streams are bound to the identity of their first message,
+			// so ADMIN_CLIENTS and namespaces cannot be bypassed through From
+			if msg.From != "" && msg.From != clientID {
+				logger.Emoji("⛔").WithFields(logger.Fields{"client_id": clientID, "claimed": msg.From, "msg_id": msg.Id}).
+					Warn("message sent under another identity, dropping")
+				claimed := msg.From
+				msg.From = clientID
+				s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "connection is registered as %s, not %s", clientID, claimed))
+				continue
+			}
+			msg.From = clientID
+
+			s.connMgr.Touch(clientID, msg)
+			s.handleMessage(msg)
 		}
+	}()
 
-		fmt.Printf("→ Message from %s to %s (type: %v)\n", msg.From, msg.To, msg.Type)
-		s.handleMessage(msg)
+	// Evict the client as soon as either direction breaks
+	select {
+	case err := <-recvErrs:
+		return err
+	case err := <-sendErrs:
+		if errors.Is(err, ErrEvicted) {
+			logger.Emoji("✗").WithField("client_id", clientID).Info("stream taken over by a newer connection")
+			return status.Error(codes.Aborted, err.Error())
+		}
+		if errors.Is(err, ErrKicked) {
+			logger.Emoji("✗").WithField("client_id", clientID).Info("stream closed by an administrator")
+			return status.Error(codes.Aborted, err.Error())
+		}
+		logger.Emoji("❌").WithField("client_id", clientID).WithError(err).Error("send failed, closing connection")
+		return err
 	}
 }
 
+// authenticate validates the first message of a stream and returns the
+// client ID to bind to it
+func (s *Server) authenticate(firstMsg *proto.Message) (string, error) {
+	if s.authenticator == nil {
+		if firstMsg.From == "" {
+			return utils.PrefixedID("client"), nil
+		}
+		return firstMsg.From, nil
+	}
+
+	if firstMsg.Type != proto.MessageType_REGISTER && firstMsg.Type != proto.MessageType_AUTH {
+		return "", fmt.Errorf("%w: first message must be REGISTER or AUTH", ErrUnauthenticated)
+	}
+	return s.authenticator.Authenticate(firstMsg)
+}
+
+// handleMessage runs msg through the middleware pipeline
 func (s *Server) handleMessage(msg *proto.Message) {
+	s.pipeline(msg)
+}
+
+// routeMessage dispatches msg by type
+func (s *Server) routeMessage(msg *proto.Message) {
+	// Handle auth handshake (identity is already bound in Connect)
+	if msg.Type == proto.MessageType_AUTH {
+		s.handleAuth(msg)
+		return
+	}
+
 	// Handle registration messages
 	if msg.Type == proto.MessageType_REGISTER {
 		s.handleRegistration(msg)
 		return
 	}
 
+	// Handle channel subscriptions
+	if msg.Type == proto.MessageType_SUBSCRIBE || msg.Type == proto.MessageType_UNSUBSCRIBE {
+		s.handleSubscription(msg)
+		return
+	}
+
+	// Handle hub control messages; pings are answered first, without logging
+	if msg.Type == proto.MessageType_CONTROL {
+		if msg.Action == ControlActionPing {
+			s.handlePing(msg)
+			return
+		}
+		s.handleControl(msg)
+		return
+	}
+
 	// Handle worker-to-worker calls
 	if msg.Type == proto.MessageType_WORKER_CALL {
+		if s.refuseWhileShuttingDown(msg) {
+			return
+		}
 		s.handleWorkerCall(msg)
 		return
 	}
 
 	// Handle responses (including worker-to-worker responses)
-	if msg.Type == proto.MessageType_RESPONSE {
+	if envelope.IsResponse(msg) {
 		s.handleResponse(msg)
 		return
 	}
@@ -172,7 +395,8 @@ func (s *Server) handleMessage(msg *proto.Message) {
 	// Handle capability discovery requests
 	if msg.Channel == "capability_discovery" || (msg.Type == proto.MessageType_REQUEST && msg.Content != "") {
 		var reqData map[string]interface{}
-		if err := json.Unmarshal([]byte(msg.Content), &reqData); err == nil {
+		content, _ := codec.Content(msg)
+		if err := json.Unmarshal([]byte(content), &reqData); err == nil {
 			if action, ok := reqData["action"].(string); ok {
 				if action == "discover" || action == "list_capabilities" {
 					s.handleCapabilityDiscovery(msg)
@@ -189,10 +413,25 @@ func (s *Server) handleMessage(msg *proto.Message) {
 
 	// Handle regular request routing
 	if msg.Type == proto.MessageType_REQUEST {
+		if s.refuseWhileShuttingDown(msg) {
+			return
+		}
 		s.handleServiceRequest(msg)
 		return
 	}
 
+	// System channels are published by the hub only
+	if msg.Type == proto.MessageType_CHANNEL && strings.HasPrefix(msg.Channel, systemChannelPrefix) {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "channel %s is reserved for the hub", msg.Channel))
+		return
+	}
+	// A worker's log channel is published by that worker only
+	if msg.Type == proto.MessageType_CHANNEL && strings.HasPrefix(msg.Channel, LogsChannelPrefix) &&
+		msg.Channel != LogsChannelPrefix+msg.From {
+		s.replyError(msg, apierr.Newf(apierr.CodeForbidden, "channel %s is reserved for worker %s", msg.Channel, strings.TrimPrefix(msg.Channel, LogsChannelPrefix)))
+		return
+	}
+
 	// Default: dispatch to router
 	s.dispatcher.Dispatch(msg)
 }
\ No newline at end of file
@@ -0,0 +1,30 @@
+package hub
+
+import (
+	"testing"
+
+	"deepapp_golang_grpc_hub/internal/config"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+func TestStreamBoundToFirstIdentity(t *testing.T) {
+	for _, auth := range []bool{false, true} {
+		h := newTestHub(t, func(cfg *config.Config) {
+			cfg.AuthRequired = auth
+			if auth {
+				cfg.AdminClients = []string{"admin"}
+			}
+		})
+		client := h.connectClient(t, "c1", nil)
+
+		client.send(&proto.Message{From: "admin", To: "hub", Type: proto.MessageType_CONTROL, Action: "list_connections"})
+		reply := client.next()
+		if code := errorCode(reply); code != apierr.CodeForbidden {
+			t.Fatalf("auth %v: message claiming another identity got %q, want %s", auth, code, apierr.CodeForbidden)
+		}
+		if reply.To != "c1" {
+			t.Fatalf("auth %v: rejection sent to %q, want c1", auth, reply.To)
+		}
+	}
+}
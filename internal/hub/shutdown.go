@@ -0,0 +1,96 @@
+package hub
+
+import (
+	"context"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/pkg/logger"
+)
+
+// DefaultShutdownTimeout bounds Stop when the config sets no timeout
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Shutdown stops the hub gracefully. It stops accepting connections and
+// refuses new requests with UNAVAILABLE, waits until every in-flight
+// request has its response delivered, then closes the remaining streams
+// and stops the background goroutines. If ctx ends first, the streams are
+// closed with requests still pending and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.shuttingDown.CompareAndSwap(false, true) {
+		return nil
+	}
+	started := time.Now()
+	logger.Emoji("🛑").WithFields(logger.Fields{
+		"connections": s.connMgr.Count(),
+		"in_flight":   s.requestTracker.ActiveCount(),
+	}).Info("shutting down, draining in-flight requests")
+
+	// GracefulStop closes the listener right away, but only returns once
+	// every stream has ended, which Connect streams do not do on their own
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	err := s.drain(ctx)
+	abandoned := s.requestTracker.ActiveCount()
+	if err != nil {
+		logger.Emoji("⚠️").WithFields(logger.Fields{"abandoned": abandoned}).Warn("shutdown timeout reached, closing streams with requests in flight")
+	}
+
+	// Ends the remaining streams; GracefulStop returns with them
+	s.server.Stop()
+	<-stopped
+	s.streams.Wait()
+
+	s.requestTracker.Stop()
+	s.rateLimiter.Stop()
+	s.dispatcher.Stop()
+
+	logger.Emoji("✅").WithFields(logger.Fields{
+		"duration":  time.Since(started).Round(time.Millisecond).String(),
+		"abandoned": abandoned,
+		"uptime":    time.Since(s.startedAt).Round(time.Second).String(),
+	}).Info("hub stopped")
+	return err
+}
+
+// drain waits until no request is in flight and every queued message has
+// been sent, logging progress every second
+func (s *Server) drain(ctx context.Context) error {
+	poll := time.NewTicker(50 * time.Millisecond)
+	defer poll.Stop()
+	lastLog := time.Now()
+
+	for {
+		inFlight := s.requestTracker.ActiveCount()
+		queued := s.dispatcher.Pending() + s.connMgr.Pending()
+		if inFlight == 0 && queued == 0 {
+			logger.Emoji("✅").Info("all in-flight requests completed")
+			return nil
+		}
+		if time.Since(lastLog) >= time.Second {
+			logger.Emoji("⏳").WithFields(logger.Fields{"in_flight": inFlight, "queued": queued}).Info("waiting for in-flight requests")
+			lastLog = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-poll.C:
+		}
+	}
+}
+
+// refuseWhileShuttingDown answers msg with UNAVAILABLE once Shutdown has
+// started, so callers retry on another hub instead of waiting
+func (s *Server) refuseWhileShuttingDown(msg *proto.Message) bool {
+	if !s.shuttingDown.Load() {
+		return false
+	}
+	s.replyError(msg, apierr.New(apierr.CodeUnavailable, "hub is shutting down"))
+	return true
+}
@@ -0,0 +1,142 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/config"
+)
+
+// ErrFileNotFound is returned (wrapped) by StorageBackend.Get for unknown
+// file IDs
+var ErrFileNotFound = errors.New("file not found")
+
+// StorageBackend stores the files sent with UploadFile. IDs are checked
+// with validFileID before they reach the backend.
+type StorageBackend interface {
+	// Put stores everything read from r under id, replacing any file with
+	// that id. When reading r fails nothing is stored and the error is
+	// returned.
+	Put(id string, r io.Reader) error
+	// Get opens the file stored under id. The reader may also be an
+	// io.Seeker, which downloads use to start at an offset.
+	Get(id string) (io.ReadCloser, FileInfo, error)
+	// Delete removes the file; deleting an unknown id is not an error
+	Delete(id string) error
+}
+
+// StorageBackendFactory creates a backend from the hub configuration
+type StorageBackendFactory func(cfg *config.Config) (StorageBackend, error)
+
+var (
+	storageMu       sync.RWMutex
+	storageBackends = map[string]StorageBackendFactory{
+		"local": func(cfg *config.Config) (StorageBackend, error) {
+			return NewLocalBackend(cfg.FileStorePath), nil
+		},
+	}
+)
+
+// RegisterStorageBackend makes a backend selectable with STORAGE_BACKEND.
+// Only "local" is built in; others must be registered before the hub
+// calls NewStorageBackend at startup.
+func RegisterStorageBackend(name string, factory StorageBackendFactory) {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	storageBackends[name] = factory
+}
+
+// NewStorageBackend creates the backend named by cfg.StorageBackend
+func NewStorageBackend(cfg *config.Config) (StorageBackend, error) {
+	storageMu.RLock()
+	factory, ok := storageBackends[cfg.StorageBackend]
+	names := make([]string, 0, len(storageBackends))
+	for name := range storageBackends {
+		names = append(names, name)
+	}
+	storageMu.RUnlock()
+
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown storage backend %q (registered: %s)", cfg.StorageBackend, strings.Join(names, ", "))
+	}
+	return factory(cfg)
+}
+
+// LocalBackend stores files in a directory of the local filesystem
+type LocalBackend struct {
+	dir string
+}
+
+// NewLocalBackend creates a backend storing files in dir, which is created
+// if needed
+func NewLocalBackend(dir string) *LocalBackend {
+	os.MkdirAll(dir, 0755)
+	return &LocalBackend{dir: dir}
+}
+
+// Put writes to a temporary file renamed into place once r is drained, so
+// a failed upload never leaves a partial file under id
+func (b *LocalBackend) Put(id string, r io.Reader) error {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(b.dir, id+".*.part")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), b.path(id)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	return nil
+}
+
+// Get opens the file; the returned *os.File is seekable
+func (b *LocalBackend) Get(id string) (io.ReadCloser, FileInfo, error) {
+	file, err := os.Open(b.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, FileInfo{}, fmt.Errorf("%w: %s", ErrFileNotFound, id)
+	}
+	if err != nil {
+		return nil, FileInfo{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		file.Close()
+		return nil, FileInfo{}, fmt.Errorf("%w: %s", ErrFileNotFound, id)
+	}
+	return file, FileInfo{
+		FileID:    id,
+		Size:      stat.Size(),
+		Path:      file.Name(),
+		CreatedAt: stat.ModTime().Format(time.RFC3339),
+	}, nil
+}
+
+func (b *LocalBackend) Delete(id string) error {
+	if err := os.Remove(b.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (b *LocalBackend) path(id string) string {
+	return filepath.Join(b.dir, id)
+}
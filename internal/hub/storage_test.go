@@ -0,0 +1,51 @@
+package hub
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"deepapp_golang_grpc_hub/internal/config"
+)
+
+func TestNewStorageBackendRejectsUnregistered(t *testing.T) {
+	cfg := config.Default()
+	cfg.StorageBackend = "s3"
+	if _, err := NewStorageBackend(cfg); err == nil || !strings.Contains(err.Error(), "unknown storage backend") {
+		t.Fatalf("unregistered backend: got %v, want an unknown storage backend error", err)
+	}
+
+	cfg.StorageBackend = "local"
+	cfg.FileStorePath = t.TempDir()
+	if _, err := NewStorageBackend(cfg); err != nil {
+		t.Fatalf("local backend: %v", err)
+	}
+}
+
+func TestLocalBackendRoundTrip(t *testing.T) {
+	backend := NewLocalBackend(t.TempDir())
+	if err := backend.Put("f1", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	r, info, err := backend.Get("f1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "hello" || info.Size != 5 {
+		t.Fatalf("Get returned %q (size %d), want hello", data, info.Size)
+	}
+
+	if err := backend.Delete("f1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := backend.Get("f1"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("Get after Delete: %v, want ErrFileNotFound", err)
+	}
+	if err := backend.Delete("f1"); err != nil {
+		t.Fatalf("Delete of a missing file: %v", err)
+	}
+}
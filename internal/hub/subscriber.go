@@ -1,44 +1,89 @@
 package hub
 
 import (
+	"sort"
 	"sync"
 
 	"deepapp_golang_grpc_hub/internal/proto"
 )
 
+// SubscriberManager giữ danh sách client ID theo channel. Message được gửi
+// qua ConnectionManager để đi chung hàng đợi (outbox) với các message khác
+// của kết nối, thay vì gọi stream.Send trực tiếp.
 type SubscriberManager struct {
 	mu          sync.RWMutex
-	subscribers map[string][]proto.HubService_ConnectServer
+	connMgr     *ConnectionManager
+	subscribers map[string]map[string]bool // channel -> client IDs
 }
 
-func NewSubscriberManager() *SubscriberManager {
+func NewSubscriberManager(connMgr *ConnectionManager) *SubscriberManager {
 	return &SubscriberManager{
-		subscribers: make(map[string][]proto.HubService_ConnectServer),
+		connMgr:     connMgr,
+		subscribers: make(map[string]map[string]bool),
 	}
 }
 
-func (sm *SubscriberManager) Subscribe(channel string, stream proto.HubService_ConnectServer) {
+func (sm *SubscriberManager) Subscribe(channel, clientID string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
-	sm.subscribers[channel] = append(sm.subscribers[channel], stream)
+	if sm.subscribers[channel] == nil {
+		sm.subscribers[channel] = make(map[string]bool)
+	}
+	sm.subscribers[channel][clientID] = true
+}
+
+func (sm *SubscriberManager) Unsubscribe(channel, clientID string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.subscribers[channel], clientID)
+	if len(sm.subscribers[channel]) == 0 {
+		delete(sm.subscribers, channel)
+	}
 }
 
-func (sm *SubscriberManager) Unsubscribe(channel string, stream proto.HubService_ConnectServer) {
+// UnsubscribeAll gỡ client khỏi mọi channel (khi client ngắt kết nối)
+func (sm *SubscriberManager) UnsubscribeAll(clientID string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
-	subs := sm.subscribers[channel]
-	for i, s := range subs {
-		if s == stream {
-			sm.subscribers[channel] = append(subs[:i], subs[i+1:]...)
-			break
+	for channel, clients := range sm.subscribers {
+		delete(clients, clientID)
+		if len(clients) == 0 {
+			delete(sm.subscribers, channel)
+		}
+	}
+}
+
+// Subscribers trả về client IDs đã subscribe channel, sorted
+func (sm *SubscriberManager) Subscribers(channel string) []string {
+	sm.mu.RLock()
+	ids := make([]string, 0, len(sm.subscribers[channel]))
+	for clientID := range sm.subscribers[channel] {
+		ids = append(ids, clientID)
+	}
+	sm.mu.RUnlock()
+
+	sort.Strings(ids)
+	return ids
+}
+
+// Publish gửi msg tới mọi subscriber của channel và trả về lỗi theo client ID
+func (sm *SubscriberManager) Publish(channel string, msg *proto.Message) map[string]error {
+	failed := make(map[string]error)
+	for _, clientID := range sm.Subscribers(channel) {
+		if err := sm.connMgr.Send(clientID, msg); err != nil {
+			failed[clientID] = err
 		}
 	}
+	return failed
 }
 
-func (sm *SubscriberManager) Publish(channel string, msg *proto.Message) {
+// Channels trả về số subscriber của mỗi channel
+func (sm *SubscriberManager) Channels() map[string]int {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
-	for _, stream := range sm.subscribers[channel] {
-		stream.Send(msg)
+	counts := make(map[string]int, len(sm.subscribers))
+	for channel, clients := range sm.subscribers {
+		counts[channel] = len(clients)
 	}
-}
\ No newline at end of file
+	return counts
+}
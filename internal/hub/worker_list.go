@@ -0,0 +1,246 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Các trường sắp xếp của WorkerFilter.SortBy
+const (
+	WorkerSortID       = "id"
+	WorkerSortLastSeen = "last_seen"
+	WorkerSortRequests = "requests_handled"
+)
+
+// ErrInvalidWorkerFilter: WorkerFilter có sort không hỗ trợ hoặc
+// offset/limit âm
+var ErrInvalidWorkerFilter = errors.New("invalid worker filter")
+
+// WorkerFilter chọn, sắp xếp và phân trang workers cho ListWorkers. Trường
+// rỗng không lọc.
+type WorkerFilter struct {
+	Namespace  string            // "" hoặc AllNamespaces = mọi namespace
+	Status     string            // vd: online, draining
+	Type       string            // vd: python, go
+	Capability string            // worker có capability này
+	Labels     map[string]string // label selector (xem ParseLabelSelector)
+
+	// Public ẩn capabilities nội bộ và điền BreakerState như
+	// GetPublicWorkers; khi false trả về chính WorkerInfo của registry
+	Public bool
+
+	SortBy string // WorkerSortID (mặc định), WorkerSortLastSeen, WorkerSortRequests
+	Desc   bool   // sắp xếp giảm dần; hòa thì theo ID tăng dần
+
+	Offset int
+	Limit  int // <= 0 = không giới hạn
+}
+
+// WorkerList là một trang kết quả của ListWorkers
+type WorkerList struct {
+	Workers []*WorkerInfo `json:"workers"`
+	Total   int           `json:"total"` // số workers khớp filter, trước khi phân trang
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit,omitempty"`
+}
+
+// validate kiểm tra sort và phân trang
+func (f WorkerFilter) validate() error {
+	switch f.SortBy {
+	case "", WorkerSortID, WorkerSortLastSeen, WorkerSortRequests:
+	default:
+		return fmt.Errorf("%w: unknown sort %q (use %s, %s or %s)", ErrInvalidWorkerFilter,
+			f.SortBy, WorkerSortID, WorkerSortLastSeen, WorkerSortRequests)
+	}
+	if f.Offset < 0 || f.Limit < 0 {
+		return fmt.Errorf("%w: offset and limit must not be negative", ErrInvalidWorkerFilter)
+	}
+	return nil
+}
+
+// matches kiểm tra worker khớp các điều kiện lọc (trừ namespace)
+func (f WorkerFilter) matches(info *WorkerInfo) bool {
+	if f.Status != "" && !strings.EqualFold(info.Status, f.Status) {
+		return false
+	}
+	if f.Type != "" && !strings.EqualFold(info.Type, f.Type) {
+		return false
+	}
+	if f.Capability != "" && !info.hasCapability(f.Capability) {
+		return false
+	}
+	return info.MatchesLabels(f.Labels)
+}
+
+func (w *WorkerInfo) hasCapability(name string) bool {
+	for _, cap := range w.Capabilities {
+		if cap.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ListWorkers trả về workers khớp filter, đã sắp xếp và phân trang, kèm
+// tổng số workers khớp
+func (sr *ServiceRegistry) ListWorkers(filter WorkerFilter) (WorkerList, error) {
+	if err := filter.validate(); err != nil {
+		return WorkerList{}, err
+	}
+	namespace := filter.Namespace
+	if namespace == "" {
+		namespace = AllNamespaces
+	}
+
+	sr.mu.RLock()
+	workers := make([]*WorkerInfo, 0, len(sr.workers))
+	for _, info := range sr.workers {
+		if !inNamespace(info, namespace) || !filter.matches(info) {
+			continue
+		}
+		if filter.Public {
+			info = sr.publicWorker(info)
+		}
+		workers = append(workers, info)
+	}
+	sr.mu.RUnlock()
+
+	sortWorkers(workers, filter.SortBy, filter.Desc)
+	return WorkerList{
+		Workers: paginate(workers, filter.Offset, filter.Limit),
+		Total:   len(workers),
+		Offset:  filter.Offset,
+		Limit:   filter.Limit,
+	}, nil
+}
+
+// publicWorker là bản sao của info với capabilities nội bộ đã ẩn (theo
+// tên) và trạng thái circuit breaker (caller giữ lock)
+func (sr *ServiceRegistry) publicWorker(info *WorkerInfo) *WorkerInfo {
+	public := *info
+	if sr.breaker != nil {
+		public.BreakerState = sr.breaker.State(info.ID)
+	}
+	public.Capabilities = make([]ServiceCapability, 0, len(info.Capabilities))
+	for _, cap := range info.Capabilities {
+		if !IsInternalCapability(cap.Name) {
+			public.Capabilities = append(public.Capabilities, cap)
+		}
+	}
+	sort.Slice(public.Capabilities, func(i, j int) bool {
+		return public.Capabilities[i].Name < public.Capabilities[j].Name
+	})
+	return &public
+}
+
+// sortWorkers sắp xếp workers theo trường by (mặc định ID); hòa thì theo ID
+func sortWorkers(workers []*WorkerInfo, by string, desc bool) {
+	compare := func(a, b *WorkerInfo) int {
+		switch by {
+		case WorkerSortLastSeen:
+			return compareTimes(a.LastSeen, b.LastSeen)
+		case WorkerSortRequests:
+			return compareInts(a.RequestsHandled, b.RequestsHandled)
+		}
+		return 0
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		if c := compare(workers[i], workers[j]); c != 0 {
+			return (c < 0) != desc
+		}
+		if desc && (by == "" || by == WorkerSortID) {
+			return workers[i].ID > workers[j].ID
+		}
+		return workers[i].ID < workers[j].ID
+	})
+}
+
+// compareTimes so sánh hai thời điểm RFC3339; chuỗi không đọc được xếp trước
+func compareTimes(a, b string) int {
+	ta, errA := time.Parse(time.RFC3339Nano, a)
+	tb, errB := time.Parse(time.RFC3339Nano, b)
+	switch {
+	case errA != nil && errB != nil:
+		return 0
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	case ta.Before(tb):
+		return -1
+	case tb.Before(ta):
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// paginate trả về workers[offset:offset+limit] (limit <= 0 = tới hết)
+func paginate(workers []*WorkerInfo, offset, limit int) []*WorkerInfo {
+	if offset >= len(workers) {
+		return []*WorkerInfo{}
+	}
+	workers = workers[offset:]
+	if limit > 0 && limit < len(workers) {
+		workers = workers[:limit]
+	}
+	return workers
+}
+
+// workerQuery là các tham số lọc workers trong content của discovery và
+// list_workers, vd: {"status": "online", "labels": "region=eu", "sort":
+// "last_seen", "order": "desc", "limit": 50, "offset": 100}
+type workerQuery struct {
+	Status     string `json:"status"`
+	Type       string `json:"type"`
+	Capability string `json:"capability"`
+	Labels     string `json:"labels"`
+	Sort       string `json:"sort"`
+	Order      string `json:"order"` // asc (mặc định) hoặc desc
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+}
+
+// filter chuyển query thành WorkerFilter trong namespace (Public)
+func (q workerQuery) filter(namespace string) (WorkerFilter, error) {
+	selector, err := ParseLabelSelector(q.Labels)
+	if err != nil {
+		return WorkerFilter{}, err
+	}
+	filter := WorkerFilter{
+		Namespace:  namespace,
+		Status:     q.Status,
+		Type:       q.Type,
+		Capability: q.Capability,
+		Labels:     selector,
+		Public:     true,
+		SortBy:     q.Sort,
+		Offset:     q.Offset,
+		Limit:      q.Limit,
+	}
+	switch strings.ToLower(q.Order) {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return WorkerFilter{}, fmt.Errorf("%w: unknown order %q (use asc or desc)", ErrInvalidWorkerFilter, q.Order)
+	}
+	return filter, filter.validate()
+}
+
+// filtersWorkers kiểm tra query có lọc workers (ngoài phân trang)
+func (q workerQuery) filtersWorkers() bool {
+	return q.Status != "" || q.Type != "" || q.Capability != "" || q.Labels != ""
+}
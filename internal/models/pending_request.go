@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// PendingRequest is a request the hub routed to a worker and has not yet
+// answered, persisted so requesters can be told to retry after a restart
+type PendingRequest struct {
+	RequestID     string    `json:"request_id" db:"request_id"`
+	RequesterID   string    `json:"requester_id" db:"requester_id"`
+	WorkerID      string    `json:"worker_id" db:"worker_id"`
+	Capability    string    `json:"capability" db:"capability"`
+	CorrelationID string    `json:"correlation_id" db:"correlation_id"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
+}
@@ -47,4 +47,41 @@ func (r *MessagesRepo) GetAll() ([]*models.Message, error) {
 		messages = append(messages, &msg)
 	}
 	return messages, nil
-}
\ No newline at end of file
+}
+
+// GetRecentByChannel returns the last limit messages published on channel,
+// oldest first
+func (r *MessagesRepo) GetRecentByChannel(channel string, limit int) ([]*models.Message, error) {
+	rows, err := r.db.Query(`SELECT id, from_client, to_client, channel, content, timestamp FROM messages
+		WHERE channel = ? ORDER BY rowid DESC LIMIT ?`, channel, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.Message
+	for rows.Next() {
+		var msg models.Message
+		var to sql.NullString
+		err := rows.Scan(&msg.ID, &msg.From, &to, &msg.Channel, &msg.Content, &msg.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		msg.To = to.String
+		messages = append(messages, &msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// TrimChannel deletes all but the last keep messages of channel
+func (r *MessagesRepo) TrimChannel(channel string, keep int) error {
+	_, err := r.db.Exec(`DELETE FROM messages WHERE channel = ? AND rowid NOT IN
+		(SELECT rowid FROM messages WHERE channel = ? ORDER BY rowid DESC LIMIT ?)`, channel, channel, keep)
+	return err
+}
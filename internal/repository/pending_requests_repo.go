@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"database/sql"
+
+	"deepapp_golang_grpc_hub/internal/models"
+)
+
+type PendingRequestsRepo struct {
+	db *sql.DB
+}
+
+func NewPendingRequestsRepo(db *sql.DB) *PendingRequestsRepo {
+	return &PendingRequestsRepo{db: db}
+}
+
+func (r *PendingRequestsRepo) Save(req *models.PendingRequest) error {
+	_, err := r.db.Exec(`INSERT OR REPLACE INTO pending_requests
+		(request_id, requester_id, worker_id, capability, correlation_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		req.RequestID, req.RequesterID, req.WorkerID, req.Capability, req.CorrelationID, req.CreatedAt, req.ExpiresAt)
+	return err
+}
+
+func (r *PendingRequestsRepo) Delete(requestID string) error {
+	_, err := r.db.Exec("DELETE FROM pending_requests WHERE request_id = ?", requestID)
+	return err
+}
+
+// DeleteAll empties the table (after the hub has loaded it on startup)
+func (r *PendingRequestsRepo) DeleteAll() error {
+	_, err := r.db.Exec("DELETE FROM pending_requests")
+	return err
+}
+
+func (r *PendingRequestsRepo) GetAll() ([]*models.PendingRequest, error) {
+	rows, err := r.db.Query(`SELECT request_id, requester_id, worker_id, capability, correlation_id, created_at, expires_at
+		FROM pending_requests ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []*models.PendingRequest
+	for rows.Next() {
+		var req models.PendingRequest
+		var correlationID sql.NullString
+		err := rows.Scan(&req.RequestID, &req.RequesterID, &req.WorkerID, &req.Capability, &correlationID,
+			&req.CreatedAt, &req.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		req.CorrelationID = correlationID.String
+		requests = append(requests, &req)
+	}
+	return requests, rows.Err()
+}
@@ -0,0 +1,16 @@
+package utils
+
+import "strings"
+
+// SplitAddresses parses a comma-separated hub address list such as
+// "hub-a:50051, hub-b:50051", dropping blanks. The order is the order in
+// which clients try the hubs.
+func SplitAddresses(list string) []string {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
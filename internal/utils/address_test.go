@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitAddresses(t *testing.T) {
+	for _, tc := range []struct {
+		list string
+		want []string
+	}{
+		{"", nil},
+		{"hub:50051", []string{"hub:50051"}},
+		{"hub-a:50051, hub-b:50051", []string{"hub-a:50051", "hub-b:50051"}},
+		{" hub-a:50051 ,, hub-b:50051 ,", []string{"hub-a:50051", "hub-b:50051"}},
+		{" , ", nil},
+	} {
+		if got := SplitAddresses(tc.list); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SplitAddresses(%q) = %q, want %q", tc.list, got, tc.want)
+		}
+	}
+}
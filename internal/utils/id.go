@@ -1,9 +1,64 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+
 	"github.com/google/uuid"
 )
 
+// idGenerator hands out UUIDv7s (RFC 9562): a 48-bit millisecond timestamp,
+// a 12-bit counter that orders IDs created in the same millisecond, and
+// 62 random bits. The clock never goes backwards within a process, so IDs
+// sort in creation order.
+type idGenerator struct {
+	mu     sync.Mutex
+	lastMs int64
+	seq    uint16
+}
+
+var ids idGenerator
+
+// next returns the timestamp and counter for a new ID
+func (g *idGenerator) next() (int64, uint16) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	switch {
+	case now > g.lastMs:
+		g.lastMs, g.seq = now, 0
+	case g.seq < 0xfff:
+		g.seq++
+	default:
+		// Counter exhausted: borrow the next millisecond
+		g.lastMs, g.seq = g.lastMs+1, 0
+	}
+	return g.lastMs, g.seq
+}
+
+// GenerateID returns a new UUIDv7 string, unique and sortable by creation
+// time
 func GenerateID() string {
-	return uuid.New().String()
-}
\ No newline at end of file
+	ms, seq := ids.next()
+
+	var id uuid.UUID
+	if _, err := rand.Read(id[8:]); err != nil {
+		panic("utils: crypto/rand failed: " + err.Error())
+	}
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(ms))
+	copy(id[:6], ts[2:])
+	id[6] = 0x70 | byte(seq>>8) // version 7
+	id[7] = byte(seq)
+	id[8] = 0x80 | id[8]&0x3f // RFC 4122 variant
+	return id.String()
+}
+
+// PrefixedID returns prefix + "-" + GenerateID(), e.g. "resp-0190...". The
+// prefix only makes logs readable; uniqueness comes from the UUID.
+func PrefixedID(prefix string) string {
+	return prefix + "-" + GenerateID()
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestGenerateIDUnique(t *testing.T) {
+	const goroutines, perGoroutine = 10, 10000
+
+	results := make([][]string, goroutines)
+	var wg sync.WaitGroup
+	for g := range results {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids := make([]string, perGoroutine)
+			for i := range ids {
+				ids[i] = PrefixedID("resp")
+			}
+			results[g] = ids
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, goroutines*perGoroutine)
+	for _, ids := range results {
+		// IDs from one goroutine sort in the order they were created
+		if !sort.StringsAreSorted(ids) {
+			t.Error("IDs created in sequence are not sorted")
+		}
+		for _, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicate ID %s", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestGenerateIDIsUUIDv7(t *testing.T) {
+	before := time.Now().UnixMilli()
+	id, err := uuid.Parse(strings.TrimPrefix(PrefixedID("req"), "req-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Version() != 7 || id.Variant() != uuid.RFC4122 {
+		t.Fatalf("%s is version %d variant %s", id, id.Version(), id.Variant())
+	}
+	var ts [8]byte
+	copy(ts[2:], id[:6])
+	if ms := int64(binary.BigEndian.Uint64(ts[:])); ms < before || ms > time.Now().UnixMilli() {
+		t.Errorf("%s has timestamp %d, created after %d", id, ms, before)
+	}
+}
+
+// More than 4096 IDs in one millisecond move on to the next millisecond
+// instead of repeating the counter
+func TestIDGeneratorCounterExhausted(t *testing.T) {
+	future := time.Now().Add(time.Hour).UnixMilli()
+	g := idGenerator{lastMs: future, seq: 0xffe}
+
+	if ms, seq := g.next(); ms != future || seq != 0xfff {
+		t.Fatalf("got %d/%x, want %d/fff", ms, seq, future)
+	}
+	if ms, seq := g.next(); ms != future+1 || seq != 0 {
+		t.Fatalf("got %d/%x after the counter ran out, want %d/0", ms, seq, future+1)
+	}
+}
@@ -0,0 +1,157 @@
+// Package apierr defines the error payload exchanged between hub, workers
+// and clients so failures can be handled by code instead of by message text.
+package apierr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Code identifies the kind of failure
+type Code string
+
+const (
+	CodeNoWorker          Code = "NO_WORKER"          // no online worker has the capability
+	CodeWorkerBusy        Code = "WORKER_BUSY"        // every provider is at its concurrency limit; retry later
+	CodeWorkerNotFound    Code = "WORKER_NOT_FOUND"   // the addressed worker is not connected
+	CodeUnknownCapability Code = "UNKNOWN_CAPABILITY" // the worker does not provide the capability
+	CodeFileNotFound      Code = "FILE_NOT_FOUND"     // no stored file has the requested file_id
+	CodeValidation        Code = "VALIDATION"         // the request is malformed or missing params
+	CodeTimeout           Code = "TIMEOUT"            // no response within the deadline
+	CodeRateLimited       Code = "RATE_LIMITED"       // the caller exceeded its rate limit
+	CodeUnauthenticated   Code = "UNAUTHENTICATED"    // missing or invalid credentials
+	CodeForbidden         Code = "FORBIDDEN"          // authenticated but not allowed
+	CodeConflict          Code = "CONFLICT"           // the client ID is already in use
+	CodeExecution         Code = "EXECUTION_FAILED"   // the capability ran and failed
+	CodeUnavailable       Code = "UNAVAILABLE"        // the hub is shutting down; retry on another hub or later
+	CodeOverloaded        Code = "OVERLOADED"         // the hub's routing queue is full; retry later
+	CodePayloadTooLarge   Code = "PAYLOAD_TOO_LARGE"  // the request body exceeds the size limit
+	CodeBadContentType    Code = "BAD_CONTENT_TYPE"   // the request's Content-Type cannot be parsed
+	CodeInternal          Code = "INTERNAL"           // anything else
+)
+
+// MetadataKey is set on error messages so routers can detect them without
+// decoding the content
+const MetadataKey = "error_code"
+
+// ErrorResponse is the JSON body of every error response
+type ErrorResponse struct {
+	Code    Code                   `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+
+	// Legacy mirrors Message under "error" for clients that predate codes
+	Legacy string `json:"error"`
+	Status string `json:"status"`
+}
+
+// New creates an ErrorResponse
+func New(code Code, message string) *ErrorResponse {
+	return &ErrorResponse{
+		Code:    code,
+		Message: message,
+		Legacy:  message,
+		Status:  "error",
+	}
+}
+
+// Newf creates an ErrorResponse with a formatted message
+func Newf(code Code, format string, args ...interface{}) *ErrorResponse {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+// WithDetail attaches a detail field and returns e for chaining
+func (e *ErrorResponse) WithDetail(key string, value interface{}) *ErrorResponse {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+// Error implements the error interface
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// JSON serializes e; it never fails since all fields are JSON-safe
+func (e *ErrorResponse) JSON() string {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprintf(`{"code":%q,"message":%q,"error":%q,"status":"error"}`, CodeInternal, e.Message, e.Message)
+	}
+	return string(data)
+}
+
+// HTTPStatus returns the HTTP status code matching e.Code
+func (e *ErrorResponse) HTTPStatus() int {
+	return HTTPStatus(e.Code)
+}
+
+// HTTPStatus maps an error code to an HTTP status code
+func HTTPStatus(code Code) int {
+	switch code {
+	case CodeValidation:
+		return http.StatusBadRequest
+	case CodeWorkerNotFound, CodeUnknownCapability, CodeFileNotFound:
+		return http.StatusNotFound
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeConflict:
+		return http.StatusConflict
+	case CodePayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case CodeBadContentType:
+		return http.StatusUnsupportedMediaType
+	case CodeNoWorker, CodeWorkerBusy, CodeUnavailable, CodeOverloaded:
+		return http.StatusServiceUnavailable
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	case CodeExecution:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Parse extracts an ErrorResponse from response content. It also accepts
+// the legacy {"error": "..."} shape, reported with CodeInternal, or
+// CodeExecution when it came from a worker. ok is false when content is
+// not an error.
+func Parse(content string) (*ErrorResponse, bool) {
+	var raw struct {
+		Code    interface{}            `json:"code"`
+		Message string                 `json:"message"`
+		Details map[string]interface{} `json:"details"`
+		Error   interface{}            `json:"error"`
+		Status  string                 `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(content), &raw); err != nil {
+		return nil, false
+	}
+
+	legacy, _ := raw.Error.(string)
+	code, _ := raw.Code.(string)
+	if legacy == "" && (code == "" || raw.Message == "") {
+		return nil, false
+	}
+
+	e := New(Code(code), raw.Message)
+	if e.Message == "" {
+		e.Message = legacy
+		e.Legacy = legacy
+	}
+	if e.Code == "" {
+		e.Code = CodeInternal
+		if raw.Status == "error" || raw.Status == "failed" {
+			e.Code = CodeExecution
+		}
+	}
+	e.Details = raw.Details
+	return e, true
+}
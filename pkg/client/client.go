@@ -0,0 +1,511 @@
+// Package client is a Go client for the hub. It owns the stream, matches
+// responses to requests by message ID and handles timeouts, so callers
+// can use a plain request/response API.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// DefaultTimeout applies to calls whose context has no deadline
+const DefaultTimeout = 30 * time.Second
+
+var ErrClosed = errors.New("client closed")
+
+// Client is a connection to the hub. It is safe for concurrent use.
+type Client struct {
+	conn   *grpc.ClientConn
+	hub    proto.HubServiceClient
+	stream proto.HubService_ConnectClient
+	id     string
+
+	authToken            string
+	namespace            string
+	timeout              time.Duration
+	compressionThreshold int
+	grpcCompression      string
+	maxRecvMsgSize       int
+	maxSendMsgSize       int
+	onMessage            func(*proto.Message)
+
+	seq    uint64     // message ID counter
+	sendMu sync.Mutex // gRPC streams do not allow concurrent Send
+
+	mu      sync.Mutex
+	pending map[string]chan *proto.Message // request ID -> waiter
+
+	done chan struct{} // closed when the receive loop exits
+	err  error         // why the receive loop exited
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithClientID sets the ID the client connects as (default: random)
+func WithClientID(id string) Option {
+	return func(c *Client) { c.id = id }
+}
+
+// WithAuthToken authenticates the stream (required when the hub runs with
+// AUTH_REQUIRED)
+func WithAuthToken(token string) Option {
+	return func(c *Client) { c.authToken = token }
+}
+
+// WithNamespace joins a tenant namespace: only its workers are discovered
+// and called unless the hub grants access to others. Ignored by hubs that
+// bind namespaces to credentials.
+func WithNamespace(namespace string) Option {
+	return func(c *Client) { c.namespace = namespace }
+}
+
+// WithTimeout sets the timeout for calls whose context has no deadline
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.timeout = timeout }
+}
+
+// WithCompressionThreshold sets the Content size above which requests are
+// gzipped (0 disables)
+func WithCompressionThreshold(threshold int) Option {
+	return func(c *Client) { c.compressionThreshold = threshold }
+}
+
+// WithGRPCCompression compresses the whole stream with a gRPC compressor
+// (codec.GRPCCompressionGzip); the hub compresses its replies in turn.
+// Off by default, which suits small messages best.
+func WithGRPCCompression(name string) Option {
+	return func(c *Client) { c.grpcCompression = name }
+}
+
+// WithMaxMessageSize sets the gRPC receive and send limits in bytes; they
+// should match the hub's. Messages above the send limit are uploaded with
+// UploadFile and sent as a content_file_id reference.
+func WithMaxMessageSize(recv, send int) Option {
+	return func(c *Client) {
+		c.maxRecvMsgSize = recv
+		c.maxSendMsgSize = send
+	}
+}
+
+// WithMessageHandler receives messages that do not answer a pending call
+// (direct, broadcast and channel messages)
+func WithMessageHandler(handler func(*proto.Message)) Option {
+	return func(c *Client) { c.onMessage = handler }
+}
+
+// Response is the answer to an Invoke call
+type Response struct {
+	ID       string
+	From     string
+	Content  string
+	Metadata map[string]string
+}
+
+// Decode unmarshals the response content into v
+func (r *Response) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(r.Content), v)
+}
+
+// Capability describes a capability offered by at least one worker
+type Capability struct {
+	Name          string   `json:"name"`
+	Description   string   `json:"description"`
+	InputSchema   string   `json:"input_schema,omitempty"`
+	OutputSchema  string   `json:"output_schema,omitempty"`
+	HTTPMethod    string   `json:"http_method"`
+	AcceptsFile   bool     `json:"accepts_file"`
+	FileFieldName string   `json:"file_field_name,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+
+	Deprecated         bool   `json:"deprecated,omitempty"`
+	DeprecationMessage string `json:"deprecation_message,omitempty"`
+
+	// Example is a sample request (a JSON object) for the capability
+	Example string `json:"example,omitempty"`
+
+	// SchemaChanged is set when a provider re-registered the capability
+	// with a different input or output schema
+	SchemaChanged bool `json:"schema_changed,omitempty"`
+
+	// DefaultTimeoutMs is how long callers should wait for the capability
+	// when they set no timeout of their own (0 if not declared)
+	DefaultTimeoutMs int64 `json:"default_timeout_ms,omitempty"`
+
+	// Cacheable capabilities may be answered from the hub's result cache,
+	// for CacheTTLSeconds (0 for the hub's default)
+	Cacheable       bool  `json:"cacheable,omitempty"`
+	CacheTTLSeconds int64 `json:"cache_ttl_seconds,omitempty"`
+
+	// Providers are the workers offering the capability
+	Providers []Provider `json:"providers,omitempty"`
+}
+
+// Provider is a worker offering a capability
+type Provider struct {
+	WorkerID string            `json:"worker_id"`
+	Status   string            `json:"status"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// Dial connects to the hub at addr
+func Dial(addr string, opts ...Option) (*Client, error) {
+	c := &Client{
+		id:                   utils.PrefixedID("client"),
+		timeout:              DefaultTimeout,
+		compressionThreshold: codec.DefaultCompressionThreshold,
+		maxRecvMsgSize:       codec.DefaultMaxMessageSize,
+		maxSendMsgSize:       codec.DefaultMaxMessageSize,
+		pending:              make(map[string]chan *proto.Message),
+		done:                 make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	compressor, err := codec.GRPCCompressor(c.grpcCompression)
+	if err != nil {
+		return nil, err
+	}
+	var callOpts []grpc.CallOption
+	if compressor != nil {
+		callOpts = append(callOpts, compressor)
+	}
+	if c.maxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(c.maxRecvMsgSize))
+	}
+	if c.maxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(c.maxSendMsgSize))
+	}
+
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(callOpts...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	hub := proto.NewHubServiceClient(conn)
+	stream, err := hub.Connect(context.Background())
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start stream: %w", err)
+	}
+	c.conn = conn
+	c.hub = hub
+	c.stream = stream
+
+	go c.receiveLoop()
+
+	// The hub reads the token and namespace from the first message
+	if c.authToken != "" || c.namespace != "" {
+		msg := c.newMessage(proto.MessageType_AUTH)
+		msg.To = "hub"
+		msg.Metadata = map[string]string{"auth_token": c.authToken, "namespace": c.namespace}
+		if _, err := c.Request(context.Background(), msg); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// ID returns the client ID used on the stream
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Invoke calls capability and waits for its response. workerID may be
+// empty to let the hub pick a worker. payload is sent as-is if it is a
+// string or []byte, otherwise it is JSON-encoded. Error responses are
+// returned as *apierr.ErrorResponse.
+func (c *Client) Invoke(ctx context.Context, workerID, capability string, payload interface{}) (*Response, error) {
+	content, err := encodePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := c.newMessage(proto.MessageType_REQUEST)
+	msg.To = workerID
+	msg.Content = content
+	msg.Action = "request"
+	envelope.SetCapability(msg, capability)
+
+	reply, err := c.Request(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiErr, failed := envelope.Error(reply); failed {
+		return nil, apiErr
+	}
+
+	return &Response{
+		ID:       reply.Id,
+		From:     reply.From,
+		Content:  reply.Content,
+		Metadata: reply.Metadata,
+	}, nil
+}
+
+// Discover lists the capabilities currently offered by connected workers,
+// sorted by name
+func (c *Client) Discover() ([]Capability, error) {
+	return c.DiscoverByTag("")
+}
+
+// DiscoverByTag is like Discover but only lists capabilities tagged with
+// tag (all capabilities when tag is empty)
+func (c *Client) DiscoverByTag(tag string) ([]Capability, error) {
+	request := map[string]string{"action": "discover"}
+	if tag != "" {
+		request["tag"] = tag
+	}
+	content, _ := json.Marshal(request)
+
+	msg := c.newMessage(proto.MessageType_REQUEST)
+	msg.To = "hub"
+	msg.Channel = "capability_discovery"
+	msg.Content = string(content)
+
+	reply, err := c.Request(context.Background(), msg)
+	if err != nil {
+		return nil, err
+	}
+	if apiErr, failed := envelope.Error(reply); failed {
+		return nil, apiErr
+	}
+
+	var discovery struct {
+		Capabilities map[string]Capability `json:"capabilities"`
+		Providers    map[string][]Provider `json:"providers"`
+	}
+	if err := json.Unmarshal([]byte(reply.Content), &discovery); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery response: %w", err)
+	}
+
+	capabilities := make([]Capability, 0, len(discovery.Capabilities))
+	for name, cap := range discovery.Capabilities {
+		cap.Providers = discovery.Providers[name]
+		capabilities = append(capabilities, cap)
+	}
+	sort.Slice(capabilities, func(i, j int) bool {
+		return capabilities[i].Name < capabilities[j].Name
+	})
+	return capabilities, nil
+}
+
+// Subscribe asks the hub to deliver messages published on channel; they
+// arrive at the WithMessageHandler handler
+func (c *Client) Subscribe(ctx context.Context, channel string) error {
+	return c.subscription(ctx, proto.MessageType_SUBSCRIBE, channel)
+}
+
+// Unsubscribe stops delivery of messages published on channel
+func (c *Client) Unsubscribe(ctx context.Context, channel string) error {
+	return c.subscription(ctx, proto.MessageType_UNSUBSCRIBE, channel)
+}
+
+func (c *Client) subscription(ctx context.Context, msgType proto.MessageType, channel string) error {
+	msg := c.newMessage(msgType)
+	msg.To = "hub"
+	msg.Channel = channel
+
+	reply, err := c.Request(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if apiErr, failed := envelope.Error(reply); failed {
+		return apiErr
+	}
+	return nil
+}
+
+// Request sends msg and waits for the message that answers it. msg.Id and
+// msg.RequestId are filled in when empty.
+func (c *Client) Request(ctx context.Context, msg *proto.Message) (*proto.Message, error) {
+	if msg.Id == "" {
+		msg.Id = c.nextID()
+	}
+	if msg.RequestId == "" {
+		msg.RequestId = msg.Id
+	}
+	if msg.From == "" {
+		msg.From = c.id
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	waiter := make(chan *proto.Message, 1)
+	c.mu.Lock()
+	c.pending[msg.RequestId] = waiter
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, msg.RequestId)
+		c.mu.Unlock()
+	}()
+
+	if err := c.Send(msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-waiter:
+		return reply, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, apierr.Newf(apierr.CodeTimeout, "no response to %s", msg.RequestId)
+		}
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.closeErr()
+	}
+}
+
+// Send sends msg without waiting for a response
+func (c *Client) Send(msg *proto.Message) error {
+	select {
+	case <-c.done:
+		return c.closeErr()
+	default:
+	}
+
+	if msg.From == "" {
+		msg.From = c.id
+	}
+	if msg.Timestamp == "" {
+		msg.Timestamp = time.Now().Format(time.RFC3339)
+	}
+	if err := codec.Compress(msg, c.compressionThreshold); err != nil {
+		return err
+	}
+	if _, err := codec.Offload(context.Background(), c.hub, msg, c.maxSendMsgSize); err != nil {
+		return err
+	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.stream.Send(msg)
+}
+
+// Close closes the stream and the connection
+func (c *Client) Close() error {
+	c.sendMu.Lock()
+	c.stream.CloseSend()
+	c.sendMu.Unlock()
+	return c.conn.Close()
+}
+
+func (c *Client) receiveLoop() {
+	defer close(c.done)
+
+	for {
+		msg, err := c.stream.Recv()
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+		if err := codec.Resolve(context.Background(), c.hub, msg); err != nil {
+			log.Printf("client: dropping message %s: %v", msg.Id, err)
+			continue
+		}
+		if err := codec.Decompress(msg); err != nil {
+			log.Printf("client: dropping message %s: %v", msg.Id, err)
+			continue
+		}
+		c.route(msg)
+	}
+}
+
+// route delivers msg to the call it answers. Workers echo the request ID
+// in RequestId, Metadata["request_id"] or Id, and hub errors reference it
+// in Metadata["original_message_id"]. ACKs answer no call and go to the
+// message handler.
+func (c *Client) route(msg *proto.Message) {
+	c.mu.Lock()
+	var waiter chan *proto.Message
+	if msg.Type != proto.MessageType_ACK {
+		for _, key := range envelope.ReplyKeys(msg) {
+			if ch, ok := c.pending[key]; ok {
+				waiter = ch
+				delete(c.pending, key)
+				break
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	if waiter != nil {
+		waiter <- msg
+		return
+	}
+	if c.onMessage != nil {
+		c.onMessage(msg)
+	}
+}
+
+func (c *Client) newMessage(msgType proto.MessageType) *proto.Message {
+	id := c.nextID()
+	return &proto.Message{
+		Id:        id,
+		RequestId: id,
+		From:      c.id,
+		Type:      msgType,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+}
+
+func (c *Client) nextID() string {
+	return fmt.Sprintf("%s-%d", c.id, atomic.AddUint64(&c.seq, 1))
+}
+
+func (c *Client) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return fmt.Errorf("%w: %v", ErrClosed, c.err)
+	}
+	return ErrClosed
+}
+
+func encodePayload(payload interface{}) (string, error) {
+	switch p := payload.(type) {
+	case nil:
+		return "{}", nil
+	case string:
+		return p, nil
+	case []byte:
+		return string(p), nil
+	default:
+		data, err := json.Marshal(p)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode payload: %w", err)
+		}
+		return string(data), nil
+	}
+}
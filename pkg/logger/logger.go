@@ -8,7 +8,30 @@ import (
 
 var log *logrus.Logger
 
+// Fields are structured fields attached to a log entry
+type Fields = logrus.Fields
+
+// Output formats accepted by InitWithFormat
+const (
+	FormatText = "text" // human-friendly, messages prefixed with their emoji
+	FormatJSON = "json" // one JSON object per line for log aggregation
+)
+
+// emojiKey holds the emoji set with Emoji; it is never printed as a field
+const emojiKey = "emoji"
+
+func init() {
+	// Usable before Init (e.g. in tools that embed the hub)
+	Init("info")
+}
+
 func Init(level string) {
+	InitWithFormat(level, FormatText)
+}
+
+// InitWithFormat configures the level (debug, info, warn, error) and the
+// output format (text or json)
+func InitWithFormat(level, format string) {
 	log = logrus.New()
 	log.SetOutput(os.Stdout)
 
@@ -25,9 +48,11 @@ func Init(level string) {
 		log.SetLevel(logrus.InfoLevel)
 	}
 
-	log.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
+	if format == FormatJSON {
+		log.SetFormatter(&jsonFormatter{logrus.JSONFormatter{}})
+	} else {
+		log.SetFormatter(&textFormatter{logrus.TextFormatter{FullTimestamp: true}})
+	}
 }
 
 func Info(msg string, fields ...interface{}) {
@@ -44,4 +69,55 @@ func Error(msg string, fields ...interface{}) {
 
 func Debug(msg string, fields ...interface{}) {
 	log.WithFields(logrus.Fields{"args": fields}).Debug(msg)
-}
\ No newline at end of file
+}
+
+// WithFields returns an entry that logs with fields attached
+func WithFields(fields Fields) *logrus.Entry {
+	return log.WithFields(fields)
+}
+
+// Emoji returns an entry whose message is prefixed with emoji in text
+// output; JSON output leaves it out
+func Emoji(emoji string) *logrus.Entry {
+	return log.WithField(emojiKey, emoji)
+}
+
+// textFormatter moves the emoji field in front of the message
+type textFormatter struct {
+	logrus.TextFormatter
+}
+
+func (f *textFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	emoji, ok := entry.Data[emojiKey].(string)
+	if !ok {
+		return f.TextFormatter.Format(entry)
+	}
+	e := withoutEmoji(entry)
+	e.Message = emoji + " " + e.Message
+	return f.TextFormatter.Format(e)
+}
+
+// jsonFormatter drops the emoji field
+type jsonFormatter struct {
+	logrus.JSONFormatter
+}
+
+func (f *jsonFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if _, ok := entry.Data[emojiKey]; !ok {
+		return f.JSONFormatter.Format(entry)
+	}
+	return f.JSONFormatter.Format(withoutEmoji(entry))
+}
+
+// withoutEmoji copies entry with the emoji field removed
+func withoutEmoji(entry *logrus.Entry) *logrus.Entry {
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		if k != emojiKey {
+			data[k] = v
+		}
+	}
+	e := *entry
+	e.Data = data
+	return &e
+}
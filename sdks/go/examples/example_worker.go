@@ -6,8 +6,12 @@ import (
     "encoding/json"
     "errors"
     "fmt"
+    "net/http"
     "os"
+    "path/filepath"
+    "strings"
     "time"
+    "unicode/utf8"
 
     "deepapp/sdk"
 )
@@ -187,37 +191,24 @@ func (w *ExampleWorker) handleAnalyzeFile(ctx context.Context, msg *sdk.Message)
         "size_mb":    float64(fileSize) / (1024 * 1024),
     }
 
-    // Try to detect MIME type from filename
+    // Extension-based MIME type is only a hint
+    extMimeType := ""
     if filename != "unknown" {
         analysis["filename"] = filename
-        // Simple extension-based MIME type detection
-        mimeTypes := map[string]string{
-            ".txt":  "text/plain",
-            ".json": "application/json",
-            ".xml":  "application/xml",
-            ".html": "text/html",
-            ".css":  "text/css",
-            ".js":   "application/javascript",
-            ".png":  "image/png",
-            ".jpg":  "image/jpeg",
-            ".jpeg": "image/jpeg",
-            ".gif":  "image/gif",
-            ".pdf":  "application/pdf",
-            ".zip":  "application/zip",
-        }
-
-        for ext, mimeType := range mimeTypes {
-            if len(filename) > len(ext) && filename[len(filename)-len(ext):] == ext {
-                analysis["mime_type"] = mimeType
-                break
-            }
-        }
+        extMimeType = extensionMimeTypes[strings.ToLower(filepath.Ext(filename))]
     }
 
-    if analysis["mime_type"] == nil {
-        analysis["mime_type"] = "application/octet-stream"
+    // Sniff the content and prefer it when it is specific
+    sniffed := sniffMimeType(fileBytes)
+    analysis["mime_type_sniffed"] = sniffed
+    if extMimeType != "" {
+        analysis["mime_type_extension"] = extMimeType
     }
 
+    mimeType, mismatch := resolveMimeType(sniffed, extMimeType)
+    analysis["mime_type"] = mimeType
+    analysis["mime_mismatch"] = mismatch
+
     // Try to detect if it's text
     isText := true
     for _, b := range fileBytes {
@@ -228,9 +219,16 @@ func (w *ExampleWorker) handleAnalyzeFile(ctx context.Context, msg *sdk.Message)
     }
     analysis["is_text"] = isText
 
+    if utf8.Valid(fileBytes) {
+        analysis["encoding"] = "utf-8"
+    } else {
+        analysis["encoding"] = "binary"
+    }
+
     if isText {
         textContent := string(fileBytes)
-        analysis["line_count"] = len(fmt.Sprintf("%s", textContent)) // Simple line count
+        analysis["line_count"] = countLines(textContent)
+        analysis["word_count"] = len(strings.Fields(textContent))
         analysis["char_count"] = len(textContent)
     }
 
@@ -247,6 +245,60 @@ func (w *ExampleWorker) handleAnalyzeFile(ctx context.Context, msg *sdk.Message)
     }, nil
 }
 
+// extensionMimeTypes maps file extensions to MIME types
+var extensionMimeTypes = map[string]string{
+    ".txt":  "text/plain",
+    ".json": "application/json",
+    ".xml":  "application/xml",
+    ".html": "text/html",
+    ".css":  "text/css",
+    ".js":   "application/javascript",
+    ".png":  "image/png",
+    ".jpg":  "image/jpeg",
+    ".jpeg": "image/jpeg",
+    ".gif":  "image/gif",
+    ".pdf":  "application/pdf",
+    ".zip":  "application/zip",
+}
+
+// sniffMimeType detects the MIME type from the first 512 bytes of content
+func sniffMimeType(data []byte) string {
+    if len(data) > 512 {
+        data = data[:512]
+    }
+    return http.DetectContentType(data)
+}
+
+// resolveMimeType picks between the sniffed and extension MIME types.
+// Generic sniff results (octet-stream, plain text) are not confident, so the
+// extension wins for them; otherwise the sniffed type wins and a mismatch is
+// reported if the extension disagrees.
+func resolveMimeType(sniffed, fromExtension string) (string, bool) {
+    base := sniffed
+    if i := strings.Index(base, ";"); i >= 0 {
+        base = strings.TrimSpace(base[:i])
+    }
+
+    confident := base != "application/octet-stream" && base != "text/plain"
+    if !confident {
+        if fromExtension != "" {
+            return fromExtension, false
+        }
+        return sniffed, false
+    }
+
+    return sniffed, fromExtension != "" && fromExtension != base
+}
+
+// countLines returns the number of newline-separated lines (0 for empty text).
+// CRLF content counts the same as LF since only '\n' is considered.
+func countLines(text string) int {
+    if text == "" {
+        return 0
+    }
+    return strings.Count(text, "\n") + 1
+}
+
 func main() {
     worker := NewExampleWorker()
 
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "testing"
+
+    "deepapp/sdk"
+)
+
+// analyzeFile runs the analyze_file handler on content and returns the
+// analysis
+func analyzeFile(t *testing.T, filename string, content []byte) map[string]interface{} {
+    t.Helper()
+    input, _ := json.Marshal(map[string]string{
+        "filename": filename,
+        "file":     base64.StdEncoding.EncodeToString(content),
+    })
+    resp, err := (&ExampleWorker{}).handleAnalyzeFile(context.Background(), &sdk.Message{Content: string(input)})
+    if err != nil {
+        t.Fatalf("analyze %s: %v", filename, err)
+    }
+    analysis, ok := resp.Data["analysis"].(map[string]interface{})
+    if !ok {
+        t.Fatalf("analyze %s: no analysis in %v", filename, resp.Data)
+    }
+    return analysis
+}
+
+func TestAnalyzeFileCounts(t *testing.T) {
+    tests := []struct {
+        name    string
+        content string
+        lines   int
+        words   int
+    }{
+        {"empty", "", 0, 0},
+        {"single line", "hello world", 1, 2},
+        {"no trailing newline", "first line\nsecond line", 2, 4},
+        {"crlf", "first line\r\nsecond line\r\nthird", 3, 5},
+        {"blank lines", "a\n\n\nb", 4, 2},
+        {"tabs and spaces", "  one\ttwo   three  ", 1, 3},
+        {"utf-8", "xin chào\nthế giới", 2, 4},
+    }
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            analysis := analyzeFile(t, "test.txt", []byte(tc.content))
+            if analysis["is_text"] != true {
+                t.Fatalf("%q not detected as text", tc.content)
+            }
+            if analysis["line_count"] != tc.lines {
+                t.Errorf("line_count = %v, want %d", analysis["line_count"], tc.lines)
+            }
+            if analysis["word_count"] != tc.words {
+                t.Errorf("word_count = %v, want %d", analysis["word_count"], tc.words)
+            }
+            if analysis["char_count"] != len(tc.content) {
+                t.Errorf("char_count = %v, want %d", analysis["char_count"], len(tc.content))
+            }
+            if analysis["encoding"] != "utf-8" {
+                t.Errorf("encoding = %v, want utf-8", analysis["encoding"])
+            }
+        })
+    }
+}
+
+func TestAnalyzeFileBinary(t *testing.T) {
+    analysis := analyzeFile(t, "image.png", []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0xff, 0x00})
+    if analysis["is_text"] != false {
+        t.Error("binary content detected as text")
+    }
+    if analysis["encoding"] != "binary" {
+        t.Errorf("encoding = %v, want binary", analysis["encoding"])
+    }
+    if _, counted := analysis["line_count"]; counted {
+        t.Error("lines counted in binary content")
+    }
+}
@@ -3,6 +3,14 @@ module deepapp/sdk
 go 1.18
 
 require (
-    google.golang.org/grpc v1.60.0
-    google.golang.org/protobuf v1.31.0
-)
\ No newline at end of file
+	google.golang.org/grpc v1.60.0
+	google.golang.org/protobuf v1.31.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.16.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20231002182017-d307bd883b97 // indirect
+)
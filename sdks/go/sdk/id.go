@@ -0,0 +1,25 @@
+package sdk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// idCounter keeps IDs created in the same clock tick distinct
+var idCounter uint64
+
+// newID returns prefix-<unix ms>-<counter>-<random>, e.g. "resp-18f3a1c2b40-2a-9c41e0d7".
+// The counter makes IDs unique within the process even on coarse clocks;
+// the random suffix keeps them unique across workers.
+func newID(prefix string) string {
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		panic("sdk: crypto/rand failed: " + err.Error())
+	}
+	return prefix + "-" + strconv.FormatInt(time.Now().UnixMilli(), 16) +
+		"-" + strconv.FormatUint(atomic.AddUint64(&idCounter, 1), 16) +
+		"-" + hex.EncodeToString(suffix[:])
+}
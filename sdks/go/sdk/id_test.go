@@ -0,0 +1,44 @@
+package sdk
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewIDUnique(t *testing.T) {
+	const goroutines, perGoroutine = 10, 10000
+
+	results := make([][]string, goroutines)
+	var wg sync.WaitGroup
+	for g := range results {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids := make([]string, perGoroutine)
+			for i := range ids {
+				ids[i] = newID("resp")
+			}
+			results[g] = ids
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, goroutines*perGoroutine)
+	for _, ids := range results {
+		for _, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicate ID %s", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestNewIDFormat(t *testing.T) {
+	parts := strings.Split(newID("req"), "-")
+	if len(parts) != 4 || parts[0] != "req" || len(parts[3]) != 8 {
+		t.Fatalf("ID %v is not req-<ms>-<counter>-<random>", parts)
+	}
+}
@@ -8,6 +8,7 @@ import (
     "log"
     "os"
     "os/signal"
+    "runtime/debug"
     "sync"
     "syscall"
     "time"
@@ -22,8 +23,28 @@ import (
 type Config struct {
     WorkerID   string
     HubAddress string
+
+    // MaxConcurrency caps how many handlers run at once (0 = unlimited).
+    // It is also advertised to the hub so it stops routing here when full.
+    MaxConcurrency int
+
+    // QueueTimeout is how long a request waits for a free handler slot
+    // before it is answered WORKER_BUSY (default 30s). A request's own
+    // Metadata["deadline"] (RFC3339) takes precedence.
+    QueueTimeout time.Duration
+
+    // Labels let requests select this worker with a label selector in
+    // Metadata["label_selector"], e.g. "region=eu,gpu=true"
+    Labels map[string]string
+
+    // Namespace is the tenant namespace the worker registers in (empty =
+    // the hub's default). With hub auth it must match the credential's.
+    Namespace string
 }
 
+// DeadlineMetadata is the request metadata key holding its deadline
+const DeadlineMetadata = "deadline"
+
 // Capability defines a worker capability
 type Capability struct {
     Name          string
@@ -33,6 +54,9 @@ type Capability struct {
     HTTPMethod    string
     AcceptsFile   bool
     FileFieldName string
+
+    // Example is a sample request (a JSON object) shown in Swagger UI
+    Example string
 }
 
 // Message represents a message from the hub
@@ -67,22 +91,30 @@ type Worker struct {
     client       hubpb.HubServiceClient
     running      bool
     mu           sync.RWMutex
+    slots        chan struct{} // handler semaphore, nil when unlimited
 }
 
 // NewWorker creates a new worker instance
 func NewWorker(config Config) *Worker {
     if config.WorkerID == "" {
-        config.WorkerID = fmt.Sprintf("go-worker-%d", time.Now().Unix())
+        config.WorkerID = newID("go-worker")
     }
     if config.HubAddress == "" {
         config.HubAddress = "localhost:50051"
     }
+    if config.QueueTimeout <= 0 {
+        config.QueueTimeout = 30 * time.Second
+    }
 
-    return &Worker{
+    w := &Worker{
         config:   config,
         handlers: make(map[string]CapabilityHandler),
         running:  false,
     }
+    if config.MaxConcurrency > 0 {
+        w.slots = make(chan struct{}, config.MaxConcurrency)
+    }
+    return w
 }
 
 // AddCapability adds a capability to the worker
@@ -185,18 +217,32 @@ func (w *Worker) sendRegistration(stream hubpb.HubService_ConnectClient) error {
         if cap.FileFieldName != "" {
             capMap["file_field_name"] = cap.FileFieldName
         }
+        if cap.Example != "" {
+            capMap["example"] = cap.Example
+        }
         caps[i] = capMap
     }
 
+    metadata := map[string]interface{}{
+        "version":     "1.0.0",
+        "description": "Go SDK Worker",
+        "sdk":         "go-sdk",
+    }
+    if w.config.MaxConcurrency > 0 {
+        metadata["max_concurrency"] = w.config.MaxConcurrency
+    }
+
     registrationData := map[string]interface{}{
         "worker_id":   w.config.WorkerID,
         "worker_type": "go-sdk",
         "capabilities": caps,
-        "metadata": map[string]interface{}{
-            "version":     "1.0.0",
-            "description": "Go SDK Worker",
-            "sdk":         "go-sdk",
-        },
+        "metadata":    metadata,
+    }
+    if len(w.config.Labels) > 0 {
+        registrationData["labels"] = w.config.Labels
+    }
+    if w.config.Namespace != "" {
+        registrationData["namespace"] = w.config.Namespace
     }
 
     content, err := json.Marshal(registrationData)
@@ -205,7 +251,7 @@ func (w *Worker) sendRegistration(stream hubpb.HubService_ConnectClient) error {
     }
 
     msg := &hubpb.Message{
-        Id:        fmt.Sprintf("register-%d", time.Now().UnixNano()),
+        Id:        newID("register"),
         From:      w.config.WorkerID,
         To:        "hub",
         Channel:   "system",
@@ -252,6 +298,14 @@ func (w *Worker) handleMessage(msg *Message, stream hubpb.HubService_ConnectClie
 
     capability := msg.Channel
 
+    // A panicking handler fails its request instead of the whole worker
+    defer func() {
+        if r := recover(); r != nil {
+            log.Printf("💥 Panic handling capability %s: %v\n%s", capability, r, debug.Stack())
+            w.sendErrorResponseWithCode(msg, stream, "INTERNAL", fmt.Sprintf("capability %s panicked: %v", capability, r))
+        }
+    }()
+
     // Find handler
     handler, exists := w.handlers[capability]
     if !exists {
@@ -260,6 +314,15 @@ func (w *Worker) handleMessage(msg *Message, stream hubpb.HubService_ConnectClie
         return
     }
 
+    // Wait for a handler slot
+    if !w.acquire(msg) {
+        log.Printf("⏳ Worker busy, rejecting %s", capability)
+        w.sendErrorResponseWithCode(msg, stream, "WORKER_BUSY",
+            fmt.Sprintf("worker %s is at its concurrency limit (%d)", w.config.WorkerID, w.config.MaxConcurrency))
+        return
+    }
+    defer w.release()
+
     // Handle the capability
     ctx := context.Background()
     response, err := handler.Handle(ctx, msg)
@@ -273,6 +336,43 @@ func (w *Worker) handleMessage(msg *Message, stream hubpb.HubService_ConnectClie
     w.sendResponse(msg, stream, response)
 }
 
+// acquire waits for a handler slot until the request's deadline or the
+// queue timeout, whichever applies
+func (w *Worker) acquire(msg *Message) bool {
+    if w.slots == nil {
+        return true
+    }
+
+    select {
+    case w.slots <- struct{}{}:
+        return true
+    default:
+    }
+
+    wait := w.config.QueueTimeout
+    if deadline, err := time.Parse(time.RFC3339, msg.Metadata[DeadlineMetadata]); err == nil {
+        wait = time.Until(deadline)
+    }
+    if wait <= 0 {
+        return false
+    }
+
+    timer := time.NewTimer(wait)
+    defer timer.Stop()
+    select {
+    case w.slots <- struct{}{}:
+        return true
+    case <-timer.C:
+        return false
+    }
+}
+
+func (w *Worker) release() {
+    if w.slots != nil {
+        <-w.slots
+    }
+}
+
 func (w *Worker) sendResponse(request *Message, stream hubpb.HubService_ConnectClient, response *Response) {
     responseData := map[string]interface{}{
         "status": "success",
@@ -290,7 +390,7 @@ func (w *Worker) sendResponse(request *Message, stream hubpb.HubService_ConnectC
     }
 
     msg := &hubpb.Message{
-        Id:        fmt.Sprintf("resp-%d", time.Now().UnixNano()),
+        Id:        newID("resp"),
         From:      w.config.WorkerID,
         To:        request.From,
         Channel:   request.Channel,
@@ -308,15 +408,25 @@ func (w *Worker) sendResponse(request *Message, stream hubpb.HubService_ConnectC
 }
 
 func (w *Worker) sendErrorResponse(request *Message, stream hubpb.HubService_ConnectClient, errorMsg string) {
+    w.sendErrorResponseWithCode(request, stream, "", errorMsg)
+}
+
+// sendErrorResponseWithCode is sendErrorResponse with an error code (e.g.
+// WORKER_BUSY) clients can act on
+func (w *Worker) sendErrorResponseWithCode(request *Message, stream hubpb.HubService_ConnectClient, code, errorMsg string) {
     responseData := map[string]interface{}{
         "error":  errorMsg,
         "status": "failed",
     }
+    if code != "" {
+        responseData["code"] = code
+        responseData["message"] = errorMsg
+    }
 
     content, _ := json.Marshal(responseData)
 
     msg := &hubpb.Message{
-        Id:        fmt.Sprintf("resp-%d", time.Now().UnixNano()),
+        Id:        newID("resp"),
         From:      w.config.WorkerID,
         To:        request.From,
         Channel:   request.Channel,
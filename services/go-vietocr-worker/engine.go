@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+// DefaultEngine is the OCR engine used when none is configured
+const DefaultEngine = "stub"
+
+// OCREngine recognizes the text of an image. Detect is called from several
+// goroutines at once (concurrent requests and batch images), so
+// implementations must be safe for concurrent use.
+type OCREngine interface {
+	Detect(img image.Image) (text string, confidence float64, err error)
+}
+
+// engines maps the names accepted by -engine / OCR_ENGINE to their
+// constructors. An ONNX Runtime, Tesseract or remote engine is added here.
+var engines = map[string]func() (OCREngine, error){
+	"stub": func() (OCREngine, error) { return stubEngine{}, nil },
+}
+
+// newEngine creates the engine registered under name
+func newEngine(name string) (OCREngine, error) {
+	factory, ok := engines[name]
+	if !ok {
+		names := make([]string, 0, len(engines))
+		for n := range engines {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown OCR engine %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return factory()
+}
+
+// stubEngine returns a fixed demo text until real inference is wired in
+type stubEngine struct{}
+
+func (stubEngine) Detect(img image.Image) (string, float64, error) {
+	return "Văn bản tiếng Việt từ Go Worker", 0.93, nil
+}
+
+// EncodedImage stands in for an image the worker validated but cannot
+// decode (webp). Bounds are the real dimensions but every pixel reads as
+// transparent: engines that need pixels type-assert it and decode Data
+// themselves, or return an error.
+type EncodedImage struct {
+	Format string
+	Data   []byte
+	Rect   image.Rectangle
+}
+
+func (e *EncodedImage) ColorModel() color.Model { return color.RGBAModel }
+
+func (e *EncodedImage) Bounds() image.Rectangle { return e.Rect }
+
+func (e *EncodedImage) At(x, y int) color.Color { return color.RGBA{} }
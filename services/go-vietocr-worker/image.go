@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/jpeg" // register the decoders used by image.Decode
+	_ "image/png"
+
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// MaxImagePixels rejects images whose dimensions would need too much memory
+// to decode (width x height)
+const MaxImagePixels = 50_000_000
+
+// Reasons given in the "reason" detail of a rejected image
+const (
+	reasonUnsupported = "unsupported_format"
+	reasonCorrupt     = "corrupt_image"
+	reasonTooLarge    = "image_too_large"
+)
+
+// imageInfo is the format and size of a validated image
+type imageInfo struct {
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// decodeImage checks that data is a png, jpeg or webp image and returns it
+// decoded, with its format and dimensions. png and jpeg are fully decoded
+// so truncated or corrupt pixel data is caught; webp is validated from its
+// RIFF header only, as the standard library has no webp decoder, and
+// returned as an *EncodedImage.
+func decodeImage(data []byte) (image.Image, imageInfo, *apierr.ErrorResponse) {
+	if len(data) == 0 {
+		return nil, imageInfo{}, imageError(reasonCorrupt, "Image is empty")
+	}
+	if isWebP(data) {
+		info, err := webpInfo(data)
+		if err != nil {
+			return nil, imageInfo{}, imageError(reasonCorrupt, "Corrupt webp image: "+err.Error())
+		}
+		if apiErr := checkPixels(info); apiErr != nil {
+			return nil, imageInfo{}, apiErr
+		}
+		return &EncodedImage{Format: info.Format, Data: data, Rect: image.Rect(0, 0, info.Width, info.Height)}, info, nil
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, imageInfo{}, imageError(reasonUnsupported, "Unsupported image format, expected png, jpeg or webp")
+	}
+	if err != nil {
+		return nil, imageInfo{}, imageError(reasonCorrupt, "Corrupt image: "+err.Error())
+	}
+	info := imageInfo{Format: format, Width: config.Width, Height: config.Height}
+	if apiErr := checkPixels(info); apiErr != nil {
+		return nil, imageInfo{}, apiErr
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, imageInfo{}, imageError(reasonCorrupt, "Corrupt "+format+" image: "+err.Error())
+	}
+	return img, info, nil
+}
+
+// checkPixels rejects empty images and images above MaxImagePixels
+func checkPixels(info imageInfo) *apierr.ErrorResponse {
+	if info.Width <= 0 || info.Height <= 0 {
+		return imageError(reasonCorrupt, "Image has no pixels")
+	}
+	if int64(info.Width)*int64(info.Height) > MaxImagePixels {
+		return imageError(reasonTooLarge, "Image is too large to process").
+			WithDetail("width", info.Width).
+			WithDetail("height", info.Height).
+			WithDetail("max_pixels", MaxImagePixels)
+	}
+	return nil
+}
+
+func imageError(reason, message string) *apierr.ErrorResponse {
+	return apierr.New(apierr.CodeValidation, message).WithDetail("reason", reason)
+}
+
+// isWebP reports whether data starts with a RIFF/WEBP header
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// webpInfo reads the dimensions from the first chunk of a webp image: a
+// lossy (VP8), lossless (VP8L) or extended (VP8X) bitstream
+func webpInfo(data []byte) (imageInfo, error) {
+	if riffSize := int64(binary.LittleEndian.Uint32(data[4:8])) + 8; int64(len(data)) < riffSize {
+		return imageInfo{}, errors.New("truncated file")
+	}
+	if len(data) < 20 {
+		return imageInfo{}, errors.New("missing bitstream chunk")
+	}
+	chunk := data[20:]
+	if size := int64(binary.LittleEndian.Uint32(data[16:20])); int64(len(chunk)) < size {
+		return imageInfo{}, errors.New("truncated chunk")
+	}
+
+	info := imageInfo{Format: "webp"}
+	switch string(data[12:16]) {
+	case "VP8 ":
+		if len(chunk) < 10 || chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return imageInfo{}, errors.New("bad VP8 frame header")
+		}
+		info.Width = int(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff)
+		info.Height = int(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff)
+	case "VP8L":
+		if len(chunk) < 5 || chunk[0] != 0x2f {
+			return imageInfo{}, errors.New("bad VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		info.Width = int(bits&0x3fff) + 1
+		info.Height = int(bits>>14&0x3fff) + 1
+	case "VP8X":
+		if len(chunk) < 10 {
+			return imageInfo{}, errors.New("short VP8X chunk")
+		}
+		info.Width = int(uint32(chunk[4])|uint32(chunk[5])<<8|uint32(chunk[6])<<16) + 1
+		info.Height = int(uint32(chunk[7])|uint32(chunk[8])<<8|uint32(chunk[9])<<16) + 1
+	default:
+		return imageInfo{}, errors.New("unknown bitstream chunk " + string(data[12:16]))
+	}
+	return info, nil
+}
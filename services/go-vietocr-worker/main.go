@@ -1,297 +1,274 @@
 package main
 
 import (
-	"context"
 	"encoding/base64"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-
-	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	workersdk "deepapp_golang_grpc_hub/shared/worker-sdk/go"
 )
 
-// VietOCRWorker - Go worker for VietOCR using ONNX Runtime via Python subprocess
-type VietOCRWorker struct {
-	workerID   string
-	hubAddress string
-
-	// gRPC
-	conn   *grpc.ClientConn
-	client pb.HubServiceClient
-	stream pb.HubService_ConnectClient
-
-	// State
-	running   bool
-	sendQueue chan *pb.Message
-	mu        sync.RWMutex
-}
-
-// NewVietOCRWorker creates new VietOCR worker
-func NewVietOCRWorker(workerID, hubAddress string) (*VietOCRWorker, error) {
-	return &VietOCRWorker{
-		workerID:   workerID,
-		hubAddress: hubAddress,
-		sendQueue:  make(chan *pb.Message, 100),
-	}, nil
-}
-
-// Connect to Hub
-func (w *VietOCRWorker) Connect() error {
-	log.Printf("🔵 Connecting to Hub at %s...", w.hubAddress)
-
-	conn, err := grpc.Dial(
-		w.hubAddress,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
-	}
-	w.conn = conn
+// DefaultMaxBatchSize caps the images of one ocr_batch request
+const DefaultMaxBatchSize = 100
 
-	w.client = pb.NewHubServiceClient(conn)
+// DefaultMaxConcurrency is how many OCR requests are handled at once
+const DefaultMaxConcurrency = 4
 
-	stream, err := w.client.Connect(context.Background())
-	if err != nil {
-		conn.Close()
-		return fmt.Errorf("failed to start stream: %w", err)
-	}
-	w.stream = stream
+// progressSteps is roughly how many progress messages a streamed batch
+// sends
+const progressSteps = 20
 
-	log.Println("✅ Connected to Hub")
-
-	// Send registration
-	if err := w.sendRegistration(); err != nil {
-		return fmt.Errorf("failed to register: %w", err)
-	}
-
-	w.running = true
-
-	// Start goroutines
-	go w.sendLoop()
-	go w.receiveLoop()
-
-	return nil
+// VietOCRWorker - Go worker for VietOCR; recognition is done by a pluggable
+// OCREngine
+type VietOCRWorker struct {
+	sdk      *workersdk.WorkerSDK
+	workerID string
+	engine   OCREngine
+
+	// MaxBatchSize rejects larger ocr_batch requests; BatchConcurrency is
+	// how many images of a batch are processed at once
+	MaxBatchSize     int
+	BatchConcurrency int
 }
 
-// sendRegistration to Hub
-func (w *VietOCRWorker) sendRegistration() error {
-	capabilities := []map[string]interface{}{
-		{
-			"name":            "ocr_detect",
-			"description":     "OCR nhận diện text từ ảnh (Vietnamese + English) - Go Worker",
-			"input_schema":    `{"type":"object","properties":{"image":{"type":"string"}},"required":["image"]}`,
-			"output_schema":   `{"type":"object","properties":{"text":{"type":"string"},"confidence":{"type":"number"},"processing_time_ms":{"type":"number"}}}`,
-			"http_method":     "POST",
-			"accepts_file":    true,
-			"file_field_name": "image",
-		},
-		{
-			"name":          "ocr_batch",
-			"description":   "Batch OCR processing - Go Worker",
-			"input_schema":  `{"type":"object","properties":{"images":{"type":"array","items":{"type":"string"}}},"required":["images"]}`,
-			"output_schema": `{"type":"object","properties":{"results":{"type":"array"},"total_processing_time_ms":{"type":"number"}}}`,
-			"http_method":   "POST",
-			"accepts_file":  false,
-		},
-	}
-
-	regData := map[string]interface{}{
-		"worker_id":    w.workerID,
-		"worker_type":  "go-vietocr",
-		"capabilities": capabilities,
-		"metadata": map[string]string{
-			"version":     "1.0.0",
-			"description": "VietOCR Worker - Go (high performance)",
-			"language":    "Vietnamese + English",
-			"engine":      "ONNX Runtime",
-		},
-	}
-
-	contentBytes, _ := json.Marshal(regData)
-
-	msg := &pb.Message{
-		Id:        fmt.Sprintf("register-%d", time.Now().UnixNano()),
-		From:      w.workerID,
-		To:        "hub",
-		Channel:   "system",
-		Content:   string(contentBytes),
-		Timestamp: time.Now().Format(time.RFC3339),
-		Type:      pb.MessageType_REGISTER,
+// NewVietOCRWorker creates a VietOCR worker on the worker SDK, recognizing
+// text with the OCR engine registered as engineName. hubAddress may list
+// several hubs separated by commas for failover.
+func NewVietOCRWorker(workerID, hubAddress, engineName string) (*VietOCRWorker, error) {
+	engine, err := newEngine(engineName)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := w.stream.Send(msg); err != nil {
-		return fmt.Errorf("failed to send registration: %w", err)
+	sdk := workersdk.NewWorkerSDK(workerID, hubAddress, "go-vietocr")
+	sdk.SetMetadata(map[string]string{
+		"description": "VietOCR Worker - Go (high performance)",
+		"language":    "Vietnamese + English",
+		"engine":      engineName,
+	})
+
+	worker := &VietOCRWorker{
+		sdk:              sdk,
+		workerID:         workerID,
+		engine:           engine,
+		MaxBatchSize:     DefaultMaxBatchSize,
+		BatchConcurrency: runtime.NumCPU(),
 	}
+	worker.registerCapabilities()
 
-	log.Println("📤 Sent registration")
-	return nil
-}
-
-func (w *VietOCRWorker) sendLoop() {
-	for msg := range w.sendQueue {
-		if err := w.stream.Send(msg); err != nil {
-			log.Printf("✗ Failed to send message: %v", err)
-			return
-		}
-	}
-}
-
-func (w *VietOCRWorker) receiveLoop() {
-	log.Println("📨 Listening for OCR requests...")
-
-	for {
-		msg, err := w.stream.Recv()
-		if err != nil {
-			log.Printf("✗ Stream error: %v", err)
-			w.running = false
-			return
-		}
-
-		go w.handleMessage(msg)
-	}
+	return worker, nil
 }
 
-func (w *VietOCRWorker) handleMessage(msg *pb.Message) {
-	log.Printf("📬 Received message:")
-	log.Printf("   ID: %s", msg.Id)
-	log.Printf("   From: %s", msg.From)
-	log.Printf("   Type: %s", msg.Type)
-	log.Printf("   Channel: %s", msg.Channel)
-
-	var responseContent string
-
-	// Get capability
-	capability := msg.Channel
-	if cap, ok := msg.Metadata["capability"]; ok {
-		capability = cap
-	}
-
-	switch capability {
-	case "ocr_detect":
-		responseContent = w.handleOCRDetect(msg)
-	case "ocr_batch":
-		responseContent = w.handleOCRBatch(msg)
-	default:
-		responseContent = fmt.Sprintf(`{"status":"error","error":"Unknown capability: %s"}`, capability)
-	}
-
-	// Send response
-	response := &pb.Message{
-		Id:        fmt.Sprintf("resp-%d", time.Now().UnixNano()),
-		From:      w.workerID,
-		To:        msg.From,
-		Channel:   msg.Channel,
-		Content:   responseContent,
-		Timestamp: time.Now().Format(time.RFC3339),
-		Type:      pb.MessageType_RESPONSE,
-	}
-
-	w.sendQueue <- response
-	log.Println("   ✓ Queued response")
+func (w *VietOCRWorker) registerCapabilities() {
+	w.sdk.AddCapability(&workersdk.Capability{
+		Name:          "ocr_detect",
+		Description:   "OCR nhận diện text từ ảnh (Vietnamese + English) - Go Worker",
+		InputSchema:   `{"type":"object","properties":{"image":{"type":"string"}},"required":["image"]}`,
+		OutputSchema:  `{"type":"object","properties":{"text":{"type":"string"},"confidence":{"type":"number"},"processing_time_ms":{"type":"number"},"image":{"type":"object","properties":{"format":{"type":"string"},"width":{"type":"integer"},"height":{"type":"integer"}}}}}`,
+		HTTPMethod:    "POST",
+		AcceptsFile:   true,
+		FileFieldName: "image",
+	}, w.handleOCRDetect)
+
+	w.sdk.AddProgressCapability(&workersdk.Capability{
+		Name:         "ocr_batch",
+		Description:  "Batch OCR processing - Go Worker",
+		InputSchema:  `{"type":"object","properties":{"images":{"type":"array","items":{"type":"string"}}},"required":["images"]}`,
+		OutputSchema: `{"type":"object","properties":{"results":{"type":"array"},"total_processing_time_ms":{"type":"number"}}}`,
+		HTTPMethod:   "POST",
+		AcceptsFile:  false,
+		// Batches take minutes; callers without a timeout wait this long
+		DefaultTimeoutMs: 180000,
+	}, w.handleOCRBatch)
 }
 
-func (w *VietOCRWorker) handleOCRDetect(msg *pb.Message) string {
+// handleOCRDetect recognizes the text of one image. The image must be a
+// png, jpeg or webp; its format and dimensions are returned with the text.
+func (w *VietOCRWorker) handleOCRDetect(params map[string]interface{}) (map[string]interface{}, error) {
 	log.Println("  → Processing OCR detect")
 
 	start := time.Now()
 
-	// Parse request
-	var req struct {
-		Image string `json:"image"`
-	}
-
-	if err := json.Unmarshal([]byte(msg.Content), &req); err != nil {
-		return fmt.Sprintf(`{"status":"error","error":"Invalid request: %v"}`, err)
+	encoded, err := workersdk.Params(params).RequireString("image")
+	if err != nil {
+		return nil, err
 	}
 
 	// Decode base64 image
-	_, err := base64.StdEncoding.DecodeString(req.Image)
+	image, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
-		return fmt.Sprintf(`{"status":"error","error":"Invalid base64: %v"}`, err)
+		return nil, apierr.Newf(apierr.CodeValidation, "Invalid base64: %v", err)
 	}
 
-	// TODO: Call ONNX inference (for now, demo response)
-	text := "Văn bản tiếng Việt từ Go Worker"
-	confidence := 0.93
+	// Reject corrupt and unsupported images before inference
+	img, info, apiErr := decodeImage(image)
+	if apiErr != nil {
+		log.Printf("  ✗ Rejected image: %s", apiErr.Message)
+		return nil, apiErr
+	}
+
+	text, confidence, err := w.engine.Detect(img)
+	if err != nil {
+		log.Printf("  ✗ OCR failed: %v", err)
+		return nil, apierr.Newf(apierr.CodeExecution, "OCR failed: %v", err)
+	}
 
 	processingTime := time.Since(start).Milliseconds()
+	log.Printf("  ✓ OCR result: '%s' (conf: %.3f, %s %dx%d, time: %dms)", text, confidence, info.Format, info.Width, info.Height, processingTime)
 
-	result := map[string]interface{}{
+	return map[string]interface{}{
 		"text":               text,
 		"confidence":         confidence,
+		"image":              info,
 		"processing_time_ms": processingTime,
 		"worker_id":          w.workerID,
 		"status":             "success",
-	}
-
-	resultBytes, _ := json.Marshal(result)
-	log.Printf("  ✓ OCR result: '%s' (conf: %.3f, time: %dms)", text, confidence, processingTime)
-
-	return string(resultBytes)
+	}, nil
 }
 
-func (w *VietOCRWorker) handleOCRBatch(msg *pb.Message) string {
+// handleOCRBatch processes the images of a batch with at most
+// BatchConcurrency at a time. Batches above MaxBatchSize are rejected.
+// An image that is not valid base64 or not a supported image gets an
+// error result instead of failing the batch. Streamed requests receive progress messages.
+func (w *VietOCRWorker) handleOCRBatch(params map[string]interface{}, progress workersdk.ProgressFunc) (map[string]interface{}, error) {
 	log.Println("  → Processing OCR batch")
 
 	start := time.Now()
 
-	// Parse request
-	var req struct {
-		Images []string `json:"images"`
+	images, ok := params["images"].([]interface{})
+	if !ok {
+		return nil, apierr.New(apierr.CodeValidation, "images must be an array of base64 strings")
 	}
 
-	if err := json.Unmarshal([]byte(msg.Content), &req); err != nil {
-		return fmt.Sprintf(`{"status":"error","error":"Invalid request: %v"}`, err)
+	total := len(images)
+	if w.MaxBatchSize > 0 && total > w.MaxBatchSize {
+		log.Printf("  ✗ Batch of %d images exceeds %d", total, w.MaxBatchSize)
+		return nil, apierr.Newf(apierr.CodeValidation, "Batch has %d images, at most %d are allowed", total, w.MaxBatchSize).
+			WithDetail("max_batch_size", w.MaxBatchSize).
+			WithDetail("images", total)
 	}
 
-	log.Printf("  📦 Processing %d images...", len(req.Images))
+	concurrency := w.BatchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > total {
+		concurrency = total
+	}
+	log.Printf("  📦 Processing %d images (%d at a time)...", total, concurrency)
 
-	results := make([]map[string]interface{}, len(req.Images))
-	for i := range req.Images {
-		// Process each image
-		results[i] = map[string]interface{}{
-			"text":       fmt.Sprintf("Text %d từ Go", i+1),
-			"confidence": 0.90 + float64(i)*0.01,
-			"index":      i,
-		}
+	step := int64(total / progressSteps)
+	if step < 1 {
+		step = 1
 	}
 
+	results := make([]map[string]interface{}, total)
+	var processed, failed int64
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = w.ocrBatchImage(i, images[i])
+				if results[i]["status"] != "success" {
+					atomic.AddInt64(&failed, 1)
+				}
+				if done := atomic.AddInt64(&processed, 1); done%step == 0 || done == int64(total) {
+					progress(map[string]interface{}{
+						"processed": done,
+						"total":     total,
+						"failed":    atomic.LoadInt64(&failed),
+					})
+				}
+			}
+		}()
+	}
+	for i := range images {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
 	processingTime := time.Since(start).Milliseconds()
+	log.Printf("  ✓ Batch complete: %d images (%d failed) in %dms", total, failed, processingTime)
 
-	result := map[string]interface{}{
+	return map[string]interface{}{
 		"results":                  results,
-		"total_images":             len(req.Images),
-		"successful":               len(results),
+		"total_images":             total,
+		"successful":               int64(total) - failed,
+		"failed":                   failed,
 		"total_processing_time_ms": processingTime,
 		"worker_id":                w.workerID,
 		"status":                   "success",
-	}
-
-	resultBytes, _ := json.Marshal(result)
-	log.Printf("  ✓ Batch complete: %d images in %dms", len(results), processingTime)
-
-	return string(resultBytes)
+	}, nil
 }
 
-func (w *VietOCRWorker) Close() error {
-	w.running = false
-	close(w.sendQueue)
+// ocrBatchImage is the result of one image of a batch
+func (w *VietOCRWorker) ocrBatchImage(index int, item interface{}) map[string]interface{} {
+	encoded, ok := item.(string)
+	if !ok {
+		return map[string]interface{}{
+			"index":  index,
+			"status": "error",
+			"error":  "image is not a base64 string",
+		}
+	}
+	image, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return map[string]interface{}{
+			"index":  index,
+			"status": "error",
+			"error":  fmt.Sprintf("invalid base64: %v", err),
+		}
+	}
+	img, info, apiErr := decodeImage(image)
+	if apiErr != nil {
+		return map[string]interface{}{
+			"index":  index,
+			"status": "error",
+			"error":  apiErr.Message,
+			"reason": apiErr.Details["reason"],
+		}
+	}
 
-	if w.conn != nil {
-		return w.conn.Close()
+	text, confidence, err := w.engine.Detect(img)
+	if err != nil {
+		return map[string]interface{}{
+			"index":  index,
+			"status": "error",
+			"error":  fmt.Sprintf("OCR failed: %v", err),
+		}
+	}
+	return map[string]interface{}{
+		"index":      index,
+		"status":     "success",
+		"text":       text,
+		"confidence": confidence,
+		"image":      info,
 	}
+}
+
+// Run connects to the hub and serves requests until Stop
+func (w *VietOCRWorker) Run() error {
+	return w.sdk.Run()
+}
 
-	return nil
+// Stop disconnects from the hub
+func (w *VietOCRWorker) Stop() {
+	w.sdk.Stop()
 }
 
 func main() {
@@ -301,7 +278,23 @@ func main() {
 
 	hubAddress := flag.String("hub-address",
 		getEnv("HUB_ADDRESS", "localhost:50051"),
-		"Hub address")
+		"Hub address (comma-separated list for failover)")
+
+	engineName := flag.String("engine",
+		getEnv("OCR_ENGINE", DefaultEngine),
+		"OCR engine")
+
+	maxConcurrency := flag.Int("max-concurrency",
+		getEnvInt("OCR_MAX_CONCURRENCY", DefaultMaxConcurrency),
+		"OCR requests handled at once")
+
+	maxBatchSize := flag.Int("max-batch-size",
+		getEnvInt("OCR_MAX_BATCH_SIZE", DefaultMaxBatchSize),
+		"Most images accepted by ocr_batch")
+
+	batchConcurrency := flag.Int("batch-concurrency",
+		getEnvInt("OCR_BATCH_CONCURRENCY", runtime.NumCPU()),
+		"Images of a batch processed at once")
 
 	flag.Parse()
 
@@ -310,28 +303,35 @@ func main() {
 	log.Println(strings.Repeat("=", 60))
 	log.Printf("Worker ID: %s", *workerID)
 	log.Printf("Hub Address: %s", *hubAddress)
+	log.Printf("Engine: %s", *engineName)
+	log.Printf("Concurrency: %d requests", *maxConcurrency)
+	log.Printf("Batch: at most %d images, %d at a time", *maxBatchSize, *batchConcurrency)
 	log.Println(strings.Repeat("=", 60))
 
 	// Create worker
-	worker, err := NewVietOCRWorker(*workerID, *hubAddress)
+	worker, err := NewVietOCRWorker(*workerID, *hubAddress, *engineName)
 	if err != nil {
 		log.Fatalf("Failed to create worker: %v", err)
 	}
-	defer worker.Close()
-
-	// Connect to Hub
-	if err := worker.Connect(); err != nil {
-		log.Fatalf("Failed to connect: %v", err)
-	}
+	worker.MaxBatchSize = *maxBatchSize
+	worker.BatchConcurrency = *batchConcurrency
+	worker.sdk.SetMaxConcurrency(*maxConcurrency)
 
-	// Wait for shutdown
+	// Handle signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	log.Println("\nWorker running. Press Ctrl+C to stop.")
-	<-sigChan
+	go func() {
+		<-sigChan
+		log.Println("\n✗ Shutting down...")
+		worker.Stop()
+		os.Exit(0)
+	}()
 
-	log.Println("\n✗ Shutting down...")
+	// Run worker
+	if err := worker.Run(); err != nil {
+		log.Fatalf("Worker error: %v", err)
+	}
 }
 
 func getEnv(key, defaultValue string) string {
@@ -340,3 +340,12 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
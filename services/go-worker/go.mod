@@ -2,7 +2,13 @@ module github.com/deepapp/go-worker
 
 go 1.18
 
-require google.golang.org/grpc v1.59.0
+require (
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/jmespath/go-jmespath v0.4.0
+	golang.org/x/crypto v0.14.0
+	google.golang.org/grpc v1.59.0
+	google.golang.org/protobuf v1.31.0
+)
 
 require (
 	github.com/golang/protobuf v1.5.3 // indirect
@@ -10,5 +16,4 @@ require (
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20231016165738-49dd2c1f3d0b // indirect
-	google.golang.org/protobuf v1.31.0 // indirect
 )
@@ -18,11 +18,15 @@ func main() {
 
 	// Initialize GRPC worker
 	worker := NewGRPCWorker("go-worker", hubAddress)
+	worker.SetAuthToken(os.Getenv("HUB_AUTH_TOKEN"))
 
 	// Register plugins
 	worker.RegisterPlugin(plugins.NewHelloGoPlugin())
 	worker.RegisterPlugin(plugins.NewHashTextPlugin())
 	worker.RegisterPlugin(plugins.NewBase64OpsPlugin())
+	worker.RegisterPlugin(plugins.NewJSONTransformPlugin())
+	worker.RegisterPlugin(plugins.NewTemplatePlugin())
+	worker.RegisterPlugin(plugins.NewUUIDPlugin())
 	worker.RegisterPlugin(plugins.NewGoCompositePlugin())
 
 	fmt.Printf("✅ Loaded %d plugins\n", len(worker.plugins))
@@ -32,6 +36,16 @@ func main() {
 		log.Fatalf("Failed to connect to Hub: %v", err)
 	}
 
+	// Hot-load .so plugins dropped into PLUGIN_DIR
+	if pluginDir := os.Getenv("PLUGIN_DIR"); pluginDir != "" {
+		loader := plugins.NewLoader(pluginDir, worker)
+		if err := loader.Start(); err != nil {
+			log.Printf("⚠️  Plugin loader disabled: %v", err)
+		} else {
+			defer loader.Close()
+		}
+	}
+
 	fmt.Println("✅ Worker registered with Hub")
 	fmt.Println("🚀 Go Worker is running!")
 
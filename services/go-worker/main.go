@@ -1,13 +1,16 @@
 package main
 
 import (
-	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"deepapp_golang_grpc_hub/pkg/apierr"
 	workersdk "deepapp_golang_grpc_hub/shared/worker-sdk/go"
 )
 
@@ -38,11 +41,12 @@ func (w *GoWorker) registerCapabilities() {
 	// Calculate capability
 	w.sdk.AddCapability(&workersdk.Capability{
 		Name:         "calculate",
-		Description:  "Performs calculations",
-		InputSchema:  `{"type":"object","properties":{"operation":{"type":"string"},"a":{"type":"number"},"b":{"type":"number"}}}`,
+		Description:  "Performs calculations: add, subtract, multiply, divide, modulo or power",
+		InputSchema:  `{"type":"object","required":["operation","a","b"],"properties":{"operation":{"type":"string","enum":["add","subtract","multiply","divide","modulo","power"]},"a":{"type":["number","string"]},"b":{"type":["number","string"]}}}`,
 		OutputSchema: `{"type":"object","properties":{"result":{"type":"number"}}}`,
 		HTTPMethod:   "POST",
 		AcceptsFile:  false,
+		Example:      `{"operation":"add","a":2,"b":3}`,
 	}, w.handleCalculate)
 	
 	// Composite task - calls Java worker
@@ -53,6 +57,7 @@ func (w *GoWorker) registerCapabilities() {
 		OutputSchema: `{"type":"object"}`,
 		HTTPMethod:   "POST",
 		AcceptsFile:  false,
+		Example:      `{"file_path":"/tmp/example.txt"}`,
 	}, w.handleComposite)
 }
 
@@ -65,10 +70,24 @@ func (w *GoWorker) handleHello(params map[string]interface{}) (map[string]interf
 	}, nil
 }
 
+// handleCalculate applies operation to a and b. Operands are numbers or
+// integer strings ("42"); missing or malformed operands, division or
+// modulo by zero and results that are not finite (overflow, NaN) are
+// VALIDATION errors.
 func (w *GoWorker) handleCalculate(params map[string]interface{}) (map[string]interface{}, error) {
-	operation, _ := params["operation"].(string)
-	a, _ := params["a"].(float64)
-	b, _ := params["b"].(float64)
+	p := workersdk.Params(params)
+	operation, err := p.RequireString("operation")
+	if err != nil {
+		return nil, err
+	}
+	a, err := operand(p, "a")
+	if err != nil {
+		return nil, err
+	}
+	b, err := operand(p, "b")
+	if err != nil {
+		return nil, err
+	}
 	
 	var result float64
 	switch operation {
@@ -80,11 +99,26 @@ func (w *GoWorker) handleCalculate(params map[string]interface{}) (map[string]in
 		result = a * b
 	case "divide":
 		if b == 0 {
-			return nil, fmt.Errorf("division by zero")
+			return nil, apierr.New(apierr.CodeValidation, "division by zero").WithDetail("param", "b")
 		}
 		result = a / b
+	case "modulo":
+		if b == 0 {
+			return nil, apierr.New(apierr.CodeValidation, "modulo by zero").WithDetail("param", "b")
+		}
+		result = math.Mod(a, b)
+	case "power":
+		result = math.Pow(a, b)
 	default:
-		return nil, fmt.Errorf("unknown operation: %s", operation)
+		return nil, apierr.Newf(apierr.CodeValidation, "unknown operation %q (use %s)", operation, strings.Join(calculateOperations, ", ")).
+			WithDetail("param", "operation")
+	}
+	
+	if math.IsInf(result, 0) {
+		return nil, apierr.Newf(apierr.CodeValidation, "%s of %v and %v overflows", operation, a, b)
+	}
+	if math.IsNaN(result) {
+		return nil, apierr.Newf(apierr.CodeValidation, "%s of %v and %v is not a number", operation, a, b)
 	}
 	
 	return map[string]interface{}{
@@ -94,8 +128,33 @@ func (w *GoWorker) handleCalculate(params map[string]interface{}) (map[string]in
 	}, nil
 }
 
+// calculateOperations are the operations handleCalculate supports
+var calculateOperations = []string{"add", "subtract", "multiply", "divide", "modulo", "power"}
+
+// operand reads a finite number, or an integer string such as "42", from
+// params[key]
+func operand(p workersdk.Params, key string) (float64, error) {
+	if text, ok := p.GetString(key); ok {
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return 0, apierr.Newf(apierr.CodeValidation, "parameter %q must be a number or an integer string, got %q", key, text).
+				WithDetail("param", key)
+		}
+		return float64(n), nil
+	}
+	value, err := p.RequireFloat(key)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, apierr.Newf(apierr.CodeValidation, "parameter %q must be a finite number", key).
+			WithDetail("param", key)
+	}
+	return value, nil
+}
+
 func (w *GoWorker) handleComposite(params map[string]interface{}) (map[string]interface{}, error) {
-	filePath, _ := params["file_path"].(string)
+	filePath, _ := workersdk.Params(params).GetString("file_path")
 	if filePath == "" {
 		filePath = "/tmp/test.txt"
 	}
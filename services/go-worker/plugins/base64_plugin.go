@@ -3,8 +3,21 @@ package plugins
 import (
 	"encoding/base64"
 	"fmt"
+	"strings"
+	"unicode"
 )
 
+// base64Variants maps variant names to encodings
+var base64Variants = map[string]*base64.Encoding{
+	"std":     base64.StdEncoding,
+	"url":     base64.URLEncoding,
+	"raw_std": base64.RawStdEncoding,
+	"raw_url": base64.RawURLEncoding,
+}
+
+// base64VariantOrder is the order variants are tried when decoding without one
+var base64VariantOrder = []string{"std", "url", "raw_std", "raw_url"}
+
 // Base64Plugin encodes/decodes base64
 type Base64Plugin struct {
 	BasePlugin
@@ -20,7 +33,15 @@ func (p *Base64Plugin) GetName() string {
 }
 
 func (p *Base64Plugin) GetDescription() string {
-	return "Encode or decode base64 strings"
+	return "Encode or decode base64 strings (std, url, raw_std, raw_url variants)"
+}
+
+func (p *Base64Plugin) GetTags() []string {
+	return []string{"text", "encoding"}
+}
+
+func (p *Base64Plugin) GetExample() string {
+	return `{"text":"hello world","operation":"encode"}`
 }
 
 func (p *Base64Plugin) Execute(params map[string]interface{}, context *ExecutionContext) (interface{}, error) {
@@ -34,30 +55,93 @@ func (p *Base64Plugin) Execute(params map[string]interface{}, context *Execution
 		operation = op
 	}
 
+	variant, _ := params["variant"].(string)
+	variant = strings.ToLower(variant)
+	if variant != "" {
+		if _, ok := base64Variants[variant]; !ok {
+			return nil, fmt.Errorf("unsupported variant: %s (supported: %s)",
+				variant, strings.Join(base64VariantOrder, ", "))
+		}
+	}
+
 	var result string
-	var err error
 
 	switch operation {
 	case "encode":
-		result = base64.StdEncoding.EncodeToString([]byte(text))
+		if variant == "" {
+			variant = "std"
+		}
+		result = base64Variants[variant].EncodeToString([]byte(text))
+
+		if wrap, ok := params["wrap"].(float64); ok {
+			if wrap < 0 {
+				return nil, fmt.Errorf("wrap must be a positive number of characters")
+			}
+			result = wrapLines(result, int(wrap))
+		}
 	case "decode":
-		decoded, decodeErr := base64.StdEncoding.DecodeString(text)
-		if decodeErr != nil {
-			return nil, fmt.Errorf("decode error: %v", decodeErr)
+		decoded, usedVariant, err := decodeBase64(text, variant)
+		if err != nil {
+			return nil, err
 		}
+		variant = usedVariant
 		result = string(decoded)
 	default:
 		return nil, fmt.Errorf("unsupported operation: %s", operation)
 	}
 
-	if err != nil {
-		return nil, err
-	}
-
 	return map[string]interface{}{
 		"input":     text,
 		"operation": operation,
+		"variant":   variant,
 		"result":    result,
 		"status":    "success",
 	}, nil
 }
+
+// decodeBase64 decodes text with the given variant, or tries every variant
+// when none is given. Whitespace (e.g. PEM-style line breaks) is ignored.
+func decodeBase64(text, variant string) ([]byte, string, error) {
+	compact := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	if variant != "" {
+		decoded, err := base64Variants[variant].DecodeString(compact)
+		if err != nil {
+			return nil, "", fmt.Errorf("input is not valid %s base64: %v", variant, err)
+		}
+		return decoded, variant, nil
+	}
+
+	for _, name := range base64VariantOrder {
+		if decoded, err := base64Variants[name].DecodeString(compact); err == nil {
+			return decoded, name, nil
+		}
+	}
+	return nil, "", fmt.Errorf("input is not valid base64 in any variant (%s)",
+		strings.Join(base64VariantOrder, ", "))
+}
+
+// wrapLines inserts a newline every width characters (0 disables wrapping)
+func wrapLines(s string, width int) string {
+	if width <= 0 || len(s) <= width {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i += width {
+		end := i + width
+		if end > len(s) {
+			end = len(s)
+		}
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(s[i:end])
+	}
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package plugins
+
+import (
+	"strings"
+	"testing"
+)
+
+// base64Sample encodes to output using both '+' and '/' (std) or '-' and
+// '_' (url), and needs padding
+const base64Sample = "subjects?_d>>~ xin chào!"
+
+func runBase64(t *testing.T, params map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	result, err := NewBase64OpsPlugin().Execute(params, nil)
+	if err != nil {
+		t.Fatalf("%v: %v", params, err)
+	}
+	return result.(map[string]interface{})
+}
+
+func TestBase64VariantRoundTrip(t *testing.T) {
+	for _, variant := range base64VariantOrder {
+		t.Run(variant, func(t *testing.T) {
+			encoded := runBase64(t, map[string]interface{}{"text": base64Sample, "operation": "encode", "variant": variant})["result"].(string)
+			if want := base64Variants[variant].EncodeToString([]byte(base64Sample)); encoded != want {
+				t.Fatalf("encoded %q, want %q", encoded, want)
+			}
+
+			decoded := runBase64(t, map[string]interface{}{"text": encoded, "operation": "decode", "variant": variant})
+			if decoded["result"] != base64Sample {
+				t.Fatalf("decoded %q", decoded["result"])
+			}
+
+			// Without a variant the decoder finds the one used
+			detected := runBase64(t, map[string]interface{}{"text": encoded, "operation": "decode"})
+			if detected["result"] != base64Sample {
+				t.Fatalf("decoded %q without a variant", detected["result"])
+			}
+		})
+	}
+}
+
+func TestBase64WrapAndWhitespace(t *testing.T) {
+	text := strings.Repeat(base64Sample, 5)
+	encoded := runBase64(t, map[string]interface{}{"text": text, "operation": "encode", "wrap": float64(64)})["result"].(string)
+
+	lines := strings.Split(encoded, "\n")
+	if len(lines) < 2 {
+		t.Fatalf("%d characters not wrapped at 64", len(encoded))
+	}
+	for i, line := range lines {
+		if len(line) > 64 || (i < len(lines)-1 && len(line) != 64) {
+			t.Fatalf("line %d is %d characters", i, len(line))
+		}
+	}
+
+	wrapped := " " + strings.ReplaceAll(encoded, "\n", "\r\n\t") + "\n"
+	if decoded := runBase64(t, map[string]interface{}{"text": wrapped, "operation": "decode", "variant": "std"}); decoded["result"] != text {
+		t.Fatalf("decoding wrapped input gave %q", decoded["result"])
+	}
+}
+
+func TestBase64InvalidInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		message string
+	}{
+		{"url input as std", map[string]interface{}{"text": "c3ViamVjdHM_X2Q-", "operation": "decode", "variant": "std"}, "not valid std base64"},
+		{"padding in raw", map[string]interface{}{"text": "aGk=", "operation": "decode", "variant": "raw_std"}, "not valid raw_std base64"},
+		{"not base64", map[string]interface{}{"text": "!!!", "operation": "decode"}, "not valid base64 in any variant"},
+		{"unknown variant", map[string]interface{}{"text": "aGk=", "operation": "decode", "variant": "mime"}, "unsupported variant: mime"},
+		{"negative wrap", map[string]interface{}{"text": "hi", "operation": "encode", "wrap": float64(-1)}, "wrap must be"},
+		{"unknown operation", map[string]interface{}{"text": "hi", "operation": "rot13"}, "unsupported operation"},
+		{"no text", map[string]interface{}{"operation": "encode"}, "missing required parameter"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewBase64OpsPlugin().Execute(tc.params, nil)
+			if err == nil || !strings.Contains(err.Error(), tc.message) {
+				t.Fatalf("got error %v, want one containing %q", err, tc.message)
+			}
+		})
+	}
+}
@@ -20,6 +20,10 @@ func (p *GoCompositePlugin) GetDescription() string {
 	return "Composite operation: hash + base64 encode"
 }
 
+func (p *GoCompositePlugin) GetExample() string {
+	return `{"text":"hello world","template":"{{.operation}} on {{.input}}"}`
+}
+
 func (p *GoCompositePlugin) Execute(params map[string]interface{}, context *ExecutionContext) (interface{}, error) {
 	text, ok := params["text"].(string)
 	if !ok || text == "" {
@@ -29,11 +33,22 @@ func (p *GoCompositePlugin) Execute(params map[string]interface{}, context *Exec
 	// This is a composite operation that combines hash and base64
 	// In a real scenario, this could call other workers
 
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"input":     text,
 		"operation": "composite (hash + base64)",
 		"message":   fmt.Sprintf("Composite operation on: %s", text),
 		"worker_id": context.WorkerID,
 		"status":    "success",
-	}, nil
+	}
+
+	// Optional text/template rendered against the combined result, e.g.
+	// "{{.operation}} on {{.input}}"
+	if format, ok := params["template"].(string); ok && format != "" {
+		formatted, err := RenderTemplate(format, result, false)
+		if err != nil {
+			return nil, err
+		}
+		result["formatted"] = formatted
+	}
+	return result, nil
 }
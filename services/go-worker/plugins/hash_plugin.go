@@ -2,11 +2,41 @@ package plugins
 
 import (
 	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
 	"strings"
+
+	"golang.org/x/crypto/sha3"
 )
 
+// hashAlgorithms maps algorithm names to hash constructors
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":      md5.New,
+	"sha1":     sha1.New,
+	"sha256":   sha256.New,
+	"sha512":   sha512.New,
+	"sha3-256": sha3.New256,
+	"crc32":    func() hash.Hash { return crc32.NewIEEE() },
+}
+
+// SupportedHashAlgorithms returns the algorithm names HashPlugin accepts
+func SupportedHashAlgorithms() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // HashPlugin computes various hash functions
 type HashPlugin struct {
 	BasePlugin
@@ -22,34 +52,129 @@ func (p *HashPlugin) GetName() string {
 }
 
 func (p *HashPlugin) GetDescription() string {
-	return "Compute hash (MD5, SHA256) of text"
+	return "Compute hash (MD5, SHA1, SHA256, SHA512, SHA3-256, CRC32) of text, base64 data or a hub file_id"
+}
+
+func (p *HashPlugin) GetTags() []string {
+	return []string{"text", "crypto"}
+}
+
+func (p *HashPlugin) GetExample() string {
+	return `{"text":"hello world","algorithm":"sha256"}`
+}
+
+// CacheTTLSeconds marks hash_text as cacheable: the same input always
+// hashes the same
+func (p *HashPlugin) CacheTTLSeconds() int64 {
+	return 600
 }
 
 func (p *HashPlugin) Execute(params map[string]interface{}, context *ExecutionContext) (interface{}, error) {
-	text, ok := params["text"].(string)
-	if !ok || text == "" {
-		return nil, fmt.Errorf("missing required parameter: text")
+	// Large files are streamed from the hub instead of sent inline
+	if fileID, ok := params["file_id"].(string); ok && fileID != "" {
+		return p.hashFile(fileID, params, context)
 	}
 
-	algorithm := "sha256"
-	if alg, ok := params["algorithm"].(string); ok {
-		algorithm = strings.ToLower(alg)
+	// Input is either plain text or base64-encoded bytes in "data"
+	var input []byte
+	text, hasText := params["text"].(string)
+	data, hasData := params["data"].(string)
+	switch {
+	case hasData && data != "":
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 in data: %v", err)
+		}
+		input = decoded
+	case hasText && text != "":
+		input = []byte(text)
+	default:
+		return nil, fmt.Errorf("missing required parameter: text or data")
 	}
 
-	var hash string
-	switch algorithm {
-	case "md5":
-		hash = fmt.Sprintf("%x", md5.Sum([]byte(text)))
-	case "sha256":
-		hash = fmt.Sprintf("%x", sha256.Sum256([]byte(text)))
-	default:
-		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
+	algorithm, newHash, encoding, err := hashOptions(params)
+	if err != nil {
+		return nil, err
 	}
 
-	return map[string]interface{}{
-		"text":      text,
+	h := newHash()
+	h.Write(input)
+	digest := encodeDigest(h.Sum(nil), encoding)
+
+	result := map[string]interface{}{
 		"algorithm": algorithm,
-		"hash":      hash,
+		"encoding":  encoding,
+		"hash":      digest,
+		"size":      len(input),
 		"status":    "success",
+	}
+	if hasText && !hasData {
+		result["text"] = text
+	}
+	return result, nil
+}
+
+// hashFile streams a hub-stored file through the hash without buffering it
+func (p *HashPlugin) hashFile(fileID string, params map[string]interface{}, context *ExecutionContext) (interface{}, error) {
+	if context == nil || context.OpenFile == nil {
+		return nil, fmt.Errorf("file_id is not supported by this worker")
+	}
+
+	algorithm, newHash, encoding, err := hashOptions(params)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := context.OpenFile(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %v", fileID, err)
+	}
+	defer reader.Close()
+
+	h := newHash()
+	n, err := io.Copy(h, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %v", fileID, err)
+	}
+
+	return map[string]interface{}{
+		"file_id":         fileID,
+		"algorithm":       algorithm,
+		"encoding":        encoding,
+		"hash":            encodeDigest(h.Sum(nil), encoding),
+		"bytes_processed": n,
+		"status":          "success",
 	}, nil
 }
+
+// hashOptions reads and validates the algorithm and encoding params
+func hashOptions(params map[string]interface{}) (string, func() hash.Hash, string, error) {
+	algorithm := "sha256"
+	if alg, ok := params["algorithm"].(string); ok {
+		algorithm = strings.ToLower(alg)
+	}
+
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return "", nil, "", fmt.Errorf("unsupported algorithm: %s (supported: %s)",
+			algorithm, strings.Join(SupportedHashAlgorithms(), ", "))
+	}
+
+	encoding := "hex"
+	if enc, ok := params["encoding"].(string); ok && enc != "" {
+		encoding = strings.ToLower(enc)
+	}
+	if encoding != "hex" && encoding != "base64" {
+		return "", nil, "", fmt.Errorf("unsupported encoding: %s (supported: hex, base64)", encoding)
+	}
+
+	return algorithm, newHash, encoding, nil
+}
+
+// encodeDigest formats a hash sum as hex or base64
+func encodeDigest(sum []byte, encoding string) string {
+	if encoding == "base64" {
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum)
+}
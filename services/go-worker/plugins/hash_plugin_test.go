@@ -0,0 +1,79 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// hashBenchSize is the size of the file hashed by the benchmarks
+const hashBenchSize = 16 << 20
+
+// fileContext serves content as every hub file
+func fileContext(content []byte) *ExecutionContext {
+	return &ExecutionContext{
+		OpenFile: func(string) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		},
+	}
+}
+
+func randomContent(size int) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(content)
+	return content
+}
+
+func TestHashFileIDMatchesInline(t *testing.T) {
+	content := randomContent(1 << 20)
+	p := NewHashTextPlugin()
+	for _, algorithm := range SupportedHashAlgorithms() {
+		streamed, err := p.Execute(map[string]interface{}{"file_id": "f1", "algorithm": algorithm}, fileContext(content))
+		if err != nil {
+			t.Fatalf("%s file_id: %v", algorithm, err)
+		}
+		inline, err := p.Execute(map[string]interface{}{"data": base64.StdEncoding.EncodeToString(content), "algorithm": algorithm}, nil)
+		if err != nil {
+			t.Fatalf("%s inline: %v", algorithm, err)
+		}
+		s, i := streamed.(map[string]interface{}), inline.(map[string]interface{})
+		if s["hash"] != i["hash"] || s["bytes_processed"] != int64(len(content)) {
+			t.Fatalf("%s: file_id gave %v over %v bytes, inline %v", algorithm, s["hash"], s["bytes_processed"], i["hash"])
+		}
+	}
+
+	if _, err := p.Execute(map[string]interface{}{"file_id": "f1"}, nil); err == nil {
+		t.Fatal("file_id accepted without OpenFile")
+	}
+}
+
+// BenchmarkHashFileID streams a hub file through the hash
+func BenchmarkHashFileID(b *testing.B) {
+	ctx := fileContext(randomContent(hashBenchSize))
+	params := map[string]interface{}{"file_id": "f1"}
+	p := NewHashTextPlugin()
+	b.SetBytes(hashBenchSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Execute(params, ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkHashInline hashes the same bytes sent inline as base64 data
+func BenchmarkHashInline(b *testing.B) {
+	params := map[string]interface{}{"data": base64.StdEncoding.EncodeToString(randomContent(hashBenchSize))}
+	p := NewHashTextPlugin()
+	b.SetBytes(hashBenchSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Execute(params, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
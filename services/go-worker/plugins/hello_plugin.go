@@ -23,6 +23,10 @@ func (p *HelloPlugin) GetDescription() string {
 	return "Returns a hello message from Go worker"
 }
 
+func (p *HelloPlugin) GetExample() string {
+	return `{"name":"Alice"}`
+}
+
 func (p *HelloPlugin) Execute(params map[string]interface{}, context *ExecutionContext) (interface{}, error) {
 	name := "World"
 	if n, ok := params["name"].(string); ok {
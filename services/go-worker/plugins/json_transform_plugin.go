@@ -0,0 +1,66 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+// JSONTransformPlugin reshapes JSON with a JMESPath expression, e.g. to
+// pick fields out of one worker's output before passing it to the next
+type JSONTransformPlugin struct {
+	BasePlugin
+}
+
+// NewJSONTransformPlugin creates a new JSONTransformPlugin instance
+func NewJSONTransformPlugin() *JSONTransformPlugin {
+	return &JSONTransformPlugin{}
+}
+
+func (p *JSONTransformPlugin) GetName() string {
+	return "json_transform"
+}
+
+func (p *JSONTransformPlugin) GetDescription() string {
+	return "Apply a JMESPath expression (e.g. \"items[?price > `10`].name | sort(@)\") to JSON data"
+}
+
+func (p *JSONTransformPlugin) GetTags() []string {
+	return []string{"json", "transform"}
+}
+
+func (p *JSONTransformPlugin) GetExample() string {
+	return "{\"data\":{\"items\":[{\"name\":\"pen\",\"price\":5},{\"name\":\"book\",\"price\":12}]},\"expression\":\"items[?price > `10`].name\"}"
+}
+
+func (p *JSONTransformPlugin) Execute(params map[string]interface{}, context *ExecutionContext) (interface{}, error) {
+	data, ok := params["data"]
+	if !ok {
+		return nil, fmt.Errorf("missing required parameter: data")
+	}
+	expression, ok := params["expression"].(string)
+	if !ok || expression == "" {
+		return nil, fmt.Errorf("missing required parameter: expression")
+	}
+
+	compiled, err := jmespath.Compile(expression)
+	if err != nil {
+		var syntaxErr jmespath.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("invalid expression %q at offset %d: %v", expression, syntaxErr.Offset, err)
+		}
+		return nil, fmt.Errorf("invalid expression %q: %v", expression, err)
+	}
+	result, err := compiled.Search(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate expression: %v", err)
+	}
+
+	return map[string]interface{}{
+		"data":       data,
+		"expression": expression,
+		"result":     result,
+		"status":     "success",
+	}, nil
+}
@@ -0,0 +1,101 @@
+package plugins
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const transformData = `{
+	"items": [
+		{"name": "pen", "price": 5, "tags": ["a", "b"]},
+		{"name": "book", "price": 12, "tags": ["c"]},
+		{"name": "bag", "price": 30, "tags": []}
+	],
+	"nums": [0, 1, 2, 3, 4, 5],
+	"nested": [[1, 2], [3, [4]]]
+}`
+
+// runTransform applies expression to transformData
+func runTransform(expression string) (interface{}, error) {
+	var data interface{}
+	json.Unmarshal([]byte(transformData), &data)
+	result, err := NewJSONTransformPlugin().Execute(map[string]interface{}{"data": data, "expression": expression}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result.(map[string]interface{})["result"], nil
+}
+
+func TestJSONTransform(t *testing.T) {
+	for _, tc := range []struct {
+		expression string
+		want       string // result as JSON
+	}{
+		// Projections
+		{"items[*].name", `["pen","book","bag"]`},
+		{"items[0].{n: name, p: price}", `{"n":"pen","p":5}`},
+		{"items[*].[name, price] | [1]", `["book",12]`},
+		{"missing.field", `null`},
+
+		// Filters
+		{"items[?price > `10`].name", `["book","bag"]`},
+		{"items[?price > `10` && name == 'bag'].price", `[30]`},
+		{"items[?!contains(tags, 'a')].name", `["book","bag"]`},
+
+		// Slices
+		{"nums[::-2]", `[5,3,1]`},
+		{"nums[4:1:-1]", `[4,3,2]`},
+		{"nums[-2:]", `[4,5]`},
+		{"items[::-1].name", `["bag","book","pen"]`},
+
+		// Flatten
+		{"items[].tags[]", `["a","b","c"]`},
+		{"nested[]", `[1,2,3,[4]]`},
+		{"nested[][]", `[1,2,3,4]`},
+
+		// Functions
+		{"length(items)", `3`},
+		{"sum(items[*].price)", `47`},
+		{"sort_by(items, &price)[-1].name", `"bag"`},
+		{"max_by(items, &price).name", `"bag"`},
+		{"join(', ', items[*].name)", `"pen, book, bag"`},
+		{"sort(keys(items[0]))", `["name","price","tags"]`},
+		{"map(&length(tags), items)", `[2,1,0]`},
+	} {
+		result, err := runTransform(tc.expression)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expression, err)
+			continue
+		}
+		if got, _ := json.Marshal(result); string(got) != tc.want {
+			t.Errorf("%s = %s, want %s", tc.expression, got, tc.want)
+		}
+	}
+}
+
+func TestJSONTransformInvalidExpression(t *testing.T) {
+	for _, tc := range []struct {
+		expression string
+		want       string // in the error
+	}{
+		{"items[?price >", "invalid expression \"items[?price >\" at offset"},
+		{"items[", "invalid expression"},
+		{"items[*].name |", "invalid expression"},
+		{"`{unclosed", "invalid expression"},
+		{"nope(items)", "unknown function"},
+		{"length()", "failed to evaluate"},
+		{"length(`5`)", "failed to evaluate"},
+	} {
+		if _, err := runTransform(tc.expression); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.expression, err, tc.want)
+		}
+	}
+
+	if _, err := NewJSONTransformPlugin().Execute(map[string]interface{}{"expression": "a"}, nil); err == nil {
+		t.Error("transform without data succeeded")
+	}
+	if _, err := NewJSONTransformPlugin().Execute(map[string]interface{}{"data": map[string]interface{}{}}, nil); err == nil {
+		t.Error("transform without an expression succeeded")
+	}
+}
@@ -0,0 +1,239 @@
+package plugins
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	goplugin "plugin"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// PluginSymbol is the symbol a .so file must export. It may be a value
+// implementing Plugin, a Plugin variable, or a func() Plugin constructor.
+const PluginSymbol = "Plugin"
+
+// Registrar receives plugins discovered by the Loader (implemented by GRPCWorker)
+type Registrar interface {
+	RegisterPluginLive(plugin Plugin) error
+	UnregisterPlugin(name string) error
+}
+
+// Loader watches a directory and registers .so plugins as they appear.
+//
+// Go cannot unload a plugin, and plugin.Open returns the plugin already
+// opened from a path however the file has changed since. A .so is therefore
+// loaded once per path: rewriting it only logs a warning, and a file removed
+// and added back registers the version loaded first. To deploy a new
+// version, restart the worker, or remove the old file and add the new build
+// under a new versioned filename (e.g. hash_v2.so).
+type Loader struct {
+	dir       string
+	registrar Registrar
+	debounce  time.Duration
+
+	mu      sync.Mutex
+	byPath  map[string]string // .so path -> plugin name
+	byName  map[string]string // plugin name -> .so path
+	opened  map[string]Plugin // every .so path opened so far
+	pending map[string]*time.Timer
+
+	watcher *fsnotify.Watcher
+}
+
+// NewLoader creates a loader for .so files in dir
+func NewLoader(dir string, registrar Registrar) *Loader {
+	return &Loader{
+		dir:       dir,
+		registrar: registrar,
+		debounce:  500 * time.Millisecond,
+		byPath:    make(map[string]string),
+		byName:    make(map[string]string),
+		opened:    make(map[string]Plugin),
+		pending:   make(map[string]*time.Timer),
+	}
+}
+
+// Start loads existing .so files and then watches the directory for changes
+func (l *Loader) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	if err := watcher.Add(l.dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", l.dir, err)
+	}
+	l.watcher = watcher
+
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		log.Printf("⚠️  Failed to read plugin dir %s: %v", l.dir, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isSharedObject(entry.Name()) {
+			l.load(filepath.Join(l.dir, entry.Name()))
+		}
+	}
+
+	go l.watch()
+
+	log.Printf("👀 Watching %s for plugins", l.dir)
+	return nil
+}
+
+// Close stops watching the directory
+func (l *Loader) Close() error {
+	if l.watcher == nil {
+		return nil
+	}
+	return l.watcher.Close()
+}
+
+func (l *Loader) watch() {
+	for {
+		select {
+		case event, ok := <-l.watcher.Events:
+			if !ok {
+				return
+			}
+			if !isSharedObject(event.Name) {
+				continue
+			}
+
+			switch {
+			case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				l.scheduleLoad(event.Name)
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				l.unload(event.Name)
+			}
+
+		case err, ok := <-l.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("⚠️  Plugin watcher error: %v", err)
+		}
+	}
+}
+
+// scheduleLoad waits for writes to settle before opening the file, since
+// copying a .so emits several events and a partial file cannot be opened
+func (l *Loader) scheduleLoad(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if timer, exists := l.pending[path]; exists {
+		timer.Stop()
+	}
+	l.pending[path] = time.AfterFunc(l.debounce, func() {
+		l.mu.Lock()
+		delete(l.pending, path)
+		l.mu.Unlock()
+		l.load(path)
+	})
+}
+
+// load opens a .so file and registers its plugin. Errors are logged and
+// the file is skipped. A path opened before is not opened again (see Loader).
+func (l *Loader) load(path string) {
+	l.mu.Lock()
+	p, reopened := l.opened[path]
+	if name, loaded := l.byPath[path]; loaded {
+		l.mu.Unlock()
+		log.Printf("⚠️  %s changed but plugin %s stays at the version loaded first: Go cannot reload a plugin, restart the worker or use a new versioned filename", filepath.Base(path), name)
+		return
+	}
+	l.mu.Unlock()
+
+	if reopened {
+		log.Printf("⚠️  %s was loaded before; registering that version, not the file on disk", filepath.Base(path))
+	} else {
+		var err error
+		if p, err = openPlugin(path); err != nil {
+			log.Printf("❌ Failed to load plugin %s: %v", path, err)
+			return
+		}
+	}
+	name := p.GetName()
+
+	l.mu.Lock()
+	l.opened[path] = p
+	if owner, exists := l.byName[name]; exists && owner != path {
+		l.mu.Unlock()
+		log.Printf("⚠️  Skipping %s: plugin %s already loaded from %s", path, name, owner)
+		return
+	}
+	if _, loaded := l.byPath[path]; loaded {
+		l.mu.Unlock()
+		return
+	}
+	l.byPath[path] = name
+	l.byName[name] = path
+	l.mu.Unlock()
+
+	if err := l.registrar.RegisterPluginLive(p); err != nil {
+		log.Printf("⚠️  Plugin %s loaded but registration failed: %v", name, err)
+		return
+	}
+	log.Printf("🔌 Loaded plugin %s from %s", name, filepath.Base(path))
+}
+
+// unload unregisters the plugin that was loaded from path. The code stays
+// mapped in the process (Go cannot unload plugins) but the capability is
+// removed from the hub.
+func (l *Loader) unload(path string) {
+	l.mu.Lock()
+	if timer, exists := l.pending[path]; exists {
+		timer.Stop()
+		delete(l.pending, path)
+	}
+	name, exists := l.byPath[path]
+	if exists {
+		delete(l.byPath, path)
+		delete(l.byName, name)
+	}
+	l.mu.Unlock()
+
+	if !exists {
+		return
+	}
+	if err := l.registrar.UnregisterPlugin(name); err != nil {
+		log.Printf("⚠️  Failed to unregister plugin %s: %v", name, err)
+	}
+}
+
+// openPlugin opens a .so file and resolves its exported Plugin symbol
+func openPlugin(path string) (Plugin, error) {
+	so, err := goplugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sym, err := so.Lookup(PluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := sym.(type) {
+	case Plugin:
+		return v, nil
+	case *Plugin:
+		if *v == nil {
+			return nil, fmt.Errorf("symbol %s is nil", PluginSymbol)
+		}
+		return *v, nil
+	case func() Plugin:
+		return v(), nil
+	default:
+		return nil, fmt.Errorf("symbol %s has type %T, which does not implement Plugin", PluginSymbol, sym)
+	}
+}
+
+func isSharedObject(name string) bool {
+	return strings.HasSuffix(name, ".so")
+}
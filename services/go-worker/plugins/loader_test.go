@@ -0,0 +1,66 @@
+package plugins
+
+import (
+	"fmt"
+	"testing"
+)
+
+// recordingRegistrar records registrations in order
+type recordingRegistrar struct {
+	events []string
+}
+
+func (r *recordingRegistrar) RegisterPluginLive(p Plugin) error {
+	r.events = append(r.events, "register "+p.GetName())
+	return nil
+}
+
+func (r *recordingRegistrar) UnregisterPlugin(name string) error {
+	r.events = append(r.events, "unregister "+name)
+	return nil
+}
+
+// A path is opened once: rewriting it keeps the plugin loaded first, and
+// adding it back after removal registers that same plugin
+func TestLoaderLoadsEachPathOnce(t *testing.T) {
+	registrar := &recordingRegistrar{}
+	l := NewLoader(t.TempDir(), registrar)
+	hello := NewHelloGoPlugin()
+	// Stands in for a .so opened earlier, which plugin.Open would return
+	// again whatever is on disk now
+	l.opened["/plugins/hello.so"] = hello
+
+	l.load("/plugins/hello.so")
+	l.load("/plugins/hello.so") // rewritten in place
+	l.unload("/plugins/hello.so")
+	l.load("/plugins/hello.so") // added back
+
+	want := fmt.Sprint([]string{"register " + hello.GetName(), "unregister " + hello.GetName(), "register " + hello.GetName()})
+	if got := fmt.Sprint(registrar.events); got != want {
+		t.Fatalf("registrar got %v, want %v", got, want)
+	}
+	if l.opened["/plugins/hello.so"] != hello {
+		t.Fatal("the plugin opened first was replaced")
+	}
+}
+
+// A second file providing a loaded plugin's name is skipped
+func TestLoaderSkipsDuplicateName(t *testing.T) {
+	registrar := &recordingRegistrar{}
+	l := NewLoader(t.TempDir(), registrar)
+	l.opened["/plugins/hello.so"] = NewHelloGoPlugin()
+	l.opened["/plugins/hello_v2.so"] = NewHelloGoPlugin()
+
+	l.load("/plugins/hello.so")
+	l.load("/plugins/hello_v2.so")
+	if len(registrar.events) != 1 {
+		t.Fatalf("registrar got %v, want only the first file registered", registrar.events)
+	}
+
+	// Removing the old file first lets the new version in
+	l.unload("/plugins/hello.so")
+	l.load("/plugins/hello_v2.so")
+	if len(registrar.events) != 3 || l.byName[NewHelloGoPlugin().GetName()] != "/plugins/hello_v2.so" {
+		t.Fatalf("registrar got %v after replacing hello.so with hello_v2.so", registrar.events)
+	}
+}
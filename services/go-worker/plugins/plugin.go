@@ -1,6 +1,9 @@
 package plugins
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"io"
+)
 
 // Plugin represents a capability that can be executed
 type Plugin interface {
@@ -23,15 +26,65 @@ type Plugin interface {
 	Execute(params map[string]interface{}, context *ExecutionContext) (interface{}, error)
 }
 
+// TaggedPlugin is implemented by plugins that group their capability
+// under tags (e.g. "text", "encoding") for filtering in discovery
+type TaggedPlugin interface {
+	GetTags() []string
+}
+
+// PluginTags returns p's tags, or nil if it is not a TaggedPlugin
+func PluginTags(p Plugin) []string {
+	if tagged, ok := p.(TaggedPlugin); ok {
+		return tagged.GetTags()
+	}
+	return nil
+}
+
+// ExamplePlugin is implemented by plugins that provide a sample request
+// (a JSON object) for Swagger UI's "Try it out"
+type ExamplePlugin interface {
+	GetExample() string
+}
+
+// PluginExample returns p's example request, or "" if it is not an
+// ExamplePlugin
+func PluginExample(p Plugin) string {
+	if example, ok := p.(ExamplePlugin); ok {
+		return example.GetExample()
+	}
+	return ""
+}
+
+// CacheablePlugin is implemented by plugins whose result depends only on
+// their params, so the hub may answer repeated requests from its result
+// cache. CacheTTLSeconds is how long a result stays valid (0 for the hub's
+// default).
+type CacheablePlugin interface {
+	CacheTTLSeconds() int64
+}
+
+// PluginCacheTTL returns p's cache TTL in seconds and whether it is a
+// CacheablePlugin
+func PluginCacheTTL(p Plugin) (int64, bool) {
+	if cacheable, ok := p.(CacheablePlugin); ok {
+		return cacheable.CacheTTLSeconds(), true
+	}
+	return 0, false
+}
+
 // ExecutionContext provides context for plugin execution
 type ExecutionContext struct {
 	WorkerID   string
 	CallWorker CallWorkerFunc
+	OpenFile   FileReaderFunc // nil when the worker cannot read hub files
 }
 
 // CallWorkerFunc is a function type for calling other workers
 type CallWorkerFunc func(targetWorkerID, capability string, params map[string]interface{}, timeout int) (map[string]interface{}, error)
 
+// FileReaderFunc opens a file stored in the hub's FileStorage for streaming reads
+type FileReaderFunc func(fileID string) (io.ReadCloser, error)
+
 // BasePlugin provides default implementations
 type BasePlugin struct{}
 
@@ -49,11 +102,13 @@ func (b *BasePlugin) GetFileFieldName() string {
 
 // Capability represents capability metadata for registration
 type Capability struct {
-	Name          string `json:"name"`
-	Description   string `json:"description"`
-	HttpMethod    string `json:"http_method"`
-	AcceptsFile   bool   `json:"accepts_file"`
-	FileFieldName string `json:"file_field_name"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description"`
+	HttpMethod    string   `json:"http_method"`
+	AcceptsFile   bool     `json:"accepts_file"`
+	FileFieldName string   `json:"file_field_name"`
+	Tags          []string `json:"tags,omitempty"`
+	Example       string   `json:"example,omitempty"`
 }
 
 // ToCapability converts a Plugin to Capability metadata
@@ -64,6 +119,8 @@ func ToCapability(p Plugin) *Capability {
 		HttpMethod:    p.GetHttpMethod(),
 		AcceptsFile:   p.AcceptsFile(),
 		FileFieldName: p.GetFileFieldName(),
+		Tags:          PluginTags(p),
+		Example:       PluginExample(p),
 	}
 }
 
@@ -0,0 +1,307 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Limits applied to every template rendered by RenderTemplate
+const (
+	MaxTemplateSize       = 64 << 10        // bytes of template source
+	MaxTemplateOutput     = 1 << 20         // bytes of rendered output
+	MaxTemplateIterations = 100000          // {{range}} iterations, nested ones included
+	MaxTemplateDuration   = 2 * time.Second // time to render
+	maxFormatWidth        = 1024            // largest width/precision printf accepts
+)
+
+// iterationFunc is called at the start of every {{range}} iteration to
+// enforce renderBudget (see budgetRanges)
+const iterationFunc = "_iteration"
+
+// formatWidth matches the width and precision of printf verbs
+var formatWidth = regexp.MustCompile(`%[-+# 0]*(\d*)(?:\.(\d+))?`)
+
+// templateFuncs are the functions available to templates in addition to
+// text/template's built-ins (printf is replaced by a bounded version)
+var templateFuncs = template.FuncMap{
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"replace": func(s, old, new string) string { return strings.ReplaceAll(s, old, new) },
+	"split":   strings.Split,
+	"join": func(items interface{}, sep string) (string, error) {
+		list, ok := items.([]interface{})
+		if !ok {
+			return "", fmt.Errorf("join: expected a list, got %T", items)
+		}
+		parts := make([]string, len(list))
+		for i, item := range list {
+			parts[i] = fmt.Sprint(item)
+		}
+		return strings.Join(parts, sep), nil
+	},
+	"default": func(def, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return def
+		}
+		return value
+	},
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"printf": func(format string, args ...interface{}) (string, error) {
+		for _, match := range formatWidth.FindAllStringSubmatch(format, -1) {
+			for _, n := range match[1:] {
+				if width, _ := strconv.Atoi(n); width > maxFormatWidth {
+					return "", fmt.Errorf("printf: width or precision %d exceeds %d", width, maxFormatWidth)
+				}
+			}
+		}
+		return fmt.Sprintf(format, args...), nil
+	},
+}
+
+// renderBudget bounds the work of one render: loops that write nothing
+// are stopped by iteration, the rest by limitedWriter
+type renderBudget struct {
+	iterations int
+	deadline   time.Time
+}
+
+// iteration counts a {{range}} iteration, failing past the limits
+func (b *renderBudget) iteration() (string, error) {
+	b.iterations++
+	if b.iterations > MaxTemplateIterations {
+		return "", fmt.Errorf("template exceeds %d range iterations", MaxTemplateIterations)
+	}
+	if b.iterations%1024 == 0 {
+		return "", b.checkDeadline()
+	}
+	return "", nil
+}
+
+func (b *renderBudget) checkDeadline() error {
+	if time.Now().After(b.deadline) {
+		return fmt.Errorf("template rendering exceeds %v", MaxTemplateDuration)
+	}
+	return nil
+}
+
+// limitedWriter fails once more than limit bytes are written or the
+// budget's deadline passes, which stops template execution
+type limitedWriter struct {
+	buf    bytes.Buffer
+	limit  int
+	budget *renderBudget
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		return 0, fmt.Errorf("rendered output exceeds %d bytes", w.limit)
+	}
+	if err := w.budget.checkDeadline(); err != nil {
+		return 0, err
+	}
+	return w.buf.Write(p)
+}
+
+// RenderTemplate renders a text/template against data. Templates that
+// invoke themselves (directly or through other {{define}}d templates) are
+// rejected, output is capped at MaxTemplateOutput, and rendering fails
+// after MaxTemplateIterations range iterations or MaxTemplateDuration.
+// With strict, a missing map key is an error instead of "<no value>".
+func RenderTemplate(text string, data interface{}, strict bool) (string, error) {
+	if len(text) > MaxTemplateSize {
+		return "", fmt.Errorf("template exceeds %d bytes", MaxTemplateSize)
+	}
+
+	budget := &renderBudget{}
+	tmpl := template.New("template").Funcs(templateFuncs).Funcs(template.FuncMap{iterationFunc: budget.iteration})
+	if strict {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	tmpl, err := tmpl.Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %v", err)
+	}
+	if err := checkTemplateRecursion(tmpl); err != nil {
+		return "", err
+	}
+	if err := budgetRanges(tmpl); err != nil {
+		return "", err
+	}
+
+	budget.deadline = time.Now().Add(MaxTemplateDuration)
+	out := &limitedWriter{limit: MaxTemplateOutput, budget: budget}
+	if err := tmpl.Execute(out, data); err != nil {
+		return "", fmt.Errorf("failed to render template: %v", err)
+	}
+	return out.buf.String(), nil
+}
+
+// checkTemplateRecursion fails if the {{template}} calls between the
+// associated templates form a cycle
+func checkTemplateRecursion(tmpl *template.Template) error {
+	calls := make(map[string][]string)
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			calls[t.Name()] = templateCalls(t.Tree.Root, nil)
+		}
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("template recursion is not allowed: %s", strings.Join(append(path, name), " -> "))
+		case done:
+			return nil
+		}
+		state[name] = visiting
+		for _, callee := range calls[name] {
+			if err := visit(callee, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+	for name := range calls {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateCalls appends the names of templates invoked under node
+func templateCalls(node parse.Node, calls []string) []string {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return calls
+		}
+		for _, child := range n.Nodes {
+			calls = templateCalls(child, calls)
+		}
+	case *parse.IfNode:
+		calls = templateCalls(n.List, calls)
+		calls = templateCalls(n.ElseList, calls)
+	case *parse.RangeNode:
+		calls = templateCalls(n.List, calls)
+		calls = templateCalls(n.ElseList, calls)
+	case *parse.WithNode:
+		calls = templateCalls(n.List, calls)
+		calls = templateCalls(n.ElseList, calls)
+	case *parse.TemplateNode:
+		calls = append(calls, n.Name)
+	}
+	return calls
+}
+
+// budgetRanges makes every {{range}} body in tmpl start with a call to
+// iterationFunc, so loops are counted even when they write nothing
+func budgetRanges(tmpl *template.Template) error {
+	call, err := parse.Parse("iteration", "{{"+iterationFunc+"}}", "", "", map[string]interface{}{iterationFunc: fmt.Sprint})
+	if err != nil {
+		return err
+	}
+	tick := call["iteration"].Root.Nodes[0]
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.IfNode:
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.List)
+			walk(n.ElseList)
+			n.List.Nodes = append([]parse.Node{tick}, n.List.Nodes...)
+		case *parse.WithNode:
+			walk(n.List)
+			walk(n.ElseList)
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+	return nil
+}
+
+// TemplatePlugin renders Go text/template templates, e.g. to build a
+// message or URL from the results of other capabilities
+type TemplatePlugin struct {
+	BasePlugin
+}
+
+// NewTemplatePlugin creates a new TemplatePlugin instance
+func NewTemplatePlugin() *TemplatePlugin {
+	return &TemplatePlugin{}
+}
+
+func (p *TemplatePlugin) GetName() string {
+	return "render_template"
+}
+
+func (p *TemplatePlugin) GetDescription() string {
+	return "Render a Go text/template (e.g. \"Hello {{.name | upper}}\") against a context object"
+}
+
+func (p *TemplatePlugin) GetTags() []string {
+	return []string{"text", "template"}
+}
+
+func (p *TemplatePlugin) GetExample() string {
+	return `{"template":"Hello {{.name | upper}}!","context":{"name":"alice"}}`
+}
+
+func (p *TemplatePlugin) Execute(params map[string]interface{}, context *ExecutionContext) (interface{}, error) {
+	text, ok := params["template"].(string)
+	if !ok || text == "" {
+		return nil, fmt.Errorf("missing required parameter: template")
+	}
+
+	data := params["context"]
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if _, ok := data.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("context must be an object, got %T", data)
+	}
+	strict, _ := params["strict"].(bool)
+
+	rendered, err := RenderTemplate(text, data, strict)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"template": text,
+		"result":   rendered,
+		"length":   len(rendered),
+		"status":   "success",
+	}, nil
+}
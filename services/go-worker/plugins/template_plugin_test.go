@@ -0,0 +1,81 @@
+package plugins
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// listOf returns a context list of n numbers
+func listOf(n int) []interface{} {
+	list := make([]interface{}, n)
+	for i := range list {
+		list[i] = float64(i)
+	}
+	return list
+}
+
+func TestRenderTemplate(t *testing.T) {
+	data := map[string]interface{}{"name": "alice", "items": []interface{}{"a", "b"}, "none": []interface{}{}}
+	for _, tc := range []struct {
+		template string
+		want     string
+	}{
+		{"Hello {{.name | upper}}!", "Hello ALICE!"},
+		{"{{range $i, $x := .items}}{{$i}}={{$x}} {{end}}", "0=a 1=b "},
+		{"{{range .none}}x{{else}}empty{{end}}", "empty"},
+		{"{{range .items}}{{range $.items}}{{.}}{{end}};{{end}}", "ab;ab;"},
+		{`{{define "item"}}[{{range .}}{{.}}{{end}}]{{end}}{{template "item" .items}}`, "[ab]"},
+		{"{{if .items}}{{range .items}}{{.}}{{end}}{{end}}", "ab"},
+	} {
+		got, err := RenderTemplate(tc.template, data, false)
+		if err != nil || got != tc.want {
+			t.Errorf("%s rendered %q, %v; want %q", tc.template, got, err, tc.want)
+		}
+	}
+}
+
+// Loops that write nothing still stop at MaxTemplateIterations
+func TestRenderTemplateIterationLimit(t *testing.T) {
+	data := map[string]interface{}{"x": listOf(1000)}
+	start := time.Now()
+	_, err := RenderTemplate("{{range .x}}{{range $.x}}{{range $.x}}{{end}}{{end}}{{end}}", data, false)
+	if err == nil || !strings.Contains(err.Error(), "range iterations") {
+		t.Fatalf("10^9 silent iterations: got %v, want the iteration limit", err)
+	}
+	if elapsed := time.Since(start); elapsed > MaxTemplateDuration {
+		t.Fatalf("stopped after %v", elapsed)
+	}
+
+	// Also in {{define}}d templates and {{with}} blocks
+	_, err = RenderTemplate(`{{define "loop"}}{{range .}}{{range $}}{{end}}{{end}}{{end}}{{with .x}}{{range .}}{{template "loop" $.x}}{{end}}{{end}}`, data, false)
+	if err == nil || !strings.Contains(err.Error(), "range iterations") {
+		t.Fatalf("silent iterations through define: got %v, want the iteration limit", err)
+	}
+
+	// Up to the limit is fine
+	data["x"] = listOf(300)
+	if _, err := RenderTemplate("{{range .x}}{{range $.x}}{{end}}{{end}}", data, false); err != nil {
+		t.Fatalf("%d iterations: %v", 300*300+300, err)
+	}
+}
+
+func TestRenderTemplateRejected(t *testing.T) {
+	for _, tc := range []struct {
+		template string
+		want     string
+	}{
+		{`{{define "a"}}{{template "b"}}{{end}}{{define "b"}}{{template "a"}}{{end}}{{template "a"}}`, "recursion"},
+		{"{{range .x}}{{range $.x}}{{printf `%1000s` `a`}}{{end}}{{end}}", "rendered output exceeds"},
+		{"{{printf `%5000s` `a`}}", "exceeds 1024"},
+		{"{{.missing}", "invalid template"},
+	} {
+		_, err := RenderTemplate(tc.template, map[string]interface{}{"x": listOf(100)}, false)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.template, err, tc.want)
+		}
+	}
+	if _, err := RenderTemplate("{{.missing}}", map[string]interface{}{}, true); err == nil {
+		t.Error("missing key accepted in strict mode")
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
@@ -22,6 +25,10 @@ type GRPCWorker struct {
 	conn       *grpc.ClientConn
 	plugins    map[string]plugins.Plugin
 	connected  bool
+	authToken  string // sent with registration when the hub requires auth
+
+	mu     sync.RWMutex // guards plugins
+	sendMu sync.Mutex   // serializes stream.Send
 }
 
 func NewGRPCWorker(workerID, hubAddress string) *GRPCWorker {
@@ -33,30 +40,67 @@ func NewGRPCWorker(workerID, hubAddress string) *GRPCWorker {
 	}
 }
 
+// SetAuthToken sets the credential sent with registration
+func (w *GRPCWorker) SetAuthToken(token string) {
+	w.authToken = token
+}
+
 func (w *GRPCWorker) RegisterPlugin(plugin plugins.Plugin) {
+	w.mu.Lock()
 	w.plugins[plugin.GetName()] = plugin
+	w.mu.Unlock()
 	log.Printf("✅ Registered plugin: %s", plugin.GetName())
 }
 
-func (w *GRPCWorker) Connect() error {
-	log.Printf("🔵 Connecting to Hub at %s...", w.hubAddress)
+// RegisterPluginLive adds (or replaces) a plugin while connected and
+// re-sends registration so the hub's capability index is updated
+func (w *GRPCWorker) RegisterPluginLive(plugin plugins.Plugin) error {
+	w.RegisterPlugin(plugin)
+	return w.refreshRegistration()
+}
 
-	conn, err := grpc.Dial(w.hubAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+// UnregisterPlugin removes a plugin and re-sends registration
+func (w *GRPCWorker) UnregisterPlugin(name string) error {
+	w.mu.Lock()
+	if _, exists := w.plugins[name]; !exists {
+		w.mu.Unlock()
+		return fmt.Errorf("plugin not registered: %s", name)
 	}
-	w.conn = conn
+	delete(w.plugins, name)
+	w.mu.Unlock()
 
-	client := pb.NewHubServiceClient(conn)
-	stream, err := client.Connect(context.Background())
-	if err != nil {
-		conn.Close()
-		return fmt.Errorf("failed to start stream: %w", err)
+	log.Printf("🗑️  Unregistered plugin: %s", name)
+	return w.refreshRegistration()
+}
+
+// refreshRegistration re-sends registration if the worker is connected;
+// otherwise the change is picked up by the next Connect
+func (w *GRPCWorker) refreshRegistration() error {
+	if !w.connected {
+		return nil
 	}
-	w.stream = stream
-	w.connected = true
+	return w.sendRegistration()
+}
 
-	log.Println("✅ Connected to Hub")
+// Connect opens the stream and registers. hubAddress may list several hubs
+// separated by commas; they are tried in order.
+func (w *GRPCWorker) Connect() error {
+	var err error
+	for _, address := range strings.Split(w.hubAddress, ",") {
+		if address = strings.TrimSpace(address); address == "" {
+			continue
+		}
+		if err = w.dial(address); err == nil {
+			break
+		}
+		log.Printf("⚠️  Hub %s unavailable: %v", address, err)
+	}
+	if err != nil {
+		return err
+	}
+	if !w.connected {
+		return fmt.Errorf("no hub address")
+	}
 
 	// Send registration
 	if err := w.sendRegistration(); err != nil {
@@ -72,7 +116,31 @@ func (w *GRPCWorker) Connect() error {
 	return nil
 }
 
+// dial opens the stream to one hub
+func (w *GRPCWorker) dial(address string) error {
+	log.Printf("🔵 Connecting to Hub at %s...", address)
+
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	client := pb.NewHubServiceClient(conn)
+	stream, err := client.Connect(context.Background())
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start stream: %w", err)
+	}
+	w.conn = conn
+	w.stream = stream
+	w.connected = true
+
+	log.Printf("✅ Connected to Hub at %s", address)
+	return nil
+}
+
 func (w *GRPCWorker) sendRegistration() error {
+	w.mu.RLock()
 	capabilities := make([]map[string]interface{}, 0, len(w.plugins))
 	
 	for _, plugin := range w.plugins {
@@ -83,8 +151,19 @@ func (w *GRPCWorker) sendRegistration() error {
 			"accepts_file":     plugin.AcceptsFile(),
 			"file_field_name":  plugin.GetFileFieldName(),
 		}
+		if tags := plugins.PluginTags(plugin); len(tags) > 0 {
+			cap["tags"] = tags
+		}
+		if example := plugins.PluginExample(plugin); example != "" {
+			cap["example"] = example
+		}
+		if ttl, cacheable := plugins.PluginCacheTTL(plugin); cacheable {
+			cap["cacheable"] = true
+			cap["cache_ttl_seconds"] = ttl
+		}
 		capabilities = append(capabilities, cap)
 	}
+	w.mu.RUnlock()
 
 	regData := map[string]interface{}{
 		"worker_id":    w.workerID,
@@ -93,7 +172,7 @@ func (w *GRPCWorker) sendRegistration() error {
 		"metadata": map[string]interface{}{
 			"version":      "1.0.0",
 			"description":  "Go worker with plugin system",
-			"plugin_count": len(w.plugins),
+			"plugin_count": len(capabilities),
 		},
 	}
 
@@ -110,12 +189,15 @@ func (w *GRPCWorker) sendRegistration() error {
 		Action:    "register",
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
+	if w.authToken != "" {
+		msg.Metadata = map[string]string{"auth_token": w.authToken}
+	}
 
-	if err := w.stream.Send(msg); err != nil {
+	if err := w.send(msg); err != nil {
 		return err
 	}
 
-	log.Printf("✅ Registration sent with %d capabilities", len(w.plugins))
+	log.Printf("✅ Registration sent with %d capabilities", len(capabilities))
 	return nil
 }
 
@@ -163,12 +245,20 @@ func (w *GRPCWorker) handleRequest(msg *pb.Message) {
 
 	log.Printf("📥 Request: %s (ID: %s)", capability, requestID)
 
+	// A panicking plugin fails its request instead of the receive loop
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("💥 Panic in capability %s: %v\n%s", capability, r, debug.Stack())
+			w.sendErrorResponse(requestID, originalSender, "INTERNAL", fmt.Sprintf("capability %s panicked: %v", capability, r))
+		}
+	}()
+
 	// Parse parameters from content
 	var params map[string]interface{}
 	if msg.Content != "" && msg.Content != "{}" {
 		if err := json.Unmarshal([]byte(msg.Content), &params); err != nil {
 			log.Printf("❌ Failed to parse content: %v", err)
-			w.sendErrorResponse(requestID, originalSender, "Invalid request format")
+			w.sendErrorResponse(requestID, originalSender, "VALIDATION", "Invalid request format")
 			return
 		}
 	} else {
@@ -176,10 +266,12 @@ func (w *GRPCWorker) handleRequest(msg *pb.Message) {
 	}
 
 	// Get plugin
+	w.mu.RLock()
 	plugin, exists := w.plugins[capability]
+	w.mu.RUnlock()
 	if !exists {
 		log.Printf("❌ Unknown capability: %s", capability)
-		w.sendErrorResponse(requestID, originalSender, fmt.Sprintf("Unknown capability: %s", capability))
+		w.sendErrorResponse(requestID, originalSender, "UNKNOWN_CAPABILITY", fmt.Sprintf("Unknown capability: %s", capability))
 		return
 	}
 
@@ -187,12 +279,13 @@ func (w *GRPCWorker) handleRequest(msg *pb.Message) {
 	ctx := &plugins.ExecutionContext{
 		WorkerID:   w.workerID,
 		CallWorker: w.callWorker,
+		OpenFile:   w.openFile,
 	}
 
 	result, err := plugin.Execute(params, ctx)
 	if err != nil {
 		log.Printf("❌ Plugin execution error: %v", err)
-		w.sendErrorResponse(requestID, originalSender, err.Error())
+		w.sendErrorResponse(requestID, originalSender, "EXECUTION_FAILED", err.Error())
 		return
 	}
 
@@ -223,17 +316,28 @@ func (w *GRPCWorker) sendResponse(requestID, targetClient string, result map[str
 		To:        targetClient,
 		Content:   string(content),
 		Timestamp: time.Now().Format(time.RFC3339),
+		Metadata:  map[string]string{"request_id": requestID},
 	}
 
-	if err := w.stream.Send(msg); err != nil {
+	if err := w.send(msg); err != nil {
 		log.Printf("❌ Failed to send response: %v", err)
 	}
 }
 
-func (w *GRPCWorker) sendErrorResponse(requestID, targetClient, errMsg string) {
+// send serializes writes to the stream; gRPC forbids concurrent Send calls
+func (w *GRPCWorker) send(msg *pb.Message) error {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	return w.stream.Send(msg)
+}
+
+// sendErrorResponse replies with the hub's ErrorResponse shape (see pkg/apierr)
+func (w *GRPCWorker) sendErrorResponse(requestID, targetClient, code, errMsg string) {
 	result := map[string]interface{}{
-		"error":  errMsg,
-		"status": "error",
+		"code":    code,
+		"message": errMsg,
+		"error":   errMsg,
+		"status":  "error",
 	}
 	w.sendResponse(requestID, targetClient, result)
 }
@@ -249,6 +353,57 @@ func (w *GRPCWorker) callWorker(targetWorker, capability string, data map[string
 	return nil, fmt.Errorf("worker-to-worker calls not yet implemented")
 }
 
+// openFile streams a file from the hub's FileStorage via DownloadFile
+func (w *GRPCWorker) openFile(fileID string) (io.ReadCloser, error) {
+	if w.conn == nil {
+		return nil, fmt.Errorf("not connected to hub")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewHubServiceClient(w.conn).DownloadFile(ctx, &pb.FileDownloadRequest{FileId: fileID})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &chunkReader{stream: stream, cancel: cancel}, nil
+}
+
+// chunkReader exposes a DownloadFile chunk stream as an io.Reader
+type chunkReader struct {
+	stream pb.HubService_DownloadFileClient
+	cancel context.CancelFunc
+	buf    []byte
+	done   bool
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		chunk, err := r.stream.Recv()
+		if err == io.EOF {
+			r.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk.Data
+		r.done = chunk.IsLast
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.cancel()
+	return nil
+}
+
 func (w *GRPCWorker) startHeartbeat() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -265,7 +420,7 @@ func (w *GRPCWorker) startHeartbeat() {
 			Timestamp: time.Now().Format(time.RFC3339),
 		}
 
-		if err := w.stream.Send(msg); err != nil {
+		if err := w.send(msg); err != nil {
 			log.Printf("❌ Heartbeat failed: %v", err)
 			return
 		}
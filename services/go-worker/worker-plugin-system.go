@@ -116,7 +116,11 @@ func (w *GoWorker) HandleRequest(requestID, capability, data string) (string, er
 }
 
 func (w *GoWorker) CallWorker(targetWorkerID, capability string, params map[string]interface{}, timeout int) (map[string]interface{}, error) {
-	requestID := fmt.Sprintf("%s-%d-%d", w.workerID, time.Now().UnixNano(), len(w.pendingCalls))
+	id, err := plugins.NewUUID("v7")
+	if err != nil {
+		return nil, err
+	}
+	requestID := w.workerID + "-" + id
 
 	responseChan := make(chan *Response, 1)
 	timer := time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
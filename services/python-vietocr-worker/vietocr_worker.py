@@ -31,9 +31,13 @@ class VietOCRWorker:
         hub_address='localhost:50051',
         encoder_path=None,
         decoder_path=None,
-        use_gpu=False
+        use_gpu=False,
+        max_concurrency=0
     ):
         self.worker_id = worker_id
+        # Advertised to the hub, which stops routing here at this many
+        # in-flight requests (0 = unlimited)
+        self.max_concurrency = max_concurrency
         self.hub_address = hub_address
         self.channel = None
         self.stub = None
@@ -246,7 +250,8 @@ class VietOCRWorker:
                                 }
                             }),
                             "http_method": "POST",
-                            "accepts_file": False
+                            "accepts_file": False,
+                            "default_timeout_ms": 180000
                         }
                     ]
                     
@@ -261,6 +266,8 @@ class VietOCRWorker:
                             "engine": "ONNX Runtime"
                         }
                     }
+                    if self.max_concurrency > 0:
+                        registration_data["metadata"]["max_concurrency"] = self.max_concurrency
                     
                     register_msg = hub_pb2.Message(
                         id=f"register-{int(time.time() * 1000000)}",
@@ -364,13 +371,15 @@ def main():
     encoder_path = os.getenv('ENCODER_PATH', '/app/models/transformer_encoder.onnx')
     decoder_path = os.getenv('DECODER_PATH', '/app/models/transformer_decoder.onnx')
     use_gpu = os.getenv('USE_GPU', 'false').lower() == 'true'
+    max_concurrency = int(os.getenv('MAX_CONCURRENCY', '2'))
     
     worker = VietOCRWorker(
         worker_id=worker_id,
         hub_address=hub_address,
         encoder_path=encoder_path,
         decoder_path=decoder_path,
-        use_gpu=use_gpu
+        use_gpu=use_gpu,
+        max_concurrency=max_concurrency
     )
     
     try:
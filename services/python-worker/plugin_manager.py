@@ -186,6 +186,14 @@ class PluginManager:
             if plugin.accepts_file and plugin.file_field_name:
                 capability_meta["file_field_name"] = plugin.file_field_name
             
+            tags = getattr(plugin, "tags", None)
+            if tags:
+                capability_meta["tags"] = list(tags)
+            
+            if getattr(plugin, "deprecated", False):
+                capability_meta["deprecated"] = True
+                capability_meta["deprecation_message"] = getattr(plugin, "deprecation_message", "")
+            
             capabilities.append(capability_meta)
         
         return capabilities
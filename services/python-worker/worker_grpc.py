@@ -472,6 +472,7 @@ class PythonWorker:
                                 
                                 response_msg = hub_pb2.Message(
                                     id=f"resp-{int(time.time() * 1000000)}",
+                                    request_id=msg.request_id,
                                     to=msg_from,
                                     channel=msg.channel,
                                     content=response_content,
@@ -479,6 +480,7 @@ class PythonWorker:
                                     type=hub_pb2.RESPONSE  # Mark as RESPONSE
                                 )
                                 setattr(response_msg, 'from', self.worker_id)
+                                response_msg.metadata['request_id'] = msg.id
                                 send_queue.put(response_msg)
                                 print(f"   ✓ Queued response for worker call\n")
                                 
@@ -489,6 +491,7 @@ class PythonWorker:
                                 
                                 response_msg = hub_pb2.Message(
                                     id=f"resp-{int(time.time() * 1000000)}",
+                                    request_id=msg.request_id,
                                     to=msg_from,
                                     channel=msg.channel,
                                     content=response_content,
@@ -496,6 +499,7 @@ class PythonWorker:
                                     type=hub_pb2.RESPONSE
                                 )
                                 setattr(response_msg, 'from', self.worker_id)
+                                response_msg.metadata['request_id'] = msg.id
                                 send_queue.put(response_msg)
                                 print(f"   ✓ Queued response for {msg_from}\n")
                             
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"encoding/json"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+)
+
+// Subscribe asks the hub to deliver messages published on channel and
+// calls fn for each of them. fn runs on the receive goroutine, so a slow
+// fn delays every response of this client. Subscribing again replaces fn.
+func (hc *HubClient) Subscribe(channel string, fn func(*pb.Message)) error {
+	hc.channelMu.Lock()
+	hc.channelHandlers[channel] = fn
+	hc.channelMu.Unlock()
+
+	if err := hc.subscription("subscribe", channel); err != nil {
+		hc.channelMu.Lock()
+		delete(hc.channelHandlers, channel)
+		hc.channelMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Unsubscribe stops delivery of channel
+func (hc *HubClient) Unsubscribe(channel string) error {
+	hc.channelMu.Lock()
+	delete(hc.channelHandlers, channel)
+	hc.channelMu.Unlock()
+
+	return hc.subscription("unsubscribe", channel)
+}
+
+// subscription sends a subscribe/unsubscribe control action
+func (hc *HubClient) subscription(action, channel string) error {
+	data, _ := json.Marshal(map[string]string{"channel": channel})
+	response, err := hc.SendControl(action, string(data))
+	if err != nil {
+		return err
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		return apiErr
+	}
+	return nil
+}
+
+// handleChannelMessage hands a CHANNEL message to its subscriber
+func (hc *HubClient) handleChannelMessage(msg *pb.Message) {
+	hc.channelMu.Lock()
+	fn := hc.channelHandlers[msg.Channel]
+	hc.channelMu.Unlock()
+	if fn != nil {
+		fn(msg)
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+)
+
+// DefaultDiscoveryCacheTTL is how long a discovery result is reused when
+// the hub does not announce a change first
+const DefaultDiscoveryCacheTTL = 5 * time.Second
+
+// ControlActionCapabilitiesChanged is pushed by the hub whenever a worker
+// registers, unregisters or changes status
+const ControlActionCapabilitiesChanged = "capabilities_changed"
+
+type discoveryEntry struct {
+	response *pb.Message
+	expires  time.Time
+}
+
+// Discover returns the hub's capability discovery result, optionally
+// filtered by tag. It is fetched with the unary Discover RPC, so it is
+// current as soon as the client is connected. Successful results are
+// cached per tag for DiscoveryCacheTTL and dropped when the hub pushes
+// capabilities_changed. The returned message is shared and must not be
+// modified.
+func (hc *HubClient) Discover(tag string) (*pb.Message, error) {
+	hc.cacheMu.Lock()
+	entry, ok := hc.discoveryCache[tag]
+	generation := hc.cacheGeneration
+	hc.cacheMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.response, nil
+	}
+
+	response, err := hc.discover(tag)
+	if err != nil {
+		return nil, err
+	}
+	if _, failed := envelope.Error(response); failed || hc.DiscoveryCacheTTL <= 0 {
+		return response, nil
+	}
+
+	hc.cacheMu.Lock()
+	// A change announced while the request was in flight makes it stale
+	if generation == hc.cacheGeneration {
+		hc.discoveryCache[tag] = discoveryEntry{response: response, expires: time.Now().Add(hc.DiscoveryCacheTTL)}
+	}
+	hc.cacheMu.Unlock()
+	return response, nil
+}
+
+// discover calls the hub's Discover RPC and wraps the result in a RESPONSE
+// message, as discovery over the stream returns. Hubs that predate the RPC
+// are asked over the stream.
+func (hc *HubClient) discover(tag string) (*pb.Message, error) {
+	req := &pb.DiscoverRequest{ClientId: hc.ClientID, Tag: tag, Metadata: map[string]string{}}
+	if hc.token != "" {
+		req.Metadata["auth_token"] = hc.token
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+
+	resp, err := hc.currentClient().Discover(ctx, req)
+	if status.Code(err) == codes.Unimplemented {
+		request := map[string]interface{}{"action": "discover"}
+		if tag != "" {
+			request["tag"] = tag
+		}
+		data, _ := json.Marshal(request)
+		return hc.SendRequest("hub", "capability_discovery", string(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Message{
+		Id:        utils.PrefixedID("discover"),
+		From:      "hub",
+		To:        hc.ClientID,
+		Type:      pb.MessageType_RESPONSE,
+		Content:   resp.Content,
+		Error:     resp.Error,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// InvalidateDiscovery drops every cached discovery result
+func (hc *HubClient) InvalidateDiscovery() {
+	hc.cacheMu.Lock()
+	hc.discoveryCache = make(map[string]discoveryEntry)
+	hc.cacheGeneration++
+	hc.cacheMu.Unlock()
+}
+
+// CapabilitiesChannel carries one CapabilityChange per hub registry change
+const CapabilitiesChannel = "system:capabilities"
+
+// CapabilityChange is a registry delta published on CapabilitiesChannel.
+// For worker_added/worker_removed the capability lists hold all of the
+// worker's capabilities.
+type CapabilityChange struct {
+	Event               string   `json:"event"` // worker_added, worker_removed or worker_updated
+	WorkerID            string   `json:"worker_id"`
+	Status              string   `json:"status,omitempty"`
+	AddedCapabilities   []string `json:"added_capabilities,omitempty"`
+	RemovedCapabilities []string `json:"removed_capabilities,omitempty"`
+	Timestamp           string   `json:"timestamp"`
+}
+
+// OnCapabilityChange subscribes to CapabilitiesChannel and calls fn for
+// every change. fn runs on the receive goroutine and must not block.
+func (hc *HubClient) OnCapabilityChange(fn func(CapabilityChange)) error {
+	return hc.Subscribe(CapabilitiesChannel, func(msg *pb.Message) {
+		var change CapabilityChange
+		if err := json.Unmarshal([]byte(msg.Content), &change); err != nil {
+			log.Printf("Dropping capability change %s: %v", msg.Id, err)
+			return
+		}
+		fn(change)
+	})
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+)
+
+// DownloadFile streams fileID from the hub's file storage starting at
+// offset, in chunks of up to chunkSize bytes (0 for the hub's default).
+// Unknown files fail on the first Recv with codes.NotFound; canceling ctx
+// stops the download.
+func (hc *HubClient) DownloadFile(ctx context.Context, fileID string, offset, chunkSize int64) (pb.HubService_DownloadFileClient, error) {
+	return hc.currentClient().DownloadFile(ctx, &pb.FileDownloadRequest{
+		FileId:    fileID,
+		Offset:    offset,
+		ChunkSize: chunkSize,
+	})
+}
+
+// UploadFile streams r to the hub's file storage under a new file ID, in
+// chunks of chunkSize bytes, so only one chunk is held in memory. The
+// total size is not known up front (TotalSize is -1). When reading r
+// fails the upload is canceled, the hub discards the partial file and the
+// read error is returned (wrapped). The last chunk carries the SHA-256 of
+// everything sent, so the hub rejects a transfer corrupted on the way
+// with codes.DataLoss.
+func (hc *HubClient) UploadFile(ctx context.Context, filename, contentType string, r io.Reader, chunkSize int) (*pb.FileUploadResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := hc.currentClient().UploadFile(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start upload: %w", err)
+	}
+
+	fileID := utils.GenerateID()
+	buf := make([]byte, chunkSize)
+	hash := sha256.New()
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		last := readErr == io.EOF || readErr == io.ErrUnexpectedEOF
+		if readErr != nil && !last {
+			return nil, fmt.Errorf("failed to read upload: %w", readErr)
+		}
+
+		chunk := &pb.FileChunk{
+			FileId:      fileID,
+			Data:        buf[:n],
+			Offset:      offset,
+			TotalSize:   -1,
+			IsLast:      last,
+			Filename:    filename,
+			ContentType: contentType,
+		}
+		hash.Write(buf[:n])
+		if last {
+			chunk.Sha256 = hex.EncodeToString(hash.Sum(nil))
+		}
+		if err := stream.Send(chunk); err != nil {
+			return nil, fmt.Errorf("failed to upload file: %w", err)
+		}
+		offset += int64(n)
+		if last {
+			break
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("failed to upload file: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+// UploadOffset asks the hub how many bytes of an interrupted upload of
+// fileID it has kept, so the upload can resume there: the first chunk of
+// the new UploadFile stream carries that offset. complete is true when
+// the file is already stored (offset is then its size).
+func (hc *HubClient) UploadOffset(fileID string) (offset int64, complete bool, err error) {
+	data, _ := json.Marshal(map[string]string{"file_id": fileID})
+	response, err := hc.SendControl("upload_offset", string(data))
+	if err != nil {
+		return 0, false, err
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		return 0, false, apiErr
+	}
+
+	var result struct {
+		Offset   int64 `json:"offset"`
+		Complete bool  `json:"complete"`
+	}
+	if err := json.Unmarshal([]byte(response.Content), &result); err != nil {
+		return 0, false, fmt.Errorf("invalid upload_offset response: %w", err)
+	}
+	return result.Offset, result.Complete, nil
+}
@@ -4,98 +4,560 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/envelope"
 	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
 )
 
+// ClientType is sent in message metadata so the hub lists this connection
+// as an API gateway
+const ClientType = "api-gateway"
+
 // HubClient represents the gRPC hub client
 type HubClient struct {
+	ClientID string // Exported for access
+
+	// connMu guards the current connection and its state; the receive
+	// loop replaces them when it reconnects
+	connMu    sync.RWMutex
 	conn      *grpc.ClientConn
 	client    pb.HubServiceClient
 	stream    pb.HubService_ConnectClient
-	ClientID  string // Exported for access
-	responses chan *pb.Message
+	state     ConnectionState
+	broken    chan struct{} // closed when the current stream dies
+	closing   chan struct{} // closed by Close
+	addrIndex int           // index of the connected hub in addresses
+
+	// Hubs in order of preference, and what reconnecting needs to open
+	// and authenticate a new stream
+	addresses []string
+	callOpts  []grpc.CallOption
+	token     string
+
+	sendMu sync.Mutex // serializes stream.Send
+
+	mu            sync.Mutex
+	responseChans map[string]chan *pb.Message // request ID -> waiter
+	streams       map[string]*Stream          // request ID -> streaming request
+	ackChans      map[string]chan *pb.Message // message ID -> SendReliable waiter
+
+	// CompressionThreshold is the Content size above which requests are
+	// gzipped (0 disables)
+	CompressionThreshold int
+
+	// maxSendMsgSize is the gRPC send limit; larger requests are uploaded
+	// with UploadFile and sent as a content_file_id reference
+	maxSendMsgSize int
+
+	// DiscoveryCacheTTL is how long Discover reuses a result (0 disables
+	// caching)
+	DiscoveryCacheTTL time.Duration
+
+	cacheMu         sync.Mutex
+	discoveryCache  map[string]discoveryEntry // tag -> result
+	cacheGeneration uint64                    // bumped on every invalidation
+
+	channelMu       sync.Mutex
+	channelHandlers map[string]func(*pb.Message) // subscribed channel -> handler
 }
 
-// NewHubClient creates a new hub client
+// NewHubClient creates a new hub client. serverAddr may list several hubs
+// separated by commas; the first that accepts a stream is used.
 func NewHubClient(serverAddr string) (*HubClient, error) {
-	conn, err := grpc.Dial(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	return NewHubClientWithAuth(serverAddr, utils.PrefixedID("web-api"), "")
+}
+
+// NewHubClientWithAuth creates a hub client that authenticates as clientID
+// with token before sending anything else. An empty token skips the
+// handshake.
+func NewHubClientWithAuth(serverAddr, clientID, token string) (*HubClient, error) {
+	return NewHubClientWithLimits(serverAddr, clientID, token, codec.DefaultMaxMessageSize, codec.DefaultMaxMessageSize)
+}
+
+// NewHubClientWithLimits is NewHubClientWithAuth with gRPC message size
+// limits in bytes (<= 0 keeps gRPC's default); they should match the hub's
+// MAX_RECV_MSG_SIZE/MAX_SEND_MSG_SIZE. Requests above maxSend are offloaded
+// to the hub's file storage instead of failing.
+func NewHubClientWithLimits(serverAddr, clientID, token string, maxRecv, maxSend int) (*HubClient, error) {
+	return NewHubClientWithCompression(serverAddr, clientID, token, maxRecv, maxSend, "")
+}
+
+// NewHubClientWithCompression is NewHubClientWithLimits with a gRPC
+// compressor for the stream and file transfers: codec.GRPCCompressionGzip,
+// or "" / codec.GRPCCompressionNone for none. The hub compresses its
+// replies on compressed streams only.
+func NewHubClientWithCompression(serverAddr, clientID, token string, maxRecv, maxSend int, compression string) (*HubClient, error) {
+	compressor, err := codec.GRPCCompressor(compression)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect: %w", err)
+		return nil, err
 	}
 
-	client := pb.NewHubServiceClient(conn)
-	stream, err := client.Connect(context.Background())
+	var callOpts []grpc.CallOption
+	if compressor != nil {
+		callOpts = append(callOpts, compressor)
+	}
+	if maxRecv > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(maxRecv))
+	}
+	if maxSend > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(maxSend))
+	}
+
+	addresses := utils.SplitAddresses(serverAddr)
+	conn, client, stream, index, err := dialFirst(addresses, 0, callOpts)
 	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to start stream: %w", err)
+		return nil, err
 	}
 
 	hc := &HubClient{
+		ClientID:  clientID,
 		conn:      conn,
 		client:    client,
 		stream:    stream,
-		ClientID:  fmt.Sprintf("web-api-%d", time.Now().UnixNano()),
-		responses: make(chan *pb.Message, 100),
+		state:     StateConnected,
+		broken:    make(chan struct{}),
+		closing:   make(chan struct{}),
+		addrIndex: index,
+		addresses: addresses,
+		callOpts:  callOpts,
+		token:     token,
+
+		responseChans: make(map[string]chan *pb.Message),
+		streams:       make(map[string]*Stream),
+		ackChans:      make(map[string]chan *pb.Message),
+
+		CompressionThreshold: codec.DefaultCompressionThreshold,
+		maxSendMsgSize:       maxSend,
+
+		DiscoveryCacheTTL: DefaultDiscoveryCacheTTL,
+		discoveryCache:    make(map[string]discoveryEntry),
+
+		channelHandlers: make(map[string]func(*pb.Message)),
+	}
+
+	if token != "" {
+		if err := hc.authenticate(stream, token); err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
 
-	// Start receiving messages
+	// Start receiving messages; the loop also reconnects when the stream
+	// dies
 	go hc.receiveMessages()
 
 	return hc, nil
 }
 
+// dialFirst opens a stream to the first hub in addresses, starting at
+// index start and wrapping around, that accepts one, and returns its index
+func dialFirst(addresses []string, start int, callOpts []grpc.CallOption) (*grpc.ClientConn, pb.HubServiceClient, pb.HubService_ConnectClient, int, error) {
+	if len(addresses) == 0 {
+		return nil, nil, nil, 0, fmt.Errorf("failed to connect: no hub address")
+	}
+
+	var failures []string
+	for i := range addresses {
+		index := (start + i) % len(addresses)
+		conn, err := grpc.Dial(addresses[index],
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(callOpts...),
+		)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", addresses[index], err))
+			continue
+		}
+		client := pb.NewHubServiceClient(conn)
+		stream, err := client.Connect(context.Background())
+		if err != nil {
+			conn.Close()
+			log.Printf("⚠️  Hub %s unavailable: %v", addresses[index], err)
+			failures = append(failures, fmt.Sprintf("%s: %v", addresses[index], err))
+			continue
+		}
+		return conn, client, stream, index, nil
+	}
+	return nil, nil, nil, 0, fmt.Errorf("failed to start stream: no hub reachable (%s)", strings.Join(failures, "; "))
+}
+
+// Address returns the address of the hub this client is connected to (or
+// was last connected to while reconnecting)
+func (hc *HubClient) Address() string {
+	hc.connMu.RLock()
+	defer hc.connMu.RUnlock()
+	return hc.addresses[hc.addrIndex]
+}
+
+// authenticate performs the AUTH handshake on stream and waits for the
+// hub's verdict
+func (hc *HubClient) authenticate(stream pb.HubService_ConnectClient, token string) error {
+	msg := &pb.Message{
+		Id:        utils.PrefixedID("auth"),
+		From:      hc.ClientID,
+		To:        "hub",
+		Type:      pb.MessageType_AUTH,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Metadata:  map[string]string{"auth_token": token, "client_type": ClientType},
+	}
+	if err := stream.Send(msg); err != nil {
+		return fmt.Errorf("failed to send auth: %w", err)
+	}
+
+	ack, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	if apiErr, failed := envelope.Error(ack); failed {
+		return apiErr
+	}
+	return nil
+}
+
+// receiveMessages routes incoming messages until Close. When the stream
+// dies, requests in flight fail with UNAVAILABLE and the loop reconnects.
 func (hc *HubClient) receiveMessages() {
 	for {
-		msg, err := hc.stream.Recv()
+		stream, client, _, err := hc.connection()
 		if err != nil {
+			return // closed
+		}
+		msg, err := stream.Recv()
+		if err != nil {
+			if hc.State() == StateClosed {
+				return
+			}
 			log.Printf("Receive error: %v", err)
+			hc.disconnected()
+			if !hc.reconnect() {
+				return
+			}
+			continue
+		}
+		if err := codec.Resolve(context.Background(), client, msg); err != nil {
+			log.Printf("Dropping message %s: %v", msg.Id, err)
+			continue
+		}
+		if err := codec.Decompress(msg); err != nil {
+			log.Printf("Dropping message %s: %v", msg.Id, err)
+			continue
+		}
+		if msg.Type == pb.MessageType_CONTROL && msg.Action == ControlActionCapabilitiesChanged {
+			hc.InvalidateDiscovery()
+			continue
+		}
+		if msg.Type == pb.MessageType_CHANNEL {
+			hc.handleChannelMessage(msg)
+			continue
+		}
+		if msg.Type == pb.MessageType_ACK {
+			hc.routeAck(msg.Metadata[envelope.AckIDKey], msg)
+			continue
+		}
+		hc.routeResponse(msg)
+	}
+}
+
+// routeResponse hands msg to the request it answers. Workers echo the
+// request ID in RequestId, Metadata["request_id"] or Id; hub errors use
+// Metadata["original_message_id"]. Streaming requests also receive the
+// messages that precede their RESPONSE.
+func (hc *HubClient) routeResponse(msg *pb.Message) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	for _, key := range envelope.ReplyKeys(msg) {
+		if stream, ok := hc.streams[key]; ok {
+			stream.deliver(msg)
+			if envelope.IsResponse(msg) {
+				delete(hc.streams, key)
+			}
+			return
+		}
+		if ch, ok := hc.responseChans[key]; ok {
+			delete(hc.responseChans, key)
+			ch <- msg // buffered, never blocks
+			return
+		}
+		// The hub rejected a message sent with SendReliable
+		if ch, ok := hc.ackChans[key]; ok {
+			delete(hc.ackChans, key)
+			ch <- msg
 			return
 		}
-		hc.responses <- msg
+	}
+	log.Printf("Dropping uncorrelated message %s from %s", msg.Id, msg.From)
+}
+
+// routeAck hands the hub's ACK of message id to SendReliable. ACKs nobody
+// waits for (e.g. of an earlier attempt) are dropped.
+func (hc *HubClient) routeAck(id string, msg *pb.Message) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if ch, ok := hc.ackChans[id]; ok {
+		delete(hc.ackChans, id)
+		ch <- msg // buffered, never blocks
+	}
+}
+
+func (hc *HubClient) nextID(prefix string) string {
+	return utils.PrefixedID(prefix)
+}
+
+// DefaultRequestTimeout bounds requests made without a context deadline
+const DefaultRequestTimeout = 30 * time.Second
+
+// roundTrip sends msg and waits up to timeout for the response carrying its ID
+func (hc *HubClient) roundTrip(msg *pb.Message, timeout time.Duration) (*pb.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return hc.roundTripContext(ctx, msg)
+}
+
+// roundTripContext sends msg and waits for the response carrying its ID
+// until ctx is done. An expired deadline is reported as TIMEOUT; a
+// canceled ctx returns context.Canceled; losing the hub stream before the
+// response arrives returns UNAVAILABLE.
+func (hc *HubClient) roundTripContext(ctx context.Context, msg *pb.Message) (*pb.Message, error) {
+	waiter := make(chan *pb.Message, 1)
+	hc.mu.Lock()
+	hc.responseChans[msg.Id] = waiter
+	hc.mu.Unlock()
+	defer func() {
+		hc.mu.Lock()
+		delete(hc.responseChans, msg.Id)
+		hc.mu.Unlock()
+	}()
+
+	broken, err := hc.send(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-waiter:
+		return response, nil
+	case <-broken:
+		return nil, errConnectionLost
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, apierr.New(apierr.CodeTimeout, "timeout waiting for response")
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// send stamps msg as a request from this gateway and sends it, offloading
+// content above the send limit. It returns a channel that is closed if the
+// stream msg went out on dies, and UNAVAILABLE while reconnecting.
+func (hc *HubClient) send(ctx context.Context, msg *pb.Message) (<-chan struct{}, error) {
+	stream, client, broken, err := hc.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	msg.RequestId = msg.Id
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]string)
+	}
+	msg.Metadata["client_type"] = ClientType
+
+	if _, err := codec.Offload(ctx, client, msg, hc.maxSendMsgSize); err != nil {
+		return nil, err
+	}
+
+	hc.sendMu.Lock()
+	defer hc.sendMu.Unlock()
+	if err := stream.Send(msg); err != nil {
+		return nil, err
+	}
+	return broken, nil
+}
+
+// DefaultAckTimeout is how long SendReliable waits for the hub's ACK
+// before sending the message again
+const DefaultAckTimeout = 5 * time.Second
+
+// SendReliable sends msg with Metadata["ack"] = "true" and waits for the
+// hub's ACK, which the hub sends once msg is queued for routing. Without
+// an ACK within DefaultAckTimeout msg is sent again with the same ID,
+// until ctx is done, so delivery is at least once and recipients should
+// tolerate duplicates. A message the hub rejects returns the hub's error;
+// losing the hub stream returns UNAVAILABLE so the caller can retry once
+// the client has reconnected.
+// Messages the hub answers itself (CONTROL, SUBSCRIBE) get their reply
+// instead of an ACK; use SendControl for those.
+func (hc *HubClient) SendReliable(ctx context.Context, msg *pb.Message) error {
+	if msg.Id == "" {
+		msg.Id = hc.nextID("msg")
+	}
+	if msg.From == "" {
+		msg.From = hc.ClientID
+	}
+	if msg.Timestamp == "" {
+		msg.Timestamp = time.Now().Format(time.RFC3339)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]string)
+		}
+		msg.Metadata[envelope.AckKey] = "true"
+
+		waiter := make(chan *pb.Message, 1)
+		hc.mu.Lock()
+		hc.ackChans[msg.Id] = waiter
+		hc.mu.Unlock()
+
+		broken, err := hc.send(ctx, msg)
+		if err == nil {
+			timer := time.NewTimer(DefaultAckTimeout)
+			select {
+			case reply := <-waiter:
+				timer.Stop()
+				if apiErr, failed := envelope.Error(reply); failed {
+					return apiErr
+				}
+				return nil
+			case <-timer.C:
+				log.Printf("⚠️  No ack for %s after attempt %d, sending again", msg.Id, attempt)
+			case <-broken:
+				timer.Stop()
+				err = errConnectionLost
+			case <-ctx.Done():
+				timer.Stop()
+				err = ctx.Err()
+			}
+		}
+
+		hc.mu.Lock()
+		delete(hc.ackChans, msg.Id)
+		hc.mu.Unlock()
+		if err != nil {
+			if err == context.DeadlineExceeded {
+				return apierr.Newf(apierr.CodeTimeout, "no ack for message %s after %d attempts", msg.Id, attempt)
+			}
+			return err
+		}
 	}
 }
 
 // SendRequest sends a request to the hub
 func (hc *HubClient) SendRequest(targetWorker, capability, data string) (*pb.Message, error) {
-	msg := pb.Message{
-		Id:        fmt.Sprintf("req-%d", time.Now().UnixNano()),
+	return hc.SendRequestWithMetadata(targetWorker, capability, data, nil)
+}
+
+// SendRequestWithMetadata is SendRequest with extra request metadata
+// (e.g. correlation_id, session_key)
+func (hc *HubClient) SendRequestWithMetadata(targetWorker, capability, data string, metadata map[string]string) (*pb.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return hc.SendRequestContext(ctx, targetWorker, capability, data, metadata)
+}
+
+// SendRequestContext is SendRequestWithMetadata bounded by ctx instead of
+// DefaultRequestTimeout; canceling ctx abandons the request
+func (hc *HubClient) SendRequestContext(ctx context.Context, targetWorker, capability, data string, metadata map[string]string) (*pb.Message, error) {
+	msg, err := hc.newRequest(targetWorker, capability, data, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return hc.roundTripContext(ctx, msg)
+}
+
+// newRequest builds a (compressed) service request message
+func (hc *HubClient) newRequest(targetWorker, capability, data string, metadata map[string]string) (*pb.Message, error) {
+	msg := &pb.Message{
+		Id:        hc.nextID("req"),
 		From:      hc.ClientID,
 		To:        targetWorker,
 		Content:   data,
-		Channel:   capability, // Use capability as channel for worker routing
 		Timestamp: time.Now().Format(time.RFC3339),
 		Type:      pb.MessageType_REQUEST,
 		Action:    "request",
-		Metadata: map[string]string{
-			"capability": capability,
-		},
+		Metadata:  make(map[string]string),
+	}
+	envelope.SetCapability(msg, capability)
+	for k, v := range metadata {
+		if v != "" {
+			msg.Metadata[k] = v
+		}
 	}
 
 	log.Printf("📤 Sending request: Type=%v (%d), Action='%s', Capability='%s', To='%s'",
 		msg.Type, msg.Type, msg.Action, capability, targetWorker)
 
-	if err := hc.stream.Send(&msg); err != nil {
+	if err := codec.Compress(msg, hc.CompressionThreshold); err != nil {
 		return nil, err
 	}
+	return msg, nil
+}
 
-	// Wait for response with timeout
-	select {
-	case response := <-hc.responses:
-		return response, nil
-	case <-time.After(30 * time.Second):
-		return nil, fmt.Errorf("timeout waiting for response")
+// SendControl sends a hub control message (e.g. action "system_health")
+func (hc *HubClient) SendControl(action, data string) (*pb.Message, error) {
+	msg := pb.Message{
+		Id:        hc.nextID("ctrl"),
+		From:      hc.ClientID,
+		To:        "hub",
+		Content:   data,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Type:      pb.MessageType_CONTROL,
+		Action:    action,
+	}
+
+	return hc.roundTrip(&msg, DefaultRequestTimeout)
+}
+
+// Ping measures the round-trip time to the hub. The hub answers the
+// "ping" control message itself, so no worker is involved.
+func (hc *HubClient) Ping() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	return hc.PingContext(ctx)
+}
+
+// PingContext is Ping bounded by ctx instead of DefaultRequestTimeout
+func (hc *HubClient) PingContext(ctx context.Context) (time.Duration, error) {
+	msg := pb.Message{
+		Id:        hc.nextID("ping"),
+		From:      hc.ClientID,
+		To:        "hub",
+		Timestamp: time.Now().Format(time.RFC3339Nano),
+		Type:      pb.MessageType_CONTROL,
+		Action:    "ping",
 	}
+
+	start := time.Now()
+	if _, err := hc.roundTripContext(ctx, &msg); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
 }
 
-// Close closes the hub client connection
+// Close closes the hub client connection and stops reconnecting.
+// Requests in flight fail with UNAVAILABLE.
 func (hc *HubClient) Close() error {
-	if hc.conn != nil {
-		return hc.conn.Close()
+	hc.connMu.Lock()
+	if hc.state == StateClosed {
+		hc.connMu.Unlock()
+		return nil
 	}
-	return nil
+	if hc.state == StateConnected {
+		close(hc.broken)
+	}
+	hc.state = StateClosed
+	close(hc.closing)
+	conn := hc.conn
+	hc.connMu.Unlock()
+
+	hc.failStreams(apierr.New(apierr.CodeUnavailable, "hub client closed"))
+	return conn.Close()
 }
\ No newline at end of file
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// reversingHub answers the requests of a stream in batches of batch, in the
+// reverse order they arrived, echoing their content
+type reversingHub struct {
+	pb.UnimplementedHubServiceServer
+	batch int
+}
+
+func (h *reversingHub) Connect(stream pb.HubService_ConnectServer) error {
+	var pending []*pb.Message
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		pending = append(pending, msg)
+		if len(pending) < h.batch {
+			continue
+		}
+		for i := len(pending) - 1; i >= 0; i-- {
+			req := pending[i]
+			err := stream.Send(&pb.Message{
+				Id:       "resp-" + req.Id,
+				From:     "worker",
+				To:       req.From,
+				Type:     pb.MessageType_RESPONSE,
+				Content:  req.Content,
+				Metadata: map[string]string{envelope.RequestIDKey: req.Id},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		pending = nil
+	}
+}
+
+// startHub serves hub on a local port until the test ends
+func startHub(t *testing.T, hub pb.HubServiceServer) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterHubServiceServer(server, hub)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// Concurrent requests each get their own response, whatever order the
+// responses come back in
+func TestConcurrentRequestsGetOwnResponse(t *testing.T) {
+	const requests = 50
+	hc, err := NewHubClient(startHub(t, &reversingHub{batch: requests}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		data := fmt.Sprintf(`{"n":%d}`, i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := hc.SendRequest("", "echo", data)
+			if err != nil {
+				t.Errorf("request %s: %v", data, err)
+				return
+			}
+			if resp.Content != data {
+				t.Errorf("request %s got the response to %s", data, resp.Content)
+			}
+		}()
+	}
+	wg.Wait()
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if len(hc.responseChans) != 0 {
+		t.Errorf("%d waiters left after every request completed", len(hc.responseChans))
+	}
+}
+
+// refusedAddress is a local address nothing listens on
+func refusedAddress(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+func TestClientSkipsRefusingHub(t *testing.T) {
+	secondary := startHub(t, &reversingHub{batch: 1})
+	hc, err := NewHubClient(refusedAddress(t) + ", " + secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Close()
+
+	if got := hc.Address(); got != secondary {
+		t.Fatalf("connected to %s, want the secondary %s", got, secondary)
+	}
+	resp, err := hc.SendRequest("", "echo", `{"n":1}`)
+	if err != nil || resp.Content != `{"n":1}` {
+		t.Fatalf("request through the secondary: %v %v", resp, err)
+	}
+}
+
+func TestClientWithoutReachableHub(t *testing.T) {
+	if hc, err := NewHubClient(refusedAddress(t) + "," + refusedAddress(t)); err == nil {
+		hc.Close()
+		t.Fatal("connected with no hub listening")
+	}
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+
+	pb "deepapp_golang_grpc_hub/internal/proto"
+)
+
+// Invoke calls capability through the hub's unary Invoke RPC instead of
+// the stream: the response (or the hub's error, e.g. NO_WORKER or
+// TIMEOUT) comes back on the call itself, without response correlation.
+// An empty workerID lets the hub pick a worker. The hub waits up to its
+// REQUEST_TIMEOUT, or less if ctx has an earlier deadline. The client's ID
+// and auth token identify the call.
+func (hc *HubClient) Invoke(ctx context.Context, workerID, capability, data string, metadata map[string]string) (*pb.InvokeResponse, error) {
+	req := &pb.InvokeRequest{
+		Capability: capability,
+		Content:    data,
+		To:         workerID,
+		ClientId:   hc.ClientID,
+		Metadata:   make(map[string]string, len(metadata)+1),
+	}
+	for key, value := range metadata {
+		if value != "" {
+			req.Metadata[key] = value
+		}
+	}
+	if hc.token != "" {
+		req.Metadata["auth_token"] = hc.token
+	}
+	return hc.currentClient().Invoke(ctx, req)
+}
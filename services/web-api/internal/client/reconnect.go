@@ -0,0 +1,151 @@
+package client
+
+import (
+	"log"
+	"time"
+
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+const (
+	reconnectBackoff    = 1 * time.Second
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// ConnectionState describes the client's stream to the hub
+type ConnectionState string
+
+const (
+	StateConnected    ConnectionState = "connected"    // the stream is up
+	StateReconnecting ConnectionState = "reconnecting" // the stream died; requests fail with UNAVAILABLE
+	StateClosed       ConnectionState = "closed"       // Close was called
+)
+
+// errConnectionLost is returned to requests whose stream died before they
+// were answered; they may be retried once the client has reconnected
+var errConnectionLost = apierr.New(apierr.CodeUnavailable, "hub connection lost")
+
+// State returns the state of the client's stream to the hub
+func (hc *HubClient) State() ConnectionState {
+	hc.connMu.RLock()
+	defer hc.connMu.RUnlock()
+	return hc.state
+}
+
+// connection returns the current stream, its gRPC client and the channel
+// closed when the stream dies, or UNAVAILABLE unless connected
+func (hc *HubClient) connection() (pb.HubService_ConnectClient, pb.HubServiceClient, <-chan struct{}, error) {
+	hc.connMu.RLock()
+	defer hc.connMu.RUnlock()
+	switch hc.state {
+	case StateConnected:
+		return hc.stream, hc.client, hc.broken, nil
+	case StateClosed:
+		return nil, nil, nil, apierr.New(apierr.CodeUnavailable, "hub client closed")
+	default:
+		return nil, nil, nil, apierr.Newf(apierr.CodeUnavailable, "reconnecting to hub %s", hc.addresses[hc.addrIndex])
+	}
+}
+
+// currentClient returns the gRPC client of the current (or last)
+// connection, for the file transfer RPCs
+func (hc *HubClient) currentClient() pb.HubServiceClient {
+	hc.connMu.RLock()
+	defer hc.connMu.RUnlock()
+	return hc.client
+}
+
+// disconnected marks the stream dead: waiting requests return
+// UNAVAILABLE, streaming requests get an error response, and new requests
+// fail until reconnect succeeds
+func (hc *HubClient) disconnected() {
+	hc.connMu.Lock()
+	if hc.state != StateConnected {
+		hc.connMu.Unlock()
+		return
+	}
+	hc.state = StateReconnecting
+	close(hc.broken)
+	hc.connMu.Unlock()
+
+	hc.failStreams(errConnectionLost)
+}
+
+// failStreams ends every streaming request with apiErr
+func (hc *HubClient) failStreams(apiErr *apierr.ErrorResponse) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for id, stream := range hc.streams {
+		delete(hc.streams, id)
+		stream.fail(apiErr)
+	}
+}
+
+// reconnect opens a new stream, starting with the hub after the one that
+// failed and wrapping around, retrying with exponential backoff until one
+// accepts (and authenticates) or the client is closed. Channel
+// subscriptions are renewed on the new stream.
+func (hc *HubClient) reconnect() bool {
+	backoff := reconnectBackoff
+	for {
+		hc.connMu.RLock()
+		next := hc.addrIndex + 1
+		hc.connMu.RUnlock()
+
+		log.Printf("🔄 Reconnecting to hub...")
+		conn, client, stream, index, err := dialFirst(hc.addresses, next, hc.callOpts)
+		if err == nil && hc.token != "" {
+			if err = hc.authenticate(stream, hc.token); err != nil {
+				conn.Close()
+			}
+		}
+		if err == nil {
+			hc.connMu.Lock()
+			if hc.state == StateClosed {
+				hc.connMu.Unlock()
+				conn.Close()
+				return false
+			}
+			previous := hc.conn
+			hc.conn, hc.client, hc.stream, hc.addrIndex = conn, client, stream, index
+			hc.broken = make(chan struct{})
+			hc.state = StateConnected
+			hc.connMu.Unlock()
+			previous.Close()
+
+			log.Printf("✅ Reconnected to hub at %s", hc.addresses[index])
+			// Workers may have come and gone while disconnected
+			hc.InvalidateDiscovery()
+			// Needs the receive loop, which resumes when we return
+			go hc.resubscribe()
+			return true
+		}
+
+		log.Printf("⚠️  Reconnect failed, retrying in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-hc.closing:
+			return false
+		}
+		if backoff *= 2; backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// resubscribe renews the channel subscriptions held by the previous stream
+func (hc *HubClient) resubscribe() {
+	hc.channelMu.Lock()
+	channels := make([]string, 0, len(hc.channelHandlers))
+	for channel := range hc.channelHandlers {
+		channels = append(channels, channel)
+	}
+	hc.channelMu.Unlock()
+
+	for _, channel := range channels {
+		if err := hc.subscription("subscribe", channel); err != nil {
+			log.Printf("⚠️  Could not resubscribe to %s: %v", channel, err)
+		}
+	}
+}
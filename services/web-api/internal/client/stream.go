@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"log"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// streamEventBuffer is how many progress messages a slow reader may fall
+// behind before further ones are dropped
+const streamEventBuffer = 64
+
+// Stream is a request whose intermediate messages are relayed as they
+// arrive. Every message correlated to the request before its RESPONSE
+// (e.g. progress chunks sent by the worker) goes to Events; the RESPONSE
+// itself goes to Done.
+type Stream struct {
+	ID     string
+	Events <-chan *pb.Message
+	Done   <-chan *pb.Message
+
+	events chan *pb.Message
+	done   chan *pb.Message
+	hc     *HubClient
+}
+
+// StreamRequest sends a request and returns its Stream. Close the stream
+// when no longer interested; the hub has no way to cancel a request, so a
+// response arriving after Close is dropped.
+func (hc *HubClient) StreamRequest(ctx context.Context, targetWorker, capability, data string, metadata map[string]string) (*Stream, error) {
+	msg, err := hc.newRequest(targetWorker, capability, data, metadata)
+	if err != nil {
+		return nil, err
+	}
+	// Workers only send progress to callers that relay it
+	msg.Metadata[envelope.StreamKey] = "true"
+
+	stream := &Stream{
+		ID:     msg.Id,
+		events: make(chan *pb.Message, streamEventBuffer),
+		done:   make(chan *pb.Message, 1),
+		hc:     hc,
+	}
+	stream.Events = stream.events
+	stream.Done = stream.done
+
+	hc.mu.Lock()
+	hc.streams[msg.Id] = stream
+	hc.mu.Unlock()
+
+	if _, err := hc.send(ctx, msg); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Close stops routing messages to the stream
+func (s *Stream) Close() {
+	s.hc.mu.Lock()
+	delete(s.hc.streams, s.ID)
+	s.hc.mu.Unlock()
+}
+
+// fail ends the stream with an error response carrying apiErr. Like
+// deliver it is called with hc.mu held, after removing the stream.
+func (s *Stream) fail(apiErr *apierr.ErrorResponse) {
+	msg := &pb.Message{Id: s.ID, RequestId: s.ID, Type: pb.MessageType_ERROR}
+	envelope.SetError(msg, apiErr)
+	s.done <- msg // buffered; removed streams get nothing else
+}
+
+// deliver is called by routeResponse with hc.mu held, so it never blocks
+func (s *Stream) deliver(msg *pb.Message) {
+	if envelope.IsResponse(msg) {
+		s.done <- msg // buffered, delivered once
+		return
+	}
+	select {
+	case s.events <- msg:
+	default:
+		log.Printf("Dropping stream event %s for %s: reader is behind", msg.Id, s.ID)
+	}
+}
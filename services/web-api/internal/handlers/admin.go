@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/services/web-api/internal/client"
+)
+
+// AdminHandler serves /api/admin/* endpoints. Requests must carry
+// "Authorization: Bearer <token>"; without a token the endpoints are off.
+type AdminHandler struct {
+	hubClient *client.HubClient
+	token     string
+}
+
+// NewAdminHandler creates an admin handler guarded by token
+func NewAdminHandler(hubClient *client.HubClient, token string) *AdminHandler {
+	return &AdminHandler{hubClient: hubClient, token: token}
+}
+
+// authorize checks the bearer token and writes the error response if it fails
+func (h *AdminHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if h.token == "" {
+		writeAPIError(w, apierr.New(apierr.CodeForbidden, "admin API is disabled (set ADMIN_TOKEN)"))
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, apierr.New(apierr.CodeUnauthenticated, "invalid or missing admin token"))
+		return false
+	}
+	return true
+}
+
+// HandleConnections handles GET /api/admin/connections by asking the hub
+// for its live connections
+func (h *AdminHandler) HandleConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+
+	response, err := h.hubClient.SendControl("list_connections", "")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(response.Content))
+}
+
+// HandleWorkers handles GET /api/admin/workers by asking the hub for a
+// page of workers. Query parameters status, type, capability, labels
+// (selector), namespace, sort (id, last_seen, requests_handled), order
+// (asc, desc), limit and offset are passed through; the response carries
+// the matching total.
+func (h *AdminHandler) HandleWorkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	request := make(map[string]interface{})
+	for _, key := range []string{"status", "type", "capability", "labels", "namespace", "sort", "order"} {
+		if value := query.Get(key); value != "" {
+			request[key] = value
+		}
+	}
+	for _, key := range []string{"limit", "offset"} {
+		if value := query.Get(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				writeAPIError(w, apierr.Newf(apierr.CodeValidation, "%s must be an integer", key).WithDetail(key, value))
+				return
+			}
+			request[key] = n
+		}
+	}
+
+	content, _ := json.Marshal(request)
+	response, err := h.hubClient.SendControl("list_workers", string(content))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(response.Content))
+}
+
+// HandleWorker handles DELETE /api/admin/workers/{id}: the hub unregisters
+// the worker, deletes its rows and closes its connection, and the removed
+// capabilities are returned
+func (h *AdminHandler) HandleWorker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+
+	workerID := strings.TrimPrefix(r.URL.Path, "/api/admin/workers/")
+	if workerID == "" || strings.Contains(workerID, "/") {
+		writeAPIError(w, apierr.New(apierr.CodeValidation, "Use DELETE /api/admin/workers/{id}"))
+		return
+	}
+
+	content, _ := json.Marshal(map[string]string{"worker_id": workerID})
+	response, err := h.hubClient.SendControl("unregister_worker", string(content))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(response.Content))
+}
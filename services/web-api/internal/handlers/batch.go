@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// Batch defaults, overridable on DynamicHandler
+const (
+	DefaultBatchConcurrency = 8
+	DefaultMaxBatchSize     = 100
+	DefaultBatchTimeout     = 30 * time.Second
+)
+
+// Batch item statuses
+const (
+	batchItemSuccess = "success"
+	batchItemError   = "error"
+	batchItemSkipped = "skipped" // not sent, or abandoned after a fail_fast error
+)
+
+// BatchItemResult is the outcome of one payload of a batch, at the same
+// index as the payload
+type BatchItemResult struct {
+	Index         int                   `json:"index"`
+	Status        string                `json:"status"`
+	Response      string                `json:"response,omitempty"`
+	From          string                `json:"from,omitempty"`
+	CorrelationID string                `json:"correlation_id,omitempty"`
+	Error         *apierr.ErrorResponse `json:"error,omitempty"`
+}
+
+// HandleBatch calls a capability once per payload in a JSON array, with
+// at most BatchConcurrency requests in flight, and returns the results in
+// payload order.
+// Pattern: POST /api/{worker_id}/batch/{capability}?fail_fast=true&timeout=10s
+// With fail_fast the first failure cancels the payloads still pending;
+// timeout bounds the whole batch (default DefaultBatchTimeout).
+func (h *DynamicHandler) HandleBatch(w http.ResponseWriter, r *http.Request, workerID, capabilityName string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, apierr.New(apierr.CodeValidation, "Batch requests must use POST"))
+		return
+	}
+
+	query := r.URL.Query()
+	failFast, _ := strconv.ParseBool(query.Get("fail_fast"))
+	timeout := DefaultBatchTimeout
+	if value := query.Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			writeAPIError(w, apierr.Newf(apierr.CodeValidation, "Invalid timeout %q", value))
+			return
+		}
+		timeout = parsed
+	}
+
+	if !h.limitBody(w, r) {
+		return
+	}
+	var payloads []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&payloads); err != nil {
+		writeAPIError(w, h.bodyError(err, "Batch body must be a JSON array of payloads"))
+		return
+	}
+	if len(payloads) == 0 {
+		writeAPIError(w, apierr.New(apierr.CodeValidation, "Batch is empty"))
+		return
+	}
+	if maxSize := h.maxBatchSize(); len(payloads) > maxSize {
+		writeAPIError(w, apierr.Newf(apierr.CodeValidation, "Batch has %d payloads, the limit is %d", len(payloads), maxSize).
+			WithDetail("limit", maxSize))
+		return
+	}
+
+	// The request context also stops the batch when the caller disconnects
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	results := make([]BatchItemResult, len(payloads))
+	metadata := requestMetadata(r)
+	slots := make(chan struct{}, h.batchConcurrency())
+	var wg sync.WaitGroup
+
+	for i, payload := range payloads {
+		results[i] = BatchItemResult{Index: i, Status: batchItemSkipped}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, payload json.RawMessage) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result := h.callBatchItem(ctx, workerID, capabilityName, payload, metadata)
+			result.Index = i
+			results[i] = result
+			if failFast && result.Status == batchItemError {
+				cancel()
+			}
+		}(i, payload)
+	}
+	wg.Wait()
+
+	succeeded, failed := 0, 0
+	for _, result := range results {
+		switch result.Status {
+		case batchItemSuccess:
+			succeeded++
+		case batchItemError:
+			failed++
+		}
+	}
+	status := "success"
+	if succeeded == 0 {
+		status = "error"
+	} else if succeeded < len(results) {
+		status = "partial"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    status,
+		"total":     len(results),
+		"succeeded": succeeded,
+		"failed":    failed,
+		"skipped":   len(results) - succeeded - failed,
+		"results":   results,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// callBatchItem sends one payload. Payloads abandoned because the batch
+// was canceled are skipped rather than failed.
+func (h *DynamicHandler) callBatchItem(ctx context.Context, workerID, capabilityName string, payload json.RawMessage, metadata map[string]string) BatchItemResult {
+	var body map[string]interface{}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return BatchItemResult{
+			Status: batchItemError,
+			Error:  apierr.New(apierr.CodeValidation, fmt.Sprintf("Payload is not a JSON object: %v", err)),
+		}
+	}
+	requestJSON, _ := json.Marshal(body)
+
+	response, err := h.hubClient.SendRequestContext(ctx, workerID, capabilityName, string(requestJSON), metadata)
+	if errors.Is(err, context.Canceled) {
+		return BatchItemResult{Status: batchItemSkipped}
+	}
+	if err != nil {
+		var apiErr *apierr.ErrorResponse
+		if !errors.As(err, &apiErr) {
+			apiErr = apierr.New(apierr.CodeInternal, err.Error())
+		}
+		return BatchItemResult{Status: batchItemError, Error: apiErr}
+	}
+
+	result := BatchItemResult{
+		From:          response.From,
+		CorrelationID: response.Metadata["correlation_id"],
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		result.Status = batchItemError
+		result.Error = apiErr
+		return result
+	}
+	result.Status = batchItemSuccess
+	result.Response = response.Content
+	return result
+}
+
+func (h *DynamicHandler) batchConcurrency() int {
+	if h.BatchConcurrency > 0 {
+		return h.BatchConcurrency
+	}
+	return DefaultBatchConcurrency
+}
+
+func (h *DynamicHandler) maxBatchSize() int {
+	if h.MaxBatchSize > 0 {
+		return h.MaxBatchSize
+	}
+	return DefaultMaxBatchSize
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// DefaultBroadcastTimeout bounds a broadcast call when ?timeout= is not set
+const DefaultBroadcastTimeout = 10 * time.Second
+
+// Broadcast result statuses
+const (
+	broadcastSuccess = "success"
+	broadcastError   = "error"
+	broadcastTimeout = "timeout" // no response before the broadcast timeout
+)
+
+// broadcastQueryKeys control a broadcast and are not capability params
+var broadcastQueryKeys = []string{"broadcast", "timeout"}
+
+// BroadcastResult is one provider's outcome of a broadcast call
+type BroadcastResult struct {
+	Status        string                `json:"status"`
+	Response      string                `json:"response,omitempty"`
+	CorrelationID string                `json:"correlation_id,omitempty"`
+	DurationMs    int64                 `json:"duration_ms"`
+	Error         *apierr.ErrorResponse `json:"error,omitempty"`
+}
+
+// isBroadcast reports whether a call asked for ?broadcast=true
+func isBroadcast(r *http.Request) bool {
+	broadcast, _ := strconv.ParseBool(r.URL.Query().Get("broadcast"))
+	return broadcast
+}
+
+// handleBroadcast sends the same payload to every online provider of
+// capabilityName and returns worker_id -> result. Providers that have not
+// answered when the timeout (?timeout=, default DefaultBroadcastTimeout)
+// expires are reported as "timeout" next to the results that did arrive.
+// Pattern: /api/{worker_id}/call/{capability}?broadcast=true (worker_id is
+// ignored)
+func (h *DynamicHandler) handleBroadcast(w http.ResponseWriter, r *http.Request, capabilityName, requestData string) {
+	timeout := DefaultBroadcastTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			writeAPIError(w, apierr.Newf(apierr.CodeValidation, "Invalid timeout %q", value))
+			return
+		}
+		timeout = parsed
+	}
+
+	metadata := requestMetadata(r)
+	workerIDs, apiErr := h.broadcastProviders(capabilityName, metadata["label_selector"])
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	// The request context also stops the broadcast when the caller disconnects
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	results := make(map[string]BroadcastResult, len(workerIDs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, workerID := range workerIDs {
+		wg.Add(1)
+		go func(workerID string) {
+			defer wg.Done()
+			result := h.callBroadcastProvider(ctx, workerID, capabilityName, requestData, metadata)
+			mu.Lock()
+			results[workerID] = result
+			mu.Unlock()
+		}(workerID)
+	}
+	wg.Wait()
+
+	succeeded, failed := 0, 0
+	for _, result := range results {
+		if result.Status == broadcastSuccess {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
+	status := "success"
+	if succeeded == 0 {
+		status = "error"
+	} else if failed > 0 {
+		status = "partial"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     status,
+		"capability": capabilityName,
+		"total":      len(results),
+		"succeeded":  succeeded,
+		"failed":     failed,
+		"results":    results,
+		"timestamp":  time.Now().Format(time.RFC3339),
+	})
+}
+
+// callBroadcastProvider sends the payload to one provider
+func (h *DynamicHandler) callBroadcastProvider(ctx context.Context, workerID, capabilityName, requestData string, metadata map[string]string) BroadcastResult {
+	start := time.Now()
+	response, err := h.hubClient.SendRequestContext(ctx, workerID, capabilityName, requestData, metadata)
+	result := BroadcastResult{DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		var apiErr *apierr.ErrorResponse
+		if !errors.As(err, &apiErr) {
+			apiErr = apierr.New(apierr.CodeInternal, err.Error())
+		}
+		result.Status = broadcastError
+		if apiErr.Code == apierr.CodeTimeout || errors.Is(err, context.Canceled) {
+			result.Status = broadcastTimeout
+		}
+		result.Error = apiErr
+		return result
+	}
+
+	result.CorrelationID = response.Metadata["correlation_id"]
+	if apiErr, failed := envelope.Error(response); failed {
+		result.Status = broadcastError
+		result.Error = apiErr
+		return result
+	}
+	result.Status = broadcastSuccess
+	result.Response = response.Content
+	return result
+}
+
+// broadcastProviders returns the online workers offering capabilityName,
+// from the cached discovery, restricted to those matching selector
+// ("key=value,...", empty matches all)
+func (h *DynamicHandler) broadcastProviders(capabilityName, selector string) ([]string, *apierr.ErrorResponse) {
+	wanted := make(map[string]string)
+	if strings.TrimSpace(selector) != "" {
+		for _, term := range strings.Split(selector, ",") {
+			key, value, found := strings.Cut(term, "=")
+			if !found || strings.TrimSpace(key) == "" {
+				return nil, apierr.Newf(apierr.CodeValidation, "Invalid label selector term %q (expected key=value)", strings.TrimSpace(term)).
+					WithDetail("label_selector", selector)
+			}
+			wanted[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	response, err := h.hubClient.Discover("")
+	if err != nil {
+		return nil, apierr.Newf(apierr.CodeInternal, "Error discovering capabilities: %v", err)
+	}
+	var discovery struct {
+		Providers map[string][]struct {
+			WorkerID string            `json:"worker_id"`
+			Status   string            `json:"status"`
+			Labels   map[string]string `json:"labels"`
+		} `json:"providers"`
+	}
+	if err := json.Unmarshal([]byte(response.Content), &discovery); err != nil {
+		return nil, apierr.New(apierr.CodeInternal, "Failed to parse capabilities")
+	}
+
+	var workerIDs []string
+	for _, provider := range discovery.Providers[capabilityName] {
+		if provider.Status != "online" {
+			continue
+		}
+		matches := true
+		for key, value := range wanted {
+			if actual, ok := provider.Labels[key]; !ok || actual != value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			workerIDs = append(workerIDs, provider.WorkerID)
+		}
+	}
+	if len(workerIDs) == 0 {
+		return nil, apierr.Newf(apierr.CodeNoWorker, "No worker available for capability: %s", capabilityName).
+			WithDetail("capability", capabilityName)
+	}
+	return workerIDs, nil
+}
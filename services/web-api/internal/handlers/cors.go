@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig controls which browser origins may call the API. With no
+// AllowedOrigins, no CORS headers are sent and only same-origin pages
+// (such as the bundled UI) can read responses.
+type CORSConfig struct {
+	AllowedOrigins   []string // exact origins, e.g. "https://app.example.com"; "*" allows any
+	AllowedMethods   []string
+	AllowedHeaders   []string // "*" allows whatever the preflight requests
+	ExposedHeaders   []string // response headers readable by the page
+	AllowCredentials bool     // cookies and Authorization on cross-origin requests
+	MaxAge           time.Duration
+}
+
+// DefaultCORSConfig returns the methods, headers and exposed headers the
+// API uses, with no allowed origins
+func DefaultCORSConfig() CORSConfig {
+	return CORSConfig{
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: []string{"Content-Type", "Authorization", CorrelationHeader, LabelSelectorHeader, TimeoutHeader, "Cache-Control"},
+		ExposedHeaders: []string{CorrelationHeader, "Warning", "Retry-After", CacheHeader},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// CORS wraps next with CORS handling. The Origin of an allowed request is
+// echoed back (or "*" when any origin is allowed without credentials), and
+// preflight requests are answered here with the configured values.
+func CORS(cfg CORSConfig, next http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+
+	anyOrigin := false
+	origins := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			anyOrigin = true
+		}
+		origins[strings.ToLower(strings.TrimRight(origin, "/"))] = true
+	}
+	anyHeader := false
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			anyHeader = true
+		}
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// Responses differ per origin, so caches must key on it
+		w.Header().Add("Vary", "Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !anyOrigin && !origins[strings.ToLower(origin)] {
+			// No CORS headers: the browser blocks the page from the response
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if anyOrigin && !cfg.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if exposed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposed)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+		if anyHeader {
+			w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		} else if headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		if cfg.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -1,20 +1,41 @@
 package handlers
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
 	"deepapp_golang_grpc_hub/services/web-api/internal/client"
 )
 
 // DynamicHandler handles dynamic capability discovery and Swagger
 type DynamicHandler struct {
 	hubClient *client.HubClient
+
+	// BatchConcurrency caps the requests in flight per batch and
+	// MaxBatchSize the payloads per batch (0 uses the defaults)
+	BatchConcurrency int
+	MaxBatchSize     int
+
+	// MaxRequestBytes caps request bodies (413 above it) and
+	// MaxMultipartMemory the upload bytes buffered in memory (0 uses the
+	// defaults)
+	MaxRequestBytes    int64
+	MaxMultipartMemory int64
+
+	// MaxCallTimeout caps the default timeout a capability declares (0
+	// uses DefaultMaxCallTimeout)
+	MaxCallTimeout time.Duration
 }
 
 // NewDynamicHandler creates a new dynamic handler
@@ -22,15 +43,11 @@ func NewDynamicHandler(hubClient *client.HubClient) *DynamicHandler {
 	return &DynamicHandler{hubClient: hubClient}
 }
 
-// HandleCapabilities returns all available capabilities from Hub
+// HandleCapabilities returns all available capabilities from Hub,
+// optionally filtered with ?tag=
 func (h *DynamicHandler) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
-	// Send discovery request to Hub
-	discoveryMsg := map[string]interface{}{
-		"action": "discover",
-	}
-	discoveryJSON, _ := json.Marshal(discoveryMsg)
-
-	response, err := h.hubClient.SendRequest("hub", "capability_discovery", string(discoveryJSON))
+	// Discovery results are cached by the hub client
+	response, err := h.hubClient.Discover(r.URL.Query().Get("tag"))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error discovering capabilities: %v", err), http.StatusInternalServerError)
 		return
@@ -50,14 +67,13 @@ func (h *DynamicHandler) HandleCapabilities(w http.ResponseWriter, r *http.Reque
 // HandleSwagger generates dynamic Swagger documentation
 func (h *DynamicHandler) HandleSwagger(w http.ResponseWriter, r *http.Request) {
 	// Get capabilities from Hub
-	discoveryMsg := map[string]interface{}{
-		"action": "discover",
-	}
-	discoveryJSON, _ := json.Marshal(discoveryMsg)
-
-	response, err := h.hubClient.SendRequest("hub", "capability_discovery", string(discoveryJSON))
+	response, err := h.hubClient.Discover("")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
 		return
 	}
 
@@ -103,8 +119,8 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 
 	paths := spec["paths"].(map[string]interface{})
 
-	// Track which workers have which capabilities
-	workerCapabilities := make(map[string][]string)
+	// Track which workers have which capabilities (worker_id -> name -> capability)
+	workerCapabilities := make(map[string]map[string]map[string]interface{})
 	for _, workerData := range discoveryResult.Workers {
 		if workerMap, ok := workerData.(map[string]interface{}); ok {
 			workerID, _ := workerMap["id"].(string)
@@ -112,7 +128,10 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 				for _, cap := range caps {
 					if capMap, ok := cap.(map[string]interface{}); ok {
 						if capName, ok := capMap["name"].(string); ok {
-							workerCapabilities[workerID] = append(workerCapabilities[workerID], capName)
+							if workerCapabilities[workerID] == nil {
+								workerCapabilities[workerID] = make(map[string]map[string]interface{})
+							}
+							workerCapabilities[workerID][capName] = capMap
 						}
 					}
 				}
@@ -120,9 +139,19 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 		}
 	}
 
-	// Add dynamic endpoints based on capabilities with worker-specific paths
-	for capName, capData := range discoveryResult.Capabilities {
-		capMap, ok := capData.(map[string]interface{})
+	// Tags used by operations, listed at the top level of the spec
+	usedTags := map[string]bool{hubTag: true}
+
+	// Add dynamic endpoints based on capabilities with worker-specific
+	// paths, in sorted order so the generated spec is stable
+	workerIDs := make([]string, 0, len(workerCapabilities))
+	for workerID := range workerCapabilities {
+		workerIDs = append(workerIDs, workerID)
+	}
+	sort.Strings(workerIDs)
+
+	for _, capName := range sortedKeys(discoveryResult.Capabilities) {
+		capMap, ok := discoveryResult.Capabilities[capName].(map[string]interface{})
 		if !ok {
 			continue
 		}
@@ -132,11 +161,6 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 			description = desc
 		}
 
-		httpMethod := "post"
-		if method, ok := capMap["http_method"].(string); ok {
-			httpMethod = strings.ToLower(method) // Normalize to lowercase for OpenAPI spec
-		}
-
 		acceptsFile := false
 		if af, ok := capMap["accepts_file"].(bool); ok {
 			acceptsFile = af
@@ -149,20 +173,46 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 
 		// Create worker-specific paths: /api/{worker_id}/call/{capability}
 		// Find which workers have this capability
-		for workerID, caps := range workerCapabilities {
-			hasCapability := false
-			for _, cap := range caps {
-				if cap == capName {
-					hasCapability = true
-					break
-				}
-			}
+		for _, workerID := range workerIDs {
+			workerCap, hasCapability := workerCapabilities[workerID][capName]
 			if !hasCapability {
 				continue
 			}
 
+			// Workers may tag the same capability differently
+			tags := capabilityTags(capName, workerCap)
+			for _, tag := range tags {
+				usedTags[tag] = true
+			}
+
+			// Description and file upload also come from this worker's
+			// registration, whichever worker the capability list was
+			// built from
+			description := description
+			if desc, ok := workerCap["description"].(string); ok {
+				description = desc
+			}
+			acceptsFile := acceptsFile
+			if af, ok := workerCap["accepts_file"].(bool); ok {
+				acceptsFile = af
+			}
+			fileFieldName := fileFieldName
+			if ffn := stringField(workerCap, "file_field_name"); ffn != "" {
+				fileFieldName = ffn
+			}
+
 			path := fmt.Sprintf("/api/%s/call/%s", workerID, capName)
 
+			// Workers may register the same capability with different
+			// methods; lowercase for the OpenAPI spec
+			httpMethod := strings.ToLower(capabilityMethod(stringField(workerCap, "http_method")))
+
+			// Schemas come from this worker's registration, falling back to a
+			// generic object when it declared none
+			inputSchema, hasInputSchema := convertSchema(stringField(workerCap, "input_schema"))
+			outputSchema, hasOutputSchema := convertSchema(stringField(workerCap, "output_schema"))
+			example, hasExample := parseExample(stringField(workerCap, "example"))
+
 			requestBody := map[string]interface{}{
 				"required": true,
 				"content":  map[string]interface{}{},
@@ -171,8 +221,16 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 			content := requestBody["content"].(map[string]interface{})
 
 			if acceptsFile {
+				paramsSchema := map[string]interface{}{
+					"type":        "object",
+					"description": "Additional parameters as JSON",
+				}
+				if hasInputSchema {
+					paramsSchema = inputSchema
+				}
+
 				// Multipart form data for file upload
-				content["multipart/form-data"] = map[string]interface{}{
+				multipart := map[string]interface{}{
 					"schema": map[string]interface{}{
 						"type": "object",
 						"properties": map[string]interface{}{
@@ -180,27 +238,51 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 								"type":   "string",
 								"format": "binary",
 							},
-							"params": map[string]interface{}{
-								"type":        "object",
-								"description": "Additional parameters as JSON",
-							},
+							"params": paramsSchema,
 						},
 					},
 				}
+				if hasExample {
+					multipart["example"] = map[string]interface{}{"params": example}
+				}
+				content["multipart/form-data"] = multipart
 			} else {
+				bodySchema := map[string]interface{}{
+					"type":        "object",
+					"description": "Request parameters",
+				}
+				if hasInputSchema {
+					bodySchema = inputSchema
+				}
+
 				// JSON request body
-				content["application/json"] = map[string]interface{}{
-					"schema": map[string]interface{}{
-						"type":        "object",
-						"description": "Request parameters",
-					},
+				jsonBody := map[string]interface{}{
+					"schema": bodySchema,
+				}
+				if hasExample {
+					jsonBody["example"] = example
+				}
+				content["application/json"] = jsonBody
+			}
+
+			responseSchema := map[string]interface{}{"type": "string"}
+			if hasOutputSchema {
+				responseSchema = outputSchema
+				responseSchema["description"] = "Capability output (returned as a JSON-encoded string)"
+			}
+
+			operationDescription := description
+			deprecated, _ := workerCap["deprecated"].(bool)
+			if deprecated {
+				if message := stringField(workerCap, "deprecation_message"); message != "" {
+					operationDescription = fmt.Sprintf("**Deprecated:** %s\n\n%s", message, description)
 				}
 			}
 
 			operation := map[string]interface{}{
 				"summary":     fmt.Sprintf("Call %s capability on %s", capName, workerID),
-				"description": description,
-				"requestBody": requestBody,
+				"description": operationDescription,
+				"tags":        tags,
 				"responses": map[string]interface{}{
 					"200": map[string]interface{}{
 						"description": "Successful response",
@@ -210,7 +292,7 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 									"type": "object",
 									"properties": map[string]interface{}{
 										"status":    map[string]interface{}{"type": "string"},
-										"response":  map[string]interface{}{"type": "string"},
+										"response":  responseSchema,
 										"from":      map[string]interface{}{"type": "string"},
 										"timestamp": map[string]interface{}{"type": "string"},
 									},
@@ -221,6 +303,17 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 				},
 			}
 
+			if httpMethod == "get" || httpMethod == "delete" {
+				// No body: params go in the query
+				operation["parameters"] = queryParameters(inputSchema, hasInputSchema, example)
+			} else {
+				operation["requestBody"] = requestBody
+			}
+
+			if deprecated {
+				operation["deprecated"] = true
+			}
+
 			paths[path] = map[string]interface{}{
 				httpMethod: operation,
 			}
@@ -232,6 +325,16 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 		"get": map[string]interface{}{
 			"summary":     "Get all available capabilities",
 			"description": "Returns list of all registered worker capabilities",
+			"tags":        []string{hubTag},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "tag",
+					"in":          "query",
+					"required":    false,
+					"description": "Only list capabilities with this tag",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			},
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "List of capabilities",
@@ -240,10 +343,132 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 		},
 	}
 
+	paths["/api/{worker_id}/batch/{capability}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Call a capability with many payloads",
+			"description": "Sends each payload of the array as a separate request (bounded concurrency) and returns the results in the same order",
+			"tags":        []string{hubTag},
+			"parameters": []map[string]interface{}{
+				{"name": "worker_id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+				{"name": "capability", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+				{
+					"name":        "fail_fast",
+					"in":          "query",
+					"required":    false,
+					"description": "Skip the remaining payloads after the first failure",
+					"schema":      map[string]interface{}{"type": "boolean"},
+				},
+				{
+					"name":        "timeout",
+					"in":          "query",
+					"required":    false,
+					"description": "Deadline for the whole batch, e.g. 10s (default 30s)",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			},
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type":  "array",
+							"items": map[string]interface{}{"type": "object"},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Per-payload results with status success, error or skipped",
+				},
+			},
+		},
+	}
+
+	paths["/api/{worker_id}/stream/{capability}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Call a capability and stream its progress",
+			"description": "Server-Sent Events: worker progress messages as unnamed events, then a \"response\" or \"error\" event",
+			"tags":        []string{hubTag},
+			"parameters": []map[string]interface{}{
+				{"name": "worker_id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+				{"name": "capability", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+				{
+					"name":        "params",
+					"in":          "query",
+					"required":    false,
+					"description": "Capability parameters as a JSON object",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+				{
+					"name":        "timeout",
+					"in":          "query",
+					"required":    false,
+					"description": "Deadline for the call, e.g. 1m (default 5m)",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Event stream",
+					"content": map[string]interface{}{
+						"text/event-stream": map[string]interface{}{
+							"schema": map[string]interface{}{"type": "string"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	paths["/api/files"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Upload a file",
+			"description": "Streams the first file part to the hub's file storage and returns its file_id, which capabilities can take instead of inline base64",
+			"tags":        []string{hubTag},
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"multipart/form-data": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"file": map[string]interface{}{"type": "string", "format": "binary"},
+							},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"201": map[string]interface{}{"description": "Stored file: file_id, filename, size, content_type"},
+				"413": map[string]interface{}{"description": "File above MAX_UPLOAD_BYTES (PAYLOAD_TOO_LARGE)"},
+			},
+		},
+	}
+
+	paths["/api/files/{file_id}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Download a stored file",
+			"description": "Streams a file from the hub's file storage; a single Range: bytes= range gets 206",
+			"tags":        []string{hubTag},
+			"parameters": []map[string]interface{}{
+				{"name": "file_id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+				{"name": "Range", "in": "header", "required": false, "schema": map[string]interface{}{"type": "string"}},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{"description": "File content"},
+				"206": map[string]interface{}{"description": "Requested byte range"},
+				"404": map[string]interface{}{"description": "Unknown file_id (FILE_NOT_FOUND)"},
+				"416": map[string]interface{}{"description": "Range beyond the file size"},
+			},
+		},
+	}
+
 	paths["/api/status"] = map[string]interface{}{
 		"get": map[string]interface{}{
 			"summary":     "API Status",
 			"description": "Check API health status",
+			"tags":        []string{hubTag},
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "Status information",
@@ -252,10 +477,45 @@ This pattern prevents naming conflicts when multiple workers implement capabilit
 		},
 	}
 
+	spec["tags"] = swaggerTags(usedTags)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(spec)
 }
 
+// HandleWorkerHealth calls the built-in __health capability of a worker
+// Pattern: /api/health/{worker_id}
+func (h *DynamicHandler) HandleWorkerHealth(w http.ResponseWriter, r *http.Request) {
+	workerID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/health/"), "/")
+	if workerID == "" {
+		http.Error(w, "Worker ID required. Use /api/health/{worker_id}", http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.hubClient.SendRequest(workerID, "__health", "{}")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	var health map[string]interface{}
+	if err := json.Unmarshal([]byte(response.Content), &health); err != nil {
+		http.Error(w, "Failed to parse health response", http.StatusBadGateway)
+		return
+	}
+
+	// Workers without the built-in capability answer with an error
+	if _, failed := health["error"]; failed {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(health)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health)
+}
+
 // HandleSwaggerUI serves Swagger UI HTML
 func (h *DynamicHandler) HandleSwaggerUI(w http.ResponseWriter, r *http.Request) {
 	html := `
@@ -307,17 +567,25 @@ func (h *DynamicHandler) HandleDynamicCall(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !h.limitBody(w, r) {
+		return
+	}
+
 	var requestData string
 
 	// Check if request has file upload
-	contentType := r.Header.Get("Content-Type")
-	if len(contentType) > 19 && contentType[:19] == "multipart/form-data" {
+	multipartForm, apiErr := isMultipart(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	if multipartForm {
 		// Handle file upload
-		err := r.ParseMultipartForm(100 << 20) // 100 MB max
-		if err != nil {
-			http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+		if err := r.ParseMultipartForm(h.maxMultipartMemory()); err != nil {
+			writeAPIError(w, h.bodyError(err, "Failed to parse multipart form"))
 			return
 		}
+		defer r.MultipartForm.RemoveAll()
 
 		file, header, err := r.FormFile("file")
 		if err != nil {
@@ -362,7 +630,7 @@ func (h *DynamicHandler) HandleDynamicCall(w http.ResponseWriter, r *http.Reques
 		// Handle JSON request
 		var body map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			writeAPIError(w, h.bodyError(err, "Invalid JSON"))
 			return
 		}
 
@@ -371,9 +639,23 @@ func (h *DynamicHandler) HandleDynamicCall(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Send to Hub (let Hub route to appropriate worker)
-	response, err := h.hubClient.SendRequest("", capabilityName, requestData)
+	timeout, apiErr := h.callTimeout(r, "", capabilityName)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	response, err := h.hubClient.SendRequestContext(ctx, "", capabilityName, requestData, requestMetadata(r))
+	setCorrelationHeader(w, r, response)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	setDeprecationWarning(w, response)
+	setCacheHeader(w, response)
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
 		return
 	}
 
@@ -391,6 +673,7 @@ func (h *DynamicHandler) HandleDynamicCall(w http.ResponseWriter, r *http.Reques
 // Examples:
 //   /api/python-worker/call/hello
 //   /api/java-simple-worker/call/read_file_info
+//   /api/any/call/hello_go?broadcast=true (every provider, see handleBroadcast)
 func (h *DynamicHandler) HandleWorkerCall(w http.ResponseWriter, r *http.Request) {
 	// Parse path: /api/{worker_id}/call/{capability}
 	path := strings.TrimPrefix(r.URL.Path, "/api/")
@@ -423,8 +706,8 @@ func (h *DynamicHandler) HandleWorkerCall(w http.ResponseWriter, r *http.Request
 	action := parts[1]
 	capabilityName := parts[2]
 
-	if action != "call" {
-		http.Error(w, "Invalid action. Use 'call'", http.StatusBadRequest)
+	if action != "call" && action != "batch" && action != "stream" {
+		http.Error(w, "Invalid action. Use 'call', 'batch' or 'stream'", http.StatusBadRequest)
 		return
 	}
 
@@ -433,17 +716,44 @@ func (h *DynamicHandler) HandleWorkerCall(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	switch action {
+	case "batch":
+		h.HandleBatch(w, r, workerID, capabilityName)
+		return
+	case "stream":
+		h.HandleStream(w, r, workerID, capabilityName)
+		return
+	}
+
+	// Calls must use the method the capability registered with (a
+	// broadcast goes to several workers and is not tied to one of them)
+	broadcast := isBroadcast(r)
+	if method, found := h.declaredMethod(workerID, capabilityName); found && !broadcast && r.Method != method {
+		writeMethodNotAllowed(w, method, apierr.Newf(apierr.CodeValidation,
+			"%s on %s must be called with %s, not %s", capabilityName, workerID, method, r.Method).
+			WithDetail("allowed", method))
+		return
+	}
+
+	if !h.limitBody(w, r) {
+		return
+	}
+
 	var requestData string
 
 	// Check if request has file upload
-	contentType := r.Header.Get("Content-Type")
-	if len(contentType) > 19 && contentType[:19] == "multipart/form-data" {
+	multipartForm, apiErr := isMultipart(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	if multipartForm {
 		// Handle file upload
-		err := r.ParseMultipartForm(100 << 20) // 100 MB max
-		if err != nil {
-			http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+		if err := r.ParseMultipartForm(h.maxMultipartMemory()); err != nil {
+			writeAPIError(w, h.bodyError(err, "Failed to parse multipart form"))
 			return
 		}
+		defer r.MultipartForm.RemoveAll()
 
 		file, header, err := r.FormFile("file")
 		if err != nil {
@@ -486,13 +796,26 @@ func (h *DynamicHandler) HandleWorkerCall(w http.ResponseWriter, r *http.Request
 		params["file"] = encodedData
 		params["image"] = encodedData // Also add as 'image' for OCR workers
 
+		requestJSON, _ := json.Marshal(params)
+		requestData = string(requestJSON)
+	} else if r.Method == http.MethodGet || r.Method == http.MethodDelete {
+		// Requests without a body take their params from the query
+		var reserved []string
+		if broadcast {
+			reserved = broadcastQueryKeys
+		}
+		params, apiErr := queryParams(r.URL.Query(), reserved...)
+		if apiErr != nil {
+			writeAPIError(w, apiErr)
+			return
+		}
 		requestJSON, _ := json.Marshal(params)
 		requestData = string(requestJSON)
 	} else {
 		// Handle JSON request
 		var body map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			writeAPIError(w, h.bodyError(err, "Invalid JSON"))
 			return
 		}
 
@@ -500,10 +823,29 @@ func (h *DynamicHandler) HandleWorkerCall(w http.ResponseWriter, r *http.Request
 		requestData = string(requestJSON)
 	}
 
+	if broadcast {
+		h.handleBroadcast(w, r, capabilityName, requestData)
+		return
+	}
+
 	// Send to specific worker
-	response, err := h.hubClient.SendRequest(workerID, capabilityName, requestData)
+	timeout, apiErr := h.callTimeout(r, workerID, capabilityName)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	response, err := h.hubClient.SendRequestContext(ctx, workerID, capabilityName, requestData, requestMetadata(r))
+	setCorrelationHeader(w, r, response)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	setDeprecationWarning(w, response)
+	setCacheHeader(w, response)
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
 		return
 	}
 
@@ -514,4 +856,127 @@ func (h *DynamicHandler) HandleWorkerCall(w http.ResponseWriter, r *http.Request
 		"from":      response.From,
 		"timestamp": time.Now().Format(time.RFC3339),
 	})
-}
\ No newline at end of file
+}
+
+// setDeprecationWarning adds a Warning header when the hub flagged the
+// invoked capability as deprecated
+func setDeprecationWarning(w http.ResponseWriter, response *pb.Message) {
+	capability, deprecated := response.Metadata["deprecated"]
+	if !deprecated {
+		return
+	}
+
+	warning := fmt.Sprintf("capability %s is deprecated", capability)
+	if message := response.Metadata["deprecation_message"]; message != "" {
+		warning += ": " + message
+	}
+	w.Header().Add("Warning", fmt.Sprintf("299 - %q", warning))
+}
+
+// CorrelationHeader carries the correlation ID between HTTP callers and the hub
+const CorrelationHeader = "X-Correlation-ID"
+
+// LabelSelectorHeader restricts which workers the hub may pick, e.g.
+// "region=eu,gpu=true"
+const LabelSelectorHeader = "X-Label-Selector"
+
+// CacheHeader tells whether the hub answered from its result cache ("HIT")
+const CacheHeader = "X-Cache"
+
+// requestMetadata is the hub metadata taken from an HTTP request.
+// "Cache-Control: no-cache" asks the hub for a fresh result even when the
+// capability is cacheable.
+func requestMetadata(r *http.Request) map[string]string {
+	metadata := map[string]string{
+		"correlation_id": r.Header.Get(CorrelationHeader),
+		"label_selector": r.Header.Get(LabelSelectorHeader),
+	}
+	if strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache") {
+		metadata["cache_bypass"] = "true"
+	}
+	return metadata
+}
+
+// setCacheHeader sets CacheHeader to HIT when the hub served the response
+// from its result cache
+func setCacheHeader(w http.ResponseWriter, response *pb.Message) {
+	if response.Metadata["cache"] == "hit" {
+		w.Header().Set(CacheHeader, "HIT")
+	}
+}
+
+// setCorrelationHeader echoes the correlation ID assigned by the hub, or
+// the caller's own when there is no response
+func setCorrelationHeader(w http.ResponseWriter, r *http.Request, response *pb.Message) {
+	id := r.Header.Get(CorrelationHeader)
+	if response != nil && response.Metadata["correlation_id"] != "" {
+		id = response.Metadata["correlation_id"]
+	}
+	if id != "" {
+		w.Header().Set(CorrelationHeader, id)
+	}
+}
+
+// declaredMethod returns the HTTP method workerID registered for
+// capabilityName, from the cached discovery. found is false when the
+// capability is unknown, leaving the hub to report it.
+func (h *DynamicHandler) declaredMethod(workerID, capabilityName string) (method string, found bool) {
+	response, err := h.hubClient.Discover("")
+	if err != nil {
+		return "", false
+	}
+
+	var discovery struct {
+		Workers []struct {
+			ID           string `json:"id"`
+			Capabilities []struct {
+				Name       string `json:"name"`
+				HTTPMethod string `json:"http_method"`
+			} `json:"capabilities"`
+		} `json:"workers"`
+	}
+	if err := json.Unmarshal([]byte(response.Content), &discovery); err != nil {
+		return "", false
+	}
+
+	for _, worker := range discovery.Workers {
+		if worker.ID != workerID {
+			continue
+		}
+		for _, capability := range worker.Capabilities {
+			if capability.Name == capabilityName {
+				return capabilityMethod(capability.HTTPMethod), true
+			}
+		}
+	}
+	return "", false
+}
+
+// capabilityMethod normalizes a registered http_method; POST when unset
+func capabilityMethod(method string) string {
+	if method == "" {
+		return http.MethodPost
+	}
+	return strings.ToUpper(method)
+}
+
+// isMultipart reports whether the request body is multipart/form-data.
+// Other or missing content types are read as JSON; a Content-Type that
+// does not parse is rejected.
+func isMultipart(r *http.Request) (bool, *apierr.ErrorResponse) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return false, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, apierr.Newf(apierr.CodeBadContentType, "Invalid Content-Type %q: %v", contentType, err)
+	}
+	if mediaType != "multipart/form-data" {
+		return false, nil
+	}
+	if params["boundary"] == "" {
+		return false, apierr.New(apierr.CodeValidation, "multipart/form-data requires a boundary")
+	}
+	return true, nil
+}
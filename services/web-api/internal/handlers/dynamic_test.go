@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+func TestIsMultipart(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		multipart   bool
+		code        apierr.Code
+	}{
+		{"", false, ""},
+		{"text/plain", false, ""},
+		{"application/json; charset=utf-8", false, ""},
+		{"a", false, ""},
+		{"multipart", false, ""},
+		{"multipart/form-data; boundary=xyz", true, ""},
+		{"Multipart/Form-Data; boundary=\"a b\"", true, ""},
+		{"multipart/form-data", false, apierr.CodeValidation},
+		{"multipart/form-datax; boundary=xyz", false, ""},
+		{"multipart/", false, apierr.CodeBadContentType},
+		{";;", false, apierr.CodeBadContentType},
+		{"/", false, apierr.CodeBadContentType},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/w1/call/echo", nil)
+		if tc.contentType != "" {
+			r.Header.Set("Content-Type", tc.contentType)
+		}
+		multipart, apiErr := isMultipart(r)
+		var code apierr.Code
+		if apiErr != nil {
+			code = apiErr.Code
+		}
+		if multipart != tc.multipart || code != tc.code {
+			t.Errorf("%q: got %v %q, want %v %q", tc.contentType, multipart, code, tc.multipart, tc.code)
+		}
+	}
+}
+
+// A JSON body sent as text/plain is read as JSON; short malformed values
+// are answered instead of crashing the handler
+func TestCallWithUnusualContentType(t *testing.T) {
+	hub := &fakeHub{}
+	h := newTestHandler(t, hub)
+
+	rec := callWorker(h, http.MethodPost, "echo", "text/plain", strings.NewReader(`{"text":"xin chào"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("text/plain: %d %s", rec.Code, rec.Body)
+	}
+	if requests := hub.requests(); len(requests) != 1 || requests[0].Content != `{"text":"xin chào"}` {
+		t.Fatalf("hub got %v", requests)
+	}
+
+	for contentType, status := range map[string]int{
+		"m":          http.StatusOK, // parses, so read as JSON like any other type
+		";":          http.StatusUnsupportedMediaType,
+		"multipart/": http.StatusUnsupportedMediaType,
+	} {
+		rec := callWorker(h, http.MethodPost, "echo", contentType, strings.NewReader(`{}`))
+		if rec.Code != status {
+			t.Errorf("%q: got %d %s, want %d", contentType, rec.Code, rec.Body, status)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// writeError writes err as an ErrorResponse; errors that are not already
+// typed are reported as INTERNAL
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *apierr.ErrorResponse
+	if !errors.As(err, &apiErr) {
+		apiErr = apierr.New(apierr.CodeInternal, err.Error())
+	}
+	writeAPIError(w, apiErr)
+}
+
+// writeAPIError writes apiErr with the HTTP status matching its code.
+// Transient overload errors carry Retry-After.
+func writeAPIError(w http.ResponseWriter, apiErr *apierr.ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if apiErr.Code == apierr.CodeWorkerBusy || apiErr.Code == apierr.CodeRateLimited || apiErr.Code == apierr.CodeUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.WriteHeader(apiErr.HTTPStatus())
+	w.Write([]byte(apiErr.JSON()))
+}
+
+// writeMethodNotAllowed answers a request made with another method than
+// allowed with 405 and an Allow header
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string, apiErr *apierr.ErrorResponse) {
+	w.Header().Set("Allow", allowed)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	w.Write([]byte(apiErr.JSON()))
+}
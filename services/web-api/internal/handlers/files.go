@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/services/web-api/internal/client"
+)
+
+// downloadChunkSize is the chunk size asked of the hub for downloads
+const downloadChunkSize = 64 * 1024
+
+// uploadChunkSize is the size of the chunks uploads are streamed to the hub
+// in: small enough for fine-grained progress, and the chunk size the hub
+// recommends
+const uploadChunkSize = 64 * 1024
+
+// DefaultMaxUploadBytes caps files uploaded to POST /api/files
+const DefaultMaxUploadBytes = 1 << 30
+
+// multipartOverhead is the room left in the request body for the multipart
+// boundaries, part headers and small form fields around the file
+const multipartOverhead = 1 << 20
+
+// errUploadTooLarge stops an upload whose file exceeds MaxUploadBytes
+var errUploadTooLarge = errors.New("upload exceeds the size limit")
+
+// FileHandler serves files stored in the hub's file storage
+type FileHandler struct {
+	hubClient *client.HubClient
+
+	// MaxUploadBytes caps uploaded files (413 above it); 0 means
+	// DefaultMaxUploadBytes
+	MaxUploadBytes int64
+}
+
+// NewFileHandler creates a file handler
+func NewFileHandler(hubClient *client.HubClient) *FileHandler {
+	return &FileHandler{hubClient: hubClient}
+}
+
+// byteRange is a single "Range: bytes=" request. start < 0 asks for the
+// last suffix bytes; end < 0 means up to the end of the file.
+type byteRange struct {
+	start, end, suffix int64
+}
+
+// parseRange reads a single-range Range header. ok is false when the
+// header is absent, malformed or asks for several ranges, in which case
+// the whole file is served.
+func parseRange(header string) (byteRange, bool) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return byteRange{}, false
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if strings.Contains(spec, ",") {
+		return byteRange{}, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return byteRange{}, false
+		}
+		return byteRange{start: -1, end: -1, suffix: suffix}, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false
+	}
+	end := int64(-1)
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return byteRange{}, false
+		}
+	}
+	return byteRange{start: start, end: end}, true
+}
+
+// HandleDownload handles GET /api/files/{file_id}: the file's bytes are
+// relayed from the hub's DownloadFile stream chunk by chunk, never held in
+// memory whole. Content-Type and Content-Disposition come from the
+// filename and content type given at upload (the type is sniffed when the
+// hub does not know it). X-Checksum-SHA256 carries the whole file's
+// checksum recorded at upload, when the hub has one. A single
+// "Range: bytes=" range is answered with 206, starting the download at its
+// offset.
+func (h *FileHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, "GET, HEAD", apierr.Newf(apierr.CodeValidation, "%s is not allowed on files", r.Method))
+		return
+	}
+	fileID := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	if fileID == "" || strings.Contains(fileID, "/") {
+		writeAPIError(w, apierr.New(apierr.CodeValidation, "Use /api/files/{file_id}"))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	rng, ranged := parseRange(r.Header.Get("Range"))
+	offset := rng.start
+	if !ranged || offset < 0 {
+		offset = 0
+	}
+	stream, first, apiErr := h.open(ctx, fileID, offset)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	total := first.TotalSize
+
+	// A suffix range needs the size first: reopen at the computed offset
+	if ranged && rng.suffix > 0 {
+		rng.start = total - rng.suffix
+		if rng.start < 0 {
+			rng.start = 0
+		}
+		if rng.start > 0 {
+			cancel()
+			ctx, cancel = context.WithCancel(r.Context())
+			defer cancel()
+			if stream, first, apiErr = h.open(ctx, fileID, rng.start); apiErr != nil {
+				writeAPIError(w, apiErr)
+				return
+			}
+		}
+	}
+
+	start, end := int64(0), total-1
+	if ranged {
+		if rng.start >= total {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			w.Write([]byte(apierr.Newf(apierr.CodeValidation, "Range %q is beyond the file size %d", r.Header.Get("Range"), total).
+				WithDetail("size", total).JSON()))
+			return
+		}
+		start = rng.start
+		if rng.end >= 0 && rng.end < end {
+			end = rng.end
+		}
+	}
+	length := end - start + 1
+
+	contentType := first.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+		if start == 0 && len(first.Data) > 0 {
+			contentType = http.DetectContentType(first.Data)
+		}
+	}
+	filename := first.Filename
+	if filename == "" {
+		filename = fileID
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if first.Sha256 != "" {
+		w.Header().Set("X-Checksum-SHA256", first.Sha256)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if ranged {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	remaining := length
+	chunk := first
+	for remaining > 0 {
+		data := chunk.Data
+		if int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+		if _, err := w.Write(data); err != nil {
+			return // the caller went away
+		}
+		remaining -= int64(len(data))
+		if remaining == 0 || chunk.IsLast {
+			break
+		}
+
+		var err error
+		if chunk, err = stream.Recv(); err != nil {
+			if err != io.EOF {
+				log.Printf("❌ Download of %s failed after %d bytes: %v", fileID, length-remaining, err)
+			}
+			break
+		}
+	}
+}
+
+// open starts downloading fileID at offset and returns the stream with its
+// first chunk, which carries the file size, name and content type
+func (h *FileHandler) open(ctx context.Context, fileID string, offset int64) (pb.HubService_DownloadFileClient, *pb.FileChunk, *apierr.ErrorResponse) {
+	stream, err := h.hubClient.DownloadFile(ctx, fileID, offset, downloadChunkSize)
+	if err == nil {
+		var first *pb.FileChunk
+		if first, err = stream.Recv(); err == nil {
+			return stream, first, nil
+		}
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		return nil, nil, apierr.Newf(apierr.CodeFileNotFound, "File not found: %s", fileID).WithDetail("file_id", fileID)
+	case codes.InvalidArgument:
+		return nil, nil, apierr.Newf(apierr.CodeValidation, "Invalid file id %q", fileID)
+	default:
+		return nil, nil, apierr.Newf(apierr.CodeInternal, "Failed to download file %s: %v", fileID, err)
+	}
+}
+
+// HandleUpload handles POST /api/files: the first file part of a
+// multipart/form-data body is streamed to the hub's UploadFile in
+// uploadChunkSize chunks as it arrives, never buffered whole, and answered
+// with 201 and its file_id. Capabilities can then be given the file_id
+// instead of inline base64. Files above MaxUploadBytes get 413 and are
+// discarded by the hub.
+func (h *FileHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST", apierr.Newf(apierr.CodeValidation, "%s is not allowed on /api/files", r.Method))
+		return
+	}
+	limit := h.maxUploadBytes()
+	if r.ContentLength > limit+multipartOverhead {
+		writeAPIError(w, tooLarge(limit))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeAPIError(w, apierr.Newf(apierr.CodeBadContentType, "Expected a multipart/form-data body: %v", err))
+		return
+	}
+
+	// Skip form fields up to the first file part
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if err == io.EOF {
+			writeAPIError(w, apierr.New(apierr.CodeValidation, "No file part in the multipart body"))
+			return
+		}
+		if err != nil {
+			writeAPIError(w, uploadError(err, limit))
+			return
+		}
+		if part.FileName() != "" {
+			break
+		}
+		part.Close()
+	}
+	defer part.Close()
+
+	filename := part.FileName()
+	contentType := part.Header.Get("Content-Type")
+	if contentType == "application/octet-stream" {
+		contentType = "" // let downloads sniff it
+	}
+
+	started := time.Now()
+	body := &limitedReader{r: part, remaining: limit}
+	resp, err := h.hubClient.UploadFile(r.Context(), filename, contentType, body, uploadChunkSize)
+	if err != nil {
+		log.Printf("❌ Upload of %s failed after %d bytes: %v", filename, limit-body.remaining, err)
+		writeAPIError(w, uploadError(err, limit))
+		return
+	}
+	log.Printf("📥 Uploaded %s as %s (%d bytes in %v)", filename, resp.FileId, resp.BytesReceived, time.Since(started))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/files/"+resp.FileId)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       "success",
+		"file_id":      resp.FileId,
+		"filename":     filename,
+		"size":         resp.BytesReceived,
+		"content_type": contentType,
+		"sha256":       resp.Sha256,
+	})
+}
+
+// uploadError maps a failed upload to 413 when a size limit was hit
+func uploadError(err error, limit int64) *apierr.ErrorResponse {
+	var maxErr *http.MaxBytesError
+	if errors.Is(err, errUploadTooLarge) || errors.As(err, &maxErr) {
+		return tooLarge(limit)
+	}
+	if status.Code(errors.Unwrap(err)) == codes.InvalidArgument {
+		return apierr.Newf(apierr.CodeValidation, "%v", err)
+	}
+	return apierr.Newf(apierr.CodeInternal, "%v", err)
+}
+
+// limitedReader fails with errUploadTooLarge once more than remaining
+// bytes are read
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
+func (h *FileHandler) maxUploadBytes() int64 {
+	if h.MaxUploadBytes > 0 {
+		return h.MaxUploadBytes
+	}
+	return DefaultMaxUploadBytes
+}
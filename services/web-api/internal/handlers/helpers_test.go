@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/services/web-api/internal/client"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeHub answers Discover with the content discovery returns for the n'th
+// call (an empty discovery if nil), records the messages clients send on
+// their streams and answers each request with its own content
+type fakeHub struct {
+	pb.UnimplementedHubServiceServer
+	discovery func(n int) string
+
+	mu          sync.Mutex
+	discoveries int
+	received    []*pb.Message
+}
+
+func (h *fakeHub) Discover(ctx context.Context, req *pb.DiscoverRequest) (*pb.DiscoverResponse, error) {
+	h.mu.Lock()
+	n := h.discoveries
+	h.discoveries++
+	h.mu.Unlock()
+	if h.discovery == nil {
+		return &pb.DiscoverResponse{Content: "{}"}, nil
+	}
+	return &pb.DiscoverResponse{Content: h.discovery(n)}, nil
+}
+
+func (h *fakeHub) Connect(stream pb.HubService_ConnectServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		h.mu.Lock()
+		h.received = append(h.received, msg)
+		h.mu.Unlock()
+		if msg.Type != pb.MessageType_REQUEST {
+			continue
+		}
+		err = stream.Send(&pb.Message{
+			Id:       "resp-" + msg.Id,
+			From:     msg.To,
+			To:       msg.From,
+			Type:     pb.MessageType_RESPONSE,
+			Content:  msg.Content,
+			Metadata: map[string]string{envelope.RequestIDKey: msg.Id},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// requests returns the requests clients sent the hub
+func (h *fakeHub) requests() []*pb.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var requests []*pb.Message
+	for _, msg := range h.received {
+		if msg.Type == pb.MessageType_REQUEST {
+			requests = append(requests, msg)
+		}
+	}
+	return requests
+}
+
+// newTestHandler returns a DynamicHandler whose hub client is connected to
+// hub, without discovery caching
+func newTestHandler(t *testing.T, hub *fakeHub) *DynamicHandler {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterHubServiceServer(server, hub)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	hc, err := client.NewHubClient(lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc.DiscoveryCacheTTL = 0
+	t.Cleanup(func() { hc.Close() })
+	return NewDynamicHandler(hc)
+}
+
+// callWorker sends an HTTP request for capability on w1 to h with the given
+// Content-Type (none if empty)
+func callWorker(h *DynamicHandler, method, capability, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/w1/call/"+capability, body)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	h.HandleWorkerCall(rec, r)
+	return rec
+}
+
+// errorCode is the API error code of a response, "" if it has none
+func errorCode(rec *httptest.ResponseRecorder) apierr.Code {
+	var body apierr.ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Code
+}
@@ -2,9 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
+	"deepapp_golang_grpc_hub/internal/envelope"
 	"deepapp_golang_grpc_hub/services/web-api/internal/client"
 )
 
@@ -28,7 +28,11 @@ func (h *JavaWorkerHandler) HandleHello(w http.ResponseWriter, r *http.Request)
 	// Send request to Java simple worker
 	response, err := h.hubClient.SendRequest("java-simple-worker", "hello_world", "{}")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
 		return
 	}
 
@@ -70,7 +74,11 @@ func (h *JavaWorkerHandler) HandleFileInfo(w http.ResponseWriter, r *http.Reques
 	// Send to Java simple worker
 	response, err := h.hubClient.SendRequest("java-simple-worker", "read_file_info", string(requestJSON))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
 		return
 	}
 
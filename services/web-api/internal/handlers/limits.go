@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// Request size defaults, overridable on DynamicHandler
+const (
+	DefaultMaxRequestBytes    = 100 << 20 // whole body, uploads included
+	DefaultMaxMultipartMemory = 32 << 20  // upload bytes kept in memory; the rest goes to temp files
+)
+
+// limitBody caps r.Body at MaxRequestBytes. It returns false after
+// answering 413 when Content-Length already exceeds the limit.
+func (h *DynamicHandler) limitBody(w http.ResponseWriter, r *http.Request) bool {
+	limit := h.maxRequestBytes()
+	if r.ContentLength > limit {
+		writeAPIError(w, tooLarge(limit))
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// bodyError reports a failure reading the request body: 413 when the size
+// limit was hit, otherwise a validation error starting with message
+func (h *DynamicHandler) bodyError(err error, message string) *apierr.ErrorResponse {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return tooLarge(maxErr.Limit)
+	}
+	return apierr.Newf(apierr.CodeValidation, "%s: %v", message, err)
+}
+
+func tooLarge(limit int64) *apierr.ErrorResponse {
+	return apierr.Newf(apierr.CodePayloadTooLarge, "Request body exceeds %d bytes", limit).
+		WithDetail("limit", limit)
+}
+
+func (h *DynamicHandler) maxRequestBytes() int64 {
+	if h.MaxRequestBytes > 0 {
+		return h.MaxRequestBytes
+	}
+	return DefaultMaxRequestBytes
+}
+
+func (h *DynamicHandler) maxMultipartMemory() int64 {
+	if h.MaxMultipartMemory > 0 {
+		return h.MaxMultipartMemory
+	}
+	return DefaultMaxMultipartMemory
+}
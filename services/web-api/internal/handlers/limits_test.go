@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+const testMaxRequestBytes = 1024
+
+// multipartBody is a form with a file of size bytes under field
+func multipartBody(t *testing.T, field string, size int) (string, *bytes.Buffer) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, "scan.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(bytes.Repeat([]byte{0x89}, size))
+	form.WriteField("params", `{"language":"vi"}`)
+	form.Close()
+	return form.FormDataContentType(), &body
+}
+
+func TestOversizedRequestRejected(t *testing.T) {
+	hub := &fakeHub{}
+	h := newTestHandler(t, hub)
+	h.MaxRequestBytes = testMaxRequestBytes
+	large := `{"text":"` + strings.Repeat("a", testMaxRequestBytes) + `"}`
+
+	t.Run("content length", func(t *testing.T) {
+		rec := callWorker(h, http.MethodPost, "echo", "application/json", strings.NewReader(large))
+		if rec.Code != http.StatusRequestEntityTooLarge || errorCode(rec) != apierr.CodePayloadTooLarge {
+			t.Fatalf("got %d %s, want 413 %s", rec.Code, errorCode(rec), apierr.CodePayloadTooLarge)
+		}
+	})
+	t.Run("chunked", func(t *testing.T) {
+		// No Content-Length: the limit is hit while decoding
+		r := httptest.NewRequest(http.MethodPost, "/api/w1/call/echo", strings.NewReader(large))
+		r.ContentLength = -1
+		rec := httptest.NewRecorder()
+		h.HandleWorkerCall(rec, r)
+		if rec.Code != http.StatusRequestEntityTooLarge || errorCode(rec) != apierr.CodePayloadTooLarge {
+			t.Fatalf("got %d %s, want 413 %s", rec.Code, errorCode(rec), apierr.CodePayloadTooLarge)
+		}
+	})
+	t.Run("multipart", func(t *testing.T) {
+		contentType, body := multipartBody(t, "file", 4*testMaxRequestBytes)
+		rec := callWorker(h, http.MethodPost, "ocr", contentType, body)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("got %d %s, want 413", rec.Code, rec.Body)
+		}
+	})
+
+	if n := len(hub.requests()); n != 0 {
+		t.Errorf("%d oversized requests reached the hub", n)
+	}
+}
+
+func TestMalformedRequestRejected(t *testing.T) {
+	hub := &fakeHub{}
+	h := newTestHandler(t, hub)
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		code        apierr.Code
+	}{
+		{"invalid json", "application/json", `{"text":`, apierr.CodeValidation},
+		{"json array", "application/json", `["a"]`, apierr.CodeValidation},
+		{"multipart without boundary", "multipart/form-data", "--x\r\n", apierr.CodeValidation},
+		{"multipart with wrong boundary", "multipart/form-data; boundary=other", "--x\r\nbroken", apierr.CodeValidation},
+		{"unparsable content type", "multipart/", `{}`, apierr.CodeBadContentType},
+	} {
+		rec := callWorker(h, http.MethodPost, "echo", tc.contentType, strings.NewReader(tc.body))
+		if got := errorCode(rec); got != tc.code || rec.Code != apierr.New(tc.code, "").HTTPStatus() {
+			t.Errorf("%s: got %d %s, want %s", tc.name, rec.Code, got, tc.code)
+		}
+	}
+	if n := len(hub.requests()); n != 0 {
+		t.Errorf("%d malformed requests reached the hub", n)
+	}
+}
+
+// Requests within the limit still go through, including uploads larger
+// than the multipart memory cap
+func TestRequestWithinLimitForwarded(t *testing.T) {
+	hub := &fakeHub{}
+	h := newTestHandler(t, hub)
+	h.MaxRequestBytes = testMaxRequestBytes
+	h.MaxMultipartMemory = 64
+
+	rec := callWorker(h, http.MethodPost, "echo", "application/json", strings.NewReader(`{"text":"xin chào"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("json: %d %s", rec.Code, rec.Body)
+	}
+	contentType, body := multipartBody(t, "image", testMaxRequestBytes/4)
+	rec = callWorker(h, http.MethodPost, "ocr", contentType, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("multipart: %d %s", rec.Code, rec.Body)
+	}
+
+	requests := hub.requests()
+	if len(requests) != 2 {
+		t.Fatalf("hub got %d requests, want 2", len(requests))
+	}
+	if !strings.Contains(requests[1].Content, `"filename":"scan.png"`) || !strings.Contains(requests[1].Content, `"language":"vi"`) {
+		t.Errorf("upload forwarded as %.200s", requests[1].Content)
+	}
+}
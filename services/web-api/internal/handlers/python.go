@@ -3,10 +3,10 @@ package handlers
 import (
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 
+	"deepapp_golang_grpc_hub/internal/envelope"
 	"deepapp_golang_grpc_hub/services/web-api/internal/client"
 )
 
@@ -30,7 +30,11 @@ func (h *PythonWorkerHandler) HandleHello(w http.ResponseWriter, r *http.Request
 	// Send request to Python worker
 	response, err := h.hubClient.SendRequest("python-worker", "hello", "")
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
 		return
 	}
 
@@ -86,7 +90,11 @@ func (h *PythonWorkerHandler) HandleAnalyzeImage(w http.ResponseWriter, r *http.
 	// Send to Python worker
 	response, err := h.hubClient.SendRequest("python-worker", "analyze_image", string(requestJSON))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
 		return
 	}
 
@@ -1,37 +1,119 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	"deepapp_golang_grpc_hub/services/web-api/internal/client"
 )
 
+// DefaultReadinessTimeout bounds the hub ping behind /readyz
+const DefaultReadinessTimeout = 2 * time.Second
+
 // StatusHandler handles status endpoint
-type StatusHandler struct{}
+type StatusHandler struct {
+	hubClient *client.HubClient
+	// ReadinessTimeout bounds the hub ping behind /readyz
+	ReadinessTimeout time.Duration
+}
 
 // NewStatusHandler creates a new status handler
-func NewStatusHandler() *StatusHandler {
-	return &StatusHandler{}
+func NewStatusHandler(hubClient *client.HubClient) *StatusHandler {
+	return &StatusHandler{hubClient: hubClient, ReadinessTimeout: DefaultReadinessTimeout}
+}
+
+// HandleHealthz handles /healthz (liveness): it answers 200 as long as the
+// process can serve HTTP and never touches the hub
+func (h *StatusHandler) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "ok",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// HandleReadyz handles /readyz (readiness): it answers 503 while the
+// client is reconnecting to the hub, and otherwise pings the hub over the
+// gRPC stream, answering 503 if no pong arrives within ReadinessTimeout
+func (h *StatusHandler) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.ReadinessTimeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/json")
+	state := h.hubClient.State()
+	var rtt time.Duration
+	err := fmt.Errorf("hub connection %s", state)
+	if state == client.StateConnected {
+		rtt, err = h.hubClient.PingContext(ctx)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     "unavailable",
+			"hub":        h.hubClient.Address(),
+			"connection": state,
+			"error":      err.Error(),
+			"timestamp":  time.Now().Format(time.RFC3339),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "ready",
+		"hub":        h.hubClient.Address(),
+		"connection": state,
+		"hub_rtt_ms": float64(rtt.Microseconds()) / 1000,
+		"timestamp":  time.Now().Format(time.RFC3339),
+	})
 }
 
-// HandleStatus handles /api/status
+// HandleStatus handles /api/status by asking the hub for its system health
 func (h *StatusHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	response, err := h.hubClient.SendControl("system_health", "")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error querying hub health: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	var hubHealth map[string]interface{}
+	if err := json.Unmarshal([]byte(response.Content), &hubHealth); err != nil {
+		http.Error(w, "Failed to parse hub health", http.StatusBadGateway)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":      "running",
-		"service":     "web-api",
-		"hub_address": "localhost:50051",
-		"endpoints": []string{
-			"/api/worker/python/hello",
-			"/api/worker/python/analyze_image",
-			"/api/worker/java/hello",
-			"/api/worker/java/file_info",
-			"/api/status",
-			"/api/capabilities",
-			"/api/swagger.json",
-			"/api/docs",
-			"/api/call/{capability}",
-		},
+		"status":    "running",
+		"service":   "web-api",
+		"client_id": h.hubClient.ClientID,
+		"hub":       hubHealth,
 		"timestamp": time.Now().Format(time.RFC3339),
 	})
-}
\ No newline at end of file
+}
+
+// HandleCapabilityStats handles GET /api/capabilities/stats?capability=name
+// by asking the hub for its response latency per capability
+func (h *StatusHandler) HandleCapabilityStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, _ := json.Marshal(map[string]string{"capability": r.URL.Query().Get("capability")})
+	response, err := h.hubClient.SendControl("capability_stats", string(filter))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(response.Content))
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// DefaultStreamTimeout bounds a streamed call unless ?timeout= is given
+const DefaultStreamTimeout = 5 * time.Minute
+
+// HandleStream calls a capability and relays its progress as Server-Sent
+// Events: every message the worker correlates to the request before its
+// response is sent as an unnamed event (EventSource.onmessage), then the
+// response as a "response" event (or an "error" event) and the stream ends.
+// Pattern: GET /api/{worker_id}/stream/{capability}?params={json}&timeout=1m
+// Other query parameters are passed to the capability as strings.
+func (h *DynamicHandler) HandleStream(w http.ResponseWriter, r *http.Request, workerID, capabilityName string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, apierr.New(apierr.CodeInternal, "Streaming is not supported by this server"))
+		return
+	}
+
+	params, timeout, apiErr := streamParams(r)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	requestJSON, _ := json.Marshal(params)
+
+	// The request context is canceled when the browser disconnects
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	stream, err := h.hubClient.StreamRequest(ctx, workerID, capabilityName, string(requestJSON), requestMetadata(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case msg := <-stream.Events:
+			writeEvent(w, "", msg.Id, msg.Content)
+			flusher.Flush()
+
+		case response := <-stream.Done:
+			drainEvents(w, stream.Events)
+			if apiErr, failed := envelope.Error(response); failed {
+				writeEvent(w, "error", response.Id, apiErr.JSON())
+			} else {
+				result, _ := json.Marshal(map[string]interface{}{
+					"status":    "success",
+					"response":  response.Content,
+					"from":      response.From,
+					"timestamp": time.Now().Format(time.RFC3339),
+				})
+				writeEvent(w, "response", response.Id, string(result))
+			}
+			flusher.Flush()
+			return
+
+		case <-ctx.Done():
+			if r.Context().Err() == nil {
+				writeEvent(w, "error", "", apierr.New(apierr.CodeTimeout, "timeout waiting for response").JSON())
+				flusher.Flush()
+			}
+			return
+		}
+	}
+}
+
+// drainEvents writes progress messages that arrived before the response
+// but were not yet relayed
+func drainEvents(w http.ResponseWriter, events <-chan *pb.Message) {
+	for {
+		select {
+		case msg := <-events:
+			writeEvent(w, "", msg.Id, msg.Content)
+		default:
+			return
+		}
+	}
+}
+
+// streamParams reads the capability params and timeout from the query
+func streamParams(r *http.Request) (map[string]interface{}, time.Duration, *apierr.ErrorResponse) {
+	params, apiErr := queryParams(r.URL.Query(), "timeout")
+	if apiErr != nil {
+		return nil, 0, apiErr
+	}
+
+	timeout := DefaultStreamTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, 0, apierr.Newf(apierr.CodeValidation, "Invalid timeout %q", value)
+		}
+		timeout = parsed
+	}
+	return params, timeout, nil
+}
+
+// queryParams reads capability params from a query: the "params" JSON
+// object, plus every other key (except reserved ones) as a string unless
+// params already sets it
+func queryParams(query url.Values, reserved ...string) (map[string]interface{}, *apierr.ErrorResponse) {
+	params := make(map[string]interface{})
+	if raw := query.Get("params"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &params); err != nil {
+			return nil, apierr.Newf(apierr.CodeValidation, "params must be a JSON object: %v", err)
+		}
+	}
+
+	skip := map[string]bool{"params": true}
+	for _, key := range reserved {
+		skip[key] = true
+	}
+	for key, values := range query {
+		if skip[key] {
+			continue
+		}
+		if _, set := params[key]; !set {
+			params[key] = values[0]
+		}
+	}
+	return params, nil
+}
+
+// writeEvent writes one SSE event; multi-line data is split over several
+// data: lines as the format requires
+func writeEvent(w http.ResponseWriter, event, id, data string) {
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// hubTag groups the web API's own endpoints in the Swagger UI
+const hubTag = "hub"
+
+// defaultTag is used when a capability has no tags and no known prefix
+const defaultTag = "services"
+
+// tagPrefixes maps capability name prefixes to tags for capabilities
+// registered without tags
+var tagPrefixes = []struct {
+	prefix string
+	tag    string
+}{
+	{"text_", "text"},
+	{"image_", "image"},
+	{"ocr", "ocr"},
+	{"file_", "file"},
+	{"hash", "crypto"},
+	{"base64", "encoding"},
+	{"hello", "demo"},
+}
+
+// tagDescriptions describes well-known tags in the spec's tags list
+var tagDescriptions = map[string]string{
+	hubTag:     "Capability discovery and API status",
+	defaultTag: "Capabilities without a declared tag",
+	"text":     "Text processing",
+	"image":    "Image processing",
+	"ocr":      "Optical character recognition",
+	"file":     "File handling",
+	"crypto":   "Hashing and checksums",
+	"encoding": "Encoding and decoding",
+	"demo":     "Example capabilities",
+}
+
+// capabilityTags returns the tags declared by the capability, falling back
+// to a guess from its name
+func capabilityTags(capName string, capMap map[string]interface{}) []string {
+	var tags []string
+	if declared, ok := capMap["tags"].([]interface{}); ok {
+		for _, t := range declared {
+			if tag, ok := t.(string); ok && tag != "" {
+				tags = append(tags, strings.ToLower(tag))
+			}
+		}
+	}
+	if len(tags) > 0 {
+		return tags
+	}
+	return []string{generateTag(capName)}
+}
+
+// generateTag guesses a tag from the capability name prefix
+func generateTag(capName string) string {
+	name := strings.ToLower(capName)
+	for _, p := range tagPrefixes {
+		if strings.HasPrefix(name, p.prefix) {
+			return p.tag
+		}
+	}
+	return defaultTag
+}
+
+// swaggerTags builds the spec's top-level tags list, sorted by name with
+// the hub's own tag first
+func swaggerTags(used map[string]bool) []map[string]interface{} {
+	names := make([]string, 0, len(used))
+	for name := range used {
+		if name != hubTag {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if used[hubTag] {
+		names = append([]string{hubTag}, names...)
+	}
+
+	tags := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		description, ok := tagDescriptions[name]
+		if !ok {
+			description = fmt.Sprintf("Capabilities tagged %q", name)
+		}
+		tags = append(tags, map[string]interface{}{
+			"name":        name,
+			"description": description,
+		})
+	}
+	return tags
+}
+
+// convertSchema parses a capability's JSON Schema string into an OpenAPI
+// schema object. It reports false when the string is empty, unparseable
+// or an empty object, so callers can fall back to a generic schema.
+func convertSchema(schema string) (map[string]interface{}, bool) {
+	schema = strings.TrimSpace(schema)
+	if schema == "" {
+		return nil, false
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(schema), &parsed); err != nil || len(parsed) == 0 {
+		return nil, false
+	}
+	return toOpenAPISchema(parsed), true
+}
+
+// toOpenAPISchema rewrites JSON Schema keywords OpenAPI 3.0 does not
+// accept: $schema/$id are dropped and "type": [..., "null"] becomes
+// "nullable"
+func toOpenAPISchema(schema map[string]interface{}) map[string]interface{} {
+	delete(schema, "$schema")
+	delete(schema, "$id")
+
+	if types, ok := schema["type"].([]interface{}); ok {
+		var kept []interface{}
+		for _, t := range types {
+			if t == "null" {
+				schema["nullable"] = true
+			} else {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 1 {
+			schema["type"] = kept[0]
+		} else {
+			delete(schema, "type")
+		}
+	}
+
+	if props, ok := schema["properties"].(map[string]interface{}); ok {
+		for name, prop := range props {
+			if propSchema, ok := prop.(map[string]interface{}); ok {
+				props[name] = toOpenAPISchema(propSchema)
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		schema["items"] = toOpenAPISchema(items)
+	}
+	return schema
+}
+
+// parseExample parses a capability's example request. It reports false
+// when the string is empty or not a JSON object.
+func parseExample(example string) (map[string]interface{}, bool) {
+	example = strings.TrimSpace(example)
+	if example == "" {
+		return nil, false
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(example), &parsed); err != nil {
+		return nil, false
+	}
+	return parsed, true
+}
+
+// stringField returns m[key] if it is a string
+func stringField(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// queryParameters describes the query of a GET or DELETE capability call:
+// one parameter per top-level property of the input schema, or a free-form
+// "params" JSON object when the capability declared none. Values from the
+// capability's example (nil if none) become the parameters' examples.
+func queryParameters(inputSchema map[string]interface{}, hasInputSchema bool, example map[string]interface{}) []map[string]interface{} {
+	properties, _ := inputSchema["properties"].(map[string]interface{})
+	if !hasInputSchema || len(properties) == 0 {
+		parameter := map[string]interface{}{
+			"name":        "params",
+			"in":          "query",
+			"required":    false,
+			"description": "Request parameters as a JSON object",
+			"schema":      map[string]interface{}{"type": "string"},
+		}
+		if example != nil {
+			data, _ := json.Marshal(example)
+			parameter["example"] = string(data)
+		}
+		return []map[string]interface{}{parameter}
+	}
+
+	required := make(map[string]bool)
+	if names, ok := inputSchema["required"].([]interface{}); ok {
+		for _, name := range names {
+			if name, ok := name.(string); ok {
+				required[name] = true
+			}
+		}
+	}
+
+	parameters := make([]map[string]interface{}, 0, len(properties))
+	for _, name := range sortedKeys(properties) {
+		parameter := map[string]interface{}{
+			"name":     name,
+			"in":       "query",
+			"required": required[name],
+			"schema":   properties[name],
+		}
+		if value, ok := example[name]; ok {
+			parameter["example"] = value
+		}
+		parameters = append(parameters, parameter)
+	}
+	return parameters
+}
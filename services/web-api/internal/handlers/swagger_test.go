@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// shuffledDiscovery lists the same workers in a different order on each call
+func shuffledDiscovery(n int) string {
+	workers := []map[string]interface{}{
+		{"id": "w1", "capabilities": []map[string]interface{}{
+			{"name": "ocr", "description": "ocr on w1", "http_method": "POST", "tags": []string{"vision"}, "accepts_file": false},
+			{"name": "echo", "http_method": "GET"},
+		}},
+		{"id": "w2", "capabilities": []map[string]interface{}{
+			{"name": "ocr", "description": "ocr on w2", "http_method": "PUT", "tags": []string{"text"}, "accepts_file": true},
+		}},
+		{"id": "w3", "capabilities": []map[string]interface{}{
+			{"name": "translate", "input_schema": `{"type":"object","properties":{"text":{"type":"string"}}}`},
+			{"name": "echo"},
+		}},
+	}
+	rotated := append(workers[n%len(workers):], workers[:n%len(workers)]...)
+	capabilities := map[string]interface{}{}
+	for _, worker := range rotated {
+		for _, cap := range worker["capabilities"].([]map[string]interface{}) {
+			capabilities[cap["name"].(string)] = cap
+		}
+	}
+	content, _ := json.Marshal(map[string]interface{}{"capabilities": capabilities, "workers": rotated})
+	return string(content)
+}
+
+func TestSwaggerStableAcrossDiscoveryOrder(t *testing.T) {
+	h := newTestHandler(t, &fakeHub{discovery: shuffledDiscovery})
+
+	var first string
+	for i := 0; i < 6; i++ {
+		rec := httptest.NewRecorder()
+		h.HandleSwagger(rec, httptest.NewRequest(http.MethodGet, "/swagger.json", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("swagger: %d %s", rec.Code, rec.Body)
+		}
+		if i == 0 {
+			first = rec.Body.String()
+			continue
+		}
+		if rec.Body.String() != first {
+			t.Fatalf("swagger %d differs from the first:\n%s\n%s", i+1, rec.Body, first)
+		}
+	}
+
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	json.Unmarshal([]byte(first), &spec)
+	for _, path := range []string{"/api/w1/call/ocr", "/api/w2/call/ocr", "/api/w1/call/echo", "/api/w3/call/echo", "/api/w3/call/translate"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("spec has no %s", path)
+		}
+	}
+	// Each worker's operation describes its own registration
+	if op := spec.Paths["/api/w1/call/ocr"]["post"]; op == nil || op.(map[string]interface{})["description"] != "ocr on w1" {
+		t.Errorf("w1 ocr operation: %v", op)
+	}
+	if op := spec.Paths["/api/w2/call/ocr"]["put"]; op == nil || op.(map[string]interface{})["description"] != "ocr on w2" {
+		t.Errorf("w2 ocr operation: %v", op)
+	}
+}
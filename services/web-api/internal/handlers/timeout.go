@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/services/web-api/internal/client"
+)
+
+// DefaultMaxCallTimeout caps the default_timeout_ms a capability declares
+// (the hub's default REQUEST_TIMEOUT)
+const DefaultMaxCallTimeout = 5 * time.Minute
+
+// TimeoutHeader sets the deadline of a call, e.g. "90s"; without it the
+// capability's declared default_timeout_ms applies
+const TimeoutHeader = "X-Request-Timeout"
+
+// callTimeout returns how long a call may wait for its response: the
+// caller's TimeoutHeader, else the default_timeout_ms declared by the
+// capability (capped at MaxCallTimeout), else client.DefaultRequestTimeout.
+// workerID is empty when the hub picks the worker.
+func (h *DynamicHandler) callTimeout(r *http.Request, workerID, capabilityName string) (time.Duration, *apierr.ErrorResponse) {
+	if value := r.Header.Get(TimeoutHeader); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return 0, apierr.Newf(apierr.CodeValidation, "Invalid %s %q", TimeoutHeader, value)
+		}
+		return timeout, nil
+	}
+
+	declared := h.declaredTimeout(workerID, capabilityName)
+	if declared <= 0 {
+		return client.DefaultRequestTimeout, nil
+	}
+	if max := h.maxCallTimeout(); declared > max {
+		return max, nil
+	}
+	return declared, nil
+}
+
+// declaredTimeout returns the default_timeout_ms of capabilityName, as
+// registered by workerID or, without one, as listed in the discovery
+// capabilities; 0 when it declares none or is unknown
+func (h *DynamicHandler) declaredTimeout(workerID, capabilityName string) time.Duration {
+	response, err := h.hubClient.Discover("")
+	if err != nil {
+		return 0
+	}
+
+	type capability struct {
+		Name             string `json:"name"`
+		DefaultTimeoutMs int64  `json:"default_timeout_ms"`
+	}
+	var discovery struct {
+		Capabilities map[string]capability `json:"capabilities"`
+		Workers      []struct {
+			ID           string       `json:"id"`
+			Capabilities []capability `json:"capabilities"`
+		} `json:"workers"`
+	}
+	if err := json.Unmarshal([]byte(response.Content), &discovery); err != nil {
+		return 0
+	}
+
+	if workerID == "" {
+		return time.Duration(discovery.Capabilities[capabilityName].DefaultTimeoutMs) * time.Millisecond
+	}
+	for _, worker := range discovery.Workers {
+		if worker.ID != workerID {
+			continue
+		}
+		for _, cap := range worker.Capabilities {
+			if cap.Name == capabilityName {
+				return time.Duration(cap.DefaultTimeoutMs) * time.Millisecond
+			}
+		}
+	}
+	return 0
+}
+
+func (h *DynamicHandler) maxCallTimeout() time.Duration {
+	if h.MaxCallTimeout > 0 {
+		return h.MaxCallTimeout
+	}
+	return DefaultMaxCallTimeout
+}
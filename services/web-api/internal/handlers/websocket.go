@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+	"deepapp_golang_grpc_hub/services/web-api/internal/client"
+)
+
+// HubDialer opens a hub connection with the given client ID
+type HubDialer func(clientID string) (*client.HubClient, error)
+
+// Frame types sent by the browser
+const (
+	FrameInvoke      = "invoke"
+	FrameDiscover    = "discover"
+	FrameSubscribe   = "subscribe"
+	FrameUnsubscribe = "unsubscribe"
+)
+
+// Frame types sent back
+const (
+	FrameResponse     = "response"
+	FrameProgress     = "progress"
+	FrameError        = "error"
+	FrameEvent        = "event"
+	FrameSubscribed   = "subscribed"
+	FrameUnsubscribed = "unsubscribed"
+)
+
+// wsRequest is a JSON frame received from the browser. ID is echoed in
+// every frame answering it.
+type wsRequest struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	WorkerID   string                 `json:"worker_id,omitempty"` // invoke; empty lets the hub pick
+	Capability string                 `json:"capability,omitempty"`
+	Params     map[string]interface{} `json:"params,omitempty"`
+	Timeout    string                 `json:"timeout,omitempty"` // invoke, e.g. "10s"
+	Labels     string                 `json:"labels,omitempty"`  // invoke, label selector e.g. "region=eu"
+	Tag        string                 `json:"tag,omitempty"`     // discover
+	Channel    string                 `json:"channel,omitempty"` // subscribe/unsubscribe
+}
+
+// wsReply is a JSON frame sent to the browser
+type wsReply struct {
+	ID      string                `json:"id,omitempty"`
+	Type    string                `json:"type"`
+	Data    string                `json:"data,omitempty"` // content as sent by the worker or hub
+	From    string                `json:"from,omitempty"`
+	Channel string                `json:"channel,omitempty"`
+	Error   *apierr.ErrorResponse `json:"error,omitempty"`
+}
+
+// WebSocketHandler bridges browser WebSockets to the hub. Each socket gets
+// its own hub client, so responses and channel messages are routed to the
+// socket that asked for them; the hub client is closed with the socket.
+type WebSocketHandler struct {
+	dial HubDialer
+}
+
+// NewWebSocketHandler creates a handler opening hub connections with dial
+func NewWebSocketHandler(dial HubDialer) *WebSocketHandler {
+	return &WebSocketHandler{dial: dial}
+}
+
+// ServeHTTP upgrades the request to a WebSocket
+// Pattern: /ws
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	websocket.Server{Handler: h.serve}.ServeHTTP(w, r)
+}
+
+// wsSession is one browser socket and its hub client
+type wsSession struct {
+	ws  *websocket.Conn
+	hub *client.HubClient
+	ctx context.Context
+
+	sendMu sync.Mutex
+}
+
+func (h *WebSocketHandler) serve(ws *websocket.Conn) {
+	defer ws.Close()
+
+	clientID := utils.PrefixedID("ws")
+	hub, err := h.dial(clientID)
+	if err != nil {
+		websocket.JSON.Send(ws, wsReply{Type: FrameError, Error: apierr.Newf(apierr.CodeInternal, "Failed to connect to hub: %v", err)})
+		return
+	}
+	defer hub.Close()
+
+	// Canceled when the socket closes, abandoning requests still in flight
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+
+	session := &wsSession{ws: ws, hub: hub, ctx: ctx}
+	log.Printf("🔌 WebSocket %s connected from %s", clientID, ws.Request().RemoteAddr)
+
+	var wg sync.WaitGroup
+	for {
+		var req wsRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			// A frame that is not valid JSON leaves the socket usable
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				session.send(wsReply{Type: FrameError, Error: apierr.Newf(apierr.CodeValidation, "Invalid frame: %v", err)})
+				continue
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session.handle(req)
+		}()
+	}
+
+	cancel()
+	wg.Wait()
+	log.Printf("🔌 WebSocket %s closed", clientID)
+}
+
+// handle answers one frame
+func (s *wsSession) handle(req wsRequest) {
+	switch req.Type {
+	case FrameInvoke:
+		s.invoke(req)
+	case FrameDiscover:
+		response, err := s.hub.Discover(req.Tag)
+		s.reply(req.ID, response, err)
+	case FrameSubscribe:
+		if req.Channel == "" {
+			s.sendError(req.ID, apierr.New(apierr.CodeValidation, "channel is required"))
+			return
+		}
+		err := s.hub.Subscribe(req.Channel, func(msg *pb.Message) {
+			s.send(wsReply{Type: FrameEvent, Channel: msg.Channel, Data: msg.Content, From: msg.From})
+		})
+		s.ack(req, FrameSubscribed, err)
+	case FrameUnsubscribe:
+		if req.Channel == "" {
+			s.sendError(req.ID, apierr.New(apierr.CodeValidation, "channel is required"))
+			return
+		}
+		s.ack(req, FrameUnsubscribed, s.hub.Unsubscribe(req.Channel))
+	default:
+		s.sendError(req.ID, apierr.Newf(apierr.CodeValidation, "Unknown frame type %q", req.Type).
+			WithDetail("types", []string{FrameInvoke, FrameDiscover, FrameSubscribe, FrameUnsubscribe}))
+	}
+}
+
+// invoke calls a capability, relaying progress messages before the response
+func (s *wsSession) invoke(req wsRequest) {
+	if req.Capability == "" {
+		s.sendError(req.ID, apierr.New(apierr.CodeValidation, "capability is required"))
+		return
+	}
+	timeout := client.DefaultRequestTimeout
+	if req.Timeout != "" {
+		parsed, err := time.ParseDuration(req.Timeout)
+		if err != nil || parsed <= 0 {
+			s.sendError(req.ID, apierr.Newf(apierr.CodeValidation, "Invalid timeout %q", req.Timeout))
+			return
+		}
+		timeout = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+
+	params := req.Params
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	data, _ := json.Marshal(params)
+
+	stream, err := s.hub.StreamRequest(ctx, req.WorkerID, req.Capability, string(data),
+		map[string]string{"label_selector": req.Labels})
+	if err != nil {
+		s.reply(req.ID, nil, err)
+		return
+	}
+	defer stream.Close()
+
+	for {
+		select {
+		case progress := <-stream.Events:
+			s.send(wsReply{ID: req.ID, Type: FrameProgress, Data: progress.Content, From: progress.From})
+		case response := <-stream.Done:
+			s.reply(req.ID, response, nil)
+			return
+		case <-ctx.Done():
+			if s.ctx.Err() == nil {
+				s.sendError(req.ID, apierr.New(apierr.CodeTimeout, "timeout waiting for response"))
+			}
+			return
+		}
+	}
+}
+
+// reply sends a hub response (or the error getting it) as a response or
+// error frame
+func (s *wsSession) reply(id string, response *pb.Message, err error) {
+	if err != nil {
+		var apiErr *apierr.ErrorResponse
+		if !errors.As(err, &apiErr) {
+			apiErr = apierr.New(apierr.CodeInternal, err.Error())
+		}
+		s.sendError(id, apiErr)
+		return
+	}
+	if apiErr, failed := envelope.Error(response); failed {
+		s.sendError(id, apiErr)
+		return
+	}
+	s.send(wsReply{ID: id, Type: FrameResponse, Data: response.Content, From: response.From})
+}
+
+// ack confirms a subscription change
+func (s *wsSession) ack(req wsRequest, frameType string, err error) {
+	if err != nil {
+		s.reply(req.ID, nil, err)
+		return
+	}
+	s.send(wsReply{ID: req.ID, Type: frameType, Channel: req.Channel})
+}
+
+func (s *wsSession) sendError(id string, apiErr *apierr.ErrorResponse) {
+	s.send(wsReply{ID: id, Type: FrameError, Error: apiErr})
+}
+
+// send writes a frame; frames come from several goroutines
+func (s *wsSession) send(reply wsReply) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if err := websocket.JSON.Send(s.ws, reply); err != nil {
+		log.Printf("⚠️  WebSocket send failed: %v", err)
+	}
+}
@@ -6,15 +6,20 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/utils"
 	"deepapp_golang_grpc_hub/services/web-api/internal/client"
 	"deepapp_golang_grpc_hub/services/web-api/internal/handlers"
 	"deepapp_golang_grpc_hub/services/web-api/internal/ui"
 )
 
 func main() {
-	// Get Hub address from environment or use default
+	// Get Hub address from environment or use default; a comma-separated
+	// list is tried in order
 	hubAddress := os.Getenv("HUB_ADDRESS")
 	if hubAddress == "" {
 		hubAddress = "localhost:50051"
@@ -28,17 +33,64 @@ func main() {
 
 	// Connect to gRPC Hub
 	log.Printf("🌐 Connecting to gRPC Hub at %s...", hubAddress)
-	hubClient, err := client.NewHubClient(hubAddress)
+	// HUB_AUTH_TOKEN is required when the hub runs with AUTH_REQUIRED
+	clientID := os.Getenv("HUB_CLIENT_ID")
+	if clientID == "" {
+		clientID = utils.PrefixedID("web-api")
+	}
+	// MAX_RECV_MSG_SIZE/MAX_SEND_MSG_SIZE should match the hub's limits
+	authToken := os.Getenv("HUB_AUTH_TOKEN")
+	maxRecv := envInt("MAX_RECV_MSG_SIZE", codec.DefaultMaxMessageSize)
+	maxSend := envInt("MAX_SEND_MSG_SIZE", codec.DefaultMaxMessageSize)
+	// GRPC_COMPRESSION=gzip compresses the whole stream (default: none)
+	grpcCompression := os.Getenv("GRPC_COMPRESSION")
+	hubClient, err := client.NewHubClientWithCompression(hubAddress, clientID, authToken, maxRecv, maxSend, grpcCompression)
 	if err != nil {
 		log.Fatalf("❌ Failed to connect to hub: %v", err)
 	}
 	defer hubClient.Close()
-	log.Printf("✅ Connected to hub with client ID: %s", hubClient.ClientID)
+	log.Printf("✅ Connected to hub at %s with client ID: %s", hubClient.Address(), hubClient.ClientID)
+
+	// Compress request content above this size (bytes, 0 disables)
+	hubClient.CompressionThreshold = envInt("COMPRESSION_THRESHOLD", hubClient.CompressionThreshold)
+	// Reuse discovery results for this long (e.g. "5s", 0 disables); the hub
+	// also invalidates the cache whenever workers change
+	hubClient.DiscoveryCacheTTL = envDuration("DISCOVERY_CACHE_TTL", hubClient.DiscoveryCacheTTL)
+
+	// Log worker and capability changes pushed by the hub
+	err = hubClient.OnCapabilityChange(func(change client.CapabilityChange) {
+		log.Printf("🔄 %s %s (+%d/-%d capabilities)", change.Event, change.WorkerID,
+			len(change.AddedCapabilities), len(change.RemovedCapabilities))
+	})
+	if err != nil {
+		log.Printf("⚠️  Could not subscribe to capability changes: %v", err)
+	}
 
 	// Initialize handlers
 	dynamicHandler := handlers.NewDynamicHandler(hubClient)
-	statusHandler := handlers.NewStatusHandler()
+	// Limits for /api/{worker_id}/batch/{capability}
+	dynamicHandler.BatchConcurrency = envInt("BATCH_CONCURRENCY", handlers.DefaultBatchConcurrency)
+	dynamicHandler.MaxBatchSize = envInt("MAX_BATCH_SIZE", handlers.DefaultMaxBatchSize)
+	// Request bodies above MAX_REQUEST_BYTES get 413; uploads keep at most
+	// MAX_MULTIPART_MEMORY bytes in memory
+	dynamicHandler.MaxRequestBytes = int64(envInt("MAX_REQUEST_BYTES", handlers.DefaultMaxRequestBytes))
+	dynamicHandler.MaxMultipartMemory = int64(envInt("MAX_MULTIPART_MEMORY", handlers.DefaultMaxMultipartMemory))
+	// Calls wait for the capability's declared default timeout, at most
+	// MAX_CALL_TIMEOUT, unless X-Request-Timeout says otherwise
+	dynamicHandler.MaxCallTimeout = envDuration("MAX_CALL_TIMEOUT", handlers.DefaultMaxCallTimeout)
+	statusHandler := handlers.NewStatusHandler(hubClient)
+	// /readyz answers 503 when the hub does not pong within READINESS_TIMEOUT
+	statusHandler.ReadinessTimeout = envDuration("READINESS_TIMEOUT", handlers.DefaultReadinessTimeout)
+	// ADMIN_TOKEN enables /api/admin/* (Authorization: Bearer <token>)
+	adminHandler := handlers.NewAdminHandler(hubClient, os.Getenv("ADMIN_TOKEN"))
+	fileHandler := handlers.NewFileHandler(hubClient)
+	// Files uploaded to POST /api/files above MAX_UPLOAD_BYTES get 413
+	fileHandler.MaxUploadBytes = int64(envInt("MAX_UPLOAD_BYTES", handlers.DefaultMaxUploadBytes))
 	indexHandler := ui.NewIndexHandler()
+	// Each WebSocket gets its own hub connection
+	wsHandler := handlers.NewWebSocketHandler(func(id string) (*client.HubClient, error) {
+		return client.NewHubClientWithLimits(hubAddress, id, authToken, maxRecv, maxSend)
+	})
 
 	// Setup HTTP routes (100% Dynamic - No hard-coded endpoints!)
 	log.Println("🔌 Setting up dynamic routes from Hub registry...")
@@ -46,14 +98,28 @@ func main() {
 	// Main UI
 	http.HandleFunc("/", indexHandler.HandleIndex)
 
+	// Kubernetes probes: liveness and readiness (hub reachable)
+	http.HandleFunc("/healthz", statusHandler.HandleHealthz)
+	http.HandleFunc("/readyz", statusHandler.HandleReadyz)
+
 	// Core API endpoints
 	http.HandleFunc("/api/capabilities", dynamicHandler.HandleCapabilities)
+	http.HandleFunc("/api/capabilities/stats", statusHandler.HandleCapabilityStats)
 	http.HandleFunc("/api/swagger.json", dynamicHandler.HandleSwagger)
 	http.HandleFunc("/api/docs", dynamicHandler.HandleSwaggerUI)
 	http.HandleFunc("/api/status", statusHandler.HandleStatus)
+	http.HandleFunc("/api/health/", dynamicHandler.HandleWorkerHealth)
+	http.HandleFunc("/api/admin/connections", adminHandler.HandleConnections)
+	http.HandleFunc("/api/admin/workers", adminHandler.HandleWorkers)
+	http.HandleFunc("/api/admin/workers/", adminHandler.HandleWorker)
+	http.HandleFunc("/api/files", fileHandler.HandleUpload)
+	http.HandleFunc("/api/files/", fileHandler.HandleDownload)
+	http.Handle("/ws", wsHandler)
 
 	// Dynamic worker-specific routes
 	// Pattern: /api/{worker_id}/call/{capability}
+	//          /api/{worker_id}/batch/{capability} (JSON array of payloads)
+	//          /api/{worker_id}/stream/{capability} (Server-Sent Events)
 	// Examples:
 	//   /api/python-worker/call/hello
 	//   /api/java-simple-worker/call/read_file_info
@@ -65,16 +131,9 @@ func main() {
 	// Discover and log all available capabilities
 	log.Println("\n📡 Discovering available capabilities from Hub...")
 	go func() {
-		// Give workers time to register
-		time.Sleep(2 * time.Second)
-		
-		// Query Hub for capabilities
-		discoveryMsg := map[string]interface{}{
-			"action": "discover",
-		}
-		discoveryJSON, _ := json.Marshal(discoveryMsg)
-		
-		response, err := hubClient.SendRequest("hub", "capability_discovery", string(discoveryJSON))
+		// Query Hub for the capabilities registered so far; later changes
+		// reach the discovery cache through capabilities_changed
+		response, err := hubClient.Discover("")
 		if err != nil {
 			log.Printf("⚠️  Could not discover capabilities: %v", err)
 			return
@@ -133,7 +192,56 @@ func main() {
 	log.Printf("📚 API Docs: http://localhost:%s/api/docs", port)
 	log.Printf("🔍 Capabilities: http://localhost:%s/api/capabilities", port)
 
-	if err := http.ListenAndServe(portAddr, nil); err != nil {
+	// CORS_ALLOWED_ORIGINS enables cross-origin access (comma-separated, "*"
+	// for any); methods, headers and credentials are configurable too
+	cors := handlers.DefaultCORSConfig()
+	cors.AllowedOrigins = envList("CORS_ALLOWED_ORIGINS", nil)
+	cors.AllowedMethods = envList("CORS_ALLOWED_METHODS", cors.AllowedMethods)
+	cors.AllowedHeaders = envList("CORS_ALLOWED_HEADERS", cors.AllowedHeaders)
+	cors.ExposedHeaders = envList("CORS_EXPOSED_HEADERS", cors.ExposedHeaders)
+	cors.AllowCredentials, _ = strconv.ParseBool(os.Getenv("CORS_ALLOW_CREDENTIALS"))
+	cors.MaxAge = envDuration("CORS_MAX_AGE", cors.MaxAge)
+	if len(cors.AllowedOrigins) > 0 {
+		log.Printf("🌍 CORS enabled for %s (credentials: %t)", strings.Join(cors.AllowedOrigins, ", "), cors.AllowCredentials)
+		for _, origin := range cors.AllowedOrigins {
+			if origin == "*" && cors.AllowCredentials {
+				log.Printf("⚠️  CORS allows credentials from any origin; list the trusted origins instead of *")
+			}
+		}
+	}
+
+	if err := http.ListenAndServe(portAddr, handlers.CORS(cors, http.DefaultServeMux)); err != nil {
 		log.Fatalf("❌ Server failed: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+// envInt reads an integer environment variable, falling back to defaultValue
+func envInt(key string, defaultValue int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return defaultValue
+}
+
+// envList reads a comma-separated environment variable, falling back to
+// defaultValue when it is unset
+func envList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envDuration(key string, defaultValue time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return d
+	}
+	return defaultValue
+}
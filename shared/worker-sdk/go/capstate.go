@@ -0,0 +1,72 @@
+package workersdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+)
+
+// ControlActionSetCapability turns one of the worker's capabilities off or
+// on at the hub, with content {"capability": ..., "enabled": true|false}
+const ControlActionSetCapability = "set_capability"
+
+// DisableCapability stops the hub routing requests for one capability
+// (e.g. while its model reloads) without disconnecting the worker; its
+// other capabilities keep serving. Requests that still reach it are
+// answered NO_WORKER. The state is kept across reconnects. The hub's
+// acknowledgement is not awaited.
+func (w *WorkerSDK) DisableCapability(name string) error {
+	return w.setCapabilityEnabled(name, false)
+}
+
+// EnableCapability restores routing for a capability turned off with
+// DisableCapability
+func (w *WorkerSDK) EnableCapability(name string) error {
+	return w.setCapabilityEnabled(name, true)
+}
+
+// CapabilityEnabled reports whether a registered capability is enabled
+func (w *WorkerSDK) CapabilityEnabled(name string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	_, ok := w.capabilities[name]
+	return ok && !w.disabled[name]
+}
+
+// setCapabilityEnabled records the state locally, so the next registration
+// carries it, and tells the hub when connected
+func (w *WorkerSDK) setCapabilityEnabled(name string, enabled bool) error {
+	if name == HealthCapability {
+		return fmt.Errorf("capability %s cannot be disabled", name)
+	}
+	w.mu.Lock()
+	if _, ok := w.capabilities[name]; !ok {
+		w.mu.Unlock()
+		return fmt.Errorf("unknown capability: %s", name)
+	}
+	w.disabled[name] = !enabled
+	w.mu.Unlock()
+	log.Printf("[%s] 🔀 Capability %s enabled: %v", w.workerID, name, enabled)
+
+	if !w.isRunning() {
+		return nil
+	}
+	content, err := json.Marshal(map[string]interface{}{"capability": name, "enabled": enabled})
+	if err != nil {
+		return fmt.Errorf("failed to marshal capability state: %w", err)
+	}
+	w.send(&pb.Message{
+		Id:        utils.PrefixedID("capstate"),
+		From:      w.workerID,
+		To:        "hub",
+		Type:      pb.MessageType_CONTROL,
+		Action:    ControlActionSetCapability,
+		Content:   string(content),
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	return nil
+}
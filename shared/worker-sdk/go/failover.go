@@ -0,0 +1,143 @@
+package workersdk
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+)
+
+const (
+	reconnectBackoff    = 1 * time.Second
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// SetHubAddresses sets the hubs to connect to, in order of preference
+// (entries may themselves be comma-separated). Run connects to the first
+// that accepts; when the stream breaks the worker moves on to the next
+// address, wrapping around, and re-registers there. Must be called before
+// Run.
+func (w *WorkerSDK) SetHubAddresses(addresses ...string) {
+	w.hubAddresses = utils.SplitAddresses(strings.Join(addresses, ","))
+	w.hubIndex = 0
+}
+
+// HubAddress returns the address of the hub the worker is connected to
+// (or will try first)
+func (w *WorkerSDK) HubAddress() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if len(w.hubAddresses) == 0 {
+		return ""
+	}
+	return w.hubAddresses[w.hubIndex]
+}
+
+// connect tries each hub once, starting at index start, and installs the
+// first stream on which the registration could be sent
+func (w *WorkerSDK) connect(start int) error {
+	if len(w.hubAddresses) == 0 {
+		return fmt.Errorf("no hub address configured")
+	}
+
+	var failures []string
+	for i := range w.hubAddresses {
+		index := (start + i) % len(w.hubAddresses)
+		address := w.hubAddresses[index]
+		conn, client, stream, err := w.dial(address)
+		if err != nil {
+			log.Printf("[%s] ✗ Hub %s unavailable: %v", w.workerID, address, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", address, err))
+			continue
+		}
+
+		w.mu.Lock()
+		previous := w.conn
+		w.conn, w.hubIndex = conn, index
+		w.mu.Unlock()
+		if previous != nil {
+			previous.Close()
+		}
+		w.setStream(client, stream)
+
+		log.Printf("[%s] ✓ Connected to Hub at %s", w.workerID, address)
+		return nil
+	}
+	return fmt.Errorf("no hub reachable (%s)", strings.Join(failures, "; "))
+}
+
+// dial opens a stream to address and sends the registration on it, before
+// any held message is replayed
+func (w *WorkerSDK) dial(address string) (*grpc.ClientConn, pb.HubServiceClient, pb.HubService_ConnectClient, error) {
+	regMsg, err := w.registrationMessage()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(callOptions(w.maxRecvMsgSize, w.maxSendMsgSize, w.grpcCompression)...),
+	)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	client := pb.NewHubServiceClient(conn)
+	stream, err := client.Connect(context.Background())
+	if err == nil {
+		err = stream.Send(regMsg)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	log.Printf("[%s] 📤 Sent registration", w.workerID)
+	return conn, client, stream, nil
+}
+
+// reconnect fails over to the next hub after the stream broke, retrying
+// every hub with exponential backoff until one accepts or the worker is
+// stopped. Messages sent meanwhile are held and replayed on the new
+// stream (see SetSendBufferSize).
+func (w *WorkerSDK) reconnect() bool {
+	backoff := reconnectBackoff
+	for w.isRunning() {
+		log.Printf("[%s] 🔄 Reconnecting...", w.workerID)
+		w.mu.RLock()
+		next := w.hubIndex + 1
+		w.mu.RUnlock()
+
+		err := w.connect(next)
+		if err == nil {
+			return true
+		}
+		log.Printf("[%s] ✗ Reconnect failed, retrying in %v: %v", w.workerID, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-w.done:
+			return false
+		}
+		if backoff *= 2; backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+	return false
+}
+
+// closeConn closes the connection to the current hub
+func (w *WorkerSDK) closeConn() {
+	w.mu.Lock()
+	conn := w.conn
+	w.conn = nil
+	w.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
@@ -0,0 +1,75 @@
+package workersdk
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	pb "deepapp_golang_grpc_hub/internal/proto"
+)
+
+// refusedAddress is a local address nothing listens on
+func refusedAddress(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+func TestWorkerSkipsRefusingHub(t *testing.T) {
+	secondary := startTestHub(t)
+	primary := refusedAddress(t)
+	w := startWorker(t, secondary, func(w *WorkerSDK) {
+		w.SetHubAddresses(primary + ", " + secondary.addr)
+		w.AddCapability(&Capability{Name: "echo"}, func(params map[string]interface{}) (map[string]interface{}, error) {
+			return params, nil
+		})
+	})
+
+	if got := w.HubAddress(); got != secondary.addr {
+		t.Fatalf("connected to %s, want the secondary %s", got, secondary.addr)
+	}
+	if resp := secondary.request(t, "echo", `{"n":1}`); errorCode(resp) != "" || resp.Content != `{"n":1}` {
+		t.Fatalf("echo through the secondary: %q %q", errorCode(resp), resp.Content)
+	}
+}
+
+// When the hub it is connected to goes away, the worker moves on to the
+// next address and registers there
+func TestWorkerFailsOverWhenHubStops(t *testing.T) {
+	primary, secondary := startTestHub(t), startTestHub(t)
+	w := startWorker(t, primary, func(w *WorkerSDK) {
+		w.SetHubAddresses(primary.addr, secondary.addr)
+		w.AddCapability(&Capability{Name: "echo"}, func(params map[string]interface{}) (map[string]interface{}, error) {
+			return params, nil
+		})
+	})
+
+	primary.server.Stop()
+	secondary.next(t, pb.MessageType_REGISTER)
+	if got := w.HubAddress(); got != secondary.addr {
+		t.Fatalf("connected to %s after failover, want %s", got, secondary.addr)
+	}
+	if resp := secondary.request(t, "echo", `{"n":2}`); errorCode(resp) != "" || resp.Content != `{"n":2}` {
+		t.Fatalf("echo after failover: %q %q", errorCode(resp), resp.Content)
+	}
+}
+
+func TestWorkerRunFailsWithoutReachableHub(t *testing.T) {
+	w := NewWorkerSDK("w1", refusedAddress(t)+","+refusedAddress(t), "test")
+	done := make(chan error, 1)
+	go func() { done <- w.Run() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Run succeeded with no hub listening")
+		}
+	case <-time.After(testTimeout):
+		w.Stop()
+		t.Fatal("Run still connecting with no hub listening")
+	}
+}
@@ -0,0 +1,148 @@
+package workersdk
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// testTimeout bounds every wait for a message
+const testTimeout = 2 * time.Second
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testHub accepts worker streams, hands everything workers send to
+// received and sends test messages on the latest stream
+type testHub struct {
+	pb.UnimplementedHubServiceServer
+	addr     string
+	server   *grpc.Server
+	received chan *pb.Message
+
+	mu     sync.Mutex
+	stream pb.HubService_ConnectServer
+}
+
+// startTestHub serves a testHub on a local port until the test ends
+func startTestHub(t *testing.T) *testHub {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &testHub{addr: lis.Addr().String(), server: grpc.NewServer(), received: make(chan *pb.Message, 1024)}
+	pb.RegisterHubServiceServer(h.server, h)
+	go h.server.Serve(lis)
+	t.Cleanup(h.server.Stop)
+	return h
+}
+
+func (h *testHub) Connect(stream pb.HubService_ConnectServer) error {
+	h.mu.Lock()
+	h.stream = stream
+	h.mu.Unlock()
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		h.received <- msg
+	}
+}
+
+// send sends msg to the worker on the latest stream
+func (h *testHub) send(t *testing.T, msg *pb.Message) {
+	t.Helper()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.stream.Send(msg); err != nil {
+		t.Fatalf("hub send: %v", err)
+	}
+}
+
+// next returns the next message of type typ a worker sent, skipping others
+// (stats updates, logs)
+func (h *testHub) next(t *testing.T, typ pb.MessageType) *pb.Message {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case msg := <-h.received:
+			if msg.Type == typ {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("hub: no %s within %v", typ, testTimeout)
+			return nil
+		}
+	}
+}
+
+// request sends the worker a REQUEST for capability and returns its
+// response
+func (h *testHub) request(t *testing.T, capability, params string) *pb.Message {
+	t.Helper()
+	msg := &pb.Message{
+		Id:        utils.PrefixedID("req"),
+		From:      "client",
+		To:        "w1",
+		Type:      pb.MessageType_REQUEST,
+		Content:   params,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	msg.RequestId = msg.Id
+	envelope.SetCapability(msg, capability)
+	h.send(t, msg)
+
+	for {
+		resp := h.next(t, pb.MessageType_RESPONSE)
+		if resp.Metadata[envelope.RequestIDKey] == msg.Id {
+			return resp
+		}
+	}
+}
+
+// startWorker runs worker w1 against hub, set up by configure, until the
+// test ends. It returns once the hub got the registration, so configure
+// may list other hub addresses as long as hub is the one that accepts.
+func startWorker(t *testing.T, hub *testHub, configure func(w *WorkerSDK)) *WorkerSDK {
+	t.Helper()
+	w := NewWorkerSDK("w1", hub.addr, "test")
+	if configure != nil {
+		configure(w)
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.Run() }()
+	t.Cleanup(func() {
+		w.Stop()
+		select {
+		case <-done:
+		case <-time.After(testTimeout):
+			t.Error("Run did not return after Stop")
+		}
+	})
+	hub.next(t, pb.MessageType_REGISTER)
+	return w
+}
+
+// errorCode is the error code a response carries, "" if it succeeded
+func errorCode(resp *pb.Message) apierr.Code {
+	if apiErr, failed := envelope.Error(resp); failed {
+		return apiErr.Code
+	}
+	return ""
+}
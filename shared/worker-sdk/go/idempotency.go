@@ -0,0 +1,96 @@
+package workersdk
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long results are kept for retried calls
+const DefaultIdempotencyTTL = 5 * time.Minute
+
+// idempotencyEntry is a call that is running or has finished. done is
+// closed once content/err are set.
+type idempotencyEntry struct {
+	done    chan struct{}
+	content string
+	err     error
+	expires time.Time
+}
+
+// idempotencyCache remembers results by idempotency key so retried calls
+// return the original result instead of executing again
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotencyEntry
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// do runs fn once per key. A duplicate that arrives while fn is running
+// waits for it; one that arrives later gets the stored result. Only
+// successful results are kept, so a failed call may be retried.
+func (c *idempotencyCache) do(key string, fn func() (string, error)) (string, error) {
+	c.mu.Lock()
+	if c.ttl <= 0 {
+		c.mu.Unlock()
+		return fn()
+	}
+
+	now := time.Now()
+	if entry, exists := c.entries[key]; exists && (entry.expires.IsZero() || now.Before(entry.expires)) {
+		c.mu.Unlock()
+		<-entry.done
+		if entry.err == nil {
+			return entry.content, nil
+		}
+		// The original failed; fall through and execute again
+		c.mu.Lock()
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		return c.do(key, fn)
+	}
+
+	entry := &idempotencyEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.sweep(now)
+	c.mu.Unlock()
+
+	entry.content, entry.err = fn()
+
+	c.mu.Lock()
+	if entry.err != nil {
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+	} else {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	c.mu.Unlock()
+	close(entry.done)
+
+	return entry.content, entry.err
+}
+
+// sweep drops expired entries (caller holds the lock)
+func (c *idempotencyCache) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// setTTL changes how long results are kept; ttl <= 0 disables caching
+func (c *idempotencyCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	c.ttl = ttl
+	c.mu.Unlock()
+}
@@ -0,0 +1,192 @@
+package workersdk
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+)
+
+// ControlActionWorkerLogs turns log forwarding on or off; the hub relays it
+// from an admin with content {"enabled": true|false}
+const ControlActionWorkerLogs = "worker_logs"
+
+// LogsChannelPrefix + worker ID is the channel forwarded logs are published
+// on; only admin clients may subscribe to it
+const LogsChannelPrefix = "logs:"
+
+// Limits for forwarded logs: lines per second (excess is dropped and
+// counted), bytes per line (longer lines are truncated) and lines waiting
+// to be sent
+const (
+	logForwardRate     = 20
+	logForwardMaxBytes = 2048
+	logForwardBuffer   = 256
+)
+
+// DroppedLogsMetadata is set on a forwarded line when lines before it were
+// dropped by the rate limit or a full buffer
+const DroppedLogsMetadata = "dropped"
+
+// logLine is one forwarded log entry, the content of its CHANNEL message
+type logLine struct {
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Time    string                 `json:"time"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// LogHook returns a logrus hook forwarding the worker's log entries to
+// the hub (logrus.AddHook(w.LogHook())). It also implements io.Writer, so
+// the standard logger can be forwarded with
+// log.SetOutput(io.MultiWriter(os.Stderr, w.LogHook())).
+//
+// Nothing is forwarded until an admin enables it with the worker_logs
+// control action (or SetLogForwarding is called); lines are then
+// published on LogsChannelPrefix + worker ID.
+func (w *WorkerSDK) LogHook() *LogHook {
+	return &LogHook{w: w}
+}
+
+// SetLogForwarding turns log forwarding on or off, like the worker_logs
+// control action
+func (w *WorkerSDK) SetLogForwarding(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	if atomic.SwapInt32(&w.logForwarding, value) != value {
+		log.Printf("[%s] 📜 Log forwarding enabled: %v", w.workerID, enabled)
+	}
+}
+
+// LogForwarding reports whether logs are being forwarded to the hub
+func (w *WorkerSDK) LogForwarding() bool {
+	return atomic.LoadInt32(&w.logForwarding) == 1
+}
+
+// handleWorkerLogs applies a worker_logs control message
+func (w *WorkerSDK) handleWorkerLogs(msg *pb.Message) {
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.Unmarshal([]byte(msg.Content), &req); err != nil {
+		log.Printf("[%s] ⚠️  Ignoring malformed %s: %v", w.workerID, ControlActionWorkerLogs, err)
+		return
+	}
+	w.SetLogForwarding(req.Enabled)
+}
+
+// LogHook forwards log entries to the hub, see WorkerSDK.LogHook
+type LogHook struct {
+	w *WorkerSDK
+}
+
+// Levels implements logrus.Hook
+func (h *LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook; it never blocks
+func (h *LogHook) Fire(entry *logrus.Entry) error {
+	if !h.w.LogForwarding() {
+		return nil
+	}
+	line := logLine{
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+		Time:    entry.Time.Format(time.RFC3339Nano),
+	}
+	if len(entry.Data) > 0 {
+		line.Fields = make(map[string]interface{}, len(entry.Data))
+		for key, value := range entry.Data {
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			line.Fields[key] = value
+		}
+	}
+	h.w.queueLog(line)
+	return nil
+}
+
+// Write implements io.Writer for the standard logger; every line is
+// forwarded at level info. It never blocks or fails.
+func (h *LogHook) Write(p []byte) (int, error) {
+	if !h.w.LogForwarding() {
+		return len(p), nil
+	}
+	for _, text := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		h.w.queueLog(logLine{
+			Level:   logrus.InfoLevel.String(),
+			Message: text,
+			Time:    time.Now().Format(time.RFC3339Nano),
+		})
+	}
+	return len(p), nil
+}
+
+// queueLog hands line to logLoop, dropping it when the buffer is full
+func (w *WorkerSDK) queueLog(line logLine) {
+	if len(line.Message) > logForwardMaxBytes {
+		line.Message = line.Message[:logForwardMaxBytes] + "…(truncated)"
+	}
+	select {
+	case w.logLines <- line:
+	default:
+		atomic.AddInt64(&w.logsDropped, 1)
+	}
+}
+
+// logLoop publishes queued lines on the worker's log channel, at most
+// logForwardRate per second, while running
+func (w *WorkerSDK) logLoop() {
+	channel := LogsChannelPrefix + w.workerID
+	windowStart := time.Now()
+	sentInWindow := 0
+
+	for {
+		var line logLine
+		select {
+		case line = <-w.logLines:
+		case <-w.done:
+			return
+		}
+		if !w.LogForwarding() {
+			continue // queued before forwarding was turned off
+		}
+		if now := time.Now(); now.Sub(windowStart) >= time.Second {
+			windowStart, sentInWindow = now, 0
+		}
+		if sentInWindow >= logForwardRate {
+			atomic.AddInt64(&w.logsDropped, 1)
+			continue
+		}
+		sentInWindow++
+
+		content, err := json.Marshal(line)
+		if err != nil {
+			continue
+		}
+		msg := &pb.Message{
+			Id:        utils.PrefixedID("log"),
+			From:      w.workerID,
+			Type:      pb.MessageType_CHANNEL,
+			Channel:   channel,
+			Action:    line.Level,
+			Content:   string(content),
+			Timestamp: time.Now().Format(time.RFC3339),
+		}
+		if dropped := atomic.SwapInt64(&w.logsDropped, 0); dropped > 0 {
+			msg.Metadata = map[string]string{DroppedLogsMetadata: strconv.FormatInt(dropped, 10)}
+		}
+		w.send(msg)
+	}
+}
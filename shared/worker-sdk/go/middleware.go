@@ -0,0 +1,50 @@
+package workersdk
+
+import (
+	"log"
+	"runtime/debug"
+
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// Middleware wraps the handler of a capability; capability is the name the
+// handler was registered under. Middleware runs in the order it was added
+// with Use, the first one outermost.
+type Middleware func(capability string, next CapabilityHandler) CapabilityHandler
+
+// Use adds middleware applied to every capability, including ones added
+// before the call and the built-in health check
+func (w *WorkerSDK) Use(mw ...Middleware) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.middleware = append(w.middleware, mw...)
+}
+
+// wrap builds the middleware chain for a handler. Recover always runs
+// outermost so a panic in middleware is caught too.
+func (w *WorkerSDK) wrap(capability string, handler CapabilityHandler) CapabilityHandler {
+	w.mu.RLock()
+	chain := w.middleware
+	w.mu.RUnlock()
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](capability, handler)
+	}
+	return Recover(capability, handler)
+}
+
+// Recover turns a panic in the handler into an INTERNAL error response and
+// logs the stack, so one bad request doesn't kill the worker
+func Recover(capability string, next CapabilityHandler) CapabilityHandler {
+	return func(params map[string]interface{}) (result map[string]interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("💥 Panic in capability %s: %v\n%s", capability, r, debug.Stack())
+				result = nil
+				err = apierr.Newf(apierr.CodeInternal, "capability %s panicked: %v", capability, r).
+					WithDetail("capability", capability)
+			}
+		}()
+		return next(params)
+	}
+}
@@ -0,0 +1,192 @@
+package workersdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// Params gives typed access to the params a CapabilityHandler receives:
+//
+//	text, err := workersdk.Params(params).RequireString("text")
+//
+// Get* report whether the key holds a value of the type; Require* return a
+// VALIDATION error naming the parameter, which the hub passes on to the
+// caller unchanged. A null value counts as missing. Numbers may be any Go
+// numeric type, as decoded from JSON or MessagePack.
+type Params map[string]interface{}
+
+// GetString returns the string at key
+func (p Params) GetString(key string) (string, bool) {
+	s, ok := p[key].(string)
+	return s, ok
+}
+
+// GetFloat returns the number at key
+func (p Params) GetFloat(key string) (float64, bool) {
+	return toFloat(p[key])
+}
+
+// GetInt returns the number at key if it is a whole number that fits in
+// an int; 2.0 is accepted, 2.5 is not
+func (p Params) GetInt(key string) (int, bool) {
+	return toInt(p[key])
+}
+
+// GetBool returns the boolean at key
+func (p Params) GetBool(key string) (bool, bool) {
+	b, ok := p[key].(bool)
+	return b, ok
+}
+
+// RequireString returns the string at key, or a VALIDATION error if it is
+// missing or not a string
+func (p Params) RequireString(key string) (string, error) {
+	s, ok := p.GetString(key)
+	if !ok {
+		return "", p.invalid(key, "a string")
+	}
+	return s, nil
+}
+
+// RequireFloat returns the number at key, or a VALIDATION error if it is
+// missing or not a number
+func (p Params) RequireFloat(key string) (float64, error) {
+	f, ok := p.GetFloat(key)
+	if !ok {
+		return 0, p.invalid(key, "a number")
+	}
+	return f, nil
+}
+
+// RequireInt returns the whole number at key, or a VALIDATION error if it
+// is missing or not a whole number
+func (p Params) RequireInt(key string) (int, error) {
+	n, ok := p.GetInt(key)
+	if !ok {
+		return 0, p.invalid(key, "an integer")
+	}
+	return n, nil
+}
+
+// RequireBool returns the boolean at key, or a VALIDATION error if it is
+// missing or not a boolean
+func (p Params) RequireBool(key string) (bool, error) {
+	b, ok := p.GetBool(key)
+	if !ok {
+		return false, p.invalid(key, "a boolean")
+	}
+	return b, nil
+}
+
+// invalid is the error for a parameter that is missing or not expected
+func (p Params) invalid(key, expected string) *apierr.ErrorResponse {
+	value := p[key]
+	if value == nil {
+		return apierr.Newf(apierr.CodeValidation, "missing required parameter %q", key).
+			WithDetail("param", key)
+	}
+	got := jsonType(value)
+	return apierr.Newf(apierr.CodeValidation, "parameter %q must be %s, got %s", key, expected, got).
+		WithDetail("param", key).
+		WithDetail("got", got)
+}
+
+// jsonType names the JSON type of a decoded value, for error messages
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	if _, ok := toFloat(value); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		if v < math.MinInt || v > math.MaxInt {
+			return 0, false
+		}
+		return int(v), true
+	case uint:
+		if v > math.MaxInt {
+			return 0, false
+		}
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		if uint64(v) > math.MaxInt {
+			return 0, false
+		}
+		return int(v), true
+	case uint64:
+		if v > math.MaxInt {
+			return 0, false
+		}
+		return int(v), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return toInt(n)
+		}
+	}
+	f, ok := toFloat(value)
+	if !ok || f != math.Trunc(f) || f < math.MinInt || f >= math.MaxInt {
+		return 0, false
+	}
+	return int(f), true
+}
@@ -0,0 +1,68 @@
+package workersdk
+
+import (
+	"encoding/json"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+)
+
+// ProgressFunc reports how far a request has got, e.g.
+// {"processed": 10, "total": 40}. It is safe to call from several
+// goroutines.
+type ProgressFunc func(update map[string]interface{})
+
+// ProgressHandler is a capability handler that can report progress while
+// it runs
+type ProgressHandler func(params map[string]interface{}, progress ProgressFunc) (map[string]interface{}, error)
+
+// AddProgressCapability registers a capability whose handler reports
+// progress. Each update is sent to the caller as a DIRECT message carrying
+// the request's id when the caller streams the response
+// (Metadata["stream"] = "true"); otherwise progress is discarded.
+// Middleware applies as with AddCapability.
+func (w *WorkerSDK) AddProgressCapability(cap *Capability, handler ProgressHandler) {
+	w.AddCapability(cap, func(params map[string]interface{}) (map[string]interface{}, error) {
+		return handler(params, noProgress)
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.progressHandlers[cap.Name] = handler
+}
+
+// withProgress binds a progress handler to the request it is answering
+func (w *WorkerSDK) withProgress(handler ProgressHandler, msg *pb.Message) CapabilityHandler {
+	progress := noProgress
+	if msg.Metadata[envelope.StreamKey] == "true" {
+		progress = func(update map[string]interface{}) {
+			w.sendProgress(msg, update)
+		}
+	}
+	return func(params map[string]interface{}) (map[string]interface{}, error) {
+		return handler(params, progress)
+	}
+}
+
+// sendProgress queues a progress update for the caller of msg
+func (w *WorkerSDK) sendProgress(msg *pb.Message, update map[string]interface{}) {
+	content, err := json.Marshal(update)
+	if err != nil {
+		return
+	}
+	w.send(&pb.Message{
+		Id:        utils.PrefixedID("progress"),
+		From:      w.workerID,
+		To:        msg.From,
+		RequestId: msg.RequestId,
+		Channel:   msg.Channel,
+		Content:   string(content),
+		Timestamp: time.Now().Format(time.RFC3339),
+		Type:      pb.MessageType_DIRECT,
+		Metadata:  map[string]string{envelope.RequestIDKey: msg.Id},
+	})
+}
+
+func noProgress(map[string]interface{}) {}
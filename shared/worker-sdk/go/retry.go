@@ -0,0 +1,109 @@
+package workersdk
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// IdempotencyKeyMetadata carries the idempotency key of a call so the
+// target worker can return a cached result for retries
+const IdempotencyKeyMetadata = "idempotency_key"
+
+// RetryOptions controls CallWorkerWithRetry. Zero values use the defaults.
+type RetryOptions struct {
+	Attempts   int           // total attempts including the first (default 3)
+	Timeout    time.Duration // per-attempt timeout (default 30s)
+	Backoff    time.Duration // delay before the first retry (default 500ms)
+	MaxBackoff time.Duration // cap for the delay (default 10s)
+	Multiplier float64       // delay growth per retry (default 2)
+
+	// IdempotencyKey is sent with every attempt; one is generated if empty
+	IdempotencyKey string
+
+	// Retryable decides whether an error is worth another attempt
+	// (default DefaultRetryable)
+	Retryable func(err error) bool
+}
+
+func (o RetryOptions) withDefaults() RetryOptions {
+	if o.Attempts < 1 {
+		o.Attempts = 3
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 30 * time.Second
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 500 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 10 * time.Second
+	}
+	if o.Multiplier < 1 {
+		o.Multiplier = 2
+	}
+	if o.IdempotencyKey == "" {
+		o.IdempotencyKey = newIdempotencyKey()
+	}
+	if o.Retryable == nil {
+		o.Retryable = DefaultRetryable
+	}
+	return o
+}
+
+// DefaultRetryable retries transient failures: timeouts, missing, busy or
+// unreachable workers and rate limiting. Validation and execution errors
+// are returned immediately.
+func DefaultRetryable(err error) bool {
+	var apiErr *apierr.ErrorResponse
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case apierr.CodeTimeout, apierr.CodeNoWorker, apierr.CodeWorkerBusy, apierr.CodeWorkerNotFound, apierr.CodeRateLimited,
+		apierr.CodeUnavailable:
+		return true
+	}
+	return false
+}
+
+// CallWorkerWithRetry calls another worker, retrying retryable failures
+// with exponential backoff. Every attempt carries the same idempotency key,
+// so a target that already finished the work returns its cached result
+// instead of running it again.
+func (w *WorkerSDK) CallWorkerWithRetry(targetWorker, capability string, params map[string]interface{}, opts RetryOptions) (map[string]interface{}, error) {
+	opts = opts.withDefaults()
+	metadata := map[string]string{IdempotencyKeyMetadata: opts.IdempotencyKey}
+
+	delay := opts.Backoff
+	var lastErr error
+	for attempt := 1; attempt <= opts.Attempts; attempt++ {
+		result, err := w.callWorker(targetWorker, capability, params, opts.Timeout, metadata)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+
+		if attempt == opts.Attempts || !opts.Retryable(err) {
+			break
+		}
+
+		log.Printf("[%s] ↻ Retrying %s.%s in %v (attempt %d/%d): %v",
+			w.workerID, targetWorker, capability, delay, attempt+1, opts.Attempts, err)
+		time.Sleep(delay)
+
+		delay = time.Duration(float64(delay) * opts.Multiplier)
+		if delay > opts.MaxBackoff {
+			delay = opts.MaxBackoff
+		}
+	}
+
+	return nil, lastErr
+}
+
+func newIdempotencyKey() string {
+	return utils.GenerateID()
+}
@@ -0,0 +1,154 @@
+package workersdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultSelfTestTimeout bounds each capability's self-test
+const DefaultSelfTestTimeout = 5 * time.Second
+
+// ErrSelfTestFailed is returned by Run when a critical capability fails
+// its self-test; the worker does not register
+var ErrSelfTestFailed = errors.New("self-test failed")
+
+// SelfTestResult is the outcome of one capability's self-test
+type SelfTestResult struct {
+	Capability string        `json:"capability"`
+	Passed     bool          `json:"passed"`
+	Critical   bool          `json:"critical"`
+	Panicked   bool          `json:"panicked,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// EnableSelfTest makes Run call RunSelfTest before registering and refuse
+// to register when a critical capability fails. With no names every
+// capability is critical. Must be called before Run.
+func (w *WorkerSDK) EnableSelfTest(critical ...string) {
+	w.selfTest = true
+	w.selfTestCritical = nil
+	if len(critical) > 0 {
+		w.selfTestCritical = make(map[string]bool, len(critical))
+		for _, name := range critical {
+			w.selfTestCritical[name] = true
+		}
+	}
+}
+
+// SetSelfTestTimeout sets how long each capability's self-test may run
+// before it fails (default DefaultSelfTestTimeout)
+func (w *WorkerSDK) SetSelfTestTimeout(timeout time.Duration) {
+	w.selfTestTimeout = timeout
+}
+
+// RunSelfTest invokes every registered capability (except __health) once
+// with its Example, or empty params when it has none, and reports the
+// results sorted by capability name. A capability fails when its handler
+// panics or times out, when its Example is not a JSON object, or when it
+// returns an error for its Example; errors for empty params are recorded
+// but pass, since most handlers reject a request without params.
+// Handlers run without middleware and must not depend on the hub
+// connection, which is not up yet when Run calls this.
+func (w *WorkerSDK) RunSelfTest() []SelfTestResult {
+	w.mu.RLock()
+	names := make([]string, 0, len(w.capabilities))
+	for name := range w.capabilities {
+		if name != HealthCapability {
+			names = append(names, name)
+		}
+	}
+	w.mu.RUnlock()
+	sort.Strings(names)
+
+	results := make([]SelfTestResult, 0, len(names))
+	for _, name := range names {
+		result := w.selfTestCapability(name)
+		if result.Passed {
+			log.Printf("[%s] ✅ Self-test passed: %s (%v)", w.workerID, name, result.Duration)
+		} else {
+			log.Printf("[%s] ❌ Self-test failed: %s: %s", w.workerID, name, result.Error)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// selfTestCapability runs one capability's self-test
+func (w *WorkerSDK) selfTestCapability(name string) SelfTestResult {
+	w.mu.RLock()
+	cap := w.capabilities[name]
+	handler := w.handlers[name]
+	w.mu.RUnlock()
+
+	result := SelfTestResult{
+		Capability: name,
+		Critical:   w.selfTestCritical == nil || w.selfTestCritical[name],
+	}
+
+	params := map[string]interface{}{}
+	hasExample := strings.TrimSpace(cap.Example) != ""
+	if hasExample {
+		if err := json.Unmarshal([]byte(cap.Example), &params); err != nil {
+			result.Error = fmt.Sprintf("example is not a JSON object: %v", err)
+			return result
+		}
+	}
+
+	timeout := w.selfTestTimeout
+	if timeout <= 0 {
+		timeout = DefaultSelfTestTimeout
+	}
+
+	type outcome struct {
+		err      error
+		panicked bool
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[%s] 💥 Panic in self-test of %s: %v\n%s", w.workerID, name, r, debug.Stack())
+				done <- outcome{err: fmt.Errorf("panic: %v", r), panicked: true}
+			}
+		}()
+		_, err := handler(params)
+		done <- outcome{err: err}
+	}()
+
+	select {
+	case out := <-done:
+		result.Duration = time.Since(start)
+		result.Panicked = out.panicked
+		if out.err != nil {
+			result.Error = out.err.Error()
+		}
+		result.Passed = !out.panicked && (out.err == nil || !hasExample)
+	case <-time.After(timeout):
+		result.Duration = timeout
+		result.Error = fmt.Sprintf("timed out after %v", timeout)
+	}
+	return result
+}
+
+// checkSelfTest runs the self-test and returns ErrSelfTestFailed naming
+// the critical capabilities that failed
+func (w *WorkerSDK) checkSelfTest() error {
+	var failed []string
+	for _, result := range w.RunSelfTest() {
+		if result.Critical && !result.Passed {
+			failed = append(failed, result.Capability)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrSelfTestFailed, strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package workersdk
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/codec"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+)
+
+// DefaultSendBufferSize is how many messages are held for retry when the
+// stream to the hub fails
+const DefaultSendBufferSize = 64
+
+const (
+	sendRetryBackoff    = 100 * time.Millisecond
+	sendRetryMaxBackoff = 5 * time.Second
+)
+
+// SendStats counts outbound messages. Growing Retried or Dropped means the
+// link to the hub is unhealthy.
+type SendStats struct {
+	Sent    int64 `json:"sent"`
+	Retried int64 `json:"retried"` // re-sent after a failed attempt
+	Dropped int64 `json:"dropped"` // evicted from a full buffer or lost on exit
+	Held    int64 `json:"held"`    // waiting for the stream to recover
+}
+
+// SetSendBufferSize sets how many failed sends are held for retry; when
+// the buffer is full the oldest message is dropped (<= 0 drops failed
+// sends immediately). Must be called before Run.
+func (w *WorkerSDK) SetSendBufferSize(n int) {
+	w.sendBufferSize = n
+}
+
+// SendStats returns the outbound message counters
+func (w *WorkerSDK) SendStats() SendStats {
+	return SendStats{
+		Sent:    atomic.LoadInt64(&w.sendStats.Sent),
+		Retried: atomic.LoadInt64(&w.sendStats.Retried),
+		Dropped: atomic.LoadInt64(&w.sendStats.Dropped),
+		Held:    atomic.LoadInt64(&w.sendStats.Held),
+	}
+}
+
+// setStream installs a (new) stream to the hub and wakes the send loop so
+// held messages are replayed right away
+func (w *WorkerSDK) setStream(client pb.HubServiceClient, stream pb.HubService_ConnectClient) {
+	w.mu.Lock()
+	w.client = client
+	w.stream = stream
+	w.mu.Unlock()
+
+	select {
+	case w.streamReady <- struct{}{}:
+	default:
+	}
+}
+
+func (w *WorkerSDK) currentStream() pb.HubService_ConnectClient {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.stream
+}
+
+func (w *WorkerSDK) currentClient() pb.HubServiceClient {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.client
+}
+
+// sendLoop handles sending messages to Hub. A failed send is held and
+// retried with backoff, ahead of newer messages so ordering is kept. It
+// returns once Stop closes sendChan and the queued messages are sent.
+func (w *WorkerSDK) sendLoop() {
+	var held []*pb.Message
+	var retry <-chan time.Time
+	backoff := sendRetryBackoff
+
+	// flush sends held messages in order, stopping at the first failure
+	flush := func() bool {
+		for len(held) > 0 {
+			if err := w.currentStream().Send(held[0]); err != nil {
+				log.Printf("[%s] ✗ Retry failed, %d message(s) held: %v", w.workerID, len(held), err)
+				return false
+			}
+			atomic.AddInt64(&w.sendStats.Retried, 1)
+			w.sent(held[0])
+			held = held[1:]
+			atomic.AddInt64(&w.sendStats.Held, -1)
+		}
+		return true
+	}
+	hold := func(msg *pb.Message) {
+		if w.sendBufferSize <= 0 {
+			atomic.AddInt64(&w.sendStats.Dropped, 1)
+			log.Printf("[%s] ✗ Dropped message %s", w.workerID, msg.Id)
+			return
+		}
+		if len(held) >= w.sendBufferSize {
+			atomic.AddInt64(&w.sendStats.Dropped, 1)
+			atomic.AddInt64(&w.sendStats.Held, -1)
+			log.Printf("[%s] ✗ Send buffer full, dropped message %s", w.workerID, held[0].Id)
+			held = held[1:]
+		}
+		held = append(held, msg)
+		atomic.AddInt64(&w.sendStats.Held, 1)
+	}
+
+loop:
+	for {
+		select {
+		case msg, ok := <-w.sendChan:
+			if !ok {
+				break loop // closed by Stop after the last send
+			}
+			w.prepare(msg)
+
+			if len(held) > 0 {
+				hold(msg)
+				continue
+			}
+			if err := w.currentStream().Send(msg); err != nil {
+				log.Printf("[%s] ✗ Send error: %v", w.workerID, err)
+				if hold(msg); len(held) > 0 && w.isRunning() {
+					retry = time.After(backoff)
+				}
+				continue
+			}
+			w.sent(msg)
+
+		case <-retry:
+			if !w.isRunning() {
+				continue // stopping: held messages are dropped on exit
+			}
+			if flush() {
+				log.Printf("[%s] ✓ Send recovered", w.workerID)
+				backoff, retry = sendRetryBackoff, nil
+				continue
+			}
+			if backoff *= 2; backoff > sendRetryMaxBackoff {
+				backoff = sendRetryMaxBackoff
+			}
+			retry = time.After(backoff)
+
+		case <-w.streamReady:
+			if len(held) > 0 && flush() {
+				log.Printf("[%s] ✓ Replayed held messages on new stream", w.workerID)
+				backoff, retry = sendRetryBackoff, nil
+			}
+		}
+	}
+
+	if len(held) > 0 {
+		atomic.AddInt64(&w.sendStats.Dropped, int64(len(held)))
+		atomic.AddInt64(&w.sendStats.Held, -int64(len(held)))
+		log.Printf("[%s] ✗ Dropped %d held message(s) on exit", w.workerID, len(held))
+	}
+	log.Printf("[%s] Send loop exited", w.workerID)
+}
+
+// prepare compresses or offloads a message once, before its first attempt
+func (w *WorkerSDK) prepare(msg *pb.Message) {
+	if err := codec.Compress(msg, w.compressionThreshold); err != nil {
+		log.Printf("[%s] ⚠️  Sending uncompressed: %v", w.workerID, err)
+	}
+	if offloaded, err := codec.Offload(context.Background(), w.currentClient(), msg, w.maxSendMsgSize); err != nil {
+		log.Printf("[%s] ⚠️  Sending inline, offload failed: %v", w.workerID, err)
+	} else if offloaded {
+		log.Printf("[%s] 📦 Content of %s sent as file %s", w.workerID, msg.Id, msg.Metadata[codec.ContentFileKey])
+	}
+}
+
+// sent records a delivered message and fires onDrained after the drain ack
+func (w *WorkerSDK) sent(msg *pb.Message) {
+	atomic.AddInt64(&w.sendStats.Sent, 1)
+
+	w.mu.RLock()
+	drained := msg == w.drainedAck
+	w.mu.RUnlock()
+	if drained && w.onDrained != nil {
+		go w.onDrained()
+	}
+}
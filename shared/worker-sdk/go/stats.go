@@ -0,0 +1,55 @@
+package workersdk
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStatsInterval is how often the worker reports its uptime and
+// request counters to the hub
+const DefaultStatsInterval = 30 * time.Second
+
+// Metadata keys of the reported stats; the hub shows them per worker in
+// discovery
+const (
+	StartedAtMetadata       = "started_at"
+	RequestsHandledMetadata = "requests_handled"
+	ErrorsReturnedMetadata  = "errors_returned"
+)
+
+// SetStatsInterval sets how often the stats are sent with update_metadata
+// (<= 0 only reports them at registration). Must be called before Run.
+func (w *WorkerSDK) SetStatsInterval(interval time.Duration) {
+	w.statsInterval = interval
+}
+
+// statsMetadata returns the start time and the number of requests handled
+// and answered with an error, __health calls excluded
+func (w *WorkerSDK) statsMetadata() map[string]interface{} {
+	return map[string]interface{}{
+		StartedAtMetadata:       w.startedAt.Format(time.RFC3339),
+		RequestsHandledMetadata: atomic.LoadInt64(&w.requestsHandled),
+		ErrorsReturnedMetadata:  atomic.LoadInt64(&w.errorsReturned),
+	}
+}
+
+// statsLoop sends the stats to the hub every statsInterval while running
+func (w *WorkerSDK) statsLoop() {
+	if w.statsInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(w.statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.done:
+			return
+		}
+		if err := w.UpdateMetadata(w.statsMetadata()); err != nil {
+			log.Printf("[%s] ⚠️  Could not report stats: %v", w.workerID, err)
+		}
+	}
+}
@@ -5,15 +5,58 @@ package workersdk
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
+	"deepapp_golang_grpc_hub/internal/codec"
+	"deepapp_golang_grpc_hub/internal/envelope"
 	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/internal/utils"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// HealthCapability is the built-in liveness capability exposed by every worker
+const HealthCapability = "__health"
+
+// Control actions for draining a worker before shutdown
+const (
+	ControlActionDrain   = "drain"
+	ControlActionDrained = "drained"
+)
+
+// Control actions measuring the round trip to the hub, which answers a
+// "ping" with a "pong" itself
+const (
+	ControlActionPing = "ping"
+	ControlActionPong = "pong"
+)
+
+// DefaultPingTimeout bounds Ping
+const DefaultPingTimeout = 5 * time.Second
+
+// ControlActionUpdateMetadata patches the worker's registration metadata
+// on the hub without re-registering its capabilities
+const ControlActionUpdateMetadata = "update_metadata"
+
+// DeadlineMetadata is the request metadata key (RFC3339 time) after which a
+// request still waiting for a handler slot is answered WORKER_BUSY
+const DeadlineMetadata = "deadline"
+
+// Defaults for SetMaxConcurrency and SetMaxQueueWait. One slot keeps the
+// historical one-request-at-a-time behaviour.
+const (
+	DefaultMaxConcurrency = 1
+	DefaultMaxQueueWait   = 30 * time.Second
 )
 
 // CapabilityHandler is a function that handles a capability request
@@ -21,31 +64,145 @@ type CapabilityHandler func(params map[string]interface{}) (map[string]interface
 
 // Capability represents a worker capability
 type Capability struct {
-	Name          string `json:"name"`
-	Description   string `json:"description"`
-	InputSchema   string `json:"input_schema"`
-	OutputSchema  string `json:"output_schema"`
-	HTTPMethod    string `json:"http_method"`
-	AcceptsFile   bool   `json:"accepts_file"`
-	FileFieldName string `json:"file_field_name,omitempty"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description"`
+	InputSchema   string   `json:"input_schema"`
+	OutputSchema  string   `json:"output_schema"`
+	HTTPMethod    string   `json:"http_method"`
+	AcceptsFile   bool     `json:"accepts_file"`
+	FileFieldName string   `json:"file_field_name,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+
+	// Deprecated capabilities still route but are flagged in discovery and docs
+	Deprecated         bool   `json:"deprecated,omitempty"`
+	DeprecationMessage string `json:"deprecation_message,omitempty"`
+
+	// Example is a sample request (a JSON object) shown in Swagger UI
+	Example string `json:"example,omitempty"`
+
+	// DefaultTimeoutMs tells callers how long to wait for this capability
+	// when they set no timeout of their own (0 = the caller's default)
+	DefaultTimeoutMs int64 `json:"default_timeout_ms,omitempty"`
+
+	// Cacheable lets the hub answer repeated requests with identical params
+	// from its result cache for CacheTTLSeconds (0 = the hub's default).
+	// Only for capabilities whose result depends on the params alone.
+	Cacheable       bool  `json:"cacheable,omitempty"`
+	CacheTTLSeconds int64 `json:"cache_ttl_seconds,omitempty"`
+
+	// Disabled capabilities stay registered but receive no requests until
+	// EnableCapability is called (see DisableCapability)
+	Disabled bool `json:"disabled,omitempty"`
 }
 
 // WorkerSDK provides the base SDK for creating workers
 type WorkerSDK struct {
 	workerID    string
-	hubAddress  string
 	workerType  string
-	running     bool
+	running     int32 // 1 between connecting and Stop; see isRunning
 	stream      pb.HubService_ConnectClient
+	client      pb.HubServiceClient
 	sendChan    chan *pb.Message
 	
+	// Hubs tried in order; the worker fails over to the next one when its
+	// stream breaks. hubIndex is the hub currently connected.
+	hubAddresses []string
+	hubIndex     int
+	conn         *grpc.ClientConn
+	
+	// Failed sends are held (up to sendBufferSize) and retried; streamReady
+	// wakes the send loop when a new stream is installed
+	sendBufferSize int
+	streamReady    chan struct{}
+	sendStats      SendStats
+	
+	// gRPC message size limits. Outgoing messages above maxSendMsgSize are
+	// offloaded to the hub's file storage (see codec.Offload).
+	maxRecvMsgSize int
+	maxSendMsgSize int
+	
+	// Outgoing Content larger than this is gzipped (0 disables)
+	compressionThreshold int
+	
+	// gRPC compressor for the whole stream ("" = none)
+	grpcCompression string
+	
 	// Capability registry
 	capabilities map[string]*Capability
 	handlers     map[string]CapabilityHandler
+	middleware   []Middleware
+	
+	// Capabilities turned off with DisableCapability
+	disabled map[string]bool
+	
+	// Handlers added with AddProgressCapability, bound to each request
+	progressHandlers map[string]ProgressHandler
+	
+	// Extra registration metadata (see SetMetadata)
+	metadata map[string]string
+	
+	// Shutdown: Stop closes done, then closes sendChan once no send (see
+	// send) is in progress; sendMu guards sendChan against that close
+	done     chan struct{}
+	stopOnce sync.Once
+	sendMu   sync.RWMutex
 	
 	// Worker-to-worker call tracking
 	pendingCalls sync.Map
 	mu           sync.RWMutex
+	
+	// Results of recent calls by idempotency key
+	idempotency *idempotencyCache
+	
+	// Sent with registration when the hub requires authentication
+	authToken string
+	
+	// Handler concurrency: at most maxConcurrency handlers run at once;
+	// others wait up to maxQueueWait for a slot
+	maxConcurrency int
+	maxQueueWait   time.Duration
+	
+	// Share of traffic under the hub's "weighted" selection strategy
+	weight float64
+	
+	// Labels requests can select this worker by (e.g. region=eu)
+	labels map[string]string
+	
+	// Tenant namespace the worker registers in (empty = hub default)
+	namespace string
+	slots          chan struct{}
+	
+	// Self-test run by Run before registering (see EnableSelfTest);
+	// selfTestCritical nil means every capability is critical
+	selfTest         bool
+	selfTestCritical map[string]bool
+	selfTestTimeout  time.Duration
+	
+	// Health reporting
+	startedAt          time.Time
+	inFlight           int64
+	disableHealthCheck bool
+	
+	// Request counters (excluding __health), reported to the hub every
+	// statsInterval through update_metadata
+	requestsHandled int64
+	errorsReturned  int64
+	statsInterval   time.Duration
+	
+	// Draining: new requests are rejected and a "drained" ack is sent once
+	// in-flight reaches zero
+	draining   int32
+	drainFrom  string // who asked for the drain; receives the ack
+	drainReqID string
+	drainOnce  sync.Once
+	drainedAck *pb.Message
+	onDrained  func()
+	
+	// Log forwarding (see LogHook): off until enabled; lines wait in
+	// logLines for logLoop, logsDropped counts lines lost to the limits
+	logForwarding int32
+	logLines      chan logLine
+	logsDropped   int64
 }
 
 // PendingCall tracks a pending worker-to-worker call
@@ -54,18 +211,280 @@ type PendingCall struct {
 	timer        *time.Timer
 }
 
-// NewWorkerSDK creates a new worker SDK instance
+// NewWorkerSDK creates a new worker SDK instance. hubAddress may list
+// several hubs separated by commas (see SetHubAddresses).
 func NewWorkerSDK(workerID, hubAddress, workerType string) *WorkerSDK {
 	return &WorkerSDK{
 		workerID:     workerID,
-		hubAddress:   hubAddress,
+		hubAddresses: utils.SplitAddresses(hubAddress),
 		workerType:   workerType,
 		sendChan:     make(chan *pb.Message, 100),
+		done:         make(chan struct{}),
+		logLines:     make(chan logLine, logForwardBuffer),
+		streamReady:  make(chan struct{}, 1),
 		capabilities: make(map[string]*Capability),
 		handlers:     make(map[string]CapabilityHandler),
+		disabled:     make(map[string]bool),
+		
+		progressHandlers:     make(map[string]ProgressHandler),
+		compressionThreshold: codec.DefaultCompressionThreshold,
+		maxRecvMsgSize:       codec.DefaultMaxMessageSize,
+		maxSendMsgSize:       codec.DefaultMaxMessageSize,
+		maxConcurrency:       DefaultMaxConcurrency,
+		maxQueueWait:         DefaultMaxQueueWait,
+		startedAt:            time.Now(),
+		idempotency:          newIdempotencyCache(DefaultIdempotencyTTL),
+		sendBufferSize:       DefaultSendBufferSize,
+		statsInterval:        DefaultStatsInterval,
+	}
+}
+
+// SetAuthToken sets the credential sent with registration, for hubs
+// running with AUTH_REQUIRED. Must be called before Run.
+func (w *WorkerSDK) SetAuthToken(token string) {
+	w.authToken = token
+}
+
+// SetMaxMessageSize sets the gRPC receive and send limits in bytes; they
+// should match the hub's MAX_RECV_MSG_SIZE/MAX_SEND_MSG_SIZE. Messages
+// that would exceed the send limit are uploaded in chunks with UploadFile
+// and sent as a content_file_id reference, which receivers download
+// transparently. Must be called before Run.
+func (w *WorkerSDK) SetMaxMessageSize(recv, send int) {
+	w.maxRecvMsgSize = recv
+	w.maxSendMsgSize = send
+}
+
+// SetMaxConcurrency caps how many handlers run at once (<= 0 removes the
+// cap). The limit is also advertised to the hub, which stops routing to
+// the worker while it is saturated. Must be called before Run.
+func (w *WorkerSDK) SetMaxConcurrency(n int) {
+	w.maxConcurrency = n
+}
+
+// SetLabels attaches labels (e.g. region=eu, gpu=true) to the
+// registration. Requests carrying Metadata["label_selector"] such as
+// "region=eu" are only routed to workers with matching labels. Must be
+// called before Run.
+func (w *WorkerSDK) SetLabels(labels map[string]string) {
+	w.labels = labels
+}
+
+// SetNamespace registers the worker in a tenant namespace. Only clients in
+// the same namespace (or granted access via the hub's NAMESPACE_GRANTS) can
+// discover and call it. When the hub authenticates clients the namespace
+// comes from the worker's credential and must match. Must be called before
+// Run.
+func (w *WorkerSDK) SetNamespace(namespace string) {
+	w.namespace = namespace
+}
+
+// SetMetadata adds descriptive entries (e.g. engine, language) to the
+// metadata sent with the registration and shown in discovery. Must be
+// called before Run.
+func (w *WorkerSDK) SetMetadata(metadata map[string]string) {
+	w.metadata = metadata
+}
+
+// SetWeight advertises this worker's relative capacity to the hub (default
+// 1). With SELECTION_STRATEGY=weighted a worker with weight 10 gets about
+// ten times the requests of one with weight 1. Must be called before Run.
+func (w *WorkerSDK) SetWeight(weight float64) {
+	w.weight = weight
+}
+
+// SetMaxQueueWait sets how long a request waits for a handler slot before
+// it is answered WORKER_BUSY, unless it carries its own deadline
+func (w *WorkerSDK) SetMaxQueueWait(wait time.Duration) {
+	w.maxQueueWait = wait
+}
+
+// SetIdempotencyTTL sets how long results of calls carrying an idempotency
+// key are kept for retries. A value <= 0 disables the cache.
+func (w *WorkerSDK) SetIdempotencyTTL(ttl time.Duration) {
+	w.idempotency.setTTL(ttl)
+}
+
+// DisableHealthCheck opts out of the built-in __health capability.
+// Must be called before Run.
+func (w *WorkerSDK) DisableHealthCheck() {
+	w.disableHealthCheck = true
+}
+
+// OnDrained sets a callback run after the "drained" ack has been sent to
+// the hub, e.g. to Stop the worker. Must be called before Run.
+func (w *WorkerSDK) OnDrained(fn func()) {
+	w.onDrained = fn
+}
+
+// Drain stops accepting new requests. Once in-flight requests finish the
+// worker tells the hub, which deregisters it but keeps the connection.
+func (w *WorkerSDK) Drain() {
+	w.send(&pb.Message{
+		Id:        utils.PrefixedID("drain"),
+		From:      w.workerID,
+		Type:      pb.MessageType_CONTROL,
+		Action:    ControlActionDrain,
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	w.beginDrain(w.workerID, "")
+}
+
+// UpdateMetadata patches the metadata the hub holds for this worker, e.g.
+// to report load or queue depth, without re-sending the registration. Keys
+// set to nil are removed; "max_concurrency" and "weight" take effect on the
+// hub immediately. The hub's acknowledgement is not awaited.
+func (w *WorkerSDK) UpdateMetadata(patch map[string]interface{}) error {
+	if !w.isRunning() {
+		return fmt.Errorf("worker not connected")
+	}
+	content, err := json.Marshal(map[string]interface{}{"metadata": patch})
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	w.send(&pb.Message{
+		Id:        utils.PrefixedID("meta"),
+		From:      w.workerID,
+		To:        "hub",
+		Type:      pb.MessageType_CONTROL,
+		Action:    ControlActionUpdateMetadata,
+		Content:   string(content),
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	return nil
+}
+
+// IsDraining reports whether the worker has been asked to drain
+func (w *WorkerSDK) IsDraining() bool {
+	return atomic.LoadInt32(&w.draining) == 1
+}
+
+// handleControl handles control messages forwarded by the hub
+func (w *WorkerSDK) handleControl(msg *pb.Message) {
+	switch msg.Action {
+	case ControlActionDrain:
+		w.beginDrain(msg.From, msg.RequestId)
+	case ControlActionWorkerLogs:
+		w.handleWorkerLogs(msg)
+	default:
+		log.Printf("[%s] ⚠️  Ignoring control action: %s", w.workerID, msg.Action)
+	}
+}
+
+// beginDrain marks the worker draining; later drain requests are no-ops
+func (w *WorkerSDK) beginDrain(from, requestID string) {
+	w.mu.Lock()
+	if atomic.LoadInt32(&w.draining) == 1 {
+		w.mu.Unlock()
+		return
+	}
+	w.drainFrom, w.drainReqID = from, requestID
+	atomic.StoreInt32(&w.draining, 1)
+	w.mu.Unlock()
+	
+	log.Printf("[%s] 🚰 Draining (%d in flight)", w.workerID, atomic.LoadInt64(&w.inFlight))
+	if atomic.LoadInt64(&w.inFlight) == 0 {
+		w.sendDrained()
 	}
 }
 
+// sendDrained queues the "drained" ack (at most once)
+func (w *WorkerSDK) sendDrained() {
+	w.drainOnce.Do(func() {
+		w.mu.Lock()
+		ack := &pb.Message{
+			Id:        utils.PrefixedID("drained"),
+			RequestId: w.drainReqID,
+			From:      w.workerID,
+			To:        w.drainFrom,
+			Type:      pb.MessageType_CONTROL,
+			Action:    ControlActionDrained,
+			Timestamp: time.Now().Format(time.RFC3339),
+		}
+		w.drainedAck = ack
+		w.mu.Unlock()
+		
+		log.Printf("[%s] ✓ Drained", w.workerID)
+		w.send(ack)
+	})
+}
+
+// release marks a request finished and completes a pending drain
+func (w *WorkerSDK) release() {
+	if atomic.AddInt64(&w.inFlight, -1) == 0 && w.IsDraining() {
+		w.sendDrained()
+	}
+}
+
+// addHealthCapability registers the built-in __health capability
+func (w *WorkerSDK) addHealthCapability() {
+	w.AddCapability(&Capability{
+		Name:         HealthCapability,
+		Description:  "Built-in worker health check",
+		InputSchema:  "{}",
+		OutputSchema: `{"type":"object","properties":{"status":{"type":"string"},"uptime_seconds":{"type":"number"},"capabilities":{"type":"integer"},"in_flight":{"type":"integer"},"requests":{"type":"object"},"sends":{"type":"object"},"runtime":{"type":"object"}}}`,
+		HTTPMethod:   "GET",
+		AcceptsFile:  false,
+	}, w.handleHealth)
+}
+
+// handleHealth reports uptime, load and Go runtime stats
+func (w *WorkerSDK) handleHealth(params map[string]interface{}) (map[string]interface{}, error) {
+	w.mu.RLock()
+	capCount := len(w.capabilities) - 1 // exclude __health itself
+	w.mu.RUnlock()
+	
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	
+	sends := w.SendStats()
+	status := "healthy"
+	if w.IsDraining() {
+		status = "draining"
+	} else if sends.Held > 0 {
+		status = "degraded"
+	}
+	
+	return map[string]interface{}{
+		"status":         status,
+		"worker_id":      w.workerID,
+		"worker_type":    w.workerType,
+		"uptime_seconds": time.Since(w.startedAt).Seconds(),
+		"capabilities":   capCount,
+		"in_flight":      atomic.LoadInt64(&w.inFlight) - 1, // exclude this call
+		"requests":       w.statsMetadata(),
+		"sends":          sends,
+		"runtime": map[string]interface{}{
+			"go_version":     runtime.Version(),
+			"goroutines":     runtime.NumGoroutine(),
+			"heap_alloc":     mem.HeapAlloc,
+			"heap_sys":       mem.HeapSys,
+			"num_gc":         mem.NumGC,
+			"gc_pause_total": mem.PauseTotalNs,
+		},
+		"timestamp": time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// SetCompressionThreshold sets the Content size above which outgoing
+// messages are gzipped. A value <= 0 disables compression.
+func (w *WorkerSDK) SetCompressionThreshold(threshold int) {
+	w.compressionThreshold = threshold
+}
+
+// SetGRPCCompression compresses the stream to the hub with a gRPC
+// compressor: codec.GRPCCompressionGzip, or "" / codec.GRPCCompressionNone
+// for none (the default). The hub then compresses what it sends back. It
+// pays off for large, repetitive payloads; workers exchanging small
+// messages are faster without it. Must be called before Run.
+func (w *WorkerSDK) SetGRPCCompression(name string) error {
+	if _, err := codec.GRPCCompressor(name); err != nil {
+		return err
+	}
+	w.grpcCompression = name
+	return nil
+}
+
 // AddCapability registers a new capability handler
 func (w *WorkerSDK) AddCapability(cap *Capability, handler CapabilityHandler) {
 	w.mu.Lock()
@@ -73,142 +492,261 @@ func (w *WorkerSDK) AddCapability(cap *Capability, handler CapabilityHandler) {
 	
 	w.capabilities[cap.Name] = cap
 	w.handlers[cap.Name] = handler
+	w.disabled[cap.Name] = cap.Disabled
 	
 	log.Printf("[%s] ✓ Registered capability: %s", w.workerID, cap.Name)
 }
 
 // CallWorker calls another worker's capability through the Hub
 func (w *WorkerSDK) CallWorker(targetWorker, capability string, params map[string]interface{}, timeout time.Duration) (map[string]interface{}, error) {
-	if !w.running {
+	return w.callWorker(targetWorker, capability, params, timeout, nil)
+}
+
+// callWorker sends a WORKER_CALL with extra metadata and waits for the response
+func (w *WorkerSDK) callWorker(targetWorker, capability string, params map[string]interface{}, timeout time.Duration, metadata map[string]string) (map[string]interface{}, error) {
+	if !w.isRunning() {
 		return nil, fmt.Errorf("worker not connected")
 	}
 	
-	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	requestID := utils.PrefixedID("call")
 	
 	log.Printf("[%s] 🔗 Calling %s.%s", w.workerID, targetWorker, capability)
 	
-	// Serialize params
-	content, err := json.Marshal(params)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal params: %w", err)
-	}
-	
 	// Create worker call message
 	callMsg := &pb.Message{
 		Id:        requestID,
 		From:      w.workerID,
 		To:        targetWorker,
-		Channel:   capability,
-		Content:   string(content),
 		Timestamp: time.Now().Format(time.RFC3339),
 		Type:      pb.MessageType_WORKER_CALL,
-		Metadata:  map[string]string{"capability": capability},
+		Metadata:  make(map[string]string),
+	}
+	for k, v := range metadata {
+		callMsg.Metadata[k] = v
+	}
+	if err := envelope.EncodeRequest(callMsg, &envelope.Request{Capability: capability, Payload: params}); err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
 	}
 	
-	// Create response channel
-	responseChan := make(chan *pb.Message, 1)
-	timer := time.NewTimer(timeout)
+	response := w.roundTrip(callMsg, timeout)
+	if response == nil && !w.isRunning() {
+		return nil, fmt.Errorf("worker stopped before %s answered", targetWorker)
+	}
+	if response == nil {
+		return nil, apierr.Newf(apierr.CodeTimeout, "no response from %s after %v", targetWorker, timeout).
+			WithDetail("worker_id", targetWorker)
+	}
 	
-	// Register pending call
-	w.pendingCalls.Store(requestID, &PendingCall{
-		responseChan: responseChan,
-		timer:        timer,
-	})
+	// Failures come back as an apierr.ErrorResponse
+	var result map[string]interface{}
+	if err := envelope.DecodeResponse(response, &result); err != nil {
+		var apiErr *apierr.ErrorResponse
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return result, nil
+}
+
+// Ping measures the round-trip time to the hub without involving another
+// worker, e.g. to notice a degraded link before a real call fails
+func (w *WorkerSDK) Ping() (time.Duration, error) {
+	if !w.isRunning() {
+		return 0, fmt.Errorf("worker not connected")
+	}
+	
+	start := time.Now()
+	pong := w.roundTrip(&pb.Message{
+		Id:        utils.PrefixedID("ping"),
+		From:      w.workerID,
+		To:        "hub",
+		Type:      pb.MessageType_CONTROL,
+		Action:    ControlActionPing,
+		Timestamp: start.Format(time.RFC3339Nano),
+	}, DefaultPingTimeout)
+	if pong == nil {
+		return 0, apierr.Newf(apierr.CodeTimeout, "no pong from hub after %v", DefaultPingTimeout)
+	}
+	return time.Since(start), nil
+}
+
+// roundTrip sends msg and waits up to timeout, queueing included, for the
+// reply handleWorkerCallResponse matches to msg.Id; nil means none came in
+// time or the worker stopped. The pending call is removed and its timer
+// stopped on every path.
+func (w *WorkerSDK) roundTrip(msg *pb.Message, timeout time.Duration) *pb.Message {
+	pending := &PendingCall{
+		responseChan: make(chan *pb.Message, 1),
+		timer:        time.NewTimer(timeout),
+	}
+	defer pending.timer.Stop()
+	w.pendingCalls.Store(msg.Id, pending)
 	
-	// Send the call
-	w.sendChan <- callMsg
+	if !w.sendBefore(msg, pending.timer.C) {
+		return w.abandonCall(msg.Id, pending)
+	}
 	
-	// Wait for response or timeout
 	select {
-	case response := <-responseChan:
-		// Parse response
-		var result map[string]interface{}
-		if err := json.Unmarshal([]byte(response.Content), &result); err != nil {
-			return nil, fmt.Errorf("failed to parse response: %w", err)
-		}
-		return result, nil
-		
-	case <-timer.C:
-		// Timeout - cleanup
-		w.pendingCalls.Delete(requestID)
-		return nil, fmt.Errorf("no response from %s after %v", targetWorker, timeout)
+	case response := <-pending.responseChan:
+		return response
+	case <-pending.timer.C:
+		return w.abandonCall(msg.Id, pending)
+	case <-w.done:
+		return w.abandonCall(msg.Id, pending)
 	}
 }
 
-// handleWorkerCallResponse handles response from worker-to-worker call
-func (w *WorkerSDK) handleWorkerCallResponse(msg *pb.Message) {
-	requestID, ok := msg.Metadata["request_id"]
-	if !ok {
-		return
+// abandonCall removes a timed-out call. If handleWorkerCallResponse claimed
+// it first, the response is already on its way and is returned instead.
+func (w *WorkerSDK) abandonCall(id string, pending *PendingCall) *pb.Message {
+	if _, ok := w.pendingCalls.LoadAndDelete(id); ok {
+		return nil
 	}
-	
-	if val, ok := w.pendingCalls.Load(requestID); ok {
-		pending := val.(*PendingCall)
-		pending.timer.Stop()
-		pending.responseChan <- msg
-		w.pendingCalls.Delete(requestID)
+	return <-pending.responseChan
+}
+
+// handleWorkerCallResponse hands a response to the waiting worker call or
+// ping. Responses to calls that already timed out, and acks of control
+// messages nobody waits for, are dropped.
+func (w *WorkerSDK) handleWorkerCallResponse(msg *pb.Message) {
+	// Hub-generated errors reference the call via original_message_id
+	for _, requestID := range envelope.ReplyKeys(msg) {
+		if val, ok := w.pendingCalls.LoadAndDelete(requestID); ok {
+			// Buffered and claimed once, so this never blocks
+			val.(*PendingCall).responseChan <- msg
+			return
+		}
 	}
 }
 
-// processMessage processes an incoming message
-func (w *WorkerSDK) processMessage(msg *pb.Message) (string, error) {
+// processMessage processes an incoming message. The request and result are
+// decoded/encoded with the codec named in msg.Metadata["encoding"].
+func (w *WorkerSDK) processMessage(msg *pb.Message) (content string, err error) {
+	// Handler panics are caught by Recover; this covers decoding and
+	// marshalling so the worker and idempotency waiters survive
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[%s] 💥 Panic processing %s: %v\n%s", w.workerID, msg.Id, r, debug.Stack())
+			content, err = "", apierr.Newf(apierr.CodeInternal, "panic processing request: %v", r)
+		}
+	}()
+	
+	c, err := codec.FromMetadata(msg.Metadata)
+	if err != nil {
+		return "", apierr.New(apierr.CodeValidation, err.Error())
+	}
+	
+	// Parse input
+	req, err := envelope.DecodeRequest(msg)
+	if err != nil {
+		return "", apierr.Newf(apierr.CodeValidation, "failed to parse params: %v", err)
+	}
+	
 	w.mu.RLock()
-	handler, ok := w.handlers[msg.Channel]
+	handler, ok := w.handlers[req.Capability]
+	if progressHandler, found := w.progressHandlers[req.Capability]; found {
+		handler = w.withProgress(progressHandler, msg)
+	}
+	disabled := w.disabled[req.Capability]
 	w.mu.RUnlock()
 	
 	if !ok {
-		return "", fmt.Errorf("unknown capability: %s", msg.Channel)
+		return "", apierr.Newf(apierr.CodeUnknownCapability, "unknown capability: %s", req.Capability).
+			WithDetail("capability", req.Capability)
 	}
-	
-	// Parse input
-	var params map[string]interface{}
-	if msg.Content != "" {
-		if err := json.Unmarshal([]byte(msg.Content), &params); err != nil {
-			return "", fmt.Errorf("failed to parse params: %w", err)
-		}
+	if disabled {
+		// Sent to this worker directly, or routed before the hub applied the change
+		return "", apierr.Newf(apierr.CodeNoWorker, "capability %s is disabled", req.Capability).
+			WithDetail("capability", req.Capability).
+			WithDetail("worker_id", w.workerID)
 	}
 	
-	// Call handler
-	result, err := handler(params)
+	// Call handler. Handlers may return an *apierr.ErrorResponse to pick
+	// the code; any other error is reported as EXECUTION_FAILED.
+	result, err := w.wrap(req.Capability, handler)(req.Payload)
 	if err != nil {
-		return "", err
+		var apiErr *apierr.ErrorResponse
+		if errors.As(err, &apiErr) {
+			return "", apiErr
+		}
+		return "", apierr.New(apierr.CodeExecution, err.Error())
 	}
 	
 	// Serialize result
-	content, err := json.Marshal(result)
+	content, err = c.Marshal(result)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal result: %w", err)
+		return "", apierr.Newf(apierr.CodeInternal, "failed to marshal result: %v", err)
 	}
 	
-	return string(content), nil
+	return content, nil
 }
 
-// sendRegistration sends registration message to Hub
-func (w *WorkerSDK) sendRegistration() error {
+// execute runs processMessage, deduplicating calls that carry an
+// idempotency key. Keys are scoped to the caller.
+func (w *WorkerSDK) execute(msg *pb.Message) (string, error) {
+	if w.IsDraining() && msg.Channel != HealthCapability {
+		return "", apierr.New(apierr.CodeNoWorker, "worker is draining").
+			WithDetail("worker_id", w.workerID)
+	}
+	
+	key := msg.Metadata[IdempotencyKeyMetadata]
+	if key == "" {
+		return w.processMessage(msg)
+	}
+	return w.idempotency.do(msg.From+"|"+msg.Channel+"|"+key, func() (string, error) {
+		return w.processMessage(msg)
+	})
+}
+
+// registrationMessage builds the REGISTER message sent on every new stream
+func (w *WorkerSDK) registrationMessage() (*pb.Message, error) {
 	w.mu.RLock()
-	capabilities := make([]*Capability, 0, len(w.capabilities))
-	for _, cap := range w.capabilities {
-		capabilities = append(capabilities, cap)
+	capabilities := make([]Capability, 0, len(w.capabilities))
+	for name, cap := range w.capabilities {
+		registered := *cap
+		registered.Disabled = w.disabled[name]
+		capabilities = append(capabilities, registered)
 	}
 	w.mu.RUnlock()
 	
+	metadata := map[string]string{
+		"version":     "1.0.0",
+		"sdk_version": "2.0.0",
+	}
+	for key, value := range w.metadata {
+		metadata[key] = value
+	}
+	if w.maxConcurrency > 0 {
+		metadata["max_concurrency"] = strconv.Itoa(w.maxConcurrency)
+	}
+	if w.weight > 0 {
+		metadata["weight"] = strconv.FormatFloat(w.weight, 'f', -1, 64)
+	}
+	for key, value := range w.statsMetadata() {
+		metadata[key] = fmt.Sprint(value)
+	}
+	
 	regData := map[string]interface{}{
 		"worker_id":   w.workerID,
 		"worker_type": w.workerType,
 		"capabilities": capabilities,
-		"metadata": map[string]string{
-			"version":     "1.0.0",
-			"sdk_version": "2.0.0",
-		},
+		"metadata":    metadata,
+	}
+	if len(w.labels) > 0 {
+		regData["labels"] = w.labels
+	}
+	if w.namespace != "" {
+		regData["namespace"] = w.namespace
 	}
 	
 	content, err := json.Marshal(regData)
 	if err != nil {
-		return fmt.Errorf("failed to marshal registration: %w", err)
+		return nil, fmt.Errorf("failed to marshal registration: %w", err)
 	}
 	
 	regMsg := &pb.Message{
-		Id:        fmt.Sprintf("register-%d", time.Now().UnixNano()),
+		Id:        utils.PrefixedID("register"),
 		From:      w.workerID,
 		To:        "hub",
 		Channel:   "system",
@@ -217,130 +755,301 @@ func (w *WorkerSDK) sendRegistration() error {
 		Type:      pb.MessageType_REGISTER,
 		Metadata:  make(map[string]string),
 	}
-	
-	w.sendChan <- regMsg
-	log.Printf("[%s] 📤 Sent registration", w.workerID)
-	
-	return nil
+	if w.authToken != "" {
+		regMsg.Metadata["auth_token"] = w.authToken
+	}
+	if w.namespace != "" {
+		regMsg.Metadata["namespace"] = w.namespace
+	}
+	return regMsg, nil
 }
 
 // receiveLoop handles incoming messages from Hub
 func (w *WorkerSDK) receiveLoop() {
-	for {
-		if !w.running {
-			break
-		}
-		
-		msg, err := w.stream.Recv()
+	for w.isRunning() {
+		msg, err := w.currentStream().Recv()
 		if err != nil {
+			if !w.isRunning() {
+				break
+			}
 			log.Printf("[%s] ✗ Receive error: %v", w.workerID, err)
-			w.running = false
-			break
+			if !w.reconnect() {
+				break // stopped while reconnecting
+			}
+			continue
+		}
+		
+		if err := codec.Resolve(context.Background(), w.currentClient(), msg); err != nil {
+			log.Printf("[%s] ✗ Dropping message %s: %v", w.workerID, msg.Id, err)
+			continue
+		}
+		if err := codec.Decompress(msg); err != nil {
+			log.Printf("[%s] ✗ Dropping message %s: %v", w.workerID, msg.Id, err)
+			continue
 		}
 		
 		// Handle different message types
 		switch msg.Type {
-		case pb.MessageType_RESPONSE:
+		case pb.MessageType_RESPONSE, pb.MessageType_ERROR:
 			// Response from worker-to-worker call
 			w.handleWorkerCallResponse(msg)
 			
-		case pb.MessageType_WORKER_CALL, pb.MessageType_REQUEST:
-			// Process and send response
-			content, err := w.processMessage(msg)
-			if err != nil {
-				content = fmt.Sprintf(`{"error":"%s","status":"failed"}`, err.Error())
-			}
-			
-			responseMsg := &pb.Message{
-				Id:        fmt.Sprintf("resp-%d", time.Now().UnixNano()),
-				From:      w.workerID,
-				To:        msg.From,
-				Channel:   msg.Channel,
-				Content:   content,
-				Timestamp: time.Now().Format(time.RFC3339),
-				Type:      pb.MessageType_RESPONSE,
-				Metadata:  make(map[string]string),
-			}
+		case pb.MessageType_CONTROL:
+			w.handleControl(msg)
 			
-			// Add request_id for worker-to-worker calls
-			if msg.Type == pb.MessageType_WORKER_CALL {
-				responseMsg.Metadata["request_id"] = msg.Id
-				responseMsg.Metadata["status"] = "success"
-			}
+		case pb.MessageType_WORKER_CALL, pb.MessageType_REQUEST:
+			// Counted until the response is queued, so the "drained" ack
+			// never overtakes it
+			atomic.AddInt64(&w.inFlight, 1)
 			
-			w.sendChan <- responseMsg
+			// Handlers run off the receive loop so responses to worker
+			// calls made by a handler can still be received
+			go w.handleRequest(msg)
 		}
 	}
 	
 	log.Printf("[%s] Receive loop exited", w.workerID)
 }
 
-// sendLoop handles sending messages to Hub
-func (w *WorkerSDK) sendLoop() {
-	for msg := range w.sendChan {
-		if !w.running {
-			break
+// handleRequest runs a request once a concurrency slot is free and sends
+// the response
+func (w *WorkerSDK) handleRequest(msg *pb.Message) {
+	defer w.release()
+	
+	encoding := codec.JSON
+	var apiErr *apierr.ErrorResponse
+	var content string
+	var err error
+	if w.acquireSlot(msg) {
+		content, err = w.execute(msg)
+		w.releaseSlot(msg)
+	} else {
+		err = apierr.Newf(apierr.CodeWorkerBusy, "worker %s is at its concurrency limit", w.workerID).
+			WithDetail("max_concurrency", w.maxConcurrency)
+	}
+	if err != nil {
+		if !errors.As(err, &apiErr) {
+			apiErr = apierr.New(apierr.CodeInternal, err.Error())
 		}
-		
-		if err := w.stream.Send(msg); err != nil {
-			log.Printf("[%s] ✗ Send error: %v", w.workerID, err)
+	} else if enc := msg.Metadata[codec.MetadataKey]; enc != "" {
+		encoding = enc
+	}
+	
+	responseMsg := &pb.Message{
+		Id:        utils.PrefixedID("resp"),
+		RequestId: msg.RequestId,
+		From:      w.workerID,
+		To:        msg.From,
+		Channel:   msg.Channel,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Type:      pb.MessageType_RESPONSE,
+		Metadata:  map[string]string{codec.MetadataKey: encoding},
+	}
+	codec.SetContent(responseMsg, content)
+	if apiErr != nil {
+		envelope.SetError(responseMsg, apiErr)
+	}
+	if msg.Channel != HealthCapability {
+		atomic.AddInt64(&w.requestsHandled, 1)
+		if apiErr != nil {
+			atomic.AddInt64(&w.errorsReturned, 1)
+		}
+	}
+	
+	// request_id lets the caller match the response to its request
+	responseMsg.Metadata[envelope.RequestIDKey] = msg.Id
+	if id := msg.Metadata[envelope.CorrelationIDKey]; id != "" {
+		responseMsg.Metadata[envelope.CorrelationIDKey] = id
+	}
+	if msg.Type == pb.MessageType_WORKER_CALL {
+		responseMsg.Metadata["status"] = "success"
+		if apiErr != nil {
+			responseMsg.Metadata["status"] = "error"
 		}
 	}
 	
-	log.Printf("[%s] Send loop exited", w.workerID)
+	w.send(responseMsg)
+}
+
+// acquireSlot waits for a free handler slot until the request's deadline
+// (Metadata["deadline"], RFC3339) or the max queue wait. The health check
+// never waits.
+func (w *WorkerSDK) acquireSlot(msg *pb.Message) bool {
+	if w.slots == nil || msg.Channel == HealthCapability {
+		return true
+	}
+	
+	select {
+	case w.slots <- struct{}{}:
+		return true
+	default:
+	}
+	
+	wait := w.maxQueueWait
+	if deadline, err := time.Parse(time.RFC3339, msg.Metadata[DeadlineMetadata]); err == nil {
+		wait = time.Until(deadline)
+	}
+	if wait <= 0 {
+		return false
+	}
+	
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case w.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (w *WorkerSDK) releaseSlot(msg *pb.Message) {
+	if w.slots == nil || msg.Channel == HealthCapability {
+		return
+	}
+	<-w.slots
 }
 
-// Run starts the worker and connects to Hub
+// Run starts the worker, connects to the hub and serves requests until
+// Stop is called. A stopped worker cannot be run again.
 func (w *WorkerSDK) Run() error {
+	if w.stopped() {
+		return nil
+	}
+	
 	log.Printf("[%s] 🚀 Starting Worker", w.workerID)
 	log.Printf("[%s]    ID: %s", w.workerID, w.workerID)
-	log.Printf("[%s]    Hub: %s", w.workerID, w.hubAddress)
+	log.Printf("[%s]    Hub: %s", w.workerID, strings.Join(w.hubAddresses, ", "))
 	log.Printf("[%s] %s", w.workerID, "==================================================")
 	
-	log.Printf("[%s] ✓ Registered %d capabilities", w.workerID, len(w.capabilities))
+	if !w.disableHealthCheck {
+		w.addHealthCapability()
+	}
+	if w.maxConcurrency > 0 {
+		w.slots = make(chan struct{}, w.maxConcurrency)
+	}
 	
-	// Connect to Hub
-	log.Printf("[%s] Connecting to Hub...", w.workerID)
+	log.Printf("[%s] ✓ Registered %d capabilities", w.workerID, len(w.capabilities))
 	
-	conn, err := grpc.Dial(w.hubAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+	if w.selfTest {
+		if err := w.checkSelfTest(); err != nil {
+			return err
+		}
 	}
-	defer conn.Close()
 	
-	client := pb.NewHubServiceClient(conn)
-	stream, err := client.Connect(context.Background())
-	if err != nil {
-		return fmt.Errorf("failed to create stream: %w", err)
+	// Connect to the first hub that accepts; registration is sent on the
+	// new stream
+	log.Printf("[%s] Connecting to Hub...", w.workerID)
+	if err := w.connect(0); err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
 	}
+	defer w.closeConn()
 	
-	w.stream = stream
-	w.running = true
-	
-	log.Printf("[%s] ✓ Connected to Hub", w.workerID)
-	
-	// Send registration
-	if err := w.sendRegistration(); err != nil {
-		return fmt.Errorf("failed to send registration: %w", err)
+	// Marked running before checking for Stop, so a Stop racing the
+	// connect is never overwritten
+	atomic.StoreInt32(&w.running, 1)
+	if w.stopped() {
+		atomic.StoreInt32(&w.running, 0)
+		return nil
 	}
 	
 	log.Printf("[%s] 📨 Listening for requests...\n", w.workerID)
 	
 	// Start send and receive loops
-	go w.sendLoop()
+	sendLoopDone := make(chan struct{})
+	go func() {
+		w.sendLoop()
+		close(sendLoopDone)
+	}()
 	go w.receiveLoop()
+	go w.statsLoop()
+	go w.logLoop()
 	
-	// Keep running
-	for w.running {
-		time.Sleep(1 * time.Second)
-	}
+	<-w.done
 	
+	// Stop closed sendChan once its last sender left; let the send loop
+	// deliver what was queued before closing the connection under it
+	select {
+	case <-sendLoopDone:
+	case <-time.After(stopDrainTimeout):
+		log.Printf("[%s] ⚠️  Send loop still busy after %v, closing connection", w.workerID, stopDrainTimeout)
+	}
+	log.Printf("[%s] ✗ Disconnected from Hub", w.workerID)
 	return nil
 }
 
-// Stop stops the worker
+// callOptions applies message size limits (<= 0 keeps gRPC's default) and
+// the gRPC compressor, if any
+func callOptions(maxRecv, maxSend int, compression string) []grpc.CallOption {
+	var opts []grpc.CallOption
+	if compressor, _ := codec.GRPCCompressor(compression); compressor != nil {
+		opts = append(opts, compressor)
+	}
+	if maxRecv > 0 {
+		opts = append(opts, grpc.MaxCallRecvMsgSize(maxRecv))
+	}
+	if maxSend > 0 {
+		opts = append(opts, grpc.MaxCallSendMsgSize(maxSend))
+	}
+	return opts
+}
+
+// stopDrainTimeout bounds how long Run waits, after Stop, for queued
+// messages to be sent before it closes the connection
+const stopDrainTimeout = 5 * time.Second
+
+// Stop shuts the worker down and makes Run return: new sends are refused,
+// messages already queued are still sent, then the connection is closed.
+// It does not wait for Run and is safe to call more than once, from any
+// goroutine, including before Run.
 func (w *WorkerSDK) Stop() {
-	w.running = false
-	close(w.sendChan)
-	log.Printf("[%s] ✗ Disconnected from Hub", w.workerID)
+	w.stopOnce.Do(func() {
+		log.Printf("[%s] 🛑 Stopping", w.workerID)
+		atomic.StoreInt32(&w.running, 0)
+		close(w.done)
+		
+		// Senders blocked on a full sendChan return on done; once they
+		// have left, nothing can send on sendChan any more
+		w.sendMu.Lock()
+		close(w.sendChan)
+		w.sendMu.Unlock()
+	})
+}
+
+// isRunning reports whether the worker is connected and not stopped
+func (w *WorkerSDK) isRunning() bool {
+	return atomic.LoadInt32(&w.running) == 1
+}
+
+// stopped reports whether Stop was called
+func (w *WorkerSDK) stopped() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// send queues msg for the send loop, blocking while sendChan is full. It
+// returns false, dropping msg, once the worker is stopped.
+func (w *WorkerSDK) send(msg *pb.Message) bool {
+	return w.sendBefore(msg, nil)
+}
+
+// sendBefore is send giving up when expired fires (nil never does)
+func (w *WorkerSDK) sendBefore(msg *pb.Message, expired <-chan time.Time) bool {
+	w.sendMu.RLock()
+	defer w.sendMu.RUnlock()
+	if w.stopped() {
+		return false
+	}
+	select {
+	case w.sendChan <- msg:
+		return true
+	case <-w.done:
+		return false
+	case <-expired:
+		return false
+	}
 }
@@ -0,0 +1,280 @@
+package workersdk
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"deepapp_golang_grpc_hub/internal/envelope"
+	pb "deepapp_golang_grpc_hub/internal/proto"
+	"deepapp_golang_grpc_hub/pkg/apierr"
+)
+
+// panicsOnMarshal makes encoding a handler's result panic
+type panicsOnMarshal struct{}
+
+func (panicsOnMarshal) MarshalJSON() ([]byte, error) {
+	panic("cannot encode")
+}
+
+// A panic in a handler, or while encoding its result, fails only that
+// request: the worker answers INTERNAL and keeps serving
+func TestPanickingHandlerAnsweredWithError(t *testing.T) {
+	hub := startTestHub(t)
+	startWorker(t, hub, func(w *WorkerSDK) {
+		w.AddCapability(&Capability{Name: "boom"}, func(map[string]interface{}) (map[string]interface{}, error) {
+			var params map[string]interface{}
+			params["oops"] = true // nil map
+			return params, nil
+		})
+		w.AddCapability(&Capability{Name: "unencodable"}, func(map[string]interface{}) (map[string]interface{}, error) {
+			return map[string]interface{}{"value": panicsOnMarshal{}}, nil
+		})
+		w.AddCapability(&Capability{Name: "echo"}, func(params map[string]interface{}) (map[string]interface{}, error) {
+			return params, nil
+		})
+	})
+
+	for i := 0; i < 3; i++ {
+		for _, capability := range []string{"boom", "unencodable"} {
+			resp := hub.request(t, capability, `{}`)
+			if code := errorCode(resp); code != apierr.CodeInternal {
+				t.Fatalf("%s: got %q, want %s", capability, code, apierr.CodeInternal)
+			}
+			if resp.To != "client" || resp.RequestId == "" {
+				t.Fatalf("%s: error response to %q for request %q", capability, resp.To, resp.RequestId)
+			}
+		}
+		if resp := hub.request(t, "echo", `{"n":1}`); errorCode(resp) != "" || resp.Content != `{"n":1}` {
+			t.Fatalf("echo after a panic: %q %q", errorCode(resp), resp.Content)
+		}
+	}
+}
+
+// answerCall sends the response to call, as the hub forwards it from the
+// target worker
+func (h *testHub) answerCall(t *testing.T, call *pb.Message, content string) {
+	t.Helper()
+	h.send(t, &pb.Message{
+		Id:        "resp-" + call.Id,
+		From:      call.To,
+		To:        call.From,
+		Type:      pb.MessageType_RESPONSE,
+		RequestId: call.Id,
+		Content:   content,
+		Metadata:  map[string]string{envelope.RequestIDKey: call.Id},
+	})
+}
+
+// answeredCall makes a call from w that hub answers, and fails unless it
+// gets that answer
+func answeredCall(t *testing.T, hub *testHub, w *WorkerSDK) {
+	t.Helper()
+	type outcome struct {
+		result map[string]interface{}
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := w.CallWorker("w2", "echo", nil, testTimeout)
+		done <- outcome{result, err}
+	}()
+	hub.answerCall(t, hub.next(t, pb.MessageType_WORKER_CALL), `{"ok":true}`)
+	if got := <-done; got.err != nil || got.result["ok"] != true {
+		t.Fatalf("answered call: %v %v", got.result, got.err)
+	}
+}
+
+// pendingCallCount is the number of calls waiting for a response
+func pendingCallCount(w *WorkerSDK) int {
+	n := 0
+	w.pendingCalls.Range(func(interface{}, interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Calls that time out leave no pending call, timer or goroutine behind, and
+// responses arriving after the timeout are dropped
+func TestTimedOutCallsCleanedUp(t *testing.T) {
+	hub := startTestHub(t)
+	w := startWorker(t, hub, nil)
+
+	// An answered call first, so the goroutines it starts are in the baseline
+	answeredCall(t, hub, w)
+	baseline := runtime.NumGoroutine()
+
+	const callers, callsEach = 20, 25
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < callsEach; j++ {
+				_, err := w.CallWorker("w2", "echo", map[string]interface{}{"n": j}, 5*time.Millisecond)
+				var apiErr *apierr.ErrorResponse
+				if !errors.As(err, &apiErr) || apiErr.Code != apierr.CodeTimeout {
+					t.Errorf("unanswered call: got %v, want %s", err, apierr.CodeTimeout)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := pendingCallCount(w); n != 0 {
+		t.Fatalf("%d pending calls left after every call timed out", n)
+	}
+
+	// Late responses to every timed-out call are dropped
+	for i := 0; i < callers*callsEach; i++ {
+		hub.answerCall(t, hub.next(t, pb.MessageType_WORKER_CALL), `{"late":true}`)
+	}
+
+	// The next call gets its own response, not a late one
+	answeredCall(t, hub, w)
+	if n := pendingCallCount(w); n != 0 {
+		t.Fatalf("%d pending calls left", n)
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines after %d timed-out calls, %d before", runtime.NumGoroutine(), callers*callsEach, baseline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Workers started and stopped in quick succession, some stopped before
+// they connect and all busy sending, never hang or leak goroutines
+func TestRunStopCycles(t *testing.T) {
+	hub := startTestHub(t)
+	discard := make(chan struct{})
+	defer close(discard)
+	go func() {
+		for {
+			select {
+			case <-hub.received:
+			case <-discard:
+				return
+			}
+		}
+	}()
+	baseline := runtime.NumGoroutine()
+
+	const cycles = 50
+	for i := 0; i < cycles; i++ {
+		w := NewWorkerSDK(fmt.Sprintf("w%d", i), hub.addr, "test")
+		done := make(chan error, 1)
+		go func() { done <- w.Run() }()
+
+		// Senders racing Stop
+		busy := make(chan struct{})
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			j := j
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-busy:
+						return
+					default:
+					}
+					if j%2 == 0 {
+						w.UpdateMetadata(map[string]interface{}{"n": j})
+					} else {
+						w.CallWorker("other", "echo", nil, time.Millisecond)
+					}
+					time.Sleep(50 * time.Microsecond)
+				}
+			}()
+		}
+
+		time.Sleep(time.Duration(i%5) * time.Millisecond)
+		w.Stop()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("cycle %d: Run returned %v", i, err)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("cycle %d: Run did not return after Stop", i)
+		}
+		close(busy)
+		wg.Wait()
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines after %d cycles, %d before", runtime.NumGoroutine(), cycles, baseline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Stop is idempotent, and a worker stopped before Run never connects
+func TestStopBeforeRun(t *testing.T) {
+	hub := startTestHub(t)
+	w := NewWorkerSDK("w1", hub.addr, "test")
+	w.Stop()
+	w.Stop()
+
+	done := make(chan error, 1)
+	go func() { done <- w.Run() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run on a stopped worker returned %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Run on a stopped worker did not return")
+	}
+	if _, err := w.CallWorker("other", "echo", nil, testTimeout); err == nil {
+		t.Fatal("call from a stopped worker succeeded")
+	}
+	select {
+	case msg := <-hub.received:
+		t.Fatalf("stopped worker sent %s", msg.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Stop delivers what was queued before it, and fails calls still waiting
+// for a reply at once
+func TestStopDrainsQueueAndFailsWaitingCalls(t *testing.T) {
+	hub := startTestHub(t)
+	w := startWorker(t, hub, nil)
+
+	failed := make(chan error, 1)
+	go func() {
+		_, err := w.CallWorker("other", "echo", nil, time.Minute)
+		failed <- err
+	}()
+	hub.next(t, pb.MessageType_WORKER_CALL)
+
+	if err := w.UpdateMetadata(map[string]interface{}{"last": true}); err != nil {
+		t.Fatal(err)
+	}
+	w.Stop()
+	if msg := hub.next(t, pb.MessageType_CONTROL); msg.Action != ControlActionUpdateMetadata {
+		t.Fatalf("hub got %s, want the metadata update queued before Stop", msg.Action)
+	}
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Fatal("waiting call succeeded after Stop")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("waiting call still blocked after Stop")
+	}
+	if _, err := w.CallWorker("other", "echo", nil, testTimeout); err == nil {
+		t.Fatal("call after Stop succeeded")
+	}
+}
@@ -51,6 +51,10 @@ class WorkerSDK(ABC):
         self.capabilities = {}
         self.capability_handlers: Dict[str, Callable] = {}
         
+        # Advertised to the hub, which stops routing here at this many
+        # in-flight requests (0 = unlimited)
+        self.max_concurrency = 0
+        
         # Worker-to-worker call tracking
         self.pending_calls = {}
         self.pending_lock = threading.Lock()
@@ -84,7 +88,10 @@ class WorkerSDK(ABC):
         accepts_file: bool = False,
         file_field_name: str = "",
         input_schema: str = "{}",
-        output_schema: str = "{}"
+        output_schema: str = "{}",
+        tags: Optional[List[str]] = None,
+        deprecated: bool = False,
+        deprecation_message: str = ""
     ):
         """
         Register a capability handler
@@ -98,6 +105,9 @@ class WorkerSDK(ABC):
             file_field_name: Name of the file field if accepts_file=True
             input_schema: JSON schema for input validation
             output_schema: JSON schema for output
+            tags: Tags used to group/filter capabilities in discovery
+            deprecated: Mark the capability deprecated (it still routes normally)
+            deprecation_message: Migration hint shown to callers, e.g. "use ocr_v2"
         """
         self.capability_handlers[name] = handler
         self.capabilities[name] = {
@@ -110,6 +120,12 @@ class WorkerSDK(ABC):
         }
         if file_field_name:
             self.capabilities[name]["file_field_name"] = file_field_name
+        if tags:
+            self.capabilities[name]["tags"] = list(tags)
+        if deprecated:
+            self.capabilities[name]["deprecated"] = True
+            if deprecation_message:
+                self.capabilities[name]["deprecation_message"] = deprecation_message
         
         self.log(f"✓ Registered capability: {name}")
     
@@ -240,6 +256,8 @@ class WorkerSDK(ABC):
                 "sdk_version": "2.0.0"
             }
         }
+        if self.max_concurrency > 0:
+            registration_data["metadata"]["max_concurrency"] = self.max_concurrency
         
         register_msg = self.hub_pb2.Message(
             id=f"register-{int(time.time() * 1000000)}",